@@ -0,0 +1,301 @@
+package appleapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const (
+	ApnsProductionUrl = "https://api.push.apple.com"
+	ApnsSandboxUrl    = "https://api.sandbox.push.apple.com"
+)
+
+// apns-push-type
+const (
+	PushTypeAlert        = "alert"
+	PushTypeBackground   = "background"
+	PushTypeLocation     = "location"
+	PushTypeVoip         = "voip"
+	PushTypeComplication = "complication"
+	PushTypeFileProvider = "fileprovider"
+	PushTypeMdm          = "mdm"
+	PushTypeLiveActivity = "liveactivity"
+)
+
+// apns-priority
+const (
+	PriorityImmediate    = 10
+	PriorityConserve     = 5
+	PriorityLowestEnergy = 1
+)
+
+// ApnsToken 生成 APNs 的 provider token，和 App Store Connect 使用同样的 .p8 密钥格式。
+// 苹果要求令牌至少 20 分钟、最多 60 分钟刷新一次，这里 50 分钟刷新
+type ApnsToken struct {
+	Secret string // .p8
+	Kid    string
+	TeamId string
+
+	mu      sync.Mutex
+	bearer  string
+	created time.Time
+	key     *jwt.ECDSASHA
+}
+
+type ApnsPayload struct {
+	Iss string `json:"iss,omitempty"`
+	Iat int64  `json:"iat,omitempty"`
+}
+
+// Bearer 返回当前的 provider token
+func (t *ApnsToken) Bearer() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.key == nil {
+		pk, err := ReadPrivate([]byte(t.Secret))
+		if err != nil {
+			return "", err
+		}
+		t.key = jwt.NewES256(jwt.ECDSAPrivateKey(pk))
+	}
+
+	now := time.Now()
+	if t.bearer == "" || now.Sub(t.created) > 50*time.Minute {
+		bearer, err := jwt.Sign(&ApnsPayload{Iss: t.TeamId, Iat: now.Unix()}, t.key, jwt.KeyID(t.Kid))
+		if err != nil {
+			return "", err
+		}
+		t.bearer = string(bearer)
+		t.created = now
+	}
+	return t.bearer, nil
+}
+
+// refresh 在 APNs 对 failed 返回 ExpiredProviderToken 时调用。只有 failed 仍是当前令牌时才丢弃,
+// 同时失败的多个请求只刷新一次, 频繁刷新会收到 TooManyProviderTokenUpdates
+func (t *ApnsToken) refresh(failed string) {
+	t.mu.Lock()
+	if t.bearer == failed {
+		t.bearer = ""
+	}
+	t.mu.Unlock()
+}
+
+// Notification 是一条推送, Payload 是 JSON 格式的 aps 字典及自定义键
+type Notification struct {
+	DeviceToken string
+	Topic       string    // apns-topic, 通常为 bundle ID, voip 为 <bundle ID>.voip
+	PushType    string    // apns-push-type
+	Priority    int       // apns-priority, 0 时不发送
+	Expiration  time.Time // apns-expiration, 零值时不发送, time.Unix(0, 0) 表示只尝试一次
+	CollapseId  string    // apns-collapse-id
+	Id          string    // apns-id, 为空时由 APNs 生成
+	Payload     []byte
+}
+
+type ApnsResponse struct {
+	StatusCode int
+	Id         string // apns-id
+	UniqueId   string // apns-unique-id, 只在开发环境返回
+}
+
+// https://developer.apple.com/documentation/usernotifications/handling-notification-responses-from-apns
+type ApnsReason string
+
+const (
+	ApnsBadCollapseId               ApnsReason = "BadCollapseId"
+	ApnsBadDeviceToken              ApnsReason = "BadDeviceToken"
+	ApnsBadExpirationDate           ApnsReason = "BadExpirationDate"
+	ApnsBadMessageId                ApnsReason = "BadMessageId"
+	ApnsBadPriority                 ApnsReason = "BadPriority"
+	ApnsBadTopic                    ApnsReason = "BadTopic"
+	ApnsDeviceTokenNotForTopic      ApnsReason = "DeviceTokenNotForTopic"
+	ApnsDuplicateHeaders            ApnsReason = "DuplicateHeaders"
+	ApnsIdleTimeout                 ApnsReason = "IdleTimeout"
+	ApnsInvalidPushType             ApnsReason = "InvalidPushType"
+	ApnsMissingDeviceToken          ApnsReason = "MissingDeviceToken"
+	ApnsMissingTopic                ApnsReason = "MissingTopic"
+	ApnsPayloadEmpty                ApnsReason = "PayloadEmpty"
+	ApnsTopicDisallowed             ApnsReason = "TopicDisallowed"
+	ApnsBadCertificate              ApnsReason = "BadCertificate"
+	ApnsBadCertificateEnvironment   ApnsReason = "BadCertificateEnvironment"
+	ApnsExpiredProviderToken        ApnsReason = "ExpiredProviderToken"
+	ApnsForbidden                   ApnsReason = "Forbidden"
+	ApnsInvalidProviderToken        ApnsReason = "InvalidProviderToken"
+	ApnsMissingProviderToken        ApnsReason = "MissingProviderToken"
+	ApnsUnrelatedKeyIdInToken       ApnsReason = "UnrelatedKeyIdInToken"
+	ApnsBadEnvironmentKeyIdInToken  ApnsReason = "BadEnvironmentKeyIdInToken"
+	ApnsBadPath                     ApnsReason = "BadPath"
+	ApnsMethodNotAllowed            ApnsReason = "MethodNotAllowed"
+	ApnsExpiredToken                ApnsReason = "ExpiredToken"
+	ApnsUnregistered                ApnsReason = "Unregistered"
+	ApnsPayloadTooLarge             ApnsReason = "PayloadTooLarge"
+	ApnsTooManyProviderTokenUpdates ApnsReason = "TooManyProviderTokenUpdates"
+	ApnsTooManyRequests             ApnsReason = "TooManyRequests"
+	ApnsInternalServerError         ApnsReason = "InternalServerError"
+	ApnsServiceUnavailable          ApnsReason = "ServiceUnavailable"
+	ApnsShutdown                    ApnsReason = "Shutdown"
+)
+
+// ApnsReason 实现 error, 可以用 errors.Is(err, appleapi.ApnsUnregistered) 判断 ApnsError
+func (r ApnsReason) Error() string {
+	return "apns: " + string(r)
+}
+
+// ApnsError 是 APNs 返回的非 200 响应
+type ApnsError struct {
+	StatusCode int
+	Id         string     // apns-id
+	Reason     ApnsReason `json:"reason"`
+	Timestamp  int64      `json:"timestamp,omitempty"` // 410 时设备令牌失效的时间, 毫秒
+}
+
+func (e *ApnsError) Error() string {
+	return fmt.Sprintf("apns: %d %s (apns-id %s)", e.StatusCode, string(e.Reason), e.Id)
+}
+
+func (e *ApnsError) Is(target error) bool {
+	r, ok := target.(ApnsReason)
+	return ok && r == e.Reason
+}
+
+// Unregistered 表示设备令牌已失效, 应停止向它推送
+func (e *ApnsError) Unregistered() bool {
+	return e.StatusCode == http.StatusGone || e.Reason == ApnsUnregistered || e.Reason == ApnsExpiredToken
+}
+
+// InvalidAt 返回设备令牌失效的时间
+func (e *ApnsError) InvalidAt() time.Time {
+	if e.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, e.Timestamp*int64(time.Millisecond))
+}
+
+// Apns 通过 HTTP/2 发送推送, 可以被多个 goroutine 同时使用
+type Apns struct {
+	Token   *ApnsToken
+	BaseUrl string       // 默认 ApnsProductionUrl, 测试时可指向本地服务
+	Client  *http.Client // 默认使用 HTTP/2 的客户端
+	Hooks   []Hook
+}
+
+// NewApns 返回推送客户端, sandbox 为 true 时使用开发环境
+func NewApns(token *ApnsToken, sandbox bool) *Apns {
+	a := &Apns{Token: token, BaseUrl: ApnsProductionUrl}
+	if sandbox {
+		a.BaseUrl = ApnsSandboxUrl
+	}
+	return a
+}
+
+var apnsClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     5 * time.Minute,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	Timeout: 30 * time.Second,
+}
+
+func (a *Apns) Push(n *Notification) (*ApnsResponse, error) {
+	return a.PushContext(context.Background(), n)
+}
+
+// PushContext 发送一条推送。负载超过大小限制时不发送，返回 ErrPayloadTooLarge；
+// 令牌过期时刷新后重试一次，其他错误返回 *ApnsError
+func (a *Apns) PushContext(ctx context.Context, n *Notification) (*ApnsResponse, error) {
+	resp, bearer, err := a.send(ctx, n)
+	if e, ok := err.(*ApnsError); ok && e.Reason == ApnsExpiredProviderToken {
+		a.Token.refresh(bearer)
+		resp, _, err = a.send(ctx, n)
+	}
+	return resp, err
+}
+
+// send 发送一次推送, 同时返回使用的令牌
+func (a *Apns) send(ctx context.Context, n *Notification) (*ApnsResponse, string, error) {
+	if limit := PayloadLimit(n.PushType); len(n.Payload) > limit {
+		return nil, "", fmt.Errorf("%w: %d bytes, the limit is %d", ErrPayloadTooLarge, len(n.Payload), limit)
+	}
+	base := a.BaseUrl
+	if base == "" {
+		base = ApnsProductionUrl
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/3/device/"+n.DeviceToken, bytes.NewReader(n.Payload))
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	bearer, err := a.Token.Bearer()
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Authorization", "bearer "+bearer)
+	req.Header.Set("Content-Type", "application/json")
+	if n.Topic != "" {
+		req.Header.Set("apns-topic", n.Topic)
+	}
+	if n.PushType != "" {
+		req.Header.Set("apns-push-type", n.PushType)
+	}
+	if n.Priority != 0 {
+		req.Header.Set("apns-priority", strconv.Itoa(n.Priority))
+	}
+	if !n.Expiration.IsZero() {
+		req.Header.Set("apns-expiration", strconv.FormatInt(n.Expiration.Unix(), 10))
+	}
+	if n.CollapseId != "" {
+		req.Header.Set("apns-collapse-id", n.CollapseId)
+	}
+	if n.Id != "" {
+		req.Header.Set("apns-id", n.Id)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = apnsClient
+	}
+	for _, h := range a.Hooks {
+		req = h.BeforeSend(req)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	for i := len(a.Hooks) - 1; i >= 0; i-- {
+		a.Hooks[i].AfterReceive(req, resp, err, latency)
+	}
+	if err != nil {
+		return nil, bearer, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, bearer, err
+	}
+
+	result := &ApnsResponse{
+		StatusCode: resp.StatusCode,
+		Id:         resp.Header.Get("apns-id"),
+		UniqueId:   resp.Header.Get("apns-unique-id"),
+	}
+	if resp.StatusCode == http.StatusOK {
+		return result, bearer, nil
+	}
+	e := &ApnsError{StatusCode: resp.StatusCode, Id: result.Id}
+	if err = json.Unmarshal(body, e); err != nil || e.Reason == "" {
+		e.Reason = ApnsReason(http.StatusText(resp.StatusCode))
+	}
+	return result, bearer, e
+}
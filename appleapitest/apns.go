@@ -0,0 +1,245 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// Push is a notification accepted by the fake APNs server.
+type Push struct {
+	DeviceToken string
+	Header      http.Header
+	Payload     []byte
+}
+
+// ApnsServer is an HTTP/2 fake of the APNs provider API. It validates the
+// provider token and the apns-* headers like APNs and records the accepted
+// notifications.
+type ApnsServer struct {
+	*httptest.Server
+	Kid    string
+	TeamId string
+
+	key    *ecdsa.PrivateKey
+	keyPem []byte
+
+	mu           sync.Mutex
+	pushes       []Push
+	unregistered map[string]time.Time
+	rejected     map[string]appleapi.ApnsReason
+	expired      map[string]bool
+}
+
+func NewApnsServer() *ApnsServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	s := &ApnsServer{
+		Kid:          TestKid,
+		TeamId:       TestSeedId,
+		key:          key,
+		keyPem:       pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		unregistered: make(map[string]time.Time),
+		rejected:     make(map[string]appleapi.ApnsReason),
+		expired:      make(map[string]bool),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	return s
+}
+
+// AuthKey returns the .p8 PEM the server validates provider tokens against.
+func (s *ApnsServer) AuthKey() []byte {
+	return s.keyPem
+}
+
+func (s *ApnsServer) Token() *appleapi.ApnsToken {
+	return &appleapi.ApnsToken{Secret: string(s.keyPem), Kid: s.Kid, TeamId: s.TeamId}
+}
+
+// Apns returns a client that talks to the fake server.
+func (s *ApnsServer) Apns() *appleapi.Apns {
+	return &appleapi.Apns{Token: s.Token(), BaseUrl: s.URL, Client: s.Client()}
+}
+
+// Unregister makes pushes to deviceToken fail with 410 Unregistered.
+func (s *ApnsServer) Unregister(deviceToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unregistered[deviceToken] = time.Now()
+}
+
+// Reject makes pushes to deviceToken fail with 400 and reason.
+func (s *ApnsServer) Reject(deviceToken string, reason appleapi.ApnsReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[deviceToken] = reason
+}
+
+// ExpireProviderToken makes requests with bearer fail with 403
+// ExpiredProviderToken.
+func (s *ApnsServer) ExpireProviderToken(bearer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired[bearer] = true
+}
+
+// Pushes returns the accepted notifications.
+func (s *ApnsServer) Pushes() []Push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Push(nil), s.pushes...)
+}
+
+type apnsReply struct {
+	Reason    appleapi.ApnsReason `json:"reason"`
+	Timestamp int64               `json:"timestamp,omitempty"`
+}
+
+func apnsError(w http.ResponseWriter, status int, reason appleapi.ApnsReason) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apnsReply{Reason: reason})
+}
+
+var pushTypes = map[string]bool{
+	appleapi.PushTypeAlert:        true,
+	appleapi.PushTypeBackground:   true,
+	appleapi.PushTypeLocation:     true,
+	appleapi.PushTypeVoip:         true,
+	appleapi.PushTypeComplication: true,
+	appleapi.PushTypeFileProvider: true,
+	appleapi.PushTypeMdm:          true,
+	appleapi.PushTypeLiveActivity: true,
+}
+
+func (s *ApnsServer) authorize(r *http.Request) (int, appleapi.ApnsReason) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return http.StatusForbidden, appleapi.ApnsMissingProviderToken
+	}
+	pl := &appleapi.ApnsPayload{}
+	alg := jwt.NewES256(jwt.ECDSAPublicKey(&s.key.PublicKey))
+	h, err := jwt.Verify([]byte(auth[7:]), alg, pl)
+	if err != nil {
+		return http.StatusForbidden, appleapi.ApnsInvalidProviderToken
+	}
+	switch {
+	case h.KeyID != s.Kid:
+		return http.StatusForbidden, appleapi.ApnsInvalidProviderToken
+	case pl.Iss != s.TeamId:
+		return http.StatusForbidden, appleapi.ApnsInvalidProviderToken
+	case time.Since(time.Unix(pl.Iat, 0)) > time.Hour:
+		return http.StatusForbidden, appleapi.ApnsExpiredProviderToken
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired[auth[7:]] {
+		return http.StatusForbidden, appleapi.ApnsExpiredProviderToken
+	}
+	return 0, ""
+}
+
+func (s *ApnsServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("apns-id")
+	if id == "" {
+		id = newUuid()
+	}
+	w.Header().Set("apns-id", id)
+
+	if r.Method != http.MethodPost {
+		apnsError(w, http.StatusMethodNotAllowed, appleapi.ApnsMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/3/device/") {
+		apnsError(w, http.StatusNotFound, appleapi.ApnsBadPath)
+		return
+	}
+	if status, reason := s.authorize(r); status != 0 {
+		apnsError(w, status, reason)
+		return
+	}
+
+	deviceToken := strings.TrimPrefix(r.URL.Path, "/3/device/")
+	if deviceToken == "" {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsMissingDeviceToken)
+		return
+	}
+	if _, err := hex.DecodeString(deviceToken); err != nil || len(deviceToken) < 64 {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsBadDeviceToken)
+		return
+	}
+	if r.Header.Get("apns-topic") == "" {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsMissingTopic)
+		return
+	}
+	pushType := r.Header.Get("apns-push-type")
+	if pushType != "" && !pushTypes[pushType] {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsInvalidPushType)
+		return
+	}
+	if p := r.Header.Get("apns-priority"); p != "" && p != "10" && p != "5" && p != "1" {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsBadPriority)
+		return
+	}
+	if e := r.Header.Get("apns-expiration"); e != "" {
+		if _, err := strconv.ParseInt(e, 10, 64); err != nil {
+			apnsError(w, http.StatusBadRequest, appleapi.ApnsBadExpirationDate)
+			return
+		}
+	}
+	if len(r.Header.Get("apns-collapse-id")) > 64 {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsBadCollapseId)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if len(payload) == 0 {
+		apnsError(w, http.StatusBadRequest, appleapi.ApnsPayloadEmpty)
+		return
+	}
+	if len(payload) > appleapi.PayloadLimit(pushType) {
+		apnsError(w, http.StatusRequestEntityTooLarge, appleapi.ApnsPayloadTooLarge)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason, ok := s.rejected[deviceToken]; ok {
+		apnsError(w, http.StatusBadRequest, reason)
+		return
+	}
+	if t, ok := s.unregistered[deviceToken]; ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(apnsReply{Reason: appleapi.ApnsUnregistered, Timestamp: t.UnixNano() / int64(time.Millisecond)})
+		return
+	}
+	s.pushes = append(s.pushes, Push{DeviceToken: deviceToken, Header: r.Header.Clone(), Payload: payload})
+	w.Header().Set("apns-unique-id", strings.ToLower(newUuid()))
+	w.WriteHeader(http.StatusOK)
+}
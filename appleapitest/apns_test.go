@@ -0,0 +1,93 @@
+package appleapitest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var deviceToken = strings.Repeat("ab", 32)
+
+func alert(token string) *appleapi.Notification {
+	return &appleapi.Notification{
+		DeviceToken: token,
+		Topic:       "com.example.app",
+		PushType:    appleapi.PushTypeAlert,
+		Payload:     []byte(`{"aps":{"alert":"hi"}}`),
+	}
+}
+
+func TestApnsErrors(t *testing.T) {
+	srv := NewApnsServer()
+	defer srv.Close()
+	srv.Reject("cd"+deviceToken[2:], appleapi.ApnsDeviceTokenNotForTopic)
+	srv.Unregister("ef" + deviceToken[2:])
+
+	tests := []struct {
+		name         string
+		n            *appleapi.Notification
+		status       int
+		reason       appleapi.ApnsReason
+		unregistered bool
+	}{
+		{"bad device token", alert("xyz"), http.StatusBadRequest, appleapi.ApnsBadDeviceToken, false},
+		{"missing topic", &appleapi.Notification{DeviceToken: deviceToken, Payload: []byte(`{}`)}, http.StatusBadRequest, appleapi.ApnsMissingTopic, false},
+		{"rejected", alert("cd" + deviceToken[2:]), http.StatusBadRequest, appleapi.ApnsDeviceTokenNotForTopic, false},
+		{"unregistered", alert("ef" + deviceToken[2:]), http.StatusGone, appleapi.ApnsUnregistered, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := srv.Apns().Push(tt.n)
+			var e *appleapi.ApnsError
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *ApnsError", err)
+			}
+			if e.StatusCode != tt.status || !errors.Is(err, tt.reason) || e.Id == "" || resp.Id != e.Id {
+				t.Errorf("err = %+v, want %d %s with the apns-id", e, tt.status, tt.reason)
+			}
+			if e.Unregistered() != tt.unregistered || e.InvalidAt().IsZero() == tt.unregistered {
+				t.Errorf("Unregistered() = %v at %v", e.Unregistered(), e.InvalidAt())
+			}
+		})
+	}
+}
+
+func TestApnsRefreshesExpiredTokenOnce(t *testing.T) {
+	srv := NewApnsServer()
+	defer srv.Close()
+	a := srv.Apns()
+	old, err := a.Token.Bearer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ExpireProviderToken(old)
+
+	// 同时失败的请求只刷新一次令牌
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = a.Push(alert(deviceToken))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("push %d: %v", i, err)
+		}
+	}
+	bearers := make(map[string]bool)
+	for _, p := range srv.Pushes() {
+		bearers[p.Header.Get("Authorization")] = true
+	}
+	current, _ := a.Token.Bearer()
+	if len(bearers) != 1 || !bearers["bearer "+current] || current == old {
+		t.Errorf("pushes used %d provider tokens, want only the refreshed one", len(bearers))
+	}
+}
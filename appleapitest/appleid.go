@@ -0,0 +1,312 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const (
+	TestClientId = "com.example.app.signin"
+	TestIdKid    = "TESTIDKEY1"
+)
+
+// AppleIdUser is the user a fake authorization code is issued for.
+type AppleIdUser struct {
+	Sub            string
+	Email          string
+	EmailVerified  bool
+	IsPrivateEmail bool
+	RealUserStatus int
+}
+
+type appleIdGrant struct {
+	user    AppleIdUser
+	nonce   string
+	expires time.Time
+}
+
+// AppleIdServer is a fake of the Sign in with Apple REST API. It validates
+// the client_secret JWT like Apple, exchanges the codes issued by Authorize
+// for tokens and signs id_tokens with its own RSA key, published at
+// /auth/keys.
+type AppleIdServer struct {
+	*httptest.Server
+	Kid      string
+	TeamId   string
+	ClientId string
+	IdKid    string // id_token 的 kid
+
+	key    *ecdsa.PrivateKey
+	keyPem []byte
+
+	mu      sync.Mutex
+	idKey   *rsa.PrivateKey
+	idKeys  []appleapi.Jwk
+	codes   map[string]*appleIdGrant
+	refresh map[string]*appleIdGrant
+	access  map[string]*appleIdGrant
+}
+
+func NewAppleIdServer() *AppleIdServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	idKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	s := &AppleIdServer{
+		Kid:      TestKid,
+		TeamId:   TestSeedId,
+		ClientId: TestClientId,
+		IdKid:    TestIdKid,
+		key:      key,
+		keyPem:   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		idKey:    idKey,
+		idKeys:   []appleapi.Jwk{appleapi.NewRSAJwk(TestIdKid, &idKey.PublicKey)},
+		codes:    make(map[string]*appleIdGrant),
+		refresh:  make(map[string]*appleIdGrant),
+		access:   make(map[string]*appleIdGrant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/token", s.serveToken)
+	mux.HandleFunc("/auth/revoke", s.serveRevoke)
+	mux.HandleFunc("/auth/keys", s.serveKeys)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AuthKey returns the .p8 PEM the server validates client secrets against.
+func (s *AppleIdServer) AuthKey() []byte {
+	return s.keyPem
+}
+
+// SignInWithApple returns a client that talks to the fake server.
+func (s *AppleIdServer) SignInWithApple() *appleapi.SignInWithApple {
+	return &appleapi.SignInWithApple{
+		Secret:   string(s.keyPem),
+		Kid:      s.Kid,
+		TeamId:   s.TeamId,
+		ClientId: s.ClientId,
+		BaseUrl:  s.URL,
+		Client:   s.Client(),
+	}
+}
+
+// RotateIdKey signs later id_tokens with a new key published under kid. The
+// old keys stay in /auth/keys like Apple's do during a rotation.
+func (s *AppleIdServer) RotateIdKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.IdKid = kid
+	s.idKey = key
+	s.idKeys = append(s.idKeys, appleapi.NewRSAJwk(kid, &key.PublicKey))
+}
+
+// IdToken signs claims with the current id_token key, so tests can build
+// expired or tampered tokens.
+func (s *AppleIdServer) IdToken(claims *appleapi.IdTokenClaims) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, err := s.sign(claims)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	return token
+}
+
+// sign 用当前的密钥签名, 调用时持有 s.mu
+func (s *AppleIdServer) sign(claims interface{}) (string, error) {
+	b, err := jwt.Sign(claims, jwt.NewRS256(jwt.RSAPrivateKey(s.idKey)), jwt.KeyID(s.IdKid))
+	return string(b), err
+}
+
+// Notification returns the body Apple posts to the server-to-server
+// notification endpoint for event.
+func (s *AppleIdServer) Notification(event appleapi.SiwaEvent) []byte {
+	if event.EventTime == 0 {
+		event.EventTime = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	n := &appleapi.SiwaNotification{
+		Iss:    appleapi.AppleIdUrl,
+		Aud:    s.ClientId,
+		Iat:    time.Now().Unix(),
+		Jti:    randomToken(),
+		Events: event,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payload, err := s.sign(n)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	b, _ := json.Marshal(map[string]string{"payload": payload})
+	return b
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Authorize issues an authorization code for user, valid for 5 minutes and
+// usable once, as if the user had signed in on the authorization page.
+func (s *AppleIdServer) Authorize(user AppleIdUser, nonce string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := "c" + randomToken()
+	s.codes[code] = &appleIdGrant{user: user, nonce: nonce, expires: time.Now().Add(5 * time.Minute)}
+	return code
+}
+
+func appleIdError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// authorize 检查 client_id 和 client_secret
+func (s *AppleIdServer) authorize(r *http.Request) bool {
+	if r.PostForm.Get("client_id") != s.ClientId {
+		return false
+	}
+	pl := &appleapi.ClientSecretPayload{}
+	alg := jwt.NewES256(jwt.ECDSAPublicKey(&s.key.PublicKey))
+	h, err := jwt.Verify([]byte(r.PostForm.Get("client_secret")), alg, pl)
+	if err != nil {
+		return false
+	}
+	now := time.Now().Unix()
+	return h.KeyID == s.Kid && pl.Iss == s.TeamId && pl.Sub == s.ClientId && pl.Aud == appleapi.AppleIdUrl &&
+		pl.Exp > now && pl.Iat <= now+60 && pl.Exp-pl.Iat <= int64(appleapi.MaxClientSecretLifetime/time.Second)
+}
+
+func (s *AppleIdServer) idToken(g *appleIdGrant) (string, error) {
+	now := time.Now()
+	claims := &appleapi.IdTokenClaims{
+		Iss:            appleapi.AppleIdUrl,
+		Aud:            s.ClientId,
+		Exp:            now.Add(10 * time.Minute).Unix(),
+		Iat:            now.Unix(),
+		Sub:            g.user.Sub,
+		Nonce:          g.nonce,
+		NonceSupported: true,
+		Email:          g.user.Email,
+		EmailVerified:  appleapi.FlexBool(g.user.EmailVerified),
+		IsPrivateEmail: appleapi.FlexBool(g.user.IsPrivateEmail),
+		RealUserStatus: g.user.RealUserStatus,
+		AuthTime:       now.Unix(),
+	}
+	return s.sign(claims)
+}
+
+func (s *AppleIdServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		appleIdError(w, "invalid_request")
+		return
+	}
+	if !s.authorize(r) {
+		appleIdError(w, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var g *appleIdGrant
+	resp := &appleapi.AppleIdTokenResponse{TokenType: "Bearer", ExpiresIn: 3600}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		g = s.codes[code]
+		delete(s.codes, code)
+		if g == nil || time.Now().After(g.expires) {
+			appleIdError(w, "invalid_grant")
+			return
+		}
+		resp.RefreshToken = "r" + randomToken()
+		s.refresh[resp.RefreshToken] = g
+	case "refresh_token":
+		g = s.refresh[r.PostForm.Get("refresh_token")]
+		if g == nil {
+			appleIdError(w, "invalid_grant")
+			return
+		}
+	case "":
+		appleIdError(w, "invalid_request")
+		return
+	default:
+		appleIdError(w, "unsupported_grant_type")
+		return
+	}
+
+	idToken, err := s.idToken(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.IdToken = idToken
+	resp.AccessToken = "a" + randomToken()
+	s.access[resp.AccessToken] = g
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *AppleIdServer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&appleapi.Jwks{Keys: s.idKeys})
+}
+
+// serveRevoke 使令牌失效, 撤销刷新令牌时同时撤销由它取得的访问令牌
+func (s *AppleIdServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		appleIdError(w, "invalid_request")
+		return
+	}
+	if !s.authorize(r) {
+		appleIdError(w, "invalid_client")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		appleIdError(w, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.refresh[token]; ok {
+		delete(s.refresh, token)
+		for k, v := range s.access {
+			if v == g {
+				delete(s.access, k)
+			}
+		}
+	}
+	delete(s.access, token)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,97 @@
+package appleapitest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+func TestIdTokenVerifier(t *testing.T) {
+	srv := NewAppleIdServer()
+	defer srv.Close()
+	other := NewAppleIdServer()
+	defer other.Close()
+	other.RotateIdKey("OTHERKID")
+
+	now := time.Now()
+	keys := appleapi.NewKeySet(srv.URL + "/auth/keys")
+	keys.Client = srv.Client()
+	v := &appleapi.IdTokenVerifier{
+		Keys:      keys,
+		ClientIds: []string{"com.example.web", TestClientId},
+		Leeway:    time.Minute,
+		Now:       func() time.Time { return now },
+	}
+	claims := func(edit func(c *appleapi.IdTokenClaims)) *appleapi.IdTokenClaims {
+		c := &appleapi.IdTokenClaims{
+			Iss:   appleapi.AppleIdUrl,
+			Sub:   "001234.abcdef.0123",
+			Aud:   TestClientId,
+			Iat:   now.Add(-time.Minute).Unix(),
+			Exp:   now.Add(10 * time.Minute).Unix(),
+			Nonce: "n-0S6_WzA2Mj",
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		err   error
+	}{
+		{"valid", srv.IdToken(claims(nil)), "n-0S6_WzA2Mj", nil},
+		{"nonce not checked", srv.IdToken(claims(nil)), "", nil},
+		{"second client id", srv.IdToken(claims(func(c *appleapi.IdTokenClaims) { c.Aud = "com.example.web" })), "", nil},
+		{"issuer", srv.IdToken(claims(func(c *appleapi.IdTokenClaims) { c.Iss = "https://example.com" })), "", appleapi.ErrIdTokenIssuer},
+		{"audience", srv.IdToken(claims(func(c *appleapi.IdTokenClaims) { c.Aud = "com.example.other" })), "", appleapi.ErrIdTokenAudience},
+		{"within leeway", srv.IdToken(claims(func(c *appleapi.IdTokenClaims) { c.Exp = now.Add(-30 * time.Second).Unix() })), "", nil},
+		{"expired", srv.IdToken(claims(func(c *appleapi.IdTokenClaims) { c.Exp = now.Add(-time.Minute).Unix() })), "", appleapi.ErrIdTokenExpired},
+		{"nonce", srv.IdToken(claims(nil)), "another nonce", appleapi.ErrIdTokenNonce},
+		{"unknown kid", other.IdToken(claims(nil)), "", appleapi.ErrUnknownKid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(context.Background(), tt.token, tt.nonce)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.Sub != "001234.abcdef.0123" {
+				t.Errorf("sub = %q", got.Sub)
+			}
+		})
+	}
+
+	// 用另一个密钥签名但 kid 相同
+	forged := NewAppleIdServer()
+	defer forged.Close()
+	if _, err := v.Verify(context.Background(), forged.IdToken(claims(nil)), ""); err == nil {
+		t.Error("token signed by another key with the same kid verified")
+	}
+}
+
+func TestVerifyIdTokenAfterKeyRotation(t *testing.T) {
+	srv := NewAppleIdServer()
+	defer srv.Close()
+	siwa := srv.SignInWithApple()
+	claims := &appleapi.IdTokenClaims{
+		Iss: appleapi.AppleIdUrl,
+		Sub: "001234.abcdef.0123",
+		Aud: TestClientId,
+		Exp: time.Now().Add(10 * time.Minute).Unix(),
+	}
+	if _, err := siwa.VerifyIdToken(context.Background(), srv.IdToken(claims), ""); err != nil {
+		t.Fatal(err)
+	}
+	// 新的 kid 不需要等缓存过期
+	srv.RotateIdKey("TESTIDKEY2")
+	if _, err := siwa.VerifyIdToken(context.Background(), srv.IdToken(claims), ""); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+}
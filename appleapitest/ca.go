@@ -0,0 +1,75 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// certificateAuthority signs the certificates created through the fake.
+type certificateAuthority struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newCertificateAuthority() *certificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake Worldwide Developer Relations Certification Authority", Organization: []string{"appleapitest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &certificateAuthority{key: key, cert: cert}
+}
+
+// parseCsr accepts a PEM or a base64 DER certificate signing request, like
+// App Store Connect does.
+func parseCsr(content string) (*x509.CertificateRequest, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(content)); block != nil {
+		der = block.Bytes
+	} else {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(content))
+		if err != nil {
+			return nil, errors.New("csrContent is neither PEM nor base64")
+		}
+		der = b
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	return csr, csr.CheckSignature()
+}
+
+func (ca *certificateAuthority) issue(csr *x509.CertificateRequest, name string, serial int64, notAfter time.Time) ([]byte, error) {
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: csr.Subject.Organization},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	return x509.CreateCertificate(rand.Reader, tpl, ca.cert, csr.PublicKey, ca.key)
+}
@@ -0,0 +1,326 @@
+package appleapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in recorded cassettes.
+const Redacted = "REDACTED"
+
+// ScrubbedAttributes are the JSON keys whose values are replaced by Redacted
+// when recording.
+var ScrubbedAttributes = []string{"certificateContent", "csrContent", "profileContent"}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	Url    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Cassette is a list of recorded request/response pairs.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+func LoadCassette(file string) (*Cassette, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("appleapitest: cassette %s: %w", file, err)
+	}
+	return c, nil
+}
+
+func (c *Cassette) Save(file string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// Recorder is a RoundTripper that forwards requests to Transport and
+// records the scrubbed request/response pairs.
+//
+//	rec := appleapitest.NewRecorder("testdata/devices.json", nil)
+//	token.Client = &http.Client{Transport: rec}
+//	...
+//	rec.Save()
+type Recorder struct {
+	Transport http.RoundTripper // 默认 http.DefaultTransport
+	File      string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(file string, transport http.RoundTripper) *Recorder {
+	return &Recorder{File: file, Transport: transport}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	it := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			Url:    req.URL.String(),
+			Header: scrubHeader(req.Header),
+			Body:   scrubBody(reqBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     scrubHeader(resp.Header),
+			Body:       scrubBody(respBody),
+		},
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, it)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Cassette returns the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &Cassette{Interactions: make([]*Interaction, len(r.cassette.Interactions))}
+	copy(c.Interactions, r.cassette.Interactions)
+	return c
+}
+
+// Save writes the recorded interactions to File.
+func (r *Recorder) Save() error {
+	return r.Cassette().Save(r.File)
+}
+
+func scrubHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		if k == "Authorization" {
+			c[k] = []string{"Bearer " + Redacted}
+			continue
+		}
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func scrubBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	if !scrubValue(v) {
+		return string(body)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return string(body)
+	}
+	return string(b)
+}
+
+// scrubValue replaces ScrubbedAttributes in place and reports whether it
+// changed anything.
+func scrubValue(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if isScrubbed(k) {
+				if item != Redacted {
+					v[k] = Redacted
+					changed = true
+				}
+				continue
+			}
+			if scrubValue(item) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if scrubValue(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func isScrubbed(key string) bool {
+	for _, v := range ScrubbedAttributes {
+		if v == key {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmatchedRequestError is returned by Replayer for a request that has no
+// recorded interaction left.
+type UnmatchedRequestError struct {
+	Method string
+	Path   string
+	Query  string
+}
+
+func (e *UnmatchedRequestError) Error() string {
+	return fmt.Sprintf("appleapitest: no recorded interaction for %s %s?%s", e.Method, e.Path, e.Query)
+}
+
+// Replayer is a RoundTripper that answers requests from a cassette. It
+// matches on method, path and normalized query, each interaction is used
+// once in recorded order.
+type Replayer struct {
+	mu        sync.Mutex
+	cassette  *Cassette
+	used      []bool
+	unmatched []*UnmatchedRequestError
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}
+}
+
+func LoadReplayer(file string) (*Replayer, error) {
+	c, err := LoadCassette(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(c), nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	query := normalizeQuery(req.URL.RawQuery)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, it := range r.cassette.Interactions {
+		if r.used[i] || it.Request.Method != req.Method {
+			continue
+		}
+		u, err := url.Parse(it.Request.Url)
+		if err != nil || u.Path != req.URL.Path || normalizeQuery(u.RawQuery) != query {
+			continue
+		}
+		r.used[i] = true
+		header := make(http.Header, len(it.Response.Header))
+		for k, v := range it.Response.Header {
+			header[k] = append([]string(nil), v...)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", it.Response.StatusCode, http.StatusText(it.Response.StatusCode)),
+			StatusCode:    it.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(it.Response.Body)),
+			ContentLength: int64(len(it.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	e := &UnmatchedRequestError{Method: req.Method, Path: req.URL.Path, Query: query}
+	r.unmatched = append(r.unmatched, e)
+	return nil, e
+}
+
+// Unmatched returns the requests that had no recorded interaction.
+func (r *Replayer) Unmatched() []*UnmatchedRequestError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*UnmatchedRequestError(nil), r.unmatched...)
+}
+
+// Unused returns the recorded interactions that were never replayed.
+func (r *Replayer) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*Interaction, 0)
+	for i, it := range r.cassette.Interactions {
+		if !r.used[i] {
+			list = append(list, it)
+		}
+	}
+	return list
+}
+
+// normalizeQuery sorts the parameters and the comma separated values, so
+// that "filter[id]=b,a&limit=2" matches "limit=2&filter[id]=a,b".
+func normalizeQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	for k, values := range q {
+		if k == "sort" {
+			continue // 排序字段的顺序有意义
+		}
+		for i, v := range values {
+			list := strings.Split(v, ",")
+			sort.Strings(list)
+			values[i] = strings.Join(list, ",")
+		}
+		sort.Strings(values)
+		q[k] = values
+	}
+	return q.Encode()
+}
@@ -0,0 +1,138 @@
+package appleapitest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+)
+
+func TestRecorderScrubsSecrets(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec := NewRecorder(filepath.Join(dir, "certificates.json"), srv.Client().Transport)
+	token := srv.Token()
+	token.Client = &http.Client{Transport: rec}
+	certs := &appleapi.Certificates{Token: token}
+
+	_, csr, err := appleapi.NewCertificateRequest("appleapi test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = certs.CertificateCreate(csr, "IOS_DISTRIBUTION"); err != nil {
+		t.Fatal(err)
+	}
+	if err = rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(rec.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), csr) || strings.Contains(string(saved), "Bearer ey") {
+		t.Fatalf("cassette leaks the csr or the bearer token:\n%s", saved)
+	}
+
+	c, err := LoadCassette(rec.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := c.Interactions[0]
+	tests := []struct {
+		name string
+		got  string
+	}{
+		{"request Authorization", it.Request.Header.Get("Authorization")},
+		{"request csrContent", jsonMember(t, it.Request.Body, "data", "attributes", "csrContent")},
+		{"response certificateContent", jsonMember(t, it.Response.Body, "data", "attributes", "certificateContent")},
+	}
+	for _, tt := range tests {
+		if !strings.HasSuffix(tt.got, Redacted) {
+			t.Errorf("%s = %q, want %s", tt.name, tt.got, Redacted)
+		}
+	}
+	if got := jsonMember(t, it.Response.Body, "data", "attributes", "certificateType"); got != "IOS_DISTRIBUTION" {
+		t.Errorf("certificateType = %q, other attributes must be kept", got)
+	}
+}
+
+func jsonMember(t *testing.T, body string, path ...string) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	for _, k := range path {
+		m, _ := v.(map[string]interface{})
+		v = m[k]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func TestReplayerMatching(t *testing.T) {
+	page := func(body string) RecordedResponse {
+		return RecordedResponse{StatusCode: http.StatusOK, Body: body}
+	}
+	c := &Cassette{Interactions: []*Interaction{
+		{Request: RecordedRequest{Method: http.MethodGet, Url: "https://api.appstoreconnect.apple.com/v1/devices?filter%5Bid%5D=b%2Ca&limit=2"}, Response: page("first")},
+		{Request: RecordedRequest{Method: http.MethodGet, Url: "https://api.appstoreconnect.apple.com/v1/devices?filter%5Bid%5D=b%2Ca&limit=2"}, Response: page("second")},
+		{Request: RecordedRequest{Method: http.MethodGet, Url: "https://api.appstoreconnect.apple.com/v1/devices?sort=name%2C-id"}, Response: page("sorted")},
+		{Request: RecordedRequest{Method: http.MethodDelete, Url: "https://api.appstoreconnect.apple.com/v1/profiles/P1"}, Response: RecordedResponse{StatusCode: http.StatusNoContent}},
+	}}
+	r := NewReplayer(c)
+	client := &http.Client{Transport: r}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   string // 响应的 body, 为空表示没有匹配
+	}{
+		{"reordered query", http.MethodGet, "http://localhost/v1/devices?limit=2&filter%5Bid%5D=a%2Cb", "first"},
+		{"same request is answered in recorded order", http.MethodGet, "http://localhost/v1/devices?limit=2&filter%5Bid%5D=a,b", "second"},
+		{"interaction is used once", http.MethodGet, "http://localhost/v1/devices?limit=2&filter%5Bid%5D=a,b", ""},
+		{"sort order matters", http.MethodGet, "http://localhost/v1/devices?sort=-id,name", ""},
+		{"sort", http.MethodGet, "http://localhost/v1/devices?sort=name,-id", "sorted"},
+		{"method must match", http.MethodGet, "http://localhost/v1/profiles/P1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, nil)
+			resp, err := client.Do(req)
+			if tt.want == "" {
+				var unmatched *UnmatchedRequestError
+				if !errors.As(err, &unmatched) {
+					t.Fatalf("err = %v, want *UnmatchedRequestError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != tt.want {
+				t.Errorf("body = %q, want %q", b, tt.want)
+			}
+		})
+	}
+	if n := len(r.Unmatched()); n != 3 {
+		t.Errorf("Unmatched() has %d requests, want 3", n)
+	}
+	if unused := r.Unused(); len(unused) != 1 || unused[0].Request.Method != http.MethodDelete {
+		t.Errorf("Unused() = %+v", unused)
+	}
+}
@@ -0,0 +1,119 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// JwsSigner signs JWS like the App Store does: ES256 with an x5c header
+// holding the leaf, the intermediate and a fake Apple root certificate.
+type JwsSigner struct {
+	Root         *x509.Certificate
+	Intermediate *x509.Certificate
+	Leaf         *x509.Certificate
+	Key          *ecdsa.PrivateKey // leaf 的私钥
+	X5c          []string          // header 中的证书链, 可以修改以测试不完整的链
+}
+
+func newCertificate(tpl, parent *x509.Certificate, pub, priv interface{}) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, priv)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	return cert
+}
+
+// NewJwsSigner returns a signer with a new certificate chain carrying the
+// App Store OIDs.
+func NewJwsSigner() *JwsSigner {
+	return NewJwsSignerWith(appleapi.OidAppStoreLeaf, appleapi.OidAppStoreIntermediate)
+}
+
+// NewJwsSignerWith 用给定的扩展创建证书链, 为 nil 时不加扩展, 用于测试验证失败的情况
+func NewJwsSignerWith(leafOid, intermediateOid asn1.ObjectIdentifier) *JwsSigner {
+	var keys [3]*ecdsa.PrivateKey
+	for i := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic("appleapitest: " + err.Error())
+		}
+		keys[i] = k
+	}
+	now := time.Now()
+	null := []byte{0x05, 0x00}
+	extension := func(oid asn1.ObjectIdentifier) []pkix.Extension {
+		if oid == nil {
+			return nil
+		}
+		return []pkix.Extension{{Id: oid, Value: null}}
+	}
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake Apple Root CA - G3", Organization: []string{"appleapitest"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(20, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	root = newCertificate(root, root, &keys[0].PublicKey, keys[0])
+	intermediate := newCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Fake Apple Worldwide Developer Relations Certification Authority", Organization: []string{"appleapitest"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtraExtensions:       extension(intermediateOid),
+	}, root, &keys[1].PublicKey, keys[0])
+	leaf := newCertificate(&x509.Certificate{
+		SerialNumber:    big.NewInt(3),
+		Subject:         pkix.Name{CommonName: "Fake Prod ECC Mac App Store and iTunes Store Receipt Signing", Organization: []string{"appleapitest"}},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.AddDate(2, 0, 0),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: extension(leafOid),
+	}, intermediate, &keys[2].PublicKey, keys[1])
+
+	s := &JwsSigner{Root: root, Intermediate: intermediate, Leaf: leaf, Key: keys[2]}
+	for _, c := range []*x509.Certificate{leaf, intermediate, root} {
+		s.X5c = append(s.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	return s
+}
+
+// Sign returns the compact JWS of v.
+func (s *JwsSigner) Sign(v interface{}) (string, error) {
+	header, err := json.Marshal(map[string]interface{}{"alg": "ES256", "x5c": s.X5c})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sig, err := jwt.NewES256(jwt.ECDSAPrivateKey(s.Key)).Sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,401 @@
+package appleapitest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gamebtc/appleapi"
+)
+
+// ErrNotMocked is returned by a mock method whose XxxFunc is not set.
+var ErrNotMocked = errors.New("appleapitest: mock method is not set")
+
+// Call is a recorded call of a mock method.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (r *recorder) record(method string, args ...interface{}) {
+	r.mu.Lock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+	r.mu.Unlock()
+}
+
+// Calls returns the recorded calls in order.
+func (r *recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns the recorded calls of one method.
+func (r *recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]Call, 0)
+	for _, c := range r.calls {
+		if c.Method == method {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// NewMockClient returns a Client made of mocks. A method without XxxFunc
+// falls back to the resources kept by srv, or returns ErrNotMocked when srv
+// is nil.
+//
+//	srv := appleapitest.NewServer()
+//	defer srv.Close()
+//	c := appleapitest.NewMockClient(srv)
+//	c.Profiles.(*appleapitest.ProfileServiceMock).ProfileDeleteFunc = func(id string) error { return errBoom }
+func NewMockClient(srv *Server) *appleapi.Client {
+	c := &appleapi.Client{
+		Bundles:      &BundleIDServiceMock{},
+		Certificates: &CertificateServiceMock{},
+		Devices:      &DeviceServiceMock{},
+		Profiles:     &ProfileServiceMock{},
+	}
+	if srv != nil {
+		live := appleapi.NewClient(srv.Token())
+		c.Bundles.(*BundleIDServiceMock).Fallback = live.Bundles
+		c.Certificates.(*CertificateServiceMock).Fallback = live.Certificates
+		c.Devices.(*DeviceServiceMock).Fallback = live.Devices
+		c.Profiles.(*ProfileServiceMock).Fallback = live.Profiles
+	}
+	return c
+}
+
+type BundleIDServiceMock struct {
+	QueryFunc                func(query *appleapi.ListBundlesQuery) ([]byte, error)
+	NextFunc                 func(next string) ([]byte, error)
+	BundleIdCreateFunc       func(identifier, name string) ([]byte, error)
+	BundleIdUpdateFunc       func(id, name string) ([]byte, error)
+	BundleIdDeleteFunc       func(id string) error
+	BundleIdCapabilitiesFunc func(id string, limit int) ([]byte, error)
+	CapabilityEnableFunc     func(bundleId, capabilityType string, settings []appleapi.CapabilitySetting) ([]byte, error)
+	CapabilityDisableFunc    func(id string) error
+	Fallback                 appleapi.BundleIDService // XxxFunc 为 nil 时调用, 也为 nil 时返回 ErrNotMocked
+	recorder
+}
+
+var _ appleapi.BundleIDService = (*BundleIDServiceMock)(nil)
+
+func (m *BundleIDServiceMock) Query(query *appleapi.ListBundlesQuery) ([]byte, error) {
+	m.record("Query", query)
+	if m.QueryFunc != nil {
+		return m.QueryFunc(query)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Query(query)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) Next(next string) ([]byte, error) {
+	m.record("Next", next)
+	if m.NextFunc != nil {
+		return m.NextFunc(next)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Next(next)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) BundleIdCreate(identifier, name string) ([]byte, error) {
+	m.record("BundleIdCreate", identifier, name)
+	if m.BundleIdCreateFunc != nil {
+		return m.BundleIdCreateFunc(identifier, name)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.BundleIdCreate(identifier, name)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) BundleIdUpdate(id, name string) ([]byte, error) {
+	m.record("BundleIdUpdate", id, name)
+	if m.BundleIdUpdateFunc != nil {
+		return m.BundleIdUpdateFunc(id, name)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.BundleIdUpdate(id, name)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) BundleIdDelete(id string) error {
+	m.record("BundleIdDelete", id)
+	if m.BundleIdDeleteFunc != nil {
+		return m.BundleIdDeleteFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.BundleIdDelete(id)
+	}
+	return ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) BundleIdCapabilities(id string, limit int) ([]byte, error) {
+	m.record("BundleIdCapabilities", id, limit)
+	if m.BundleIdCapabilitiesFunc != nil {
+		return m.BundleIdCapabilitiesFunc(id, limit)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.BundleIdCapabilities(id, limit)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) CapabilityEnable(bundleId, capabilityType string, settings []appleapi.CapabilitySetting) ([]byte, error) {
+	m.record("CapabilityEnable", bundleId, capabilityType, settings)
+	if m.CapabilityEnableFunc != nil {
+		return m.CapabilityEnableFunc(bundleId, capabilityType, settings)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.CapabilityEnable(bundleId, capabilityType, settings)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *BundleIDServiceMock) CapabilityDisable(id string) error {
+	m.record("CapabilityDisable", id)
+	if m.CapabilityDisableFunc != nil {
+		return m.CapabilityDisableFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.CapabilityDisable(id)
+	}
+	return ErrNotMocked
+}
+
+type CertificateServiceMock struct {
+	QueryFunc             func(query *appleapi.ListCertificatesQuery) ([]byte, error)
+	NextFunc              func(next string) ([]byte, error)
+	ReadCertificateFunc   func(id string) ([]byte, error)
+	CertificateCreateFunc func(csrContent, certificateType string) ([]byte, error)
+	CertificateRevokeFunc func(id string) error
+	Fallback              appleapi.CertificateService // XxxFunc 为 nil 时调用, 也为 nil 时返回 ErrNotMocked
+	recorder
+}
+
+var _ appleapi.CertificateService = (*CertificateServiceMock)(nil)
+
+func (m *CertificateServiceMock) Query(query *appleapi.ListCertificatesQuery) ([]byte, error) {
+	m.record("Query", query)
+	if m.QueryFunc != nil {
+		return m.QueryFunc(query)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Query(query)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *CertificateServiceMock) Next(next string) ([]byte, error) {
+	m.record("Next", next)
+	if m.NextFunc != nil {
+		return m.NextFunc(next)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Next(next)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *CertificateServiceMock) CertificateCreate(csrContent, certificateType string) ([]byte, error) {
+	m.record("CertificateCreate", csrContent, certificateType)
+	if m.CertificateCreateFunc != nil {
+		return m.CertificateCreateFunc(csrContent, certificateType)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.CertificateCreate(csrContent, certificateType)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *CertificateServiceMock) ReadCertificate(id string) ([]byte, error) {
+	m.record("ReadCertificate", id)
+	if m.ReadCertificateFunc != nil {
+		return m.ReadCertificateFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ReadCertificate(id)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *CertificateServiceMock) CertificateRevoke(id string) error {
+	m.record("CertificateRevoke", id)
+	if m.CertificateRevokeFunc != nil {
+		return m.CertificateRevokeFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.CertificateRevoke(id)
+	}
+	return ErrNotMocked
+}
+
+type DeviceServiceMock struct {
+	QueryFunc        func(query *appleapi.ListDevicesQuery) ([]byte, error)
+	NextFunc         func(next string) ([]byte, error)
+	DeviceCreateFunc func(udid, name string) ([]byte, error)
+	DeviceUpdateFunc func(id, name, status string) ([]byte, error)
+	Fallback         appleapi.DeviceService // XxxFunc 为 nil 时调用, 也为 nil 时返回 ErrNotMocked
+	recorder
+}
+
+var _ appleapi.DeviceService = (*DeviceServiceMock)(nil)
+
+func (m *DeviceServiceMock) Query(query *appleapi.ListDevicesQuery) ([]byte, error) {
+	m.record("Query", query)
+	if m.QueryFunc != nil {
+		return m.QueryFunc(query)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Query(query)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *DeviceServiceMock) Next(next string) ([]byte, error) {
+	m.record("Next", next)
+	if m.NextFunc != nil {
+		return m.NextFunc(next)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Next(next)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *DeviceServiceMock) DeviceCreate(udid, name string) ([]byte, error) {
+	m.record("DeviceCreate", udid, name)
+	if m.DeviceCreateFunc != nil {
+		return m.DeviceCreateFunc(udid, name)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.DeviceCreate(udid, name)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *DeviceServiceMock) DeviceUpdate(id, name, status string) ([]byte, error) {
+	m.record("DeviceUpdate", id, name, status)
+	if m.DeviceUpdateFunc != nil {
+		return m.DeviceUpdateFunc(id, name, status)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.DeviceUpdate(id, name, status)
+	}
+	return nil, ErrNotMocked
+}
+
+type ProfileServiceMock struct {
+	QueryFunc                 func(query *appleapi.ListProfilesQuery) ([]byte, error)
+	NextFunc                  func(next string) ([]byte, error)
+	ReadProfileFunc           func(id string) ([]byte, error)
+	ProfileCertificatesFunc   func(id string, limit int) ([]byte, error)
+	ProfileDevicesFunc        func(id string, limit int) ([]byte, error)
+	ProfileCreateFunc         func(name, bundleId string, certificates, devices []string) ([]byte, error)
+	ProfileCreateWithTypeFunc func(name, profileType, bundleId string, certificates, devices []string) ([]byte, error)
+	ProfileDeleteFunc         func(id string) error
+	Fallback                  appleapi.ProfileService // XxxFunc 为 nil 时调用, 也为 nil 时返回 ErrNotMocked
+	recorder
+}
+
+var _ appleapi.ProfileService = (*ProfileServiceMock)(nil)
+
+func (m *ProfileServiceMock) Query(query *appleapi.ListProfilesQuery) ([]byte, error) {
+	m.record("Query", query)
+	if m.QueryFunc != nil {
+		return m.QueryFunc(query)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Query(query)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) Next(next string) ([]byte, error) {
+	m.record("Next", next)
+	if m.NextFunc != nil {
+		return m.NextFunc(next)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.Next(next)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ReadProfile(id string) ([]byte, error) {
+	m.record("ReadProfile", id)
+	if m.ReadProfileFunc != nil {
+		return m.ReadProfileFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ReadProfile(id)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ProfileCertificates(id string, limit int) ([]byte, error) {
+	m.record("ProfileCertificates", id, limit)
+	if m.ProfileCertificatesFunc != nil {
+		return m.ProfileCertificatesFunc(id, limit)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ProfileCertificates(id, limit)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ProfileDevices(id string, limit int) ([]byte, error) {
+	m.record("ProfileDevices", id, limit)
+	if m.ProfileDevicesFunc != nil {
+		return m.ProfileDevicesFunc(id, limit)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ProfileDevices(id, limit)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ProfileCreate(name, bundleId string, certificates, devices []string) ([]byte, error) {
+	m.record("ProfileCreate", name, bundleId, certificates, devices)
+	if m.ProfileCreateFunc != nil {
+		return m.ProfileCreateFunc(name, bundleId, certificates, devices)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ProfileCreate(name, bundleId, certificates, devices)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ProfileCreateWithType(name, profileType, bundleId string, certificates, devices []string) ([]byte, error) {
+	m.record("ProfileCreateWithType", name, profileType, bundleId, certificates, devices)
+	if m.ProfileCreateWithTypeFunc != nil {
+		return m.ProfileCreateWithTypeFunc(name, profileType, bundleId, certificates, devices)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ProfileCreateWithType(name, profileType, bundleId, certificates, devices)
+	}
+	return nil, ErrNotMocked
+}
+
+func (m *ProfileServiceMock) ProfileDelete(id string) error {
+	m.record("ProfileDelete", id)
+	if m.ProfileDeleteFunc != nil {
+		return m.ProfileDeleteFunc(id)
+	}
+	if m.Fallback != nil {
+		return m.Fallback.ProfileDelete(id)
+	}
+	return ErrNotMocked
+}
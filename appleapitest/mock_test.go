@@ -0,0 +1,67 @@
+package appleapitest
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+)
+
+func TestMockClientFallsBackToServer(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := NewMockClient(srv)
+	devices := c.Devices.(*DeviceServiceMock)
+
+	if _, err := c.Devices.DeviceCreate("00008020-000A4C2E1A88002E", "iPhone"); err != nil {
+		t.Fatal(err)
+	}
+	errQuota := errors.New("quota")
+	devices.DeviceCreateFunc = func(udid, name string) ([]byte, error) { return nil, errQuota }
+	if _, err := c.Devices.DeviceCreate("00008020-000A4C2E1A88002F", "iPad"); err != errQuota {
+		t.Fatalf("DeviceCreate() = %v, want the mocked error", err)
+	}
+
+	list, err := c.AllDevices(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Attributes.Name != "iPhone" {
+		t.Errorf("AllDevices() = %+v, want the one device kept by the server", list)
+	}
+	if calls := devices.CallsTo("DeviceCreate"); len(calls) != 2 || calls[1].Args[1] != "iPad" {
+		t.Errorf("DeviceCreate calls = %+v", calls)
+	}
+}
+
+func TestMockClientProfileCreate(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := NewMockClient(srv)
+	bundle := srv.AddBundleId(appleapi.BundleId{})
+	cert := srv.AddCertificate(appleapi.Certificate{})
+
+	b, err := c.Profiles.ProfileCreateWithType("adhoc", "IOS_APP_ADHOC", bundle.Id, []string{cert.Id}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &appleapi.ProfileResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Attributes.ProfileType != "IOS_APP_ADHOC" {
+		t.Errorf("profileType = %q", resp.Data.Attributes.ProfileType)
+	}
+	// 没有类型的请求由服务端拒绝, 与 App Store Connect 相同
+	if _, err = c.Profiles.ProfileCreate("untyped", bundle.Id, []string{cert.Id}, nil); err == nil {
+		t.Error("ProfileCreate() without a profile type succeeded")
+	}
+}
+
+func TestMockClientWithoutServer(t *testing.T) {
+	c := NewMockClient(nil)
+	if _, err := c.Certificates.ReadCertificate("C1"); err != ErrNotMocked {
+		t.Errorf("ReadCertificate() = %v, want ErrNotMocked", err)
+	}
+}
@@ -0,0 +1,221 @@
+package appleapitest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+// sentNotification 是通知历史中的一条通知
+type sentNotification struct {
+	payload    appleapi.ResponseBodyV2DecodedPayload
+	signed     string
+	originalId string // 通知中交易的 originalTransactionId
+	testToken  string
+	attempts   []appleapi.SendAttempt
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// notification 签名一条通知并记录在通知历史中, 调用时持有 s.mu
+func (s *ServerApiServer) notification(notificationType, subtype, transactionId string) (*sentNotification, error) {
+	now := time.Now()
+	data := &appleapi.NotificationData{
+		AppAppleId:    TestAppAppleId,
+		BundleId:      s.Bid,
+		BundleVersion: "1",
+		Environment:   s.Environment,
+	}
+	n := &sentNotification{}
+	if transactionId != "" {
+		t := s.transaction(transactionId)
+		if t == nil {
+			return nil, fmt.Errorf("appleapitest: transaction %s not found", transactionId)
+		}
+		n.originalId = t.OriginalTransactionId
+		data.SignedTransactionInfo = s.signTransaction(t)
+		if t.Type == appleapi.ProductTypeAutoRenewable {
+			renewal := s.renewalInfo(t, now)
+			data.SignedRenewalInfo = s.Sign(&renewal)
+			data.Status = appleapi.SubscriptionActive
+			switch {
+			case t.RevocationDate != 0:
+				data.Status = appleapi.SubscriptionRevoked
+			case t.Expired(now):
+				data.Status = appleapi.SubscriptionExpired
+			}
+		}
+	}
+	n.payload = appleapi.ResponseBodyV2DecodedPayload{
+		NotificationType: notificationType,
+		Subtype:          subtype,
+		NotificationUUID: newUUID(),
+		Data:             data,
+		Version:          "2.0",
+		SignedDate:       millis(now),
+	}
+	n.signed = s.Sign(&n.payload)
+	s.notifications = append(s.notifications, n)
+	return n, nil
+}
+
+// send POST 通知到 NotificationUrl 并记录结果, 调用时不能持有 s.mu
+func (s *ServerApiServer) send(n *sentNotification, url string) {
+	if url == "" {
+		return
+	}
+	result := appleapi.SendAttemptSuccess
+	resp, err := http.Post(url, "application/json", bytes.NewReader(notificationBody(n.signed)))
+	if err != nil {
+		result = appleapi.SendAttemptNoResponse
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			result = appleapi.SendAttemptUnsuccessfulHttpCode
+		}
+	}
+	s.mu.Lock()
+	n.attempts = append(n.attempts, appleapi.SendAttempt{AttemptDate: millis(time.Now()), SendAttemptResult: result})
+	s.mu.Unlock()
+}
+
+func notificationBody(signedPayload string) []byte {
+	b, _ := json.Marshal(&appleapi.ResponseBodyV2{SignedPayload: signedPayload})
+	return b
+}
+
+// Notify records a notification about transactionId, or with no transaction
+// when it is empty, posts it to NotificationUrl when set and returns the
+// request body Apple posts, so tests can also deliver it again.
+func (s *ServerApiServer) Notify(notificationType, subtype, transactionId string) []byte {
+	s.mu.Lock()
+	n, err := s.notification(notificationType, subtype, transactionId)
+	url := s.NotificationUrl
+	s.mu.Unlock()
+	if err != nil {
+		panic(err.Error())
+	}
+	s.send(n, url)
+	return notificationBody(n.signed)
+}
+
+func (s *ServerApiServer) serveNotifications(w http.ResponseWriter, r *http.Request, path, id string) {
+	switch {
+	case r.Method == http.MethodPost && path == "/inApps/v1/notifications/test":
+		s.mu.Lock()
+		url := s.NotificationUrl
+		if url == "" {
+			s.mu.Unlock()
+			serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeServerNotificationUrlNotFound, "No App Store Server Notification URL found for provided app.")
+			return
+		}
+		n, _ := s.notification(appleapi.NotificationTest, "", "")
+		n.testToken = newUUID() + "_" + strconv.FormatInt(n.payload.SignedDate, 10)
+		s.mu.Unlock()
+		s.send(n, url)
+		writeJson(w, http.StatusOK, &appleapi.SendTestNotificationResponse{TestNotificationToken: n.testToken})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v1/notifications/test/"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, n := range s.notifications {
+			if n.testToken != "" && n.testToken == id {
+				writeJson(w, http.StatusOK, &appleapi.CheckTestNotificationResponse{SignedPayload: n.signed, SendAttempts: n.attempts})
+				return
+			}
+		}
+		serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTestNotificationNotFound, "Either the test notification token is expired or the notification and status are not yet available.")
+	case r.Method == http.MethodPost && path == "/inApps/v1/notifications/history":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.notificationHistory(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *ServerApiServer) notificationHistory(w http.ResponseWriter, r *http.Request) {
+	req := &appleapi.NotificationHistoryRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	now := millis(time.Now())
+	if req.StartDate <= 0 || req.StartDate < now-180*24*3600*1000 {
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidStartDate, "Invalid request start date.")
+		return
+	}
+	if req.EndDate <= req.StartDate {
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidEndDate, "Invalid request end date.")
+		return
+	}
+	if req.TransactionId != "" && req.NotificationType != "" {
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeMultipleFiltersSupplied, "Invalid request. Supply either a transaction id or a notification type, but not both.")
+		return
+	}
+	orig := ""
+	if req.TransactionId != "" {
+		t := s.transaction(req.TransactionId)
+		if t == nil {
+			serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTransactionIdNotFound, "Transaction id not found.")
+			return
+		}
+		orig = t.OriginalTransactionId
+	}
+
+	var list []*sentNotification
+	for _, n := range s.notifications {
+		p := &n.payload
+		if p.SignedDate < req.StartDate || p.SignedDate >= req.EndDate ||
+			req.NotificationType != "" && p.NotificationType != req.NotificationType ||
+			req.NotificationSubtype != "" && p.Subtype != req.NotificationSubtype ||
+			orig != "" && n.originalId != orig {
+			continue
+		}
+		if req.OnlyFailures {
+			last := len(n.attempts) - 1
+			if last < 0 || n.attempts[last].SendAttemptResult == appleapi.SendAttemptSuccess {
+				continue
+			}
+		}
+		list = append(list, n)
+	}
+
+	start := 0
+	if v := r.URL.Query().Get("paginationToken"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > len(list) {
+			serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidPaginationToken, "Invalid pagination token.")
+			return
+		}
+		start = n
+	}
+	size := s.PageSize
+	if size <= 0 {
+		size = 20
+	}
+	end := start + size
+	if end > len(list) {
+		end = len(list)
+	}
+	resp := &appleapi.NotificationHistoryResponse{NotificationHistory: []appleapi.NotificationHistoryItem{}, HasMore: end < len(list)}
+	if resp.HasMore {
+		resp.PaginationToken = strconv.Itoa(end)
+	}
+	for _, n := range list[start:end] {
+		resp.NotificationHistory = append(resp.NotificationHistory, appleapi.NotificationHistoryItem{SignedPayload: n.signed, SendAttempts: n.attempts})
+	}
+	writeJson(w, http.StatusOK, resp)
+}
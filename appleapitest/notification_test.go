@@ -0,0 +1,158 @@
+package appleapitest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/sirupsen/logrus"
+)
+
+func deliver(h http.Handler, body []byte) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", bytes.NewReader(body)))
+	return w.Code
+}
+
+func newNotificationHandler(srv *ServerApiServer) *appleapi.ServerNotificationHandler {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return &appleapi.ServerNotificationHandler{
+		Verifier: srv.JwsVerifier(),
+		Store:    appleapi.NewMemoryNotificationStore(),
+		Logger:   log,
+	}
+}
+
+func TestNotificationHandlerDedup(t *testing.T) {
+	srv := NewServerApiServer()
+	defer srv.Close()
+	body := srv.Notify(appleapi.NotificationTest, "", "")
+
+	h := newNotificationHandler(srv)
+	var calls int32
+	h.OnTest = func(ctx context.Context, n *appleapi.ServerNotification) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("database is down")
+		}
+		return nil
+	}
+	// 第一次失败后释放, 苹果重试时再次处理, 之后的重复送达直接确认
+	for i, want := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		if code := deliver(h, body); code != want {
+			t.Errorf("delivery %d: %d, want %d", i, code, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("OnTest called %d times, want 2", calls)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", bytes.NewReader([]byte(`{"signedPayload":"a.b.c"}`))))
+	if w.Code != http.StatusUnauthorized || w.Body.String() != "invalid notification\n" {
+		t.Errorf("forged notification: %d %q, want 401 without the reason", w.Code, w.Body.String())
+	}
+}
+
+func TestNotificationHandlerReleasesOnPanic(t *testing.T) {
+	srv := NewServerApiServer()
+	defer srv.Close()
+	body := srv.Notify(appleapi.NotificationTest, "", "")
+
+	h := newNotificationHandler(srv)
+	h.OnTest = func(ctx context.Context, n *appleapi.ServerNotification) error { panic("bug") }
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("OnTest did not panic")
+			}
+		}()
+		deliver(h, body)
+	}()
+
+	// net/http 恢复 panic 后苹果会重试, 通知不能一直处于占用状态
+	var calls int32
+	h.OnTest = func(ctx context.Context, n *appleapi.ServerNotification) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+	if code := deliver(h, body); code != http.StatusOK || calls != 1 {
+		t.Errorf("retry after a panic: %d, OnTest called %d times", code, calls)
+	}
+}
+
+func TestNotificationHandlerConcurrentDelivery(t *testing.T) {
+	srv := NewServerApiServer()
+	defer srv.Close()
+	body := srv.Notify(appleapi.NotificationTest, "", "")
+
+	h := newNotificationHandler(srv)
+	var calls int32
+	release := make(chan struct{})
+	h.OnTest = func(ctx context.Context, n *appleapi.ServerNotification) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}
+
+	const n = 10
+	codes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() { codes <- deliver(h, body) }()
+	}
+	// 处理中的通知再次送达时不确认, 让苹果稍后重试
+	for i := 0; i < n-1; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("concurrent delivery: %d, want %d", code, http.StatusServiceUnavailable)
+		}
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("claimed delivery: %d", code)
+	}
+	if code := deliver(h, body); code != http.StatusOK {
+		t.Errorf("retry after processing: %d", code)
+	}
+	if calls != 1 {
+		t.Errorf("OnTest called %d times, want 1", calls)
+	}
+}
+
+func TestMemoryNotificationStoreClaim(t *testing.T) {
+	s := appleapi.NewMemoryNotificationStore()
+	if !s.Claim("a") || s.Claim("a") {
+		t.Fatal("a uuid can only be claimed once")
+	}
+	s.Release("a")
+	if !s.Claim("a") {
+		t.Fatal("released uuid cannot be claimed")
+	}
+	s.MarkProcessed("a")
+	if !s.Processed("a") || s.Claim("a") {
+		t.Error("processed uuid can be claimed")
+	}
+}
+
+func TestMemoryNotificationStoreClaimTTL(t *testing.T) {
+	s := appleapi.NewMemoryNotificationStore()
+	s.ClaimTTL = 20 * time.Millisecond
+	if !s.Claim("a") || s.Claim("a") {
+		t.Fatal("a uuid can only be claimed once")
+	}
+	// 没有 Release 的占用过期后可以再次处理
+	time.Sleep(30 * time.Millisecond)
+	if !s.Claim("a") {
+		t.Fatal("expired claim cannot be claimed again")
+	}
+	s.MarkProcessed("a")
+	time.Sleep(30 * time.Millisecond)
+	if s.Claim("a") {
+		t.Error("processed uuid can be claimed after the claim TTL")
+	}
+}
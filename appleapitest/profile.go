@@ -0,0 +1,73 @@
+package appleapitest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+func newUuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+// profilePlist returns the property list of a provisioning profile. Real
+// .mobileprovision files wrap it in a CMS signature, the fake does not.
+func profilePlist(p *appleapi.Profile, bundle *appleapi.BundleId, certs []*appleapi.Certificate, devices []*appleapi.Device) []byte {
+	sb := strings.Builder{}
+	key := func(k string) {
+		sb.WriteString("\t<key>" + k + "</key>\n")
+	}
+	str := func(k, v string) {
+		key(k)
+		sb.WriteString("\t<string>" + html.EscapeString(v) + "</string>\n")
+	}
+	date := func(k, v string) {
+		t, _ := time.Parse(DateLayout, v)
+		key(k)
+		sb.WriteString("\t<date>" + t.UTC().Format(time.RFC3339) + "</date>\n")
+	}
+
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	sb.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	str("AppIDName", bundle.Attributes.Name)
+	key("ApplicationIdentifierPrefix")
+	sb.WriteString("\t<array><string>" + bundle.Attributes.SeedId + "</string></array>\n")
+	date("CreationDate", p.Attributes.CreatedDate)
+	key("DeveloperCertificates")
+	sb.WriteString("\t<array>\n")
+	for _, c := range certs {
+		sb.WriteString("\t\t<data>" + c.Attributes.CertificateContent + "</data>\n")
+	}
+	sb.WriteString("\t</array>\n")
+	key("Entitlements")
+	sb.WriteString("\t<dict>\n")
+	sb.WriteString("\t\t<key>application-identifier</key>\n")
+	sb.WriteString("\t\t<string>" + bundle.Attributes.SeedId + "." + html.EscapeString(bundle.Attributes.Identifier) + "</string>\n")
+	sb.WriteString("\t</dict>\n")
+	date("ExpirationDate", p.Attributes.ExpirationDate)
+	str("Name", p.Attributes.Name)
+	if len(devices) > 0 {
+		key("ProvisionedDevices")
+		sb.WriteString("\t<array>\n")
+		for _, d := range devices {
+			sb.WriteString("\t\t<string>" + html.EscapeString(d.Attributes.Udid) + "</string>\n")
+		}
+		sb.WriteString("\t</array>\n")
+	}
+	key("TeamIdentifier")
+	sb.WriteString("\t<array><string>" + bundle.Attributes.SeedId + "</string></array>\n")
+	str("UUID", p.Attributes.Uuid)
+	key("Version")
+	sb.WriteString("\t<integer>1</integer>\n")
+	sb.WriteString("</dict>\n</plist>\n")
+	return []byte(sb.String())
+}
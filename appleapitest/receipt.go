@@ -0,0 +1,374 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+// TestSharedSecret is the app-specific shared secret of ReceiptServer.
+const TestSharedSecret = "0123456789abcdef0123456789abcdef"
+
+// ReceiptServer is a fake of the production and sandbox verifyReceipt
+// endpoints, served at /verifyReceipt and /sandbox/verifyReceipt. Receipts
+// added with AddReceipt are valid only in their own environment, like real
+// ones, so the sandbox fallback of appleapi.ReceiptVerifier can be tested.
+// SignReceipt creates on-device receipts for appleapi.LocalReceiptVerifier.
+type ReceiptServer struct {
+	*httptest.Server
+	Password string // 共享密钥, 默认 TestSharedSecret
+
+	signer *JwsSigner
+
+	mu       sync.Mutex
+	receipts map[string]*appleapi.VerifyReceiptResponse
+	status   map[string]int // 收据固定返回的 status
+	requests []appleapi.VerifyReceiptRequest
+}
+
+func NewReceiptServer() *ReceiptServer {
+	s := &ReceiptServer{
+		Password: TestSharedSecret,
+		signer:   NewJwsSigner(),
+		receipts: make(map[string]*appleapi.VerifyReceiptResponse),
+		status:   make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/verifyReceipt", func(w http.ResponseWriter, r *http.Request) {
+		s.serveVerify(w, r, appleapi.EnvironmentProduction)
+	})
+	mux.HandleFunc("/sandbox/verifyReceipt", func(w http.ResponseWriter, r *http.Request) {
+		s.serveVerify(w, r, appleapi.EnvironmentSandbox)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// ReceiptVerifier returns a verifier for the fake endpoints.
+func (s *ReceiptServer) ReceiptVerifier() *appleapi.ReceiptVerifier {
+	v := appleapi.NewReceiptVerifier(s.Password)
+	v.ProductionUrl = s.URL + "/verifyReceipt"
+	v.SandboxUrl = s.URL + "/sandbox/verifyReceipt"
+	return v
+}
+
+// AddReceipt registers the response of a receipt and returns its base64
+// receipt data. The environment is taken from resp.Environment, Sandbox when
+// empty.
+func (s *ReceiptServer) AddReceipt(resp appleapi.VerifyReceiptResponse) string {
+	if resp.Environment == "" {
+		resp.Environment = appleapi.EnvironmentSandbox
+	}
+	data := base64.StdEncoding.EncodeToString([]byte("receipt-" + randomToken()))
+	resp.Status = appleapi.ReceiptStatusOk
+	resp.LatestReceipt = data
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receipts[data] = &resp
+	return data
+}
+
+// SetStatus makes verifyReceipt answer receiptData with status, e.g.
+// appleapi.ReceiptStatusServerUnavailable; 0 restores the normal response.
+func (s *ReceiptServer) SetStatus(receiptData string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.status, receiptData)
+	} else {
+		s.status[receiptData] = status
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *ReceiptServer) Requests() []appleapi.VerifyReceiptRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]appleapi.VerifyReceiptRequest(nil), s.requests...)
+}
+
+func (s *ReceiptServer) serveVerify(w http.ResponseWriter, r *http.Request, environment string) {
+	// verifyReceipt 的错误也是 200 响应, 错误在 status 中
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusOK, &appleapi.VerifyReceiptResponse{Status: appleapi.ReceiptStatusBadMethod, Environment: environment})
+		return
+	}
+	req := appleapi.VerifyReceiptRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJson(w, http.StatusOK, &appleapi.VerifyReceiptResponse{Status: appleapi.ReceiptStatusBadData, Environment: environment})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+
+	if status, ok := s.status[req.ReceiptData]; ok {
+		writeJson(w, http.StatusOK, &appleapi.VerifyReceiptResponse{
+			Status:      status,
+			Environment: environment,
+			IsRetryable: status >= 21100 && status <= 21199,
+		})
+		return
+	}
+	receipt, ok := s.receipts[req.ReceiptData]
+	status := appleapi.ReceiptStatusOk
+	switch {
+	case !ok:
+		status = appleapi.ReceiptStatusBadData
+	case receipt.Environment == appleapi.EnvironmentSandbox && environment == appleapi.EnvironmentProduction:
+		status = appleapi.ReceiptStatusSandboxReceipt
+	case receipt.Environment == appleapi.EnvironmentProduction && environment == appleapi.EnvironmentSandbox:
+		status = appleapi.ReceiptStatusProductionReceipt
+	case len(receipt.LatestReceiptInfo) > 0 && req.Password != s.Password:
+		// 包含自动续订订阅的收据需要共享密钥
+		status = appleapi.ReceiptStatusBadSecret
+	}
+	if status != appleapi.ReceiptStatusOk {
+		writeJson(w, http.StatusOK, &appleapi.VerifyReceiptResponse{Status: status, Environment: environment})
+		return
+	}
+
+	resp := *receipt
+	if resp.Receipt != nil {
+		v := *resp.Receipt
+		v.RequestDate = appleapi.ReceiptTime{Time: time.Now()}
+		resp.Receipt = &v
+	}
+	if len(resp.LatestReceiptInfo) == 0 {
+		resp.LatestReceipt = ""
+	} else if req.ExcludeOldTransactions {
+		// 只保留每个订阅最新的交易
+		latest := make(map[string]int)
+		var list []appleapi.InAppReceipt
+		for _, t := range resp.LatestReceiptInfo {
+			if i, ok := latest[t.OriginalTransactionId]; !ok {
+				latest[t.OriginalTransactionId] = len(list)
+				list = append(list, t)
+			} else if t.PurchaseDate.After(list[i].PurchaseDate.Time) {
+				list[i] = t
+			}
+		}
+		resp.LatestReceiptInfo = list
+	}
+	writeJson(w, http.StatusOK, &resp)
+}
+
+// RootCertificate returns the root the receipts of SignReceipt chain to, for
+// use in place of Apple Inc. Root.
+func (s *ReceiptServer) RootCertificate() *x509.Certificate {
+	return s.signer.Root
+}
+
+// LeafCertificate returns the certificate that signs the receipts.
+func (s *ReceiptServer) LeafCertificate() *x509.Certificate {
+	return s.signer.Leaf
+}
+
+// LocalReceiptVerifier returns a verifier that trusts RootCertificate.
+func (s *ReceiptServer) LocalReceiptVerifier() *appleapi.LocalReceiptVerifier {
+	return appleapi.NewLocalReceiptVerifier(s.signer.Root)
+}
+
+// receiptAttribute 是收据载荷中的一个属性
+type receiptAttribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+func mustMarshal(v interface{}, params string) []byte {
+	b, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	return b
+}
+
+func utf8Attribute(typ int, v string) receiptAttribute {
+	return receiptAttribute{Type: typ, Version: 1, Value: mustMarshal(v, "utf8")}
+}
+
+func intAttribute(typ int, v int64) receiptAttribute {
+	return receiptAttribute{Type: typ, Version: 1, Value: mustMarshal(v, "")}
+}
+
+func dateAttribute(typ int, t appleapi.ReceiptTime) receiptAttribute {
+	v := ""
+	if !t.IsZero() {
+		v = t.UTC().Format(time.RFC3339)
+	}
+	return receiptAttribute{Type: typ, Version: 1, Value: mustMarshal(v, "ia5")}
+}
+
+func boolInt(b appleapi.FlexBool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func inAppAttributes(r *appleapi.InAppReceipt) []receiptAttribute {
+	quantity, _ := strconv.ParseInt(r.Quantity, 10, 64)
+	if quantity == 0 {
+		quantity = 1
+	}
+	webOrder, _ := strconv.ParseInt(r.WebOrderLineItemId, 10, 64)
+	attrs := []receiptAttribute{
+		intAttribute(appleapi.InAppFieldQuantity, quantity),
+		utf8Attribute(appleapi.InAppFieldProductId, r.ProductId),
+		utf8Attribute(appleapi.InAppFieldTransactionId, r.TransactionId),
+		utf8Attribute(appleapi.InAppFieldOriginalTransactionId, r.OriginalTransactionId),
+		dateAttribute(appleapi.InAppFieldPurchaseDate, r.PurchaseDate),
+		dateAttribute(appleapi.InAppFieldOriginalPurchaseDate, r.OriginalPurchaseDate),
+		dateAttribute(appleapi.InAppFieldExpiresDate, r.ExpiresDate),
+		intAttribute(appleapi.InAppFieldWebOrderLineItemId, webOrder),
+		dateAttribute(appleapi.InAppFieldCancellationDate, r.CancellationDate),
+		intAttribute(appleapi.InAppFieldIsTrialPeriod, boolInt(r.IsTrialPeriod)),
+		intAttribute(appleapi.InAppFieldIsInIntroOfferPeriod, boolInt(r.IsInIntroOfferPeriod)),
+	}
+	if r.PromotionalOfferId != "" {
+		attrs = append(attrs, utf8Attribute(appleapi.InAppFieldPromotionalOfferId, r.PromotionalOfferId))
+	}
+	return attrs
+}
+
+type pkcs7SignerInfo struct {
+	Version         int
+	IssuerAndSerial struct {
+		Issuer asn1.RawValue
+		Serial *big.Int
+	}
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+// explicit 返回 [0] 标签包装的 DER
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// SignReceipt returns r as a DER PKCS#7 receipt like the one in the app's
+// bundle, signed with ECDSA SHA-256 over signed attributes holding the
+// content digest. The SHA-1 hash in the receipt is computed for deviceId.
+func (s *ReceiptServer) SignReceipt(r appleapi.AppReceipt, deviceId []byte) []byte {
+	return s.SignReceiptWith(r, deviceId, func(content []byte) []byte {
+		sum := sha256.Sum256(content)
+		return sum[:]
+	})
+}
+
+// SignReceiptWith 签名收据, messageDigest 返回签名属性中的摘要, 为 nil 时不加签名属性直接签名内容,
+// 用于测试旧格式的收据和摘要不匹配的情况
+func (s *ReceiptServer) SignReceiptWith(r appleapi.AppReceipt, deviceId []byte, messageDigest func(content []byte) []byte) []byte {
+	if r.ReceiptCreationDate.IsZero() {
+		r.ReceiptCreationDate = appleapi.ReceiptTime{Time: time.Now()}
+	}
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+	bundleId := utf8Attribute(appleapi.ReceiptFieldBundleId, r.BundleId)
+	h := sha1.New()
+	h.Write(deviceId)
+	h.Write(opaque)
+	h.Write(bundleId.Value)
+
+	attrs := []receiptAttribute{
+		utf8Attribute(appleapi.ReceiptFieldReceiptType, r.ReceiptType),
+		intAttribute(appleapi.ReceiptFieldAppItemId, r.AppItemId),
+		bundleId,
+		utf8Attribute(appleapi.ReceiptFieldApplicationVersion, r.ApplicationVersion),
+		{Type: appleapi.ReceiptFieldOpaqueValue, Version: 1, Value: opaque},
+		{Type: appleapi.ReceiptFieldSha1Hash, Version: 1, Value: h.Sum(nil)},
+		dateAttribute(appleapi.ReceiptFieldCreationDate, r.ReceiptCreationDate),
+		utf8Attribute(appleapi.ReceiptFieldOriginalApplicationVersion, r.OriginalApplicationVersion),
+	}
+	if !r.ExpirationDate.IsZero() {
+		attrs = append(attrs, dateAttribute(appleapi.ReceiptFieldExpirationDate, r.ExpirationDate))
+	}
+	for i := range r.InApp {
+		iap := mustMarshal(inAppAttributes(&r.InApp[i]), "set")
+		attrs = append(attrs, receiptAttribute{Type: appleapi.ReceiptFieldInAppPurchase, Version: 1, Value: iap})
+	}
+	content := mustMarshal(attrs, "set")
+
+	// 有签名属性时签名的是属性 SET OF 的 DER 编码, 在 SignerInfo 中改为 [0] 标签
+	signed := content
+	var authenticated asn1.RawValue
+	if messageDigest != nil {
+		signed = mustMarshal([]pkcs7Attribute{
+			{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, Values: []asn1.RawValue{{FullBytes: mustMarshal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}, "")}}},
+			{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}, Values: []asn1.RawValue{{FullBytes: mustMarshal(messageDigest(content), "")}}},
+		}, "set")
+		authenticated.FullBytes = append([]byte{0xa0}, signed[1:]...)
+	}
+	digest := sha256.Sum256(signed)
+	sigR, sigS, err := ecdsa.Sign(rand.Reader, s.signer.Key, digest[:])
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	sig := mustMarshal(struct{ R, S *big.Int }{sigR, sigS}, "")
+
+	leaf := s.signer.Leaf
+	si := pkcs7SignerInfo{
+		Version:                   1,
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+		AuthenticatedAttributes:   authenticated,
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		EncryptedDigest:           sig,
+	}
+	si.IssuerAndSerial.Issuer = asn1.RawValue{FullBytes: leaf.RawIssuer}
+	si.IssuerAndSerial.Serial = leaf.SerialNumber
+
+	var certs []byte
+	for _, c := range []*x509.Certificate{leaf, s.signer.Intermediate, s.signer.Root} {
+		certs = append(certs, c.Raw...)
+	}
+	certSet := explicit(certs)
+	sd := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{si.DigestAlgorithm},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1},
+			Content:     explicit(mustMarshal(content, "")),
+		},
+		Certificates: certSet,
+		SignerInfos:  []pkcs7SignerInfo{si},
+	}
+	return mustMarshal(pkcs7ContentInfo{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     explicit(mustMarshal(sd, "")),
+	}, "")
+}
@@ -0,0 +1,802 @@
+package appleapitest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+const (
+	TestSeedId = "TEAMID1234"
+
+	DateLayout = appleapi.DateLayout
+)
+
+func (s *Server) newId() string {
+	s.nextId++
+	return fmt.Sprintf("%s%06d", TestSeedId, s.nextId)
+}
+
+func (s *Server) put(v appleapi.Resource) {
+	ref := v.Ref()
+	if _, ok := s.resources[ref]; !ok {
+		s.order = append(s.order, ref)
+	}
+	s.resources[ref] = v
+}
+
+func (s *Server) remove(ref appleapi.TypeId) {
+	delete(s.resources, ref)
+	for i, v := range s.order {
+		if v == ref {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Server) all(typ string) []appleapi.Resource {
+	list := make([]appleapi.Resource, 0)
+	for _, ref := range s.order {
+		if ref.Type == typ {
+			list = append(list, s.resources[ref])
+		}
+	}
+	return list
+}
+
+func (s *Server) selfLink(typ, id string) appleapi.ResourceLinks {
+	return appleapi.ResourceLinks{Self: s.URL + "/v1/" + typ + "/" + id}
+}
+
+// AddBundleId stores a bundle ID, assigning an id if it has none.
+func (s *Server) AddBundleId(b appleapi.BundleId) *appleapi.BundleId {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.Id == "" {
+		b.Id = s.newId()
+	}
+	if b.Attributes.SeedId == "" {
+		b.Attributes.SeedId = TestSeedId
+	}
+	b.Type = appleapi.TypeBundleIds
+	b.Links = s.selfLink(b.Type, b.Id)
+	s.put(&b)
+	return &b
+}
+
+// AddCertificate stores a certificate, assigning an id if it has none.
+func (s *Server) AddCertificate(c appleapi.Certificate) *appleapi.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.Id == "" {
+		c.Id = s.newId()
+	}
+	c.Type = appleapi.TypeCertificates
+	c.Links = s.selfLink(c.Type, c.Id)
+	s.put(&c)
+	return &c
+}
+
+// AddDevice stores a device, assigning an id if it has none.
+func (s *Server) AddDevice(d appleapi.Device) *appleapi.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.Id == "" {
+		d.Id = s.newId()
+	}
+	if d.Attributes.Status == "" {
+		d.Attributes.Status = "ENABLED"
+	}
+	d.Type = appleapi.TypeDevices
+	d.Links = s.selfLink(d.Type, d.Id)
+	s.put(&d)
+	return &d
+}
+
+// AddProfile stores a profile, assigning an id if it has none.
+func (s *Server) AddProfile(p appleapi.Profile) *appleapi.Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.Id == "" {
+		p.Id = s.newId()
+	}
+	if p.Attributes.ProfileState == "" {
+		p.Attributes.ProfileState = "ACTIVE"
+	}
+	p.Type = appleapi.TypeProfiles
+	p.Links = s.selfLink(p.Type, p.Id)
+	s.put(&p)
+	s.linkProfile(&p)
+	return &p
+}
+
+// Resource returns the stored resource referenced by ref, or nil.
+func (s *Server) Resource(ref appleapi.TypeId) appleapi.Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resources[ref]
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "The path provided does not match a defined resource type.")
+		return
+	}
+	typ := parts[1]
+	switch typ {
+	case appleapi.TypeBundleIds, appleapi.TypeBundleIdCapabilities, appleapi.TypeCertificates, appleapi.TypeDevices, appleapi.TypeProfiles:
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "The path provided does not match a defined resource type.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(parts) == 2 {
+		switch {
+		case typ == appleapi.TypeBundleIdCapabilities && r.Method != http.MethodPost:
+			methodNotAllowed(w, r)
+		case r.Method == http.MethodGet:
+			s.list(w, r, s.all(typ))
+		case r.Method == http.MethodPost:
+			s.create(w, r, typ)
+		default:
+			methodNotAllowed(w, r)
+		}
+		return
+	}
+
+	ref := appleapi.TypeId{Id: parts[2], Type: typ}
+	v, ok := s.resources[ref]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "There is no resource of type '"+typ+"' with id '"+ref.Id+"'")
+		return
+	}
+	if len(parts) == 4 {
+		s.related(w, r, v, parts[3])
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && typ != appleapi.TypeBundleIdCapabilities:
+		s.writeDocument(w, r, http.StatusOK, v)
+	case r.Method == http.MethodPatch && (typ == appleapi.TypeBundleIds || typ == appleapi.TypeDevices):
+		s.update(w, r, v)
+	case r.Method == http.MethodDelete && typ != appleapi.TypeDevices:
+		s.delete(w, v)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "The request method is not valid for the resource path.", "The resource '"+r.URL.Path+"' does not allow '"+r.Method+"'.")
+}
+
+func parameterError(w http.ResponseWriter, detail string) {
+	writeError(w, http.StatusBadRequest, "PARAMETER_ERROR.INVALID", "A parameter has an invalid value", detail)
+}
+
+func entityError(w http.ResponseWriter, status int, detail string) {
+	writeError(w, status, "ENTITY_ERROR.ATTRIBUTE.INVALID", "An attribute value is invalid.", detail)
+}
+
+// generic is the shape shared by every resource object, used for filtering,
+// sorting and includes.
+type generic struct {
+	Id            string                                    `json:"id"`
+	Attributes    map[string]interface{}                    `json:"attributes"`
+	Relationships map[string]struct{ Data json.RawMessage } `json:"relationships"`
+}
+
+func toGeneric(v appleapi.Resource) *generic {
+	b, _ := json.Marshal(v)
+	g := &generic{}
+	json.Unmarshal(b, g)
+	return g
+}
+
+func (g *generic) value(field string) string {
+	if field == "id" {
+		return g.Id
+	}
+	if v, ok := g.Attributes[field]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func (g *generic) refs(relationship string) []appleapi.TypeId {
+	rel, ok := g.Relationships[relationship]
+	if !ok {
+		return nil
+	}
+	data := bytes.TrimSpace(rel.Data)
+	if len(data) == 0 || data[0] == 'n' {
+		return nil
+	}
+	if data[0] == '[' {
+		var list []appleapi.TypeId
+		json.Unmarshal(data, &list)
+		return list
+	}
+	var ref appleapi.TypeId
+	json.Unmarshal(data, &ref)
+	if ref.Id == "" {
+		return nil
+	}
+	return []appleapi.TypeId{ref}
+}
+
+func splitParam(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// related lists the resources of a to-many relationship, such as
+// /v1/profiles/{id}/devices.
+func (s *Server) related(w http.ResponseWriter, r *http.Request, v appleapi.Resource, relationship string) {
+	rel := toMany(v, relationship)
+	if rel == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The specified resource does not exist", "The path provided does not match a defined resource type.")
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	list := make([]appleapi.Resource, 0, len(rel.Data))
+	for _, ref := range rel.Data {
+		if v, ok := s.resources[ref]; ok {
+			list = append(list, v)
+		}
+	}
+	s.list(w, r, list)
+}
+
+func toMany(v appleapi.Resource, relationship string) *appleapi.ToManyRelationship {
+	switch v := v.(type) {
+	case *appleapi.Profile:
+		switch relationship {
+		case appleapi.TypeCertificates:
+			return &v.Relationships.Certificates
+		case appleapi.TypeDevices:
+			return &v.Relationships.Devices
+		}
+	case *appleapi.BundleId:
+		switch relationship {
+		case appleapi.TypeProfiles:
+			return &v.Relationships.Profiles
+		case appleapi.TypeBundleIdCapabilities:
+			return &v.Relationships.BundleIdCapabilities
+		}
+	}
+	return nil
+}
+
+// limitRelationships returns a copy of v whose to-many relationships are
+// cut to limit[relationship] with the paging in meta, as Apple does.
+func limitRelationships(v appleapi.Resource, q url.Values) appleapi.Resource {
+	limit := func(name string, rel *appleapi.ToManyRelationship) {
+		n, err := strconv.Atoi(q.Get("limit[" + name + "]"))
+		if err != nil {
+			return
+		}
+		total := len(rel.Data)
+		if n < total {
+			rel.Data = rel.Data[:n:n]
+		}
+		rel.Meta.Paging.Total = total
+		rel.Meta.Paging.Limit = n
+	}
+	switch v := v.(type) {
+	case *appleapi.Profile:
+		p := *v
+		limit(appleapi.TypeCertificates, &p.Relationships.Certificates)
+		limit(appleapi.TypeDevices, &p.Relationships.Devices)
+		return &p
+	case *appleapi.BundleId:
+		b := *v
+		limit(appleapi.TypeProfiles, &b.Relationships.Profiles)
+		limit(appleapi.TypeBundleIdCapabilities, &b.Relationships.BundleIdCapabilities)
+		return &b
+	}
+	return v
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, resources []appleapi.Resource) {
+	q := r.URL.Query()
+
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > appleapi.MaxLimit {
+			parameterError(w, "The parameter 'limit' must be between 1 and 200.")
+			return
+		}
+		limit = n
+	}
+	offset := 0
+	if v := q.Get("cursor"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			parameterError(w, "The parameter 'cursor' is invalid.")
+			return
+		}
+		offset = n
+	}
+	for k, v := range q {
+		if strings.HasPrefix(k, "limit[") {
+			n, err := strconv.Atoi(v[0])
+			if err != nil || n < 1 || n > appleapi.MaxRelatedLimit {
+				parameterError(w, "The parameter '"+k+"' must be between 1 and 50.")
+				return
+			}
+		}
+	}
+
+	type item struct {
+		v appleapi.Resource
+		g *generic
+	}
+	items := make([]item, 0)
+next:
+	for _, v := range resources {
+		v = limitRelationships(v, q)
+		g := toGeneric(v)
+		for k, values := range q {
+			if !strings.HasPrefix(k, "filter[") || !strings.HasSuffix(k, "]") {
+				continue
+			}
+			field := k[len("filter[") : len(k)-1]
+			matched := false
+			for _, want := range splitParam(values[0]) {
+				if g.value(field) == want {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue next
+			}
+		}
+		items = append(items, item{v: v, g: g})
+	}
+
+	if keys := splitParam(q.Get("sort")); len(keys) > 0 {
+		sort.SliceStable(items, func(i, j int) bool {
+			for _, key := range keys {
+				desc := strings.HasPrefix(key, "-")
+				field := strings.TrimPrefix(key, "-")
+				a, b := items[i].g.value(field), items[j].g.value(field)
+				if a == b {
+					continue
+				}
+				return (a < b) != desc
+			}
+			return false
+		})
+	}
+
+	total := len(items)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	page := items[offset:end]
+
+	data := make([]appleapi.Resource, len(page))
+	generics := make([]*generic, len(page))
+	for i, v := range page {
+		data[i] = v.v
+		generics[i] = v.g
+	}
+
+	doc := map[string]interface{}{
+		"data":  data,
+		"links": s.pageLinks(r, offset, limit, end < total),
+		"meta":  map[string]interface{}{"paging": map[string]int{"total": total, "limit": limit}},
+	}
+	if included := s.included(q, generics); len(included) > 0 {
+		doc["included"] = included
+	}
+	writeRead(w, r, doc)
+}
+
+func (s *Server) pageLinks(r *http.Request, offset, limit int, more bool) appleapi.PagedDocumentLinks {
+	link := func(cursor int) string {
+		q := r.URL.Query()
+		q.Del("cursor")
+		if cursor > 0 {
+			q.Set("cursor", strconv.Itoa(cursor))
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		return s.URL + u.String()
+	}
+	links := appleapi.PagedDocumentLinks{Self: link(offset), First: link(0)}
+	if more {
+		links.Next = link(offset + limit)
+	}
+	return links
+}
+
+func (s *Server) included(q url.Values, generics []*generic) []appleapi.Resource {
+	include := splitParam(q.Get("include"))
+	if len(include) == 0 {
+		return nil
+	}
+	seen := make(map[appleapi.TypeId]bool)
+	list := make([]appleapi.Resource, 0)
+	for _, g := range generics {
+		for _, rel := range include {
+			for _, ref := range g.refs(rel) {
+				if v, ok := s.resources[ref]; ok && !seen[ref] {
+					seen[ref] = true
+					list = append(list, v)
+				}
+			}
+		}
+	}
+	return list
+}
+
+func (s *Server) writeDocument(w http.ResponseWriter, r *http.Request, status int, v appleapi.Resource) {
+	v = limitRelationships(v, r.URL.Query())
+	doc := map[string]interface{}{
+		"data":  v,
+		"links": appleapi.DocumentLinks{Self: s.URL + r.URL.Path},
+	}
+	if included := s.included(r.URL.Query(), []*generic{toGeneric(v)}); len(included) > 0 {
+		doc["included"] = included
+	}
+	if r.Method == http.MethodGet {
+		writeRead(w, r, doc)
+		return
+	}
+	writeJson(w, status, doc)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "ENTITY_UNPROCESSABLE", "The request entity is not valid JSON.", err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, typ string) {
+	var v appleapi.Resource
+	switch typ {
+	case appleapi.TypeBundleIds:
+		v = s.createBundleId(w, r)
+	case appleapi.TypeBundleIdCapabilities:
+		v = s.createCapability(w, r)
+	case appleapi.TypeCertificates:
+		v = s.createCertificate(w, r)
+	case appleapi.TypeDevices:
+		v = s.createDevice(w, r)
+	case appleapi.TypeProfiles:
+		v = s.createProfile(w, r)
+	}
+	if v != nil {
+		s.writeDocument(w, r, http.StatusCreated, v)
+	}
+}
+
+func (s *Server) createBundleId(w http.ResponseWriter, r *http.Request) appleapi.Resource {
+	req := new(appleapi.BundleIdCreateRequest)
+	if !decodeBody(w, r, req) {
+		return nil
+	}
+	a := req.Data.Attributes
+	if a.Identifier == "" || a.Name == "" {
+		entityError(w, http.StatusConflict, "identifier and name are required")
+		return nil
+	}
+	for _, v := range s.all(appleapi.TypeBundleIds) {
+		if v.(*appleapi.BundleId).Attributes.Identifier == a.Identifier {
+			entityError(w, http.StatusConflict, "An App ID with Identifier '"+a.Identifier+"' is not available. Please enter a different string.")
+			return nil
+		}
+	}
+	b := &appleapi.BundleId{Id: s.newId(), Type: appleapi.TypeBundleIds}
+	b.Attributes.Identifier = a.Identifier
+	b.Attributes.Name = a.Name
+	b.Attributes.Platform = a.Platform
+	b.Attributes.SeedId = TestSeedId
+	b.Links = s.selfLink(b.Type, b.Id)
+	s.put(b)
+	return b
+}
+
+func (s *Server) createCapability(w http.ResponseWriter, r *http.Request) appleapi.Resource {
+	req := new(appleapi.BundleIdCapabilityCreateRequest)
+	if !decodeBody(w, r, req) {
+		return nil
+	}
+	a := req.Data.Attributes
+	bundle, ok := s.resources[appleapi.TypeId{Id: req.Data.Relationships.BundleId.Data.Id, Type: appleapi.TypeBundleIds}].(*appleapi.BundleId)
+	if !ok {
+		entityError(w, http.StatusConflict, "bundleId '"+req.Data.Relationships.BundleId.Data.Id+"' does not exist")
+		return nil
+	}
+	if a.CapabilityType == "" {
+		entityError(w, http.StatusConflict, "capabilityType is required")
+		return nil
+	}
+	for _, ref := range bundle.Relationships.BundleIdCapabilities.Data {
+		if c, ok := s.resources[ref].(*appleapi.BundleIdCapability); ok && c.Attributes.CapabilityType == a.CapabilityType {
+			entityError(w, http.StatusConflict, "The capability '"+a.CapabilityType+"' is already enabled.")
+			return nil
+		}
+	}
+	c := &appleapi.BundleIdCapability{Id: bundle.Id + "_" + a.CapabilityType, Type: appleapi.TypeBundleIdCapabilities}
+	c.Attributes.CapabilityType = a.CapabilityType
+	c.Attributes.Settings = a.Settings
+	c.Links = s.selfLink(c.Type, c.Id)
+	s.put(c)
+	bundle.Relationships.BundleIdCapabilities.Data = append(bundle.Relationships.BundleIdCapabilities.Data, c.Ref())
+	return c
+}
+
+func (s *Server) createCertificate(w http.ResponseWriter, r *http.Request) appleapi.Resource {
+	req := new(appleapi.CertificateCreateRequest)
+	if !decodeBody(w, r, req) {
+		return nil
+	}
+	a := req.Data.Attributes
+	if a.CertificateType == "" {
+		entityError(w, http.StatusConflict, "certificateType is required")
+		return nil
+	}
+	csr, err := parseCsr(a.CsrContent)
+	if err != nil {
+		entityError(w, http.StatusConflict, "csrContent is invalid: "+err.Error())
+		return nil
+	}
+	c := &appleapi.Certificate{Id: s.newId(), Type: appleapi.TypeCertificates}
+	s.nextId++
+	serial := int64(s.nextId)
+	expiration := time.Now().AddDate(1, 0, 0)
+	name := certificateName(a.CertificateType)
+	der, err := s.ca.issue(csr, name+": "+csr.Subject.CommonName, serial, expiration)
+	if err != nil {
+		entityError(w, http.StatusConflict, err.Error())
+		return nil
+	}
+	c.Attributes.CertificateContent = base64.StdEncoding.EncodeToString(der)
+	c.Attributes.CertificateType = a.CertificateType
+	c.Attributes.DisplayName = csr.Subject.CommonName
+	c.Attributes.ExpirationDate = expiration.UTC().Format(DateLayout)
+	c.Attributes.Name = name
+	c.Attributes.Platform = appleapi.PlatformIos
+	c.Attributes.SerialNumber = strings.ToUpper(strconv.FormatInt(serial, 16))
+	c.Links = s.selfLink(c.Type, c.Id)
+	s.put(c)
+	return c
+}
+
+func certificateName(certificateType string) string {
+	switch certificateType {
+	case "IOS_DEVELOPMENT":
+		return "iOS Development"
+	case "IOS_DISTRIBUTION":
+		return "iOS Distribution"
+	case "MAC_APP_DEVELOPMENT":
+		return "Mac Development"
+	case "MAC_APP_DISTRIBUTION":
+		return "Mac App Distribution"
+	case "MAC_INSTALLER_DISTRIBUTION":
+		return "Mac Installer Distribution"
+	case "DEVELOPER_ID_KEXT":
+		return "Developer ID Kext"
+	case "DEVELOPER_ID_APPLICATION":
+		return "Developer ID Application"
+	}
+	return certificateType
+}
+
+func (s *Server) createDevice(w http.ResponseWriter, r *http.Request) appleapi.Resource {
+	req := new(appleapi.DeviceCreateRequest)
+	if !decodeBody(w, r, req) {
+		return nil
+	}
+	a := req.Data.Attributes
+	if a.Udid == "" || a.Name == "" {
+		entityError(w, http.StatusConflict, "udid and name are required")
+		return nil
+	}
+	for _, v := range s.all(appleapi.TypeDevices) {
+		if strings.EqualFold(v.(*appleapi.Device).Attributes.Udid, a.Udid) {
+			entityError(w, http.StatusConflict, "A device with number '"+a.Udid+"' already exists on this team.")
+			return nil
+		}
+	}
+	d := &appleapi.Device{Id: s.newId(), Type: appleapi.TypeDevices}
+	d.Attributes.Name = a.Name
+	d.Attributes.Platform = a.Platform
+	d.Attributes.Udid = a.Udid
+	d.Attributes.Status = "ENABLED"
+	d.Attributes.DeviceClass = "IPHONE"
+	d.Attributes.Model = "iPhone"
+	d.Attributes.AddedDate = time.Now().UTC().Format(DateLayout)
+	d.Links = s.selfLink(d.Type, d.Id)
+	s.put(d)
+	return d
+}
+
+func (s *Server) createProfile(w http.ResponseWriter, r *http.Request) appleapi.Resource {
+	req := new(appleapi.ProfileCreateRequest)
+	if !decodeBody(w, r, req) {
+		return nil
+	}
+	a := req.Data.Attributes
+	rel := req.Data.Relationships
+	if a.Name == "" || a.ProfileType == "" {
+		entityError(w, http.StatusConflict, "name and profileType are required")
+		return nil
+	}
+	for _, v := range s.all(appleapi.TypeProfiles) {
+		if v.(*appleapi.Profile).Attributes.Name == a.Name {
+			entityError(w, http.StatusConflict, "Multiple profiles found with the name '"+a.Name+"'.  Please remove the duplicate profiles and try again.")
+			return nil
+		}
+	}
+	bundle, ok := s.resources[appleapi.TypeId{Id: rel.BundleId.Data.Id, Type: appleapi.TypeBundleIds}].(*appleapi.BundleId)
+	if !ok {
+		entityError(w, http.StatusConflict, "bundleId '"+rel.BundleId.Data.Id+"' does not exist")
+		return nil
+	}
+	if len(rel.Certificates.Data) == 0 {
+		entityError(w, http.StatusConflict, "certificates are required")
+		return nil
+	}
+	expiration := time.Now().AddDate(1, 0, 0)
+	certs := make([]*appleapi.Certificate, 0, len(rel.Certificates.Data))
+	for _, ref := range rel.Certificates.Data {
+		c, ok := s.resources[appleapi.TypeId{Id: ref.Id, Type: appleapi.TypeCertificates}].(*appleapi.Certificate)
+		if !ok {
+			entityError(w, http.StatusConflict, "certificate '"+ref.Id+"' does not exist")
+			return nil
+		}
+		if t, err := time.Parse(DateLayout, c.Attributes.ExpirationDate); err == nil && t.Before(expiration) {
+			expiration = t
+		}
+		certs = append(certs, c)
+	}
+	devices := make([]*appleapi.Device, 0, len(rel.Devices.Data))
+	for _, ref := range rel.Devices.Data {
+		d, ok := s.resources[appleapi.TypeId{Id: ref.Id, Type: appleapi.TypeDevices}].(*appleapi.Device)
+		if !ok {
+			entityError(w, http.StatusConflict, "device '"+ref.Id+"' does not exist")
+			return nil
+		}
+		devices = append(devices, d)
+	}
+
+	p := &appleapi.Profile{Id: s.newId(), Type: appleapi.TypeProfiles}
+	p.Attributes.Name = a.Name
+	p.Attributes.Platform = bundle.Attributes.Platform
+	p.Attributes.ProfileType = a.ProfileType
+	p.Attributes.ProfileState = "ACTIVE"
+	p.Attributes.Uuid = newUuid()
+	p.Attributes.CreatedDate = time.Now().UTC().Format(DateLayout)
+	p.Attributes.ExpirationDate = expiration.UTC().Format(DateLayout)
+	p.Attributes.ProfileContent = base64.StdEncoding.EncodeToString(profilePlist(p, bundle, certs, devices))
+	p.Relationships.BundleId.Data = bundle.Ref()
+	for _, c := range certs {
+		p.Relationships.Certificates.Data = append(p.Relationships.Certificates.Data, c.Ref())
+	}
+	for _, d := range devices {
+		p.Relationships.Devices.Data = append(p.Relationships.Devices.Data, d.Ref())
+	}
+	p.Links = s.selfLink(p.Type, p.Id)
+	s.put(p)
+	s.linkProfile(p)
+	return p
+}
+
+// linkProfile adds the profile to the profiles relationship of its bundle ID.
+func (s *Server) linkProfile(p *appleapi.Profile) {
+	bundle, ok := s.resources[p.Relationships.BundleId.Data].(*appleapi.BundleId)
+	if !ok {
+		return
+	}
+	for _, ref := range bundle.Relationships.Profiles.Data {
+		if ref == p.Ref() {
+			return
+		}
+	}
+	bundle.Relationships.Profiles.Data = append(bundle.Relationships.Profiles.Data, p.Ref())
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, v appleapi.Resource) {
+	switch v := v.(type) {
+	case *appleapi.BundleId:
+		req := new(appleapi.BundleIdUpdateRequest)
+		if !decodeBody(w, r, req) {
+			return
+		}
+		if req.Data.Attributes.Name != "" {
+			v.Attributes.Name = req.Data.Attributes.Name
+		}
+	case *appleapi.Device:
+		req := new(appleapi.DeviceUpdateRequest)
+		if !decodeBody(w, r, req) {
+			return
+		}
+		a := req.Data.Attributes
+		if a.Status != "" && a.Status != "ENABLED" && a.Status != "DISABLED" {
+			entityError(w, http.StatusConflict, "status must be ENABLED or DISABLED")
+			return
+		}
+		if a.Name != "" {
+			v.Attributes.Name = a.Name
+		}
+		if a.Status != "" {
+			v.Attributes.Status = a.Status
+		}
+	}
+	s.writeDocument(w, r, http.StatusOK, v)
+}
+
+func (s *Server) delete(w http.ResponseWriter, v appleapi.Resource) {
+	switch v := v.(type) {
+	case *appleapi.BundleId:
+		for _, ref := range v.Relationships.Profiles.Data {
+			s.remove(ref)
+		}
+		for _, ref := range v.Relationships.BundleIdCapabilities.Data {
+			s.remove(ref)
+		}
+	case *appleapi.BundleIdCapability:
+		for _, b := range s.all(appleapi.TypeBundleIds) {
+			b := b.(*appleapi.BundleId)
+			refs := b.Relationships.BundleIdCapabilities.Data[:0]
+			for _, ref := range b.Relationships.BundleIdCapabilities.Data {
+				if ref != v.Ref() {
+					refs = append(refs, ref)
+				}
+			}
+			b.Relationships.BundleIdCapabilities.Data = refs
+		}
+	case *appleapi.Certificate:
+		// 吊销证书后, 使用该证书的描述文件变为无效
+		for _, p := range s.all(appleapi.TypeProfiles) {
+			p := p.(*appleapi.Profile)
+			for _, ref := range p.Relationships.Certificates.Data {
+				if ref.Id == v.Id {
+					p.Attributes.ProfileState = "INVALID"
+				}
+			}
+		}
+	case *appleapi.Profile:
+		if bundle, ok := s.resources[v.Relationships.BundleId.Data].(*appleapi.BundleId); ok {
+			refs := bundle.Relationships.Profiles.Data[:0]
+			for _, ref := range bundle.Relationships.Profiles.Data {
+				if ref != v.Ref() {
+					refs = append(refs, ref)
+				}
+			}
+			bundle.Relationships.Profiles.Data = refs
+		}
+	}
+	s.remove(v.Ref())
+	w.WriteHeader(http.StatusNoContent)
+}
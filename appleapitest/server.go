@@ -0,0 +1,275 @@
+// Package appleapitest provides an in-process fake of the App Store Connect
+// provisioning API for tests.
+//
+//	srv := appleapitest.NewServer()
+//	defer srv.Close()
+//	devices := &appleapi.Devices{Token: srv.Token()}
+//
+// The fake keeps bundle IDs, certificates, devices and profiles in memory,
+// validates the bearer JWT against its own key, pages list responses and
+// the related resources of a profile or bundle ID, cuts to-many
+// relationships at limit[relationship] and answers errors as JSON:API error
+// documents. Read responses carry an ETag and honour If-None-Match. Faults
+// (429, 500, latency) are injected with AddFault.
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const (
+	TestKid = "TESTKEY123"
+	TestIss = "69a6de70-0000-47e3-e053-5b8c7c11a4d1"
+
+	// HourlyLimit is the value reported by the X-Rate-Limit header.
+	HourlyLimit = 3600
+)
+
+// Fault makes matching requests fail or slow down.
+type Fault struct {
+	Method  string        // 空表示任意方法
+	Path    string        // 路径前缀, 空表示任意路径
+	Status  int           // 返回的错误码, 0 表示只增加延迟
+	Latency time.Duration // 响应前等待的时间
+	Times   int           // 生效次数, 0 表示一直生效
+}
+
+type Server struct {
+	*httptest.Server
+	Kid string
+	Iss string
+
+	key    *ecdsa.PrivateKey
+	keyPem []byte
+	ca     *certificateAuthority
+
+	mu        sync.Mutex
+	resources map[appleapi.TypeId]appleapi.Resource
+	order     []appleapi.TypeId
+	faults    []*Fault
+	requests  int
+	nextId    int
+}
+
+// NewServer starts a fake server with an empty state.
+func NewServer() *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	s := &Server{
+		Kid:       TestKid,
+		Iss:       TestIss,
+		key:       key,
+		keyPem:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		ca:        newCertificateAuthority(),
+		resources: make(map[appleapi.TypeId]appleapi.Resource),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AuthKey returns the .p8 PEM the server validates bearer tokens against.
+func (s *Server) AuthKey() []byte {
+	return s.keyPem
+}
+
+// Token returns a Token that talks to the fake server.
+func (s *Server) Token() *appleapi.Token {
+	return &appleapi.Token{
+		Secret:  string(s.keyPem),
+		Kid:     s.Kid,
+		Iss:     s.Iss,
+		BaseUrl: s.URL,
+		Client:  s.Client(),
+	}
+}
+
+// AddFault registers a fault, faults are checked in the order they were added.
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the number of requests received.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	remaining := HourlyLimit - s.requests
+	if remaining < 0 {
+		remaining = 0
+	}
+	fault := s.matchFault(r)
+	s.mu.Unlock()
+
+	w.Header().Set("X-Rate-Limit", "user-hour-lim:"+strconv.Itoa(HourlyLimit)+";user-hour-rem:"+strconv.Itoa(remaining)+";")
+	w.Header().Set("X-Request-Id", strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			writeError(w, fault.Status, faultCode(fault.Status), http.StatusText(fault.Status), "injected fault")
+			return
+		}
+	}
+	if remaining == 0 {
+		writeError(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "The request rate limit has been reached.", "")
+		return
+	}
+	if err := s.authorize(r); err != nil {
+		writeError(w, http.StatusUnauthorized, "NOT_AUTHORIZED", "Authentication credentials are missing or invalid.", err.Error())
+		return
+	}
+	s.route(w, r)
+}
+
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func faultCode(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "RATE_LIMIT_EXCEEDED"
+	case http.StatusUnauthorized:
+		return "NOT_AUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN_ERROR"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "ENTITY_ERROR"
+	}
+	return "UNEXPECTED_ERROR"
+}
+
+type authError string
+
+func (e authError) Error() string { return string(e) }
+
+func (s *Server) authorize(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return authError("missing bearer token")
+	}
+	pl := &appleapi.ApiPayload{}
+	alg := jwt.NewES256(jwt.ECDSAPublicKey(&s.key.PublicKey))
+	h, err := jwt.Verify([]byte(strings.TrimPrefix(auth, "Bearer ")), alg, pl)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	switch {
+	case h.KeyID != s.Kid:
+		return authError("unknown kid " + h.KeyID)
+	case pl.Aud != "appstoreconnect-v1":
+		return authError("invalid aud " + pl.Aud)
+	case pl.Iss != s.Iss:
+		return authError("invalid iss " + pl.Iss)
+	case pl.Exp <= now:
+		return authError("token expired")
+	case pl.Exp > now+20*60+60:
+		return authError("exp is more than 20 minutes in the future")
+	}
+	return nil
+}
+
+type errorObject struct {
+	Id     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, title, detail string) {
+	writeJson(w, status, map[string][]errorObject{
+		"errors": {{
+			Id:     strconv.FormatInt(time.Now().UnixNano(), 36),
+			Status: strconv.Itoa(status),
+			Code:   code,
+			Title:  title,
+			Detail: detail,
+		}},
+	})
+}
+
+// writeRead writes the document of a GET request with an ETag and answers
+// 304 Not Modified to a matching If-None-Match.
+func writeRead(w http.ResponseWriter, r *http.Request, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Internal error", err.Error())
+		return
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(b, '\n'))
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
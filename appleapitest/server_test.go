@@ -0,0 +1,117 @@
+package appleapitest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+)
+
+func TestServerPagesDevices(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := appleapi.NewClient(srv.Token())
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Devices.DeviceCreate(fmt.Sprintf("0000800%d-000A4C2E1A88002E", i), fmt.Sprintf("iPhone %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := c.Devices.Query(&appleapi.ListDevicesQuery{Limit: 2, Sort: []appleapi.SortKey{appleapi.DeviceSortName.Desc()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := &appleapi.DevicesResponse{}
+	if err = json.Unmarshal(b, page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 2 || page.Meta.Paging.Total != 5 || !page.HasNext() {
+		t.Fatalf("first page has %d devices, paging %+v, next %q", len(page.Data), page.Meta.Paging, page.Links.Next)
+	}
+	if page.Data[0].Attributes.Name != "iPhone 4" {
+		t.Errorf("first device = %q, want sorted by -name", page.Data[0].Attributes.Name)
+	}
+
+	all, err := c.AllDevices(&appleapi.ListDevicesQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Errorf("AllDevices() returned %d devices, want 5", len(all))
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	devices := &appleapi.Devices{Token: srv.Token()}
+	if _, err := devices.DeviceCreate("00008020-000A4C2E1A88002E", "iPhone"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewServer()
+	defer other.Close()
+	foreign := srv.Token()
+	foreign.Secret = string(other.AuthKey())
+
+	tests := []struct {
+		name   string
+		fault  *Fault
+		token  *appleapi.Token
+		call   func(d *appleapi.Devices) error
+		status int
+		code   string
+	}{
+		{
+			name: "duplicate udid",
+			call: func(d *appleapi.Devices) error {
+				_, err := d.DeviceCreate("00008020-000A4C2E1A88002E", "again")
+				return err
+			},
+			status: http.StatusConflict,
+			code:   "ENTITY_ERROR.ATTRIBUTE.INVALID",
+		},
+		{
+			name:   "limit over 200 sent by hand",
+			call:   func(d *appleapi.Devices) error { _, err := d.Next(d.Url("/v1/devices?limit=201")); return err },
+			status: http.StatusBadRequest,
+			code:   "PARAMETER_ERROR.INVALID",
+		},
+		{
+			name:   "token signed by another key",
+			token:  foreign,
+			call:   func(d *appleapi.Devices) error { _, err := d.Query(nil); return err },
+			status: http.StatusUnauthorized,
+			code:   "NOT_AUTHORIZED",
+		},
+		{
+			name:   "injected fault",
+			fault:  &Fault{Method: http.MethodGet, Path: "/v1/devices", Status: http.StatusServiceUnavailable, Times: 1},
+			call:   func(d *appleapi.Devices) error { _, err := d.Query(nil); return err },
+			status: http.StatusServiceUnavailable,
+			code:   "UNEXPECTED_ERROR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fault != nil {
+				srv.AddFault(*tt.fault)
+				defer srv.ClearFaults()
+			}
+			d := devices
+			if tt.token != nil {
+				d = &appleapi.Devices{Token: tt.token}
+			}
+			var apiErr *appleapi.ApiError
+			if err := tt.call(d); !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want *ApiError", err)
+			}
+			if apiErr.StatusCode != tt.status || len(apiErr.Response.Error) == 0 || apiErr.Response.Error[0].Code != tt.code {
+				t.Errorf("got %d %+v, want %d %s", apiErr.StatusCode, apiErr.Response.Error, tt.status, tt.code)
+			}
+		})
+	}
+}
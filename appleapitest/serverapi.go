@@ -0,0 +1,546 @@
+package appleapitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const (
+	TestBundleId   = "com.example.app"
+	TestAppAppleId = 1234567890
+)
+
+// ServerApiServer is a fake of the App Store Server API for the purchases of
+// one customer. Transactions are added with AddTransaction and returned as
+// JWS signed with a certificate chain under RootCertificate.
+type ServerApiServer struct {
+	*httptest.Server
+	Kid         string
+	Iss         string
+	Bid         string
+	Environment string
+	PageSize    int // 每页的交易数和通知数, 默认 20
+
+	NotificationUrl string // Notify 和测试通知 POST 到这个地址, 为空时只记录在通知历史中
+
+	key    *ecdsa.PrivateKey
+	keyPem []byte
+	signer *JwsSigner
+
+	mu            sync.Mutex
+	transactions  []*appleapi.JWSTransactionDecodedPayload
+	renewals      map[string]*appleapi.JWSRenewalInfoDecodedPayload
+	orders        map[string][]string
+	extensions    map[string]int                                 // originalTransactionId 的延期次数
+	extended      map[string]*appleapi.ExtendRenewalDateResponse // requestIdentifier
+	nextId        int64
+	notifications []*sentNotification
+}
+
+func NewServerApiServer() *ServerApiServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	s := &ServerApiServer{
+		Kid:         TestKid,
+		Iss:         TestIss,
+		Bid:         TestBundleId,
+		Environment: appleapi.EnvironmentSandbox,
+		key:         key,
+		keyPem:      pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		signer:      NewJwsSigner(),
+		renewals:    make(map[string]*appleapi.JWSRenewalInfoDecodedPayload),
+		orders:      make(map[string][]string),
+		extensions:  make(map[string]int),
+		extended:    make(map[string]*appleapi.ExtendRenewalDateResponse),
+		nextId:      2000000000000000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// ServerApi returns a client that talks to the fake server.
+func (s *ServerApiServer) ServerApi() *appleapi.ServerApi {
+	api := appleapi.NewServerApi(string(s.keyPem), s.Kid, s.Iss, s.Bid, true)
+	api.BaseUrl = s.URL
+	api.Client = s.Client()
+	return api
+}
+
+// RootCertificate returns the root the signed transactions chain to, for use
+// in place of Apple Root CA - G3.
+func (s *ServerApiServer) RootCertificate() *x509.Certificate {
+	return s.signer.Root
+}
+
+// LeafCertificate returns the certificate that signs the transactions.
+func (s *ServerApiServer) LeafCertificate() *x509.Certificate {
+	return s.signer.Leaf
+}
+
+// JwsVerifier returns a verifier that trusts RootCertificate.
+func (s *ServerApiServer) JwsVerifier() *appleapi.JwsVerifier {
+	v := appleapi.NewJwsVerifier(s.signer.Root)
+	v.BundleId = s.Bid
+	return v
+}
+
+// Sign returns v as a JWS signed like the App Store signs transactions,
+// renewal infos and notifications.
+func (s *ServerApiServer) Sign(v interface{}) string {
+	jws, err := s.signer.Sign(v)
+	if err != nil {
+		panic("appleapitest: " + err.Error())
+	}
+	return jws
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// AddTransaction records a transaction of the customer. Empty ids, dates,
+// bundle ID and environment are filled in; the completed transaction is
+// returned.
+func (s *ServerApiServer) AddTransaction(t appleapi.JWSTransactionDecodedPayload) appleapi.JWSTransactionDecodedPayload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.TransactionId == "" {
+		s.nextId++
+		t.TransactionId = strconv.FormatInt(s.nextId, 10)
+	}
+	if t.OriginalTransactionId == "" {
+		t.OriginalTransactionId = t.TransactionId
+	}
+	if t.BundleId == "" {
+		t.BundleId = s.Bid
+	}
+	if t.PurchaseDate == 0 {
+		t.PurchaseDate = millis(time.Now())
+	}
+	if t.OriginalPurchaseDate == 0 {
+		t.OriginalPurchaseDate = t.PurchaseDate
+	}
+	if t.Quantity == 0 {
+		t.Quantity = 1
+	}
+	if t.Type == "" {
+		t.Type = appleapi.ProductTypeNonConsumable
+	}
+	if t.InAppOwnershipType == "" {
+		t.InAppOwnershipType = appleapi.OwnershipPurchased
+	}
+	if t.Environment == "" {
+		t.Environment = s.Environment
+	}
+	s.transactions = append(s.transactions, &t)
+	return t
+}
+
+// Refund revokes a transaction, it then appears in the refund history.
+func (s *ServerApiServer) Refund(transactionId string, reason int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.transaction(transactionId); t != nil {
+		t.RevocationDate = millis(time.Now())
+		t.RevocationReason = &reason
+	}
+}
+
+// SetRenewalInfo sets the renewal info of an auto-renewable subscription.
+// Without it the subscription is reported as renewing the same product.
+func (s *ServerApiServer) SetRenewalInfo(r appleapi.JWSRenewalInfoDecodedPayload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewals[r.OriginalTransactionId] = &r
+}
+
+// AddOrder makes Look Up Order ID return the transactions.
+func (s *ServerApiServer) AddOrder(orderId string, transactionIds ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[orderId] = append(s.orders[orderId], transactionIds...)
+}
+
+// Transaction returns the current state of a transaction.
+func (s *ServerApiServer) Transaction(transactionId string) (appleapi.JWSTransactionDecodedPayload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.transaction(transactionId); t != nil {
+		return *t, true
+	}
+	return appleapi.JWSTransactionDecodedPayload{}, false
+}
+
+func (s *ServerApiServer) transaction(id string) *appleapi.JWSTransactionDecodedPayload {
+	for _, t := range s.transactions {
+		if t.TransactionId == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *ServerApiServer) signTransaction(t *appleapi.JWSTransactionDecodedPayload) string {
+	v := *t
+	v.SignedDate = millis(time.Now())
+	return s.Sign(&v)
+}
+
+func serverApiError(w http.ResponseWriter, status int, code int64, message string) {
+	writeJson(w, status, &appleapi.ServerApiError{ErrorCode: code, ErrorMessage: message})
+}
+
+func (s *ServerApiServer) authorize(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	pl := &appleapi.ApiPayload{}
+	alg := jwt.NewES256(jwt.ECDSAPublicKey(&s.key.PublicKey))
+	h, err := jwt.Verify([]byte(strings.TrimPrefix(auth, "Bearer ")), alg, pl)
+	if err != nil {
+		return false
+	}
+	now := time.Now().Unix()
+	return h.KeyID == s.Kid && pl.Iss == s.Iss && pl.Bid == s.Bid && pl.Aud == "appstoreconnect-v1" &&
+		pl.Exp > now && pl.Exp-pl.Iat <= 3600
+}
+
+func (s *ServerApiServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := r.URL.Path
+	id := path[strings.LastIndex(path, "/")+1:]
+	if strings.HasPrefix(path, "/inApps/v1/notifications/") {
+		// 发送测试通知时不能持有 s.mu, 通知的接收方可能会调用这个服务器
+		s.serveNotifications(w, r, path, id)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v2/history/"):
+		s.history(w, r, id, true)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v1/history/"):
+		s.history(w, r, id, false)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v1/transactions/"):
+		t := s.transaction(id)
+		if t == nil {
+			serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTransactionIdNotFound, "Transaction id not found.")
+			return
+		}
+		writeJson(w, http.StatusOK, &appleapi.TransactionInfoResponse{SignedTransactionInfo: s.signTransaction(t)})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v1/subscriptions/"):
+		s.statuses(w, r, id)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v1/lookup/"):
+		resp := &appleapi.OrderLookupResponse{Status: appleapi.OrderLookupInvalid}
+		for _, tid := range s.orders[id] {
+			if t := s.transaction(tid); t != nil {
+				resp.Status = appleapi.OrderLookupValid
+				resp.SignedTransactions = append(resp.SignedTransactions, s.signTransaction(t))
+			}
+		}
+		writeJson(w, http.StatusOK, resp)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/inApps/v2/refund/lookup/"):
+		s.refunds(w, r, id)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/inApps/v1/subscriptions/extend/"):
+		s.extend(w, r, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var productTypes = map[string]string{
+	"AUTO_RENEWABLE": appleapi.ProductTypeAutoRenewable,
+	"NON_RENEWABLE":  appleapi.ProductTypeNonRenewing,
+	"CONSUMABLE":     appleapi.ProductTypeConsumable,
+	"NON_CONSUMABLE": appleapi.ProductTypeNonConsumable,
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// page 按 revision 分页, revision 是下一页的起始位置
+func (s *ServerApiServer) page(w http.ResponseWriter, r *http.Request, list []*appleapi.JWSTransactionDecodedPayload) (signed []string, revision string, hasMore bool, ok bool) {
+	start := 0
+	if v := r.URL.Query().Get("revision"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > len(list) {
+			serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidRequestRevision, "Invalid request revision.")
+			return nil, "", false, false
+		}
+		start = n
+	}
+	size := s.PageSize
+	if size <= 0 {
+		size = 20
+	}
+	end := start + size
+	if end > len(list) {
+		end = len(list)
+	}
+	signed = []string{}
+	for _, t := range list[start:end] {
+		signed = append(signed, s.signTransaction(t))
+	}
+	return signed, strconv.Itoa(end), end < len(list), true
+}
+
+func (s *ServerApiServer) history(w http.ResponseWriter, r *http.Request, id string, v2 bool) {
+	if s.transaction(id) == nil {
+		serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTransactionIdNotFound, "Transaction id not found.")
+		return
+	}
+	q := r.URL.Query()
+	var list []*appleapi.JWSTransactionDecodedPayload
+	for _, t := range s.transactions {
+		if v2 && !historyMatch(t, q) {
+			continue
+		}
+		list = append(list, t)
+	}
+	desc := v2 && q.Get("sort") == appleapi.SortDescending
+	sort.SliceStable(list, func(i, j int) bool {
+		if desc {
+			return list[i].PurchaseDate > list[j].PurchaseDate
+		}
+		return list[i].PurchaseDate < list[j].PurchaseDate
+	})
+	signed, revision, hasMore, ok := s.page(w, r, list)
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, &appleapi.HistoryResponse{
+		AppAppleId:         TestAppAppleId,
+		BundleId:           s.Bid,
+		Environment:        s.Environment,
+		HasMore:            hasMore,
+		Revision:           revision,
+		SignedTransactions: signed,
+	})
+}
+
+func historyMatch(t *appleapi.JWSTransactionDecodedPayload, q map[string][]string) bool {
+	get := func(k string) string {
+		if len(q[k]) == 0 {
+			return ""
+		}
+		return q[k][0]
+	}
+	if v, err := strconv.ParseInt(get("startDate"), 10, 64); err == nil && t.PurchaseDate < v {
+		return false
+	}
+	if v, err := strconv.ParseInt(get("endDate"), 10, 64); err == nil && t.PurchaseDate >= v {
+		return false
+	}
+	if ids := q["productId"]; len(ids) > 0 && !contains(ids, t.ProductId) {
+		return false
+	}
+	if types := q["productType"]; len(types) > 0 {
+		match := false
+		for _, v := range types {
+			match = match || productTypes[v] == t.Type
+		}
+		if !match {
+			return false
+		}
+	}
+	if groups := q["subscriptionGroupIdentifier"]; len(groups) > 0 && !contains(groups, t.SubscriptionGroupIdentifier) {
+		return false
+	}
+	if v := get("inAppOwnershipType"); v != "" && v != t.InAppOwnershipType {
+		return false
+	}
+	if v, err := strconv.ParseBool(get("revoked")); err == nil && v != (t.RevocationDate != 0) {
+		return false
+	}
+	return true
+}
+
+// latest 返回每个自动续订订阅的最后一个交易, 按 originalTransactionId
+func (s *ServerApiServer) latest() map[string]*appleapi.JWSTransactionDecodedPayload {
+	m := make(map[string]*appleapi.JWSTransactionDecodedPayload)
+	for _, t := range s.transactions {
+		if t.Type != appleapi.ProductTypeAutoRenewable {
+			continue
+		}
+		if old, ok := m[t.OriginalTransactionId]; !ok || t.PurchaseDate >= old.PurchaseDate {
+			m[t.OriginalTransactionId] = t
+		}
+	}
+	return m
+}
+
+// renewalInfo 返回订阅 t 的续订信息, 没有 SetRenewalInfo 时自动续订同一产品
+func (s *ServerApiServer) renewalInfo(t *appleapi.JWSTransactionDecodedPayload, now time.Time) appleapi.JWSRenewalInfoDecodedPayload {
+	renewal := appleapi.JWSRenewalInfoDecodedPayload{
+		OriginalTransactionId: t.OriginalTransactionId,
+		AutoRenewProductId:    t.ProductId,
+		ProductId:             t.ProductId,
+		AutoRenewStatus:       1,
+		RenewalDate:           t.ExpiresDate,
+		Environment:           t.Environment,
+	}
+	if v, ok := s.renewals[t.OriginalTransactionId]; ok {
+		renewal = *v
+	}
+	renewal.SignedDate = millis(now)
+	return renewal
+}
+
+func (s *ServerApiServer) statuses(w http.ResponseWriter, r *http.Request, id string) {
+	if s.transaction(id) == nil {
+		serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTransactionIdNotFound, "Transaction id not found.")
+		return
+	}
+	var filter []int
+	for _, v := range r.URL.Query()["status"] {
+		n, _ := strconv.Atoi(v)
+		filter = append(filter, n)
+	}
+
+	now := time.Now()
+	groups := make(map[string]*appleapi.SubscriptionGroupStatus)
+	var names []string
+	latest := s.latest()
+	ids := make([]string, 0, len(latest))
+	for k := range latest {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids)
+	for _, orig := range ids {
+		t := latest[orig]
+		status := appleapi.SubscriptionActive
+		switch {
+		case t.RevocationDate != 0:
+			status = appleapi.SubscriptionRevoked
+		case t.Expired(now):
+			status = appleapi.SubscriptionExpired
+		}
+		if len(filter) > 0 {
+			found := false
+			for _, v := range filter {
+				found = found || v == status
+			}
+			if !found {
+				continue
+			}
+		}
+		renewal := s.renewalInfo(t, now)
+		g := groups[t.SubscriptionGroupIdentifier]
+		if g == nil {
+			g = &appleapi.SubscriptionGroupStatus{SubscriptionGroupIdentifier: t.SubscriptionGroupIdentifier}
+			groups[t.SubscriptionGroupIdentifier] = g
+			names = append(names, t.SubscriptionGroupIdentifier)
+		}
+		g.LastTransactions = append(g.LastTransactions, appleapi.LastTransaction{
+			OriginalTransactionId: orig,
+			Status:                status,
+			SignedTransactionInfo: s.signTransaction(t),
+			SignedRenewalInfo:     s.Sign(&renewal),
+		})
+	}
+	resp := &appleapi.StatusResponse{AppAppleId: TestAppAppleId, BundleId: s.Bid, Environment: s.Environment, Data: []appleapi.SubscriptionGroupStatus{}}
+	for _, name := range names {
+		resp.Data = append(resp.Data, *groups[name])
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (s *ServerApiServer) refunds(w http.ResponseWriter, r *http.Request, id string) {
+	if s.transaction(id) == nil {
+		serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeTransactionIdNotFound, "Transaction id not found.")
+		return
+	}
+	var list []*appleapi.JWSTransactionDecodedPayload
+	for _, t := range s.transactions {
+		if t.RevocationDate != 0 {
+			list = append(list, t)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].RevocationDate < list[j].RevocationDate })
+	signed, revision, hasMore, ok := s.page(w, r, list)
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, &appleapi.RefundHistoryResponse{HasMore: hasMore, Revision: revision, SignedTransactions: signed})
+}
+
+// extend 延长订阅, 同一个 requestIdentifier 返回同样的结果, 每个订阅最多延长两次
+func (s *ServerApiServer) extend(w http.ResponseWriter, r *http.Request, orig string) {
+	req := &appleapi.ExtendRenewalDateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeGeneralInternal, err.Error())
+		return
+	}
+	switch {
+	case req.ExtendByDays < 1 || req.ExtendByDays > 90:
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidExtendByDays, "Invalid extend by days value.")
+		return
+	case req.ExtendReasonCode < 0 || req.ExtendReasonCode > 3:
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidExtendReasonCode, "Invalid extend reason code.")
+		return
+	case req.RequestIdentifier == "":
+		serverApiError(w, http.StatusBadRequest, appleapi.ErrorCodeInvalidRequestIdentifier, "Invalid request identifier.")
+		return
+	}
+	if resp, ok := s.extended[req.RequestIdentifier]; ok {
+		writeJson(w, http.StatusOK, resp)
+		return
+	}
+	t := s.latest()[orig]
+	if t == nil {
+		if s.transaction(orig) != nil {
+			serverApiError(w, http.StatusForbidden, appleapi.ErrorCodeSubscriptionExtensionIneligible, "Subscription extension ineligible.")
+		} else {
+			serverApiError(w, http.StatusNotFound, appleapi.ErrorCodeOriginalTransactionIdNotFound, "Original transaction id not found.")
+		}
+		return
+	}
+	if t.RevocationDate != 0 || t.Expired(time.Now()) {
+		serverApiError(w, http.StatusForbidden, appleapi.ErrorCodeSubscriptionExtensionIneligible, "Subscription extension ineligible.")
+		return
+	}
+	if s.extensions[orig] >= 2 {
+		serverApiError(w, http.StatusForbidden, appleapi.ErrorCodeSubscriptionMaxExtension, "Subscription max extension.")
+		return
+	}
+	s.extensions[orig]++
+	t.ExpiresDate += int64(req.ExtendByDays) * int64(24*time.Hour/time.Millisecond)
+	resp := &appleapi.ExtendRenewalDateResponse{
+		OriginalTransactionId: orig,
+		WebOrderLineItemId:    t.WebOrderLineItemId,
+		Success:               true,
+		EffectiveDate:         t.ExpiresDate,
+	}
+	s.extended[req.RequestIdentifier] = resp
+	writeJson(w, http.StatusOK, resp)
+}
@@ -0,0 +1,254 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter 是令牌桶，作为 Hook 加到 Token.Hooks 或 Batch.Limiter 后每个请求发送前取一个令牌,
+// ctx 在等待时结束则请求不会发送并返回 ctx.Err()。
+// 桶的容量是每小时配额，按配额匀速补充；X-Rate-Limit 报告的剩余配额直接作为桶中的令牌，
+// 配额充足时不限制并发请求，接近用完时按补充速度发送
+type RateLimiter struct {
+	mu       sync.Mutex
+	reserve  float64 // 保留给其他程序的配额
+	tokens   float64
+	capacity float64
+	rate     float64 // 每秒补充的令牌
+	last     time.Time
+}
+
+// NewRateLimiter 返回每小时 perHour 个请求的限流器，收到 X-Rate-Limit 后以其为准。
+// 剩余配额不超过 reserve 时等待, reserve 不小于 perHour 时 Wait 永远不会返回，返回错误
+func NewRateLimiter(perHour, reserve int) (*RateLimiter, error) {
+	if perHour <= 0 || reserve < 0 || reserve >= perHour {
+		return nil, fmt.Errorf("appleapi: rate limit of %d requests per hour with %d reserved", perHour, reserve)
+	}
+	return &RateLimiter{
+		reserve:  float64(reserve),
+		tokens:   float64(perHour),
+		capacity: float64(perHour),
+		rate:     float64(perHour) / 3600,
+		last:     time.Now(),
+	}, nil
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+}
+
+// Wait 阻塞到取得一个令牌或 ctx 结束
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		// X-Rate-Limit 报告的配额可能比 reserve 还小
+		need := 1 + math.Min(l.reserve, l.capacity-1)
+		if l.tokens >= need {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Hour
+		if l.rate > 0 {
+			wait = time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// BeforeSend 不修改请求, 令牌由 Token 在发送前通过 WaitHook 取得
+func (l *RateLimiter) BeforeSend(req *http.Request) *http.Request {
+	return req
+}
+
+func (l *RateLimiter) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if rl, ok := ParseRateLimit(resp.Header); ok {
+		if rl.Limit > 0 {
+			l.capacity = float64(rl.Limit)
+			l.rate = float64(rl.Limit) / 3600
+		}
+		l.tokens = float64(rl.Remaining)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		l.tokens = 0
+	}
+}
+
+type limiterKey struct{}
+
+// withLimiter 返回带有 l 的 ctx, Token 用这个 ctx 发送请求时把 l 作为 Hook
+func withLimiter(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// ItemError 是批量操作中一项的错误
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+// BatchError 汇总失败的项，按 Index 排序
+type BatchError []ItemError
+
+func (e BatchError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, v := range e {
+		msgs = append(msgs, fmt.Sprintf("#%d: %v", v.Index, v.Err))
+	}
+	return fmt.Sprintf("appleapi: %d operations failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+type Progress struct {
+	Total  int
+	Done   int // 包括失败的项
+	Failed int
+}
+
+// Batch 用固定数量的 goroutine 执行批量操作。
+// Limiter 不为 nil 时通过 ctx 加到 op 发送的每个请求的 Hooks 中, 每个请求取一个令牌,
+// 并根据响应的 X-Rate-Limit 调整; 已经在 Token.Hooks 中时不重复添加
+type Batch struct {
+	Workers    int            // 默认 4
+	Limiter    *RateLimiter   // 为 nil 时不限制请求的速度
+	OnProgress func(Progress) // 每完成一项调用一次, 不会并发调用
+}
+
+// Run 对 0..n-1 执行 op。ctx 结束后不再开始新的项，未开始的项记为 ctx.Err()，
+// op 应该用 ctx 发送请求, 否则 Limiter 和取消都不起作用。
+// 有失败的项时返回 BatchError。b 为 nil 时使用默认设置
+func (b *Batch) Run(ctx context.Context, n int, op func(ctx context.Context, i int) error) error {
+	if b == nil {
+		b = &Batch{}
+	}
+	workers := b.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var mu sync.Mutex
+	var errs BatchError
+	progress := Progress{Total: n}
+	done := func(i int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Done++
+		if err != nil {
+			progress.Failed++
+			errs = append(errs, ItemError{Index: i, Err: err})
+		}
+		if b.OnProgress != nil {
+			b.OnProgress(progress)
+		}
+	}
+
+	if b.Limiter != nil {
+		ctx = withLimiter(ctx, b.Limiter)
+	}
+	items := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if err := ctx.Err(); err != nil {
+					done(i, err)
+					continue
+				}
+				done(i, op(ctx, i))
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	return errs
+}
+
+// RegisterDevices 批量注册设备，返回的设备与 devices 一一对应，失败的项为空
+func (c *Client) RegisterDevices(ctx context.Context, b *Batch, devices []DeviceSpec) ([]Device, error) {
+	list := make([]Device, len(devices))
+	err := b.Run(ctx, len(devices), func(ctx context.Context, i int) error {
+		body, err := c.WithContext(ctx).Devices.DeviceCreate(devices[i].Udid, devices[i].Name)
+		if err != nil {
+			return err
+		}
+		resp := &DeviceResponse{}
+		if err = json.Unmarshal(body, resp); err != nil {
+			return err
+		}
+		list[i] = resp.Data
+		return nil
+	})
+	return list, err
+}
+
+// RegenerateProfiles 批量重新生成描述文件，保留原来的证书和设备
+func (c *Client) RegenerateProfiles(ctx context.Context, b *Batch, ids []string) ([]Profile, error) {
+	list := make([]Profile, len(ids))
+	err := b.Run(ctx, len(ids), func(ctx context.Context, i int) error {
+		body, err := c.WithContext(ctx).RegenerateProfile(ids[i], nil, nil)
+		if err != nil {
+			return err
+		}
+		resp := &ProfileResponse{}
+		if err = json.Unmarshal(body, resp); err != nil {
+			return err
+		}
+		list[i] = resp.Data
+		return nil
+	})
+	return list, err
+}
+
+// DownloadCertificates 批量下载证书，保存为 dir/<id>.cer
+func (c *Client) DownloadCertificates(ctx context.Context, b *Batch, ids []string, dir string) error {
+	return b.Run(ctx, len(ids), func(ctx context.Context, i int) error {
+		body, err := c.WithContext(ctx).Certificates.ReadCertificate(ids[i])
+		if err != nil {
+			return err
+		}
+		resp := &CertificateResponse{}
+		if err = json.Unmarshal(body, resp); err != nil {
+			return err
+		}
+		return resp.Data.SaveCertificateContent(filepath.Join(dir, ids[i]+".cer"))
+	})
+}
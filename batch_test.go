@@ -0,0 +1,253 @@
+package appleapi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func deviceSpecs(n int) []appleapi.DeviceSpec {
+	list := make([]appleapi.DeviceSpec, n)
+	for i := range list {
+		list[i] = appleapi.DeviceSpec{Udid: fmt.Sprintf("00008020-%016X", i), Name: fmt.Sprintf("iPhone %d", i)}
+	}
+	return list
+}
+
+// batchErrors 检查 err 是每一项都失败的 BatchError 并且都是 target
+func batchErrors(t *testing.T, err error, n int, target error) {
+	t.Helper()
+	var be appleapi.BatchError
+	if !errors.As(err, &be) || len(be) != n {
+		t.Fatalf("err = %v, want %d failed items", err, n)
+	}
+	for _, e := range be {
+		if !errors.Is(e.Err, target) {
+			t.Errorf("item %d: %v, want %v", e.Index, e.Err, target)
+		}
+	}
+}
+
+func TestBatchCancelsRequestsInFlight(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	srv.AddFault(appleapitest.Fault{Method: http.MethodPost, Path: "/v1/devices", Latency: time.Second})
+	c := appleapi.NewClient(srv.Token())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.RegisterDevices(ctx, &appleapi.Batch{Workers: 2}, deviceSpecs(4))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("RegisterDevices returned after %v, the requests were not cancelled", elapsed)
+	}
+	batchErrors(t, err, 4, context.DeadlineExceeded)
+}
+
+// exhaustedLimiter 返回已经用完配额的限流器, 下一个令牌在一小时后
+func exhaustedLimiter(t *testing.T) *appleapi.RateLimiter {
+	t.Helper()
+	l, err := appleapi.NewRateLimiter(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestBatchLimiter(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	c := appleapi.NewClient(srv.Token())
+
+	// 没有配额时等待到 ctx 结束, 不发送请求
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	b := &appleapi.Batch{Limiter: exhaustedLimiter(t)}
+	_, err := c.RegisterDevices(ctx, b, deviceSpecs(3))
+	batchErrors(t, err, 3, context.DeadlineExceeded)
+	if n := srv.Requests(); n != 0 {
+		t.Errorf("server got %d requests", n)
+	}
+
+	// Limiter 按 X-Rate-Limit 调整: 初始的 10 个令牌不够后面 20 个请求
+	if b.Limiter, err = appleapi.NewRateLimiter(10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.RegisterDevices(context.Background(), b, deviceSpecs(3)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		if err = b.Limiter.Wait(ctx); err != nil {
+			t.Fatalf("token %d: %v, the limiter did not see X-Rate-Limit", i, err)
+		}
+	}
+}
+
+// stripRateLimit 删除响应的 X-Rate-Limit, 限流器只按自己的计数
+type stripRateLimit struct{}
+
+func (stripRateLimit) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		resp.Header.Del("X-Rate-Limit")
+	}
+	return resp, err
+}
+
+func TestBatchLimiterTakesOneTokenPerRequest(t *testing.T) {
+	for _, inHooks := range []bool{false, true} {
+		srv := appleapitest.NewServer()
+		token := srv.Token()
+		token.Client = &http.Client{Transport: stripRateLimit{}}
+		l, err := appleapi.NewRateLimiter(3, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inHooks {
+			token.Hooks = []appleapi.Hook{l}
+		}
+		c := appleapi.NewClient(token)
+		b := &appleapi.Batch{Workers: 1, Limiter: l}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if _, err = c.RegisterDevices(ctx, b, deviceSpecs(3)); err != nil {
+			t.Errorf("in Token.Hooks %v: 3 requests with 3 tokens: %v", inHooks, err)
+		}
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = c.RegisterDevices(ctx, b, deviceSpecs(4)[3:])
+		batchErrors(t, err, 1, context.DeadlineExceeded)
+		cancel()
+		if n := srv.Requests(); n != 3 {
+			t.Errorf("in Token.Hooks %v: server got %d requests, want 3", inHooks, n)
+		}
+		srv.Close()
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		perHour, reserve int
+		ok               bool
+	}{
+		{3600, 0, true},
+		{3600, 3599, true},
+		{3600, 3600, false},
+		{10, 20, false},
+		{0, 0, false},
+		{10, -1, false},
+	}
+	for _, tt := range tests {
+		if _, err := appleapi.NewRateLimiter(tt.perHour, tt.reserve); (err == nil) != tt.ok {
+			t.Errorf("NewRateLimiter(%d, %d) = %v", tt.perHour, tt.reserve, err)
+		}
+	}
+}
+
+func TestRateLimiterHookReturnsWaitError(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	token := srv.Token()
+	token.Hooks = []appleapi.Hook{exhaustedLimiter(t)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := token.DoContext(ctx, http.MethodGet, token.Url("/v1/devices"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := srv.Requests(); n != 0 {
+		t.Errorf("server got %d requests", n)
+	}
+}
+
+func addRegenerateProfile(srv *appleapitest.Server, name string) *appleapi.Profile {
+	bundle := srv.AddBundleId(appleapi.BundleId{})
+	cert := appleapi.Certificate{}
+	cert.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	certRef := srv.AddCertificate(cert).Ref()
+	p := appleapi.Profile{}
+	p.Attributes.Name = name
+	p.Attributes.ProfileType = "IOS_APP_STORE"
+	p.Relationships.BundleId.Data = bundle.Ref()
+	p.Relationships.Certificates.Data = []appleapi.TypeId{certRef}
+	return srv.AddProfile(p)
+}
+
+func TestRegenerateProfileReportsDeletedProfile(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	p := addRegenerateProfile(srv, "Example App Store")
+	srv.AddFault(appleapitest.Fault{Method: http.MethodPost, Path: "/v1/profiles", Status: http.StatusInternalServerError, Times: 1})
+	c := appleapi.NewClient(srv.Token())
+
+	_, err := c.RegenerateProfiles(context.Background(), nil, []string{p.Id})
+	var be appleapi.BatchError
+	if !errors.As(err, &be) || len(be) != 1 {
+		t.Fatalf("err = %v, want 1 failed item", err)
+	}
+	err = be[0].Err
+	var re *appleapi.ProfileRecreateError
+	if !errors.As(err, &re) {
+		t.Fatalf("err = %v, want a ProfileRecreateError", err)
+	}
+	if re.DeletedId != p.Id || re.Name != "Example App Store" || re.ProfileType != "IOS_APP_STORE" ||
+		re.BundleId != p.Relationships.BundleId.Data.Id || len(re.Certificates) != 1 || re.Certificates[0] != p.Relationships.Certificates.Data[0].Id {
+		t.Errorf("deleted profile = %+v, want the spec of %+v", re, p)
+	}
+	var apiErr *appleapi.ApiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("err = %v, want the 500 of the create", err)
+	}
+
+	// 用错误中的参数可以重新创建
+	if _, err = c.Profiles.ProfileCreateWithType(re.Name, re.ProfileType, re.BundleId, re.Certificates, re.Devices); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cancelAfter 在 method 的响应后取消 ctx
+type cancelAfter struct {
+	method string
+	cancel context.CancelFunc
+}
+
+func (h *cancelAfter) BeforeSend(req *http.Request) *http.Request { return req }
+
+func (h *cancelAfter) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	if req.Method == h.method {
+		h.cancel()
+	}
+}
+
+func TestRegenerateProfileIsNotCancelledAfterDelete(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	p := addRegenerateProfile(srv, "Example App Store")
+	token := srv.Token()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	token.Hooks = []appleapi.Hook{&cancelAfter{method: http.MethodDelete, cancel: cancel}}
+	c := appleapi.NewClient(token)
+
+	profiles, err := c.RegenerateProfiles(ctx, nil, []string{p.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("ctx was not cancelled")
+	}
+	if srv.Resource(p.Ref()) != nil || srv.Resource(profiles[0].Ref()) == nil || profiles[0].Attributes.Name != p.Attributes.Name {
+		t.Errorf("profile %s was not recreated: %+v", p.Id, profiles[0])
+	}
+}
@@ -2,8 +2,6 @@ package appleapi
 
 import (
 	"encoding/json"
-	"strconv"
-	"strings"
 )
 
 type BundleId struct {
@@ -17,98 +15,53 @@ type BundleId struct {
 	Id string `json:"id,omitempty"`
 
 	Relationships struct {
-		Profiles struct {
-			Data  []TypeId `json:"data,omitempty"` //"profiles"
-			Links struct {
-				Related string `json:"related,omitempty"`
-				Self    string `json:"self,omitempty"`
-			} `json:"links,omitempty"`
-			Meta PagingInformation `json:"meta,omitempty"`
-		} `json:"profiles,omitempty"`
+		Profiles             ToManyRelationship `json:"profiles,omitempty"`             //"profiles"
+		BundleIdCapabilities ToManyRelationship `json:"bundleIdCapabilities,omitempty"` //"bundleIdCapabilities"
 	} `json:"relationships,omitempty"`
 
-	BundleIdCapabilities struct {
-		Data  []TypeId `json:"data,omitempty"` //"bundleIdCapabilities"
-		Links struct {
-			Related string `json:"related,omitempty"`
-			Self    string `json:"self,omitempty"`
-		} `json:"links,omitempty"`
-		Meta PagingInformation `json:"meta,omitempty"`
-	} `json:"bundleIdCapabilities,omitempty"`
-
 	Type  string        `json:"type,omitempty"` // "bundleIds"
 	Links ResourceLinks `json:"links,omitempty"`
 }
 
 type ListBundlesQuery struct {
-	BundleIds            string `json:"bundleIds,omitempty"` //Possible values: bundleIdCapabilities, identifier, name, platform, profiles, seedId
-	Profiles             string `json:"profiles,omitempty"`  //Possible values: bundleId, certificates, createdDate, devices, expirationDate, name, platform, profileContent, profileState, profileType, uuid
-	Id                   string `json:"id,omitempty"`
-	Identifier           string `json:"identifier,omitempty"`
-	Name                 string `json:"name,omitempty"`
-	Platform             string `json:"platform,omitempty"` //Possible values: IOS, MAC_OS
-	SeedId               string `json:"seedId,omitempty"`
-	Include              string `json:"include,omitempty"`              //Possible values: bundleIdCapabilities, profiles
-	Limit                int    `json:"limit,omitempty"`                //Maximum: 200
-	LimitProfiles        int    `json:"limit_profiles,omitempty"`       //Maximum: 50
-	Sort                 string `json:"sort,omitempty"`                 //Possible values: id, -id, name, -name, platform, -platform, seedId, -seedId
-	BundleIdCapabilities string `json:"bundleIdCapabilities,omitempty"` //Possible values: bundleId, capabilityType, settings
+	BundleIds            []BundleIdField           `json:"bundleIds,omitempty"`
+	Profiles             []ProfileField            `json:"profiles,omitempty"`
+	Id                   []string                  `json:"id,omitempty"`
+	Identifier           []string                  `json:"identifier,omitempty"`
+	Name                 []string                  `json:"name,omitempty"`
+	Platform             []string                  `json:"platform,omitempty"` //Possible values: IOS, MAC_OS
+	SeedId               []string                  `json:"seedId,omitempty"`
+	Include              []string                  `json:"include,omitempty"`                    //Possible values: bundleIdCapabilities, profiles
+	Limit                int                       `json:"limit,omitempty"`                      //Maximum: 200
+	LimitProfiles        int                       `json:"limit_profiles,omitempty"`             //Maximum: 50
+	LimitCapabilities    int                       `json:"limit_bundleIdCapabilities,omitempty"` //Maximum: 50
+	Sort                 []SortKey                 `json:"sort,omitempty"`                       //BundleIdSort
+	BundleIdCapabilities []BundleIdCapabilityField `json:"bundleIdCapabilities,omitempty"`
+}
+
+func (q *ListBundlesQuery) Builder() *QueryBuilder {
+	return NewQueryBuilder().
+		Fields("bundleIds", fieldStrings(len(q.BundleIds), func(i int) string { return string(q.BundleIds[i]) })...).
+		Fields("profiles", fieldStrings(len(q.Profiles), func(i int) string { return string(q.Profiles[i]) })...).
+		Fields("bundleIdCapabilities", fieldStrings(len(q.BundleIdCapabilities), func(i int) string { return string(q.BundleIdCapabilities[i]) })...).
+		Filter("id", q.Id...).
+		Filter("identifier", q.Identifier...).
+		Filter("name", q.Name...).
+		Filter("platform", q.Platform...).
+		Filter("seedId", q.SeedId...).
+		Include(q.Include...).
+		Limit(q.Limit).
+		LimitRelated("profiles", q.LimitProfiles).
+		LimitRelated("bundleIdCapabilities", q.LimitCapabilities).
+		Sort(q.Sort...)
+}
+
+func (q *ListBundlesQuery) Validate() error {
+	return q.Builder().Err()
 }
 
 func (q *ListBundlesQuery) QueryString() string {
-	sb := strings.Builder{}
-	if "" != q.BundleIds {
-		sb.WriteString("&fields[bundleIds]=")
-		sb.WriteString(q.BundleIds)
-	}
-	if "" != q.Profiles {
-		sb.WriteString("&fields[profiles]=")
-		sb.WriteString(q.Profiles)
-	}
-	if "" != q.Id {
-		sb.WriteString("&filter[id]=")
-		sb.WriteString(q.Id)
-	}
-	if "" != q.Identifier {
-		sb.WriteString("&filter[identifier]=")
-		sb.WriteString(q.Identifier)
-	}
-	if "" != q.Name {
-		sb.WriteString("&filter[name]=")
-		sb.WriteString(q.Name)
-	}
-	if "" != q.Platform {
-		sb.WriteString("&filter[platform]=")
-		sb.WriteString(q.Platform)
-	}
-	if "" != q.SeedId {
-		sb.WriteString("&filter[seedId]=")
-		sb.WriteString(q.SeedId)
-	}
-	if "" != q.Include {
-		sb.WriteString("&include=")
-		sb.WriteString(q.Include)
-	}
-	if q.Limit > 0 {
-		sb.WriteString("&limit=")
-		sb.WriteString(strconv.Itoa(q.Limit))
-	}
-	if q.LimitProfiles > 0 {
-		sb.WriteString("&limit[profiles]=")
-		sb.WriteString(strconv.Itoa(q.LimitProfiles))
-	}
-	if "" != q.Sort {
-		sb.WriteString("&sort=")
-		sb.WriteString(q.Sort)
-	}
-	if "" != q.BundleIdCapabilities {
-		sb.WriteString("&fields[bundleIdCapabilities]=")
-		sb.WriteString(q.BundleIdCapabilities)
-	}
-	if sb.Len() > 1 {
-		return sb.String()[1:sb.Len()]
-	}
-	return sb.String()
+	return q.Builder().Encode()
 }
 
 type BundleIdCreateRequest struct {
@@ -133,17 +86,16 @@ type BundleIdUpdateRequest struct {
 	} `json:"data,omitempty"`
 }
 
+// Included possible types: Profile, BundleIdCapability
 type BundleIdResponse struct {
-	Data     BundleId      `json:"data,omitempty"`
-	Links    DocumentLinks `json:"links,omitempty"`
-	Included interface{}   `json:"included,omitempty"` //Possible types: Profile, BundleIdCapability
+	Data BundleId `json:"data,omitempty"`
+	Document
 }
 
+// Included possible types: Profile, BundleIdCapability
 type BundleIdsResponse struct {
-	Data     []BundleId         `json:"data,omitempty"`
-	Links    PagedDocumentLinks `json:"links,omitempty"`
-	Meta     PagingInformation  `json:"meta,omitempty"`
-	Included interface{}        `json:"included,omitempty"` //Possible types: Profile, BundleIdCapability
+	Data []BundleId `json:"data,omitempty"`
+	CollectionDocument
 }
 
 type Bundles struct {
@@ -152,13 +104,21 @@ type Bundles struct {
 
 // https://developer.apple.com/documentation/appstoreconnectapi/list_bundle_ids
 func (b *Bundles) Query(query *ListBundlesQuery) ([]byte, error) {
-	url := "https://api.appstoreconnect.apple.com/v1/bundleIds"
+	url := b.Url("/v1/bundleIds")
 	if query != nil {
+		if err := query.Validate(); err != nil {
+			return nil, err
+		}
 		url += "?" + query.QueryString()
 	}
 	return b.WebGet(url)
 }
 
+// Next reads the page at the links.next url of a previous Query.
+func (b *Bundles) Next(next string) ([]byte, error) {
+	return b.WebGet(next)
+}
+
 // 创建BundleId
 // https://developer.apple.com/documentation/appstoreconnectapi/register_a_new_bundle_id
 func (b *Bundles) BundleIdCreate(identifier, name string) ([]byte, error) {
@@ -168,7 +128,30 @@ func (b *Bundles) BundleIdCreate(identifier, name string) ([]byte, error) {
 	req.Data.Attributes.Identifier = identifier
 	req.Data.Attributes.Name = name
 
-	url := "https://api.appstoreconnect.apple.com/v1/bundleIds"
+	url := b.Url("/v1/bundleIds")
 	query, _ := json.Marshal(req)
 	return b.WebPost(url, query)
 }
+
+// 修改BundleId名称
+// https://developer.apple.com/documentation/appstoreconnectapi/modify_a_bundle_id
+func (b *Bundles) BundleIdUpdate(id, name string) ([]byte, error) {
+	req := new(BundleIdUpdateRequest)
+	req.Data.Type = "bundleIds"
+	req.Data.Id = id
+	req.Data.Attributes.Name = name
+
+	url := b.Url("/v1/bundleIds/" + id)
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return b.WebPatch(url, reqJson)
+}
+
+// 删除BundleId
+// https://developer.apple.com/documentation/appstoreconnectapi/delete_a_bundle_id
+func (b *Bundles) BundleIdDelete(id string) error {
+	_, err := b.WebDelete(b.Url("/v1/bundleIds/" + id))
+	return err
+}
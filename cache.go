@@ -0,0 +1,314 @@
+package appleapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderCache 标记响应来自缓存: HIT, STALE, REVALIDATED 或 MISS
+const HeaderCache = "X-Appleapi-Cache"
+
+// CacheEntry 是缓存的 GET 响应
+type CacheEntry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+// CacheStore 保存缓存的响应，按资源类型分组以便写入时整体失效
+type CacheStore interface {
+	Get(resource, key string) (*CacheEntry, bool)
+	Set(resource, key string, e *CacheEntry)
+	Invalidate(resource string)
+}
+
+// MemoryCache 是进程内的 CacheStore
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]*CacheEntry
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]map[string]*CacheEntry)}
+}
+
+func (m *MemoryCache) Get(resource, key string) (*CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[resource][key]
+	return e, ok
+}
+
+func (m *MemoryCache) Set(resource, key string, e *CacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries[resource] == nil {
+		m.entries[resource] = make(map[string]*CacheEntry)
+	}
+	m.entries[resource][key] = e
+}
+
+func (m *MemoryCache) Invalidate(resource string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, resource)
+}
+
+// DiskCache 把响应保存在 Dir/<resource>/<sha256(key)>.json，可在多个进程间共享。
+// 缓存的响应包含证书和描述文件的内容，目录权限应只允许当前用户访问
+type DiskCache struct {
+	Dir string
+}
+
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{Dir: dir}
+}
+
+func (d *DiskCache) file(resource, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.Dir, resource, hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *DiskCache) Get(resource, key string) (*CacheEntry, bool) {
+	b, err := ioutil.ReadFile(d.file(resource, key))
+	if err != nil {
+		return nil, false
+	}
+	e := &CacheEntry{}
+	if err = json.Unmarshal(b, e); err != nil {
+		return nil, false
+	}
+	return e, true
+}
+
+func (d *DiskCache) Set(resource, key string, e *CacheEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	file := d.file(resource, key)
+	if err = os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, file)
+}
+
+func (d *DiskCache) Invalidate(resource string) {
+	os.RemoveAll(filepath.Join(d.Dir, resource))
+}
+
+// CacheDependents 是写入一种资源后还需要失效的资源类型，
+// 例如删除证书会使描述文件变为 INVALID
+var CacheDependents = map[string][]string{
+	TypeBundleIds:            {TypeBundleIdCapabilities, TypeProfiles},
+	TypeBundleIdCapabilities: {TypeBundleIds},
+	TypeCertificates:         {TypeProfiles},
+	TypeDevices:              {TypeProfiles},
+	TypeProfiles:             {TypeBundleIds},
+}
+
+// CachingTransport 缓存 GET 请求的成功响应，作为 Token.Client 的 Transport 使用:
+//
+//	token.Client = &http.Client{Transport: appleapi.NewCachingTransport(appleapi.NewMemoryCache())}
+//
+// 响应在 TTL 内直接从缓存返回；过期后 StaleWhileRevalidate 内返回旧的响应并在后台刷新；
+// 再之后重新请求，响应带 ETag 时发送 If-None-Match。
+// POST, PATCH 和 DELETE 在发送前和返回后都使同类型及 CacheDependents 中的缓存失效，
+// 不论是否成功；写入期间开始的 GET 的响应不保存。
+// 不同 API 密钥的缓存互相独立
+type CachingTransport struct {
+	Store                CacheStore
+	Transport            http.RoundTripper        // 默认 http.DefaultTransport
+	DefaultTTL           time.Duration            // 默认 5 分钟
+	TTLs                 map[string]time.Duration // 按资源类型, e.g. TTLs["devices"]
+	StaleWhileRevalidate time.Duration
+
+	mu         sync.Mutex
+	inflight   map[string]bool
+	generation map[string]uint64 // 按资源类型, 每次失效加一
+}
+
+func NewCachingTransport(store CacheStore) *CachingTransport {
+	return &CachingTransport{Store: store, DefaultTTL: 5 * time.Minute}
+}
+
+func (t *CachingTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *CachingTransport) ttl(resource string) time.Duration {
+	if v, ok := t.TTLs[resource]; ok {
+		return v
+	}
+	return t.DefaultTTL
+}
+
+// cacheIdentity 返回 Authorization 中 JWT 的 kid 和 iss，令牌每 20 分钟刷新一次，不能直接作为键
+func cacheIdentity(req *http.Request) string {
+	auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(auth, ".")
+	if len(parts) != 3 {
+		return auth
+	}
+	var header struct {
+		Kid string `json:"kid"`
+	}
+	var payload struct {
+		Iss string `json:"iss"`
+	}
+	h, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	p, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	if err1 != nil || err2 != nil || json.Unmarshal(h, &header) != nil || json.Unmarshal(p, &payload) != nil {
+		return auth
+	}
+	return header.Kid + "/" + payload.Iss
+}
+
+func (e *CacheEntry) response(req *http.Request, status string) *http.Response {
+	header := make(http.Header, len(e.Header)+1)
+	for k, v := range e.Header {
+		header[k] = v
+	}
+	header.Set(HeaderCache, status)
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// Invalidate 使一种资源及其依赖资源的缓存失效
+func (t *CachingTransport) Invalidate(resource string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.generation == nil {
+		t.generation = make(map[string]uint64)
+	}
+	for _, r := range append([]string{resource}, CacheDependents[resource]...) {
+		t.generation[r]++
+		t.Store.Invalidate(r)
+	}
+}
+
+func (t *CachingTransport) currentGeneration(resource string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation[resource]
+}
+
+// store 保存响应, 请求发出后资源已失效时丢弃, 避免旧的响应覆盖写入后的数据
+func (t *CachingTransport) store(resource, key string, generation uint64, e *CacheEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.generation[resource] == generation {
+		t.Store.Set(resource, key, e)
+	}
+}
+
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := resourceOf(req.URL.Path)
+	if req.Method != http.MethodGet {
+		// 失败或超时的写入也可能已经生效
+		t.Invalidate(resource)
+		defer t.Invalidate(resource)
+		return t.transport().RoundTrip(req)
+	}
+
+	key := cacheIdentity(req) + " " + req.URL.String()
+	e, ok := t.Store.Get(resource, key)
+	if ok {
+		age := time.Since(e.Stored)
+		ttl := t.ttl(resource)
+		if age < ttl {
+			return e.response(req, "HIT"), nil
+		}
+		if age < ttl+t.StaleWhileRevalidate {
+			t.revalidate(req, resource, key, e)
+			return e.response(req, "STALE"), nil
+		}
+	}
+	return t.fetch(req, resource, key, e)
+}
+
+// fetch 发送请求并保存成功的响应, old 不为 nil 时发送条件请求
+func (t *CachingTransport) fetch(req *http.Request, resource, key string, old *CacheEntry) (*http.Response, error) {
+	if old != nil && old.Header.Get("ETag") != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", old.Header.Get("ETag"))
+	}
+	generation := t.currentGeneration(resource)
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && old != nil {
+		resp.Body.Close()
+		e := &CacheEntry{Status: old.Status, Header: old.Header, Body: old.Body, Stored: time.Now()}
+		t.store(resource, key, generation, e)
+		return e.response(req, "REVALIDATED"), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	e := &CacheEntry{Status: resp.StatusCode, Header: resp.Header, Body: body, Stored: time.Now()}
+	t.store(resource, key, generation, e)
+	return e.response(req, "MISS"), nil
+}
+
+// revalidate 在后台刷新缓存，同一个键同时只有一个刷新请求
+func (t *CachingTransport) revalidate(req *http.Request, resource, key string, old *CacheEntry) {
+	t.mu.Lock()
+	if t.inflight == nil {
+		t.inflight = make(map[string]bool)
+	}
+	if t.inflight[key] {
+		t.mu.Unlock()
+		return
+	}
+	t.inflight[key] = true
+	t.mu.Unlock()
+
+	// 原请求的 context 在返回旧响应后可能被取消
+	bg := req.Clone(context.Background())
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.inflight, key)
+			t.mu.Unlock()
+		}()
+		if resp, err := t.fetch(bg, resource, key, old); err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
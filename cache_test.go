@@ -0,0 +1,254 @@
+package appleapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// originServer 是 CachingTransport 后面的 Transport, 返回带 ETag 的响应并记录收到的请求
+type originServer struct {
+	mu       sync.Mutex
+	version  int
+	requests []*http.Request
+}
+
+func (o *originServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, req)
+	etag := `"v` + strconv.Itoa(o.version) + `"`
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": {etag}}, Request: req}
+	switch {
+	case req.Method != http.MethodGet:
+		o.version++
+		resp.StatusCode = http.StatusCreated
+	case req.Header.Get("If-None-Match") == etag:
+		resp.StatusCode = http.StatusNotModified
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(`{"version":` + strconv.Itoa(o.version) + `}`))
+	return resp, nil
+}
+
+func (o *originServer) calls() []*http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*http.Request(nil), o.requests...)
+}
+
+func cacheGet(t *testing.T, ct *CachingTransport, url, kid string) (status, body string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if kid != "" {
+		// 只有 header 和 payload 参与缓存键
+		req.Header.Set("Authorization", "Bearer eyJraWQiOiJ"+kid+"In0.eyJpc3MiOiJpc3MifQ.sig")
+	}
+	resp, err := ct.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp.Header.Get(HeaderCache), string(b)
+}
+
+// age 把缓存的所有响应的保存时间提前 d
+func age(m *MemoryCache, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entries := range m.entries {
+		for _, e := range entries {
+			e.Stored = e.Stored.Add(-d)
+		}
+	}
+}
+
+func TestCachingTransport(t *testing.T) {
+	const devices = "https://api.appstoreconnect.apple.com/v1/devices"
+	tests := []struct {
+		name     string
+		age      time.Duration
+		status   string
+		requests int // 第二次 GET 后 origin 收到的请求数
+		etag     bool
+	}{
+		{"fresh", 4 * time.Minute, "HIT", 1, false},
+		{"stale while revalidate", 6 * time.Minute, "STALE", 2, true},
+		{"expired", 11 * time.Minute, "REVALIDATED", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := &originServer{}
+			store := NewMemoryCache()
+			ct := NewCachingTransport(store)
+			ct.Transport = origin
+			ct.StaleWhileRevalidate = 5 * time.Minute
+
+			if status, _ := cacheGet(t, ct, devices, ""); status != "MISS" {
+				t.Fatalf("first GET: %s, want MISS", status)
+			}
+			age(store, tt.age)
+			status, body := cacheGet(t, ct, devices, "")
+			if status != tt.status || body != `{"version":0}` {
+				t.Errorf("second GET: %s %s, want %s with the cached body", status, body, tt.status)
+			}
+			// 后台刷新
+			for deadline := time.Now().Add(time.Second); len(origin.calls()) < tt.requests && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			calls := origin.calls()
+			if len(calls) != tt.requests {
+				t.Fatalf("origin got %d requests, want %d", len(calls), tt.requests)
+			}
+			if got := calls[len(calls)-1].Header.Get("If-None-Match"); tt.etag && got != `"v0"` {
+				t.Errorf("If-None-Match = %q", got)
+			}
+		})
+	}
+}
+
+func TestCachingTransportInvalidates(t *testing.T) {
+	const base = "https://api.appstoreconnect.apple.com/v1/"
+	origin := &originServer{}
+	ct := NewCachingTransport(NewMemoryCache())
+	ct.Transport = origin
+	for _, path := range []string{"devices", "profiles", "certificates"} {
+		cacheGet(t, ct, base+path, "")
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, base+"devices", strings.NewReader("{}"))
+	resp, err := ct.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// 设备和依赖它的描述文件失效, 证书仍然有效
+	for path, want := range map[string]string{"devices": "MISS", "profiles": "MISS", "certificates": "HIT"} {
+		if status, _ := cacheGet(t, ct, base+path, ""); status != want {
+			t.Errorf("GET %s after POST devices: %s, want %s", path, status, want)
+		}
+	}
+}
+
+func TestCachingTransportSeparatesKeys(t *testing.T) {
+	const devices = "https://api.appstoreconnect.apple.com/v1/devices"
+	origin := &originServer{}
+	ct := NewCachingTransport(NewMemoryCache())
+	ct.Transport = origin
+	cacheGet(t, ct, devices, "A")
+	if status, _ := cacheGet(t, ct, devices, "A"); status != "HIT" {
+		t.Errorf("same key: %s, want HIT", status)
+	}
+	if status, _ := cacheGet(t, ct, devices, "B"); status != "MISS" {
+		t.Errorf("another key: %s, want MISS", status)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := NewDiskCache(dir)
+	e := &CacheEntry{Status: 200, Header: http.Header{"Etag": {`"v0"`}}, Body: []byte("{}"), Stored: time.Now().Round(0)}
+	d.Set("devices", "key", e)
+	got, ok := d.Get("devices", "key")
+	if !ok || got.Header.Get("ETag") != `"v0"` || string(got.Body) != "{}" || !got.Stored.Equal(e.Stored) {
+		t.Fatalf("Get = %+v, %v", got, ok)
+	}
+	d.Invalidate("devices")
+	if _, ok = d.Get("devices", "key"); ok {
+		t.Error("entry survived Invalidate")
+	}
+}
+
+// roundTripperFunc 把函数作为 http.RoundTripper 使用
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestCachingTransportInvalidatesOnFailedWrite(t *testing.T) {
+	const devices = "https://api.appstoreconnect.apple.com/v1/devices"
+	origin := &originServer{}
+	var writeErr error
+	writeStatus := http.StatusInternalServerError
+	ct := NewCachingTransport(NewMemoryCache())
+	ct.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodGet {
+			return origin.RoundTrip(req)
+		}
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		return &http.Response{StatusCode: writeStatus, Body: ioutil.NopCloser(strings.NewReader("{}")), Request: req}, nil
+	})
+
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"500", nil},
+		{"transport error", errors.New("connection reset")},
+	} {
+		writeErr = tt.err
+		cacheGet(t, ct, devices, "")
+		req, _ := http.NewRequest(http.MethodPost, devices, strings.NewReader("{}"))
+		if resp, err := ct.RoundTrip(req); err == nil {
+			resp.Body.Close()
+		}
+		if status, _ := cacheGet(t, ct, devices, ""); status != "MISS" {
+			t.Errorf("GET after a %s write: %s, want MISS", tt.name, status)
+		}
+	}
+}
+
+func TestCachingTransportDropsStaleStore(t *testing.T) {
+	const devices = "https://api.appstoreconnect.apple.com/v1/devices"
+	origin := &originServer{}
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	ct := NewCachingTransport(NewMemoryCache())
+	ct.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodGet {
+			return origin.RoundTrip(req)
+		}
+		// 第一个 GET 在读取旧数据后等待写入完成
+		resp, err := origin.RoundTrip(req)
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		return resp, err
+	})
+
+	done := make(chan string)
+	go func() {
+		_, body := cacheGet(t, ct, devices, "")
+		done <- body
+	}()
+	<-started
+	req, _ := http.NewRequest(http.MethodPost, devices, strings.NewReader("{}"))
+	resp, err := ct.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	close(release)
+	if body := <-done; body != `{"version":0}` {
+		t.Fatalf("slow GET returned %s", body)
+	}
+
+	// 写入前读取的响应不能保存
+	status, body := cacheGet(t, ct, devices, "")
+	if status != "MISS" || body != `{"version":1}` {
+		t.Errorf("GET after the write: %s %s, want MISS with the new version", status, body)
+	}
+}
@@ -0,0 +1,97 @@
+package appleapi
+
+import (
+	"encoding/json"
+)
+
+type CapabilityOption struct {
+	Description      string `json:"description,omitempty"`
+	Enabled          bool   `json:"enabled,omitempty"`
+	EnabledByDefault bool   `json:"enabledByDefault,omitempty"`
+	Key              string `json:"key,omitempty"`
+	Name             string `json:"name,omitempty"`
+	SupportsWildcard bool   `json:"supportsWildcard,omitempty"`
+}
+
+type CapabilitySetting struct {
+	AllowedInstances string             `json:"allowedInstances,omitempty"` //Possible values: ENTRY, SINGLE, MULTIPLE
+	Description      string             `json:"description,omitempty"`
+	EnabledByDefault bool               `json:"enabledByDefault,omitempty"`
+	Key              string             `json:"key,omitempty"`
+	Name             string             `json:"name,omitempty"`
+	Options          []CapabilityOption `json:"options,omitempty"`
+	Visible          bool               `json:"visible,omitempty"`
+	MinInstances     int                `json:"minInstances,omitempty"`
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/bundleidcapability
+type BundleIdCapability struct {
+	Attributes struct {
+		CapabilityType string              `json:"capabilityType,omitempty"` //e.g. ICLOUD, IN_APP_PURCHASE, GAME_CENTER, PUSH_NOTIFICATIONS, ...
+		Settings       []CapabilitySetting `json:"settings,omitempty"`
+	} `json:"attributes,omitempty"`
+	Id    string        `json:"id,omitempty"`
+	Type  string        `json:"type,omitempty"` // "bundleIdCapabilities"
+	Links ResourceLinks `json:"links,omitempty"`
+}
+
+type BundleIdCapabilityCreateRequest struct {
+	Data struct {
+		Attributes struct {
+			CapabilityType string              `json:"capabilityType,omitempty"`
+			Settings       []CapabilitySetting `json:"settings,omitempty"`
+		} `json:"attributes,omitempty"`
+		Relationships struct {
+			BundleId ToOneData `json:"bundleId,omitempty"` //bundleIds
+		} `json:"relationships,omitempty"`
+		Type string `json:"type,omitempty"` // "bundleIdCapabilities"
+	} `json:"data,omitempty"`
+}
+
+type BundleIdCapabilityResponse struct {
+	Data BundleIdCapability `json:"data,omitempty"`
+	Document
+}
+
+type BundleIdCapabilitiesResponse struct {
+	Data []BundleIdCapability `json:"data,omitempty"`
+	CollectionDocument
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/list_all_capabilities_for_a_bundle_id
+// limit 为 0 时使用服务端的默认值, 后续页用 Next 读取
+func (b *Bundles) BundleIdCapabilities(id string, limit int) ([]byte, error) {
+	q := NewQueryBuilder().Limit(limit)
+	if err := q.Err(); err != nil {
+		return nil, err
+	}
+	url := b.Url("/v1/bundleIds/" + id + "/bundleIdCapabilities")
+	if s := q.Encode(); s != "" {
+		url += "?" + s
+	}
+	return b.WebGet(url)
+}
+
+// 开启能力
+// https://developer.apple.com/documentation/appstoreconnectapi/enable_a_capability
+func (b *Bundles) CapabilityEnable(bundleId, capabilityType string, settings []CapabilitySetting) ([]byte, error) {
+	req := new(BundleIdCapabilityCreateRequest)
+	req.Data.Type = TypeBundleIdCapabilities
+	req.Data.Attributes.CapabilityType = capabilityType
+	req.Data.Attributes.Settings = settings
+	req.Data.Relationships.BundleId.Data = TypeId{Id: bundleId, Type: TypeBundleIds}
+
+	url := b.Url("/v1/bundleIdCapabilities")
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return b.WebPost(url, reqJson)
+}
+
+// 关闭能力
+// https://developer.apple.com/documentation/appstoreconnectapi/disable_a_capability
+func (b *Bundles) CapabilityDisable(id string) error {
+	_, err := b.WebDelete(b.Url("/v1/bundleIdCapabilities/" + id))
+	return err
+}
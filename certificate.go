@@ -4,54 +4,36 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"os"
-	"strconv"
-	"strings"
+	"time"
 )
 
 type ListCertificatesQuery struct {
-	Certificates    string `json:"certificates,omitempty"` //Possible values: certificateContent, certificateType, csrContent, displayName, expirationDate, name, platform, serialNumber
-	Id              string `json:"id,omitempty"`
-	SerialNumber    string `json:"serialNumber,omitempty"`
-	Limit           int    `json:"limit,omitempty"`           //Maximum: 200
-	Sort            string `json:"sort,omitempty"`            //Possible values: certificateType, -certificateType, displayName, -displayName, id, -id, serialNumber, -serialNumber
-	CertificateType string `json:"certificateType,omitempty"` //Possible values: IOS_DEVELOPMENT, IOS_DISTRIBUTION, MAC_APP_DISTRIBUTION, MAC_INSTALLER_DISTRIBUTION, MAC_APP_DEVELOPMENT, DEVELOPER_ID_KEXT, DEVELOPER_ID_APPLICATION
-	DisplayName     string `json:"displayName,omitempty"`
+	Certificates    []CertificateField `json:"certificates,omitempty"`
+	Id              []string           `json:"id,omitempty"`
+	SerialNumber    []string           `json:"serialNumber,omitempty"`
+	Limit           int                `json:"limit,omitempty"`           //Maximum: 200
+	Sort            []SortKey          `json:"sort,omitempty"`            //CertificateSort
+	CertificateType []string           `json:"certificateType,omitempty"` //Possible values: IOS_DEVELOPMENT, IOS_DISTRIBUTION, MAC_APP_DISTRIBUTION, MAC_INSTALLER_DISTRIBUTION, MAC_APP_DEVELOPMENT, DEVELOPER_ID_KEXT, DEVELOPER_ID_APPLICATION
+	DisplayName     []string           `json:"displayName,omitempty"`
+}
+
+func (q *ListCertificatesQuery) Builder() *QueryBuilder {
+	return NewQueryBuilder().
+		Fields("certificates", fieldStrings(len(q.Certificates), func(i int) string { return string(q.Certificates[i]) })...).
+		Filter("id", q.Id...).
+		Filter("serialNumber", q.SerialNumber...).
+		Filter("certificateType", q.CertificateType...).
+		Filter("displayName", q.DisplayName...).
+		Limit(q.Limit).
+		Sort(q.Sort...)
+}
+
+func (q *ListCertificatesQuery) Validate() error {
+	return q.Builder().Err()
 }
 
 func (q *ListCertificatesQuery) QueryString() string {
-	sb := strings.Builder{}
-	if "" != q.Certificates {
-		sb.WriteString("&fields[certificates]=")
-		sb.WriteString(q.Certificates)
-	}
-	if "" != q.Id {
-		sb.WriteString("&filter[id]=")
-		sb.WriteString(q.Id)
-	}
-	if "" != q.SerialNumber {
-		sb.WriteString("&filter[serialNumber]=")
-		sb.WriteString(q.SerialNumber)
-	}
-	if q.Limit > 0 {
-		sb.WriteString("&limit=")
-		sb.WriteString(strconv.Itoa(q.Limit))
-	}
-	if "" != q.Sort {
-		sb.WriteString("&sort=")
-		sb.WriteString(q.Sort)
-	}
-	if "" != q.CertificateType {
-		sb.WriteString("&filter[certificateType]=")
-		sb.WriteString(q.CertificateType)
-	}
-	if "" != q.DisplayName {
-		sb.WriteString("&filter[displayName]=")
-		sb.WriteString(q.DisplayName)
-	}
-	if sb.Len() > 1 {
-		return sb.String()[1:sb.Len()]
-	}
-	return sb.String()
+	return q.Builder().Encode()
 }
 
 type CertificateCreateRequest struct {
@@ -101,15 +83,18 @@ func (c *Certificate) SaveCertificateContent(file string) error {
 	return nil
 }
 
+func (c *Certificate) Expiration() (time.Time, error) {
+	return ParseDate(c.Attributes.ExpirationDate)
+}
+
 type CertificateResponse struct {
-	Data  Certificate   `json:"data,omitempty"`
-	Links DocumentLinks `json:"links,omitempty"`
+	Data Certificate `json:"data,omitempty"`
+	Document
 }
 
 type CertificatesResponse struct {
-	Data  []Certificate      `json:"data,omitempty"`
-	Links PagedDocumentLinks `json:"links,omitempty"`
-	Meta  PagingInformation  `json:"meta,omitempty"`
+	Data []Certificate `json:"data,omitempty"`
+	CollectionDocument
 }
 
 type Certificates struct {
@@ -118,13 +103,21 @@ type Certificates struct {
 
 // https://developer.apple.com/documentation/appstoreconnectapi/list_and_download_certificates
 func (c *Certificates) Query(query *ListCertificatesQuery) ([]byte, error) {
-	url := "https://api.appstoreconnect.apple.com/v1/certificates"
+	url := c.Url("/v1/certificates")
 	if query != nil {
+		if err := query.Validate(); err != nil {
+			return nil, err
+		}
 		url += "?" + query.QueryString()
 	}
 	return c.WebGet(url)
 }
 
+// Next reads the page at the links.next url of a previous Query.
+func (c *Certificates) Next(next string) ([]byte, error) {
+	return c.WebGet(next)
+}
+
 // 增加证书
 // https://developer.apple.com/documentation/appstoreconnectapi/create_a_certificate
 func (c *Certificates) CertificateCreate(csrContent, certificateType string) ([]byte, error) {
@@ -133,10 +126,23 @@ func (c *Certificates) CertificateCreate(csrContent, certificateType string) ([]
 	req.Data.Attributes.CsrContent = csrContent
 	req.Data.Attributes.CertificateType = certificateType
 
-	url := "https://api.appstoreconnect.apple.com/v1/certificates"
+	url := c.Url("/v1/certificates")
 	reqJson, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 	return c.WebPost(url, reqJson)
 }
+
+// https://developer.apple.com/documentation/appstoreconnectapi/read_and_download_certificate_information
+func (c *Certificates) ReadCertificate(id string) ([]byte, error) {
+	url := c.Url("/v1/certificates/" + id)
+	return c.WebGet(url)
+}
+
+// 吊销证书
+// https://developer.apple.com/documentation/appstoreconnectapi/revoke_a_certificate
+func (c *Certificates) CertificateRevoke(id string) error {
+	_, err := c.WebDelete(c.Url("/v1/certificates/" + id))
+	return err
+}
@@ -0,0 +1,345 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Client aggregates the resource services. Tests replace any of them with
+// the mocks of the appleapitest package.
+type Client struct {
+	Bundles      BundleIDService
+	Certificates CertificateService
+	Devices      DeviceService
+	Profiles     ProfileService
+}
+
+// NewClient returns a Client whose services share token.
+func NewClient(token *Token) *Client {
+	return &Client{
+		Bundles:      &Bundles{Token: token},
+		Certificates: &Certificates{Token: token},
+		Devices:      &Devices{Token: token},
+		Profiles:     &Profiles{Token: token},
+	}
+}
+
+// WithContext returns a copy of c whose services send their requests with
+// ctx. Services that are not backed by a Token, such as mocks, are kept.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	n := *c
+	if s, ok := c.Bundles.(*Bundles); ok {
+		n.Bundles = &Bundles{Token: s.Token.WithContext(ctx)}
+	}
+	if s, ok := c.Certificates.(*Certificates); ok {
+		n.Certificates = &Certificates{Token: s.Token.WithContext(ctx)}
+	}
+	if s, ok := c.Devices.(*Devices); ok {
+		n.Devices = &Devices{Token: s.Token.WithContext(ctx)}
+	}
+	if s, ok := c.Profiles.(*Profiles); ok {
+		n.Profiles = &Profiles{Token: s.Token.WithContext(ctx)}
+	}
+	return &n
+}
+
+// AllBundleIds lists every page of bundle IDs.
+func (c *Client) AllBundleIds(query *ListBundlesQuery) ([]BundleId, Included, error) {
+	list := make([]BundleId, 0)
+	included := make(Included, 0)
+	b, err := c.Bundles.Query(query)
+	for {
+		if err != nil {
+			return nil, nil, err
+		}
+		resp := &BundleIdsResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, nil, err
+		}
+		list = append(list, resp.Data...)
+		included = append(included, resp.Included...)
+		if !resp.HasNext() {
+			return list, included, nil
+		}
+		b, err = c.Bundles.Next(resp.Links.Next)
+	}
+}
+
+// AllCertificates lists every page of certificates.
+func (c *Client) AllCertificates(query *ListCertificatesQuery) ([]Certificate, error) {
+	list := make([]Certificate, 0)
+	b, err := c.Certificates.Query(query)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp := &CertificatesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		list = append(list, resp.Data...)
+		if !resp.HasNext() {
+			return list, nil
+		}
+		b, err = c.Certificates.Next(resp.Links.Next)
+	}
+}
+
+// AllDevices lists every page of devices.
+func (c *Client) AllDevices(query *ListDevicesQuery) ([]Device, error) {
+	list := make([]Device, 0)
+	b, err := c.Devices.Query(query)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp := &DevicesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		list = append(list, resp.Data...)
+		if !resp.HasNext() {
+			return list, nil
+		}
+		b, err = c.Devices.Next(resp.Links.Next)
+	}
+}
+
+// AllProfiles lists every page of profiles.
+func (c *Client) AllProfiles(query *ListProfilesQuery) ([]Profile, Included, error) {
+	list := make([]Profile, 0)
+	included := make(Included, 0)
+	b, err := c.Profiles.Query(query)
+	for {
+		if err != nil {
+			return nil, nil, err
+		}
+		resp := &ProfilesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, nil, err
+		}
+		list = append(list, resp.Data...)
+		included = append(included, resp.Included...)
+		if !resp.HasNext() {
+			return list, included, nil
+		}
+		b, err = c.Profiles.Next(resp.Links.Next)
+	}
+}
+
+// AllBundleIdCapabilities lists every page of the capabilities of a bundle
+// ID.
+func (c *Client) AllBundleIdCapabilities(id string) ([]BundleIdCapability, error) {
+	list := make([]BundleIdCapability, 0)
+	b, err := c.Bundles.BundleIdCapabilities(id, MaxLimit)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp := &BundleIdCapabilitiesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		list = append(list, resp.Data...)
+		if !resp.HasNext() {
+			return list, nil
+		}
+		b, err = c.Bundles.Next(resp.Links.Next)
+	}
+}
+
+// AllProfileCertificates lists every page of the certificates of a profile.
+func (c *Client) AllProfileCertificates(id string) ([]Certificate, error) {
+	list := make([]Certificate, 0)
+	b, err := c.Profiles.ProfileCertificates(id, MaxLimit)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp := &CertificatesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		list = append(list, resp.Data...)
+		if !resp.HasNext() {
+			return list, nil
+		}
+		b, err = c.Profiles.Next(resp.Links.Next)
+	}
+}
+
+// AllProfileDevices lists every page of the devices of a profile.
+func (c *Client) AllProfileDevices(id string) ([]Device, error) {
+	list := make([]Device, 0)
+	b, err := c.Profiles.ProfileDevices(id, MaxLimit)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp := &DevicesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		list = append(list, resp.Data...)
+		if !resp.HasNext() {
+			return list, nil
+		}
+		b, err = c.Profiles.Next(resp.Links.Next)
+	}
+}
+
+// CompleteProfileCertificates reads the rest of the certificates
+// relationship of p when it was cut at limit[certificates].
+func (c *Client) CompleteProfileCertificates(p *Profile) error {
+	rel := &p.Relationships.Certificates
+	if rel.Meta.Paging.Total <= len(rel.Data) {
+		return nil
+	}
+	list, err := c.AllProfileCertificates(p.Id)
+	if err != nil {
+		return err
+	}
+	rel.Data = make([]TypeId, len(list))
+	for i := range list {
+		rel.Data[i] = list[i].Ref()
+	}
+	rel.Meta.Paging.Total = len(list)
+	return nil
+}
+
+// CompleteProfileDevices reads the rest of the devices relationship of p
+// when it was cut at limit[devices].
+func (c *Client) CompleteProfileDevices(p *Profile) error {
+	rel := &p.Relationships.Devices
+	if rel.Meta.Paging.Total <= len(rel.Data) {
+		return nil
+	}
+	list, err := c.AllProfileDevices(p.Id)
+	if err != nil {
+		return err
+	}
+	rel.Data = make([]TypeId, len(list))
+	for i := range list {
+		rel.Data[i] = list[i].Ref()
+	}
+	rel.Meta.Paging.Total = len(list)
+	return nil
+}
+
+// completeProfile reads the rest of both to-many relationships of p.
+func (c *Client) completeProfile(p *Profile) error {
+	if err := c.CompleteProfileCertificates(p); err != nil {
+		return err
+	}
+	return c.CompleteProfileDevices(p)
+}
+
+// ProfileWithRelationships reads a profile with the data of its bundleId,
+// certificates and devices relationships. Relationships longer than
+// MaxRelatedLimit are read page by page.
+func (c *Client) ProfileWithRelationships(id string) (*Profile, error) {
+	b, err := c.Profiles.Query(&ListProfilesQuery{
+		Id:                []string{id},
+		Include:           []string{"bundleId", "certificates", "devices"},
+		LimitCertificates: MaxRelatedLimit,
+		LimitDevices:      MaxRelatedLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &ProfilesResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("appleapi: profile %s not found", id)
+	}
+	p := &resp.Data[0]
+	if err = c.completeProfile(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RegenerateProfile deletes a profile and creates it again with the same
+// name, type and bundle ID. A nil certificates or devices keeps the ids of
+// the old profile. It returns the response of the create request, or a
+// *ProfileRecreateError when the profile was deleted but not created again.
+func (c *Client) RegenerateProfile(id string, certificates, devices []string) ([]byte, error) {
+	p, err := c.ProfileWithRelationships(id)
+	if err != nil {
+		return nil, err
+	}
+	if certificates == nil {
+		certificates = refIds(p.Relationships.Certificates.Data)
+	}
+	if devices == nil {
+		devices = refIds(p.Relationships.Devices.Data)
+	}
+	return c.recreateProfile(&ProfileRecreateError{
+		DeletedId:    id,
+		Name:         p.Attributes.Name,
+		ProfileType:  p.Attributes.ProfileType,
+		BundleId:     p.Relationships.BundleId.Data.Id,
+		Certificates: certificates,
+		Devices:      devices,
+	})
+}
+
+// ProfileRecreateError is returned when a profile was deleted but creating
+// it again failed. It keeps what is needed to create the profile by hand.
+type ProfileRecreateError struct {
+	DeletedId    string
+	Name         string
+	ProfileType  string
+	BundleId     string // bundle ID 的 id
+	Certificates []string
+	Devices      []string
+	Err          error
+}
+
+func (e *ProfileRecreateError) Error() string {
+	return fmt.Sprintf("appleapi: profile %q (%s) was deleted but not created again: %v", e.Name, e.DeletedId, e.Err)
+}
+
+func (e *ProfileRecreateError) Unwrap() error { return e.Err }
+
+// recreateProfile deletes spec.DeletedId and creates the profile described by
+// spec. Profiles cannot be renamed, so the new one cannot be created first;
+// instead the create is not cancelled with the context of c, so cancelling an
+// operation never leaves the profile deleted.
+func (c *Client) recreateProfile(spec *ProfileRecreateError) ([]byte, error) {
+	if err := c.Profiles.ProfileDelete(spec.DeletedId); err != nil {
+		return nil, err
+	}
+	create := c.Profiles
+	if s, ok := create.(*Profiles); ok && s.Token.ctx != nil {
+		create = &Profiles{Token: s.Token.WithContext(detachedContext{s.Token.ctx})}
+	}
+	b, err := create.ProfileCreateWithType(spec.Name, spec.ProfileType, spec.BundleId, spec.Certificates, spec.Devices)
+	if err != nil {
+		e := *spec
+		e.Err = err
+		return nil, &e
+	}
+	return b, nil
+}
+
+// detachedContext keeps the values of a context, such as the RateLimiter of
+// a Batch, but is never cancelled.
+type detachedContext struct{ parent context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+func refIds(refs []TypeId) []string {
+	ids := make([]string, len(refs))
+	for i, v := range refs {
+		ids[i] = v.Id
+	}
+	return ids
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var bundleCommands = map[string]*command{
+	"list":   {usage: "list bundle IDs", run: bundlesList},
+	"create": {usage: "register a bundle ID", run: bundlesCreate},
+	"delete": {usage: "delete a bundle ID", run: bundlesDelete},
+}
+
+func bundleOutput(list []appleapi.BundleId) *output {
+	o := &output{columns: []string{"ID", "IDENTIFIER", "NAME", "PLATFORM", "SEED ID"}, data: list}
+	for _, b := range list {
+		a := b.Attributes
+		o.rows = append(o.rows, []string{b.Id, a.Identifier, a.Name, a.Platform, a.SeedId})
+	}
+	return o
+}
+
+func bundlesList(c *appleapi.Client, args []string) (*output, error) {
+	var id, identifier, name, platform, sort list
+	fs := newFlagSet("bundles list")
+	fs.Var(&id, "id", "filter by id")
+	fs.Var(&identifier, "identifier", "filter by identifier")
+	fs.Var(&name, "name", "filter by name")
+	fs.Var(&platform, "platform", "filter by platform: IOS, MAC_OS")
+	fs.Var(&sort, "sort", "sort keys, prefix - for descending: id, name, platform, seedId")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	list, _, err := c.AllBundleIds(&appleapi.ListBundlesQuery{
+		Id:         id,
+		Identifier: identifier,
+		Name:       name,
+		Platform:   platform,
+		Sort:       sortKeys(sort),
+		Limit:      appleapi.MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bundleOutput(list), nil
+}
+
+func bundlesCreate(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("bundles create")
+	identifier := fs.String("identifier", "", "bundle identifier, e.g. com.example.app")
+	name := fs.String("name", "", "name")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "identifier", "name"); err != nil {
+		return nil, err
+	}
+
+	b, err := c.Bundles.BundleIdCreate(*identifier, *name)
+	if err != nil {
+		return nil, err
+	}
+	resp := &appleapi.BundleIdResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	return bundleOutput([]appleapi.BundleId{resp.Data}), nil
+}
+
+func bundlesDelete(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("bundles delete")
+	id := fs.String("id", "", "bundle ID resource id")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+
+	if err := c.Bundles.BundleIdDelete(*id); err != nil {
+		return nil, err
+	}
+	return message("deleted bundle ID %s", *id), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var certCommands = map[string]*command{
+	"list":     {usage: "list certificates", run: certsList},
+	"create":   {usage: "create a certificate from a CSR", run: certsCreate},
+	"revoke":   {usage: "revoke a certificate", run: certsRevoke},
+	"download": {usage: "save a certificate as .cer", run: certsDownload},
+	"rotate":   {usage: "replace a certificate and regenerate its profiles", run: certsRotate},
+}
+
+func certOutput(list []appleapi.Certificate) *output {
+	o := &output{columns: []string{"ID", "TYPE", "NAME", "DISPLAY NAME", "SERIAL", "EXPIRES"}, data: list}
+	for _, c := range list {
+		a := c.Attributes
+		o.rows = append(o.rows, []string{c.Id, a.CertificateType, a.Name, a.DisplayName, a.SerialNumber, a.ExpirationDate})
+	}
+	return o
+}
+
+func certsList(c *appleapi.Client, args []string) (*output, error) {
+	var id, certType, displayName, serial, sort list
+	fs := newFlagSet("certs list")
+	fs.Var(&id, "id", "filter by id")
+	fs.Var(&certType, "type", "filter by certificate type, e.g. IOS_DISTRIBUTION")
+	fs.Var(&displayName, "display-name", "filter by display name")
+	fs.Var(&serial, "serial", "filter by serial number")
+	fs.Var(&sort, "sort", "sort keys, prefix - for descending: certificateType, displayName, id, serialNumber")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	list, err := c.AllCertificates(&appleapi.ListCertificatesQuery{
+		Id:              id,
+		CertificateType: certType,
+		DisplayName:     displayName,
+		SerialNumber:    serial,
+		Sort:            sortKeys(sort),
+		Limit:           appleapi.MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return certOutput(list), nil
+}
+
+func certsCreate(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("certs create")
+	certType := fs.String("type", "", "certificate type, e.g. IOS_DISTRIBUTION")
+	csrFile := fs.String("csr", "", "path of the certificate signing request")
+	out := fs.String("out", "", "save the certificate to this .cer file")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "type", "csr"); err != nil {
+		return nil, err
+	}
+
+	csr, err := ioutil.ReadFile(*csrFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.Certificates.CertificateCreate(string(csr), *certType)
+	if err != nil {
+		return nil, err
+	}
+	resp := &appleapi.CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	if *out != "" {
+		if err = resp.Data.SaveCertificateContent(*out); err != nil {
+			return nil, err
+		}
+	}
+	return certOutput([]appleapi.Certificate{resp.Data}), nil
+}
+
+func certsRevoke(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("certs revoke")
+	id := fs.String("id", "", "certificate id")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+
+	if err := c.Certificates.CertificateRevoke(*id); err != nil {
+		return nil, err
+	}
+	return message("revoked certificate %s", *id), nil
+}
+
+func certsDownload(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("certs download")
+	id := fs.String("id", "", "certificate id")
+	out := fs.String("out", "", "path of the .cer file, default <id>.cer")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		*out = *id + ".cer"
+	}
+
+	b, err := c.Certificates.ReadCertificate(*id)
+	if err != nil {
+		return nil, err
+	}
+	resp := &appleapi.CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	if err = resp.Data.SaveCertificateContent(*out); err != nil {
+		return nil, err
+	}
+	return message("saved certificate %s to %s", *id, *out), nil
+}
+
+func certsRotate(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("certs rotate")
+	id := fs.String("id", "", "id of the certificate to replace")
+	commonName := fs.String("common-name", "", "common name of the new certificate request, default the old certificate name")
+	revoke := fs.Bool("revoke", false, "revoke the old certificate")
+	dryRun := fs.Bool("dry-run", false, "only show what would be done")
+	out := fs.String("out", ".", "directory of the new .p12 and .mobileprovision files")
+	password := fs.String("p12-password", os.Getenv("APPLEAPI_P12_PASSWORD"), "password of the .p12 file, default $APPLEAPI_P12_PASSWORD")
+	storeDir := fs.String("store", "", "also save the new assets to this store, the passphrase is read from $APPLEAPI_STORE_PASSPHRASE")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+
+	opt := appleapi.RotateOptions{
+		CertificateId: *id,
+		CommonName:    *commonName,
+		Revoke:        *revoke,
+		DryRun:        *dryRun,
+		OutDir:        *out,
+		P12Password:   *password,
+	}
+	if *storeDir != "" {
+		opt.Store = appleapi.NewStore(*storeDir, os.Getenv("APPLEAPI_STORE_PASSPHRASE"))
+	}
+	result, err := c.RotateCertificate(opt)
+	o := &output{columns: []string{"STEP"}, data: result}
+	for _, s := range result.Steps {
+		o.rows = append(o.rows, []string{s})
+	}
+	for _, f := range result.Files {
+		o.rows = append(o.rows, []string{"saved " + f})
+	}
+	for _, f := range result.Quarantined {
+		o.rows = append(o.rows, []string{"quarantined " + f})
+	}
+	if err != nil {
+		o.write(stderr, "table")
+		return nil, err
+	}
+	return o, nil
+}
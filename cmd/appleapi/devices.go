@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var deviceCommands = map[string]*command{
+	"list":     {usage: "list devices", run: devicesList},
+	"register": {usage: "register a device", run: devicesRegister},
+	"disable":  {usage: "disable a device", run: devicesDisable},
+}
+
+func deviceOutput(list []appleapi.Device) *output {
+	o := &output{columns: []string{"ID", "UDID", "NAME", "CLASS", "MODEL", "PLATFORM", "STATUS", "ADDED"}, data: list}
+	for _, d := range list {
+		a := d.Attributes
+		o.rows = append(o.rows, []string{d.Id, a.Udid, a.Name, a.DeviceClass, a.Model, a.Platform, a.Status, a.AddedDate})
+	}
+	return o
+}
+
+func deviceResponse(b []byte) (*output, error) {
+	resp := &appleapi.DeviceResponse{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	return deviceOutput([]appleapi.Device{resp.Data}), nil
+}
+
+func devicesList(c *appleapi.Client, args []string) (*output, error) {
+	var id, name, platform, status, udid, sort list
+	fs := newFlagSet("devices list")
+	fs.Var(&id, "id", "filter by id")
+	fs.Var(&name, "name", "filter by name")
+	fs.Var(&platform, "platform", "filter by platform: IOS, MAC_OS")
+	fs.Var(&status, "status", "filter by status: ENABLED, DISABLED")
+	fs.Var(&udid, "udid", "filter by udid")
+	fs.Var(&sort, "sort", "sort keys, prefix - for descending: id, name, platform, status, udid")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	list, err := c.AllDevices(&appleapi.ListDevicesQuery{
+		Id:       id,
+		Name:     name,
+		Platform: platform,
+		Status:   status,
+		Udid:     udid,
+		Sort:     sortKeys(sort),
+		Limit:    appleapi.MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deviceOutput(list), nil
+}
+
+func devicesRegister(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("devices register")
+	udid := fs.String("udid", "", "device udid")
+	name := fs.String("name", "", "device name")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "udid", "name"); err != nil {
+		return nil, err
+	}
+
+	b, err := c.Devices.DeviceCreate(*udid, *name)
+	if err != nil {
+		return nil, err
+	}
+	return deviceResponse(b)
+}
+
+func devicesDisable(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("devices disable")
+	id := fs.String("id", "", "device id")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+
+	b, err := c.Devices.DeviceUpdate(*id, "", "DISABLED")
+	if err != nil {
+		return nil, err
+	}
+	return deviceResponse(b)
+}
@@ -0,0 +1,221 @@
+// Command appleapi manages App Store Connect provisioning resources.
+//
+//	appleapi [flags] <resource> <action> [action flags]
+//
+//	bundles  list|create|delete
+//	certs    list|create|revoke|download
+//	devices  list|register|disable
+//	profiles list|create|download|regenerate
+//	monitor  check|run
+//	spec     plan|apply
+//	store    sync|verify|export
+//
+// With -cache-dir the responses of read requests are cached on disk, so
+// repeated commands within -cache-ttl do not use the hourly rate limit.
+//
+// Credentials come from -key-file, -key-id and -issuer-id, or from the
+// APPLEAPI_KEY_FILE, APPLEAPI_KEY_ID and APPLEAPI_ISSUER_ID environment
+// variables.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/sirupsen/logrus"
+)
+
+type command struct {
+	usage string
+	run   func(c *appleapi.Client, args []string) (*output, error)
+}
+
+var commands = map[string]map[string]*command{
+	"bundles":  bundleCommands,
+	"certs":    certCommands,
+	"devices":  deviceCommands,
+	"monitor":  monitorCommands,
+	"profiles": profileCommands,
+	"spec":     specCommands,
+	"store":    storeCommands,
+}
+
+func env(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// stdout and stderr are replaced by the tests.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: appleapi [flags] <resource> <action> [action flags]\n\nResources and actions:\n")
+	resources := make([]string, 0, len(commands))
+	for k := range commands {
+		resources = append(resources, k)
+	}
+	sort.Strings(resources)
+	for _, r := range resources {
+		actions := make([]string, 0, len(commands[r]))
+		for k := range commands[r] {
+			actions = append(actions, k)
+		}
+		sort.Strings(actions)
+		for _, a := range actions {
+			fmt.Fprintf(out, "  %-8s %-10s %s\n", r, a, commands[r][a].usage)
+		}
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command line args and returns the exit code: 2 for a usage
+// error, 1 when the command failed.
+func run(args []string) int {
+	fs := flag.NewFlagSet("appleapi", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	keyFile := fs.String("key-file", env("APPLEAPI_KEY_FILE", ""), "path of the .p8 API key")
+	keyId := fs.String("key-id", env("APPLEAPI_KEY_ID", ""), "API key id (kid)")
+	issuerId := fs.String("issuer-id", env("APPLEAPI_ISSUER_ID", ""), "issuer id (iss)")
+	baseUrl := fs.String("base-url", env("APPLEAPI_BASE_URL", appleapi.DefaultBaseUrl), "API base url")
+	format := fs.String("output", "table", "output format: table, json or csv")
+	verbose := fs.Bool("verbose", false, "log every request")
+	cacheDir := fs.String("cache-dir", env("APPLEAPI_CACHE_DIR", ""), "cache responses of read requests in this directory")
+	cacheTTL := fs.Duration("cache-ttl", 5*time.Minute, "time the cached responses are used")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() < 2 {
+		usage(fs)
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)][fs.Arg(1)]
+	if !ok {
+		fmt.Fprintf(stderr, "appleapi: unknown command %q\n", fs.Arg(0)+" "+fs.Arg(1))
+		usage(fs)
+		return 2
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return fatal(fmt.Errorf("unknown output format %q", *format))
+	}
+
+	if *keyFile == "" || *keyId == "" || *issuerId == "" {
+		return fatal(fmt.Errorf("-key-file, -key-id and -issuer-id are required"))
+	}
+	secret, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return fatal(err)
+	}
+	token := &appleapi.Token{
+		Secret:     string(secret),
+		Kid:        *keyId,
+		Iss:        *issuerId,
+		BaseUrl:    *baseUrl,
+		MaxRetries: 2,
+	}
+	if *cacheDir != "" {
+		cache := appleapi.NewCachingTransport(appleapi.NewDiskCache(*cacheDir))
+		cache.DefaultTTL = *cacheTTL
+		token.Client = &http.Client{Transport: cache}
+	}
+	if *verbose {
+		logger := logrus.New()
+		logger.Out = stderr
+		logger.SetLevel(logrus.DebugLevel)
+		token.Hooks = append(token.Hooks, appleapi.NewLogrusHook(logger))
+	}
+
+	out, err := cmd.run(appleapi.NewClient(token), fs.Args()[2:])
+	if _, ok := err.(usageError); ok {
+		return 2
+	}
+	if err != nil {
+		return fatal(err)
+	}
+	if out != nil {
+		if err := out.write(stdout, *format); err != nil {
+			return fatal(err)
+		}
+	}
+	return 0
+}
+
+func fatal(err error) int {
+	fmt.Fprintln(stderr, "appleapi:", err)
+	return 1
+}
+
+// list is a flag.Value of comma separated values, it may be repeated.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// sortKeys parses "-name,id" into sort keys.
+func sortKeys(l list) []appleapi.SortKey {
+	keys := make([]appleapi.SortKey, len(l))
+	for i, v := range l {
+		keys[i] = appleapi.SortKey{Field: strings.TrimPrefix(v, "-"), Descending: strings.HasPrefix(v, "-")}
+	}
+	return keys
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// usageError is a flag parse error of an action, the flag package already
+// printed it with the usage.
+type usageError struct {
+	error
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	return nil
+}
+
+func required(fs *flag.FlagSet, names ...string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, n := range names {
+		if !set[n] {
+			return fmt.Errorf("%s: -%s is required", fs.Name(), n)
+		}
+	}
+	return nil
+}
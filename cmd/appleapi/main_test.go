@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+// runCli runs the command line against srv and returns the exit code, the
+// standard output and the standard error.
+func runCli(t *testing.T, srv *appleapitest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "appleapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "AuthKey.p8")
+	if err = ioutil.WriteFile(keyFile, srv.AuthKey(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	stdout, stderr = out, errOut
+	defer func() { stdout, stderr = os.Stdout, os.Stderr }()
+	args = append([]string{"-key-file", keyFile, "-key-id", srv.Kid, "-issuer-id", srv.Iss, "-base-url", srv.URL}, args...)
+	code := run(args)
+	return code, out.String(), errOut.String()
+}
+
+func addDevices(srv *appleapitest.Server, n int) {
+	for i := 0; i < n; i++ {
+		d := appleapi.Device{}
+		d.Attributes.Udid = fmt.Sprintf("00008020-%016X", i)
+		d.Attributes.Name = fmt.Sprintf("iPhone %d", i)
+		d.Attributes.Platform = appleapi.PlatformIos
+		srv.AddDevice(d)
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		args    []string
+		noCreds bool
+		code    int
+		stderr  string
+	}{
+		{name: "no command", code: 2, stderr: "Usage: appleapi"},
+		{name: "unknown command", args: []string{"devices", "explode"}, code: 2, stderr: `unknown command "devices explode"`},
+		{name: "unknown global flag", args: []string{"-bogus", "devices", "list"}, code: 2, stderr: "flag provided but not defined: -bogus"},
+		{name: "unknown action flag", args: []string{"devices", "list", "-bogus"}, code: 2, stderr: "flag provided but not defined: -bogus"},
+		{name: "bad output", args: []string{"-output", "xml", "devices", "list"}, code: 1, stderr: `unknown output format "xml"`},
+		{name: "missing required flag", args: []string{"certs", "revoke"}, code: 1, stderr: "certs revoke: -id is required"},
+		{name: "missing credentials", args: []string{"devices", "list"}, noCreds: true, code: 1, stderr: "-key-file, -key-id and -issuer-id are required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code int
+			var errOut string
+			if tt.noCreds {
+				buf := &bytes.Buffer{}
+				stderr = buf
+				code = run(tt.args)
+				stderr = os.Stderr
+				errOut = buf.String()
+			} else {
+				code, _, errOut = runCli(t, srv, tt.args...)
+			}
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(errOut, tt.stderr) {
+				t.Errorf("stderr = %q, want %q", errOut, tt.stderr)
+			}
+		})
+	}
+	if n := srv.Requests(); n != 0 {
+		t.Errorf("usage errors sent %d requests", n)
+	}
+}
+
+func TestDevicesListOutput(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	addDevices(srv, 3)
+
+	code, out, errOut := runCli(t, srv, "devices", "list", "-sort", "-name")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("table has %d lines, want header and 3 rows:\n%s", len(lines), out)
+	}
+	if fields := strings.Fields(lines[0]); fields[0] != "ID" || fields[1] != "UDID" || fields[2] != "NAME" {
+		t.Errorf("header = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "iPhone 2") || !strings.Contains(lines[3], "iPhone 0") {
+		t.Errorf("rows are not sorted by -name:\n%s", out)
+	}
+
+	code, out, errOut = runCli(t, srv, "-output", "json", "devices", "list")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	var devices []appleapi.Device
+	if err := json.Unmarshal([]byte(out), &devices); err != nil {
+		t.Fatalf("json output: %v\n%s", err, out)
+	}
+	if len(devices) != 3 || devices[0].Attributes.Udid == "" {
+		t.Errorf("json output has %d devices: %+v", len(devices), devices)
+	}
+}
+
+func TestRunExitsOnApiError(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+
+	code, out, errOut := runCli(t, srv, "certs", "revoke", "-id", "MISSING")
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if out != "" {
+		t.Errorf("stdout = %q, want nothing", out)
+	}
+	if !strings.HasPrefix(errOut, "appleapi: ") || !strings.Contains(errOut, "404") {
+		t.Errorf("stderr = %q, want the API error", errOut)
+	}
+}
+
+func TestCertsRotateDryRun(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	bundle := srv.AddBundleId(appleapi.BundleId{})
+	cert := appleapi.Certificate{}
+	cert.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	cert.Attributes.Name = "Example Distribution"
+	cert.Attributes.ExpirationDate = time.Now().AddDate(0, 1, 0).UTC().Format(appleapi.DateLayout)
+	certId := srv.AddCertificate(cert).Id
+	p := appleapi.Profile{}
+	p.Attributes.Name = "Example App Store"
+	p.Attributes.ProfileType = "IOS_APP_STORE"
+	p.Relationships.BundleId.Data = bundle.Ref()
+	p.Relationships.Certificates.Data = []appleapi.TypeId{{Id: certId, Type: appleapi.TypeCertificates}}
+	profileId := srv.AddProfile(p).Id
+
+	code, out, errOut := runCli(t, srv, "certs", "rotate", "-id", certId, "-revoke", "-dry-run")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	for _, want := range []string{
+		`create IOS_DISTRIBUTION certificate "Example Distribution"`,
+		fmt.Sprintf(`regenerate profile "Example App Store" (%s)`, profileId),
+		"revoke certificate " + certId,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	c := appleapi.NewClient(srv.Token())
+	certs, err := c.AllCertificates(&appleapi.ListCertificatesQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Id != certId {
+		t.Errorf("dry run changed the certificates: %+v", certs)
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	addDevices(srv, 2)
+	dir, err := ioutil.TempDir("", "appleapi-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var outs []string
+	for i := 0; i < 2; i++ {
+		code, out, errOut := runCli(t, srv, "-cache-dir", dir, "devices", "list")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, errOut)
+		}
+		outs = append(outs, out)
+	}
+	if n := srv.Requests(); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+	if outs[0] != outs[1] {
+		t.Errorf("cached output differs:\n%s\n%s", outs[0], outs[1])
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) == 0 {
+		t.Errorf("cache dir is empty: %v", err)
+	}
+
+	code, _, errOut := runCli(t, srv, "-cache-dir", dir, "-cache-ttl", "0s", "devices", "list")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, errOut)
+	}
+	if n := srv.Requests(); n != 2 {
+		t.Errorf("server received %d requests after -cache-ttl 0s, want 2", n)
+	}
+}
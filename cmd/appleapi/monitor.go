@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var monitorCommands = map[string]*command{
+	"check": {usage: "list expiring, expired and invalid certificates and profiles", run: monitorCheck},
+	"run":   {usage: "check periodically and send alerts", run: monitorRun},
+}
+
+func alertOutput(alerts []appleapi.Alert) *output {
+	o := &output{columns: []string{"KIND", "TYPE", "ID", "NAME", "EXPIRES"}, data: alerts}
+	for _, a := range alerts {
+		expires := ""
+		if !a.Expiration.IsZero() {
+			expires = a.Expiration.Format(appleapi.DateLayout)
+		}
+		o.rows = append(o.rows, []string{string(a.Kind), a.Type, a.Id, a.Name, expires})
+	}
+	return o
+}
+
+type durations []time.Duration
+
+func (d *durations) String() string {
+	return fmt.Sprint(*d)
+}
+
+func (d *durations) Set(v string) error {
+	var l list
+	l.Set(v)
+	for _, s := range l {
+		t, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = append(*d, t)
+	}
+	return nil
+}
+
+func monitorCheck(c *appleapi.Client, args []string) (*output, error) {
+	var thresholds durations
+	fs := newFlagSet("monitor check")
+	fs.Var(&thresholds, "thresholds", "alert thresholds before expiration, default 720h,168h,24h")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	m := appleapi.NewMonitor(c, "")
+	m.Thresholds = thresholds
+	alerts, err := m.Check()
+	if err != nil {
+		return nil, err
+	}
+	return alertOutput(alerts), nil
+}
+
+func monitorRun(c *appleapi.Client, args []string) (*output, error) {
+	var thresholds durations
+	var webhooks, mailTo list
+	fs := newFlagSet("monitor run")
+	fs.Var(&thresholds, "thresholds", "alert thresholds before expiration, default 720h,168h,24h")
+	interval := fs.Duration("interval", time.Hour, "time between checks")
+	once := fs.Bool("once", false, "check once and exit")
+	state := fs.String("state", "appleapi-monitor.json", "file of the alerts already sent")
+	fs.Var(&webhooks, "webhook", "post alerts to these urls")
+	smtpAddr := fs.String("smtp-addr", "", "SMTP server host:port")
+	smtpUser := fs.String("smtp-user", "", "SMTP user, the password is read from $APPLEAPI_SMTP_PASSWORD")
+	mailFrom := fs.String("mail-from", "", "sender of alert emails")
+	fs.Var(&mailTo, "mail-to", "recipients of alert emails")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	m := appleapi.NewMonitor(c, *state, &appleapi.WriterSink{W: stdout})
+	m.Thresholds = thresholds
+	for _, u := range webhooks {
+		m.Sinks = append(m.Sinks, &appleapi.WebhookSink{Url: u})
+	}
+	if *smtpAddr != "" {
+		if *mailFrom == "" || len(mailTo) == 0 {
+			return nil, fmt.Errorf("monitor run: -mail-from and -mail-to are required with -smtp-addr")
+		}
+		sink := &appleapi.SmtpSink{Addr: *smtpAddr, From: *mailFrom, To: mailTo}
+		if *smtpUser != "" {
+			host, _, err := net.SplitHostPort(*smtpAddr)
+			if err != nil {
+				return nil, err
+			}
+			sink.Auth = smtp.PlainAuth("", *smtpUser, os.Getenv("APPLEAPI_SMTP_PASSWORD"), host)
+		}
+		m.Sinks = append(m.Sinks, sink)
+	}
+
+	if *once {
+		_, err := m.RunOnce()
+		return nil, err
+	}
+	return nil, m.Run(context.Background(), *interval, func(err error) {
+		fmt.Fprintln(stderr, "appleapi:", err)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// output is the result of a command, data is written as json and the rows
+// as a table or csv.
+type output struct {
+	columns []string
+	rows    [][]string
+	data    interface{}
+}
+
+func (o *output) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.data)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(o.columns)
+		cw.WriteAll(o.rows)
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(o.columns, "\t"))
+	for _, r := range o.rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// message is the output of a command without data.
+func message(format string, args ...interface{}) *output {
+	msg := fmt.Sprintf(format, args...)
+	return &output{
+		columns: []string{"RESULT"},
+		rows:    [][]string{{msg}},
+		data:    map[string]string{"result": msg},
+	}
+}
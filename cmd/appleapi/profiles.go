@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var profileCommands = map[string]*command{
+	"list":       {usage: "list provisioning profiles", run: profilesList},
+	"create":     {usage: "create a provisioning profile", run: profilesCreate},
+	"download":   {usage: "save a profile as .mobileprovision", run: profilesDownload},
+	"regenerate": {usage: "delete and recreate a profile", run: profilesRegenerate},
+}
+
+func profileOutput(list []appleapi.Profile) *output {
+	o := &output{columns: []string{"ID", "NAME", "TYPE", "STATE", "UUID", "EXPIRES"}, data: list}
+	for _, p := range list {
+		a := p.Attributes
+		o.rows = append(o.rows, []string{p.Id, a.Name, a.ProfileType, a.ProfileState, a.Uuid, a.ExpirationDate})
+	}
+	return o
+}
+
+func profilesList(c *appleapi.Client, args []string) (*output, error) {
+	var id, name, state, profileType, sort list
+	fs := newFlagSet("profiles list")
+	fs.Var(&id, "id", "filter by id")
+	fs.Var(&name, "name", "filter by name")
+	fs.Var(&state, "state", "filter by state: ACTIVE, INVALID")
+	fs.Var(&profileType, "type", "filter by profile type, e.g. IOS_APP_STORE")
+	fs.Var(&sort, "sort", "sort keys, prefix - for descending: id, name, profileState, profileType")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+
+	list, _, err := c.AllProfiles(&appleapi.ListProfilesQuery{
+		Id:           id,
+		Name:         name,
+		ProfileState: state,
+		ProfileType:  profileType,
+		Sort:         sortKeys(sort),
+		Limit:        appleapi.MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profileOutput(list), nil
+}
+
+func profilesCreate(c *appleapi.Client, args []string) (*output, error) {
+	var certs, devices list
+	fs := newFlagSet("profiles create")
+	name := fs.String("name", "", "profile name")
+	profileType := fs.String("type", "", "profile type, e.g. IOS_APP_STORE")
+	bundleId := fs.String("bundle-id", "", "bundle ID resource id")
+	fs.Var(&certs, "certs", "certificate ids")
+	fs.Var(&devices, "devices", "device ids")
+	out := fs.String("out", "", "save the profile to this .mobileprovision file")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "name", "type", "bundle-id", "certs"); err != nil {
+		return nil, err
+	}
+
+	b, err := c.Profiles.ProfileCreateWithType(*name, *profileType, *bundleId, certs, devices)
+	if err != nil {
+		return nil, err
+	}
+	return saveProfile(b, *out)
+}
+
+func saveProfile(b []byte, file string) (*output, error) {
+	resp := &appleapi.ProfileResponse{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if err := resp.Data.SaveProfileContent(file); err != nil {
+			return nil, err
+		}
+	}
+	return profileOutput([]appleapi.Profile{resp.Data}), nil
+}
+
+func profilesDownload(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("profiles download")
+	id := fs.String("id", "", "profile id")
+	out := fs.String("out", "", "path of the .mobileprovision file, default <id>.mobileprovision")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		*out = *id + ".mobileprovision"
+	}
+
+	b, err := c.Profiles.ReadProfile(*id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = saveProfile(b, *out); err != nil {
+		return nil, err
+	}
+	return message("saved profile %s to %s", *id, *out), nil
+}
+
+func profilesRegenerate(c *appleapi.Client, args []string) (*output, error) {
+	var certs, devices list
+	fs := newFlagSet("profiles regenerate")
+	id := fs.String("id", "", "profile id")
+	fs.Var(&certs, "certs", "certificate ids, default the certificates of the old profile")
+	fs.Var(&devices, "devices", "device ids, default the devices of the old profile")
+	allDevices := fs.Bool("all-devices", false, "use every enabled device")
+	out := fs.String("out", "", "save the new profile to this .mobileprovision file")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "id"); err != nil {
+		return nil, err
+	}
+
+	if *allDevices {
+		list, err := c.AllDevices(&appleapi.ListDevicesQuery{
+			Status: []string{"ENABLED"},
+			Limit:  appleapi.MaxLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		devices = devices[:0]
+		for _, d := range list {
+			devices = append(devices, d.Id)
+		}
+	}
+	var certIds, deviceIds []string
+	if len(certs) > 0 {
+		certIds = certs
+	}
+	if len(devices) > 0 || *allDevices {
+		deviceIds = append([]string{}, devices...)
+	}
+	b, err := c.RegenerateProfile(*id, certIds, deviceIds)
+	if err != nil {
+		return nil, err
+	}
+	return saveProfile(b, *out)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var specCommands = map[string]*command{
+	"plan":  {usage: "show the changes needed to match a spec file", run: specPlan},
+	"apply": {usage: "apply the changes needed to match a spec file", run: specApply},
+}
+
+func loadPlan(c *appleapi.Client, name string, args []string) (*appleapi.Plan, error) {
+	fs := newFlagSet(name)
+	file := fs.String("file", "", "path of the YAML or JSON spec")
+	prune := fs.Bool("prune", false, "delete resources that are not in the spec")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "file"); err != nil {
+		return nil, err
+	}
+
+	spec, err := appleapi.LoadSpec(*file)
+	if err != nil {
+		return nil, err
+	}
+	spec.Prune = spec.Prune || *prune
+	plan, err := c.Plan(spec)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(stdout, plan)
+	return plan, nil
+}
+
+func specPlan(c *appleapi.Client, args []string) (*output, error) {
+	_, err := loadPlan(c, "spec plan", args)
+	return nil, err
+}
+
+func specApply(c *appleapi.Client, args []string) (*output, error) {
+	plan, err := loadPlan(c, "spec apply", args)
+	if err != nil || plan.Empty() {
+		return nil, err
+	}
+	if err = plan.Apply(); err != nil {
+		return nil, err
+	}
+	add, change, destroy := plan.Summary()
+	return message("Apply complete! Resources: %d added, %d changed, %d destroyed.", add, change, destroy), nil
+}
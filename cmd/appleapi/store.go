@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gamebtc/appleapi"
+)
+
+var storeCommands = map[string]*command{
+	"sync":   {usage: "create missing signing assets and save them encrypted", run: storeSync},
+	"verify": {usage: "check the store against App Store Connect", run: storeVerify},
+	"export": {usage: "decrypt a file of the store", run: storeExport},
+}
+
+func storeFlags(fs *flag.FlagSet) (dir, passphrase *string) {
+	dir = fs.String("dir", ".", "store directory")
+	passphrase = fs.String("passphrase", os.Getenv("APPLEAPI_STORE_PASSPHRASE"), "store passphrase, default $APPLEAPI_STORE_PASSPHRASE")
+	return
+}
+
+func syncOutput(r *appleapi.SyncReport) *output {
+	o := &output{columns: []string{"ACTION", "NAME"}, data: r}
+	for _, v := range r.Created {
+		o.rows = append(o.rows, []string{"created", v})
+	}
+	for _, v := range r.Stored {
+		o.rows = append(o.rows, []string{"stored", v})
+	}
+	for _, v := range r.Stale {
+		o.rows = append(o.rows, []string{"stale", v})
+	}
+	for _, v := range r.Quarantined {
+		o.rows = append(o.rows, []string{"quarantined", v})
+	}
+	if len(o.rows) == 0 {
+		o.rows = append(o.rows, []string{"unchanged", "certificate " + r.Certificate})
+	}
+	return o
+}
+
+func runSync(c *appleapi.Client, name string, args []string, readOnly bool) (*output, error) {
+	var identifiers list
+	fs := newFlagSet(name)
+	dir, passphrase := storeFlags(fs)
+	certType := fs.String("cert-type", "", "certificate type, e.g. IOS_DISTRIBUTION")
+	profileType := fs.String("profile-type", "", "profile type, e.g. IOS_APP_STORE")
+	fs.Var(&identifiers, "identifiers", "bundle identifiers")
+	commonName := fs.String("common-name", "", "common name of a new certificate request")
+	prune := fs.Bool("prune", false, "move the files of revoked or expired certificates to quarantine/")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "cert-type"); err != nil {
+		return nil, err
+	}
+	if *passphrase == "" {
+		return nil, fmt.Errorf("%s: -passphrase is required", name)
+	}
+
+	report, err := appleapi.NewStore(*dir, *passphrase).Sync(c, appleapi.SyncOptions{
+		CertificateType: *certType,
+		ProfileType:     *profileType,
+		Identifiers:     identifiers,
+		CommonName:      *commonName,
+		ReadOnly:        readOnly,
+		Prune:           *prune,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return syncOutput(report), nil
+}
+
+func storeSync(c *appleapi.Client, args []string) (*output, error) {
+	return runSync(c, "store sync", args, false)
+}
+
+func storeVerify(c *appleapi.Client, args []string) (*output, error) {
+	return runSync(c, "store verify", args, true)
+}
+
+func storeExport(c *appleapi.Client, args []string) (*output, error) {
+	fs := newFlagSet("store export")
+	dir, passphrase := storeFlags(fs)
+	name := fs.String("name", "", "path in the store, e.g. profiles/IOS_APP_STORE/com.example.app.mobileprovision")
+	out := fs.String("out", "", "output file, default the base name of -name")
+	if err := parse(fs, args); err != nil {
+		return nil, err
+	}
+	if err := required(fs, "name"); err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		*out = filepath.Base(*name)
+	}
+
+	if err := appleapi.NewStore(*dir, *passphrase).Export(*name, *out); err != nil {
+		return nil, err
+	}
+	return message("saved %s to %s", *name, *out), nil
+}
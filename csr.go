@@ -0,0 +1,67 @@
+package appleapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 苹果签发证书要求 2048 位 RSA 密钥
+const CertificateKeyBits = 2048
+
+// NewCertificateRequest 生成私钥和 PEM 格式的证书签名请求，可用于 CertificateCreate
+func NewCertificateRequest(commonName, email string) (*rsa.PrivateKey, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, CertificateKeyBits)
+	if err != nil {
+		return nil, "", err
+	}
+	tpl := &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: commonName},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if email != "" {
+		tpl.EmailAddresses = []string{email}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+	if err != nil {
+		return nil, "", err
+	}
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	return key, string(csr), nil
+}
+
+// MarshalPrivateKey 返回 PKCS#8 PEM 格式的私钥
+func MarshalPrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey 读取 PKCS#8 或 PKCS#1 PEM 格式的私钥
+func ParsePrivateKey(b []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("appleapi: private key is not PEM")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// EncodeP12 返回包含私钥和 DER 格式证书的 PKCS#12 (.p12) 文件，可导入钥匙串
+func EncodeP12(key crypto.PrivateKey, cer []byte, password string) ([]byte, error) {
+	cert, err := x509.ParseCertificate(cer)
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12.Encode(rand.Reader, key, cert, nil, password)
+}
@@ -2,8 +2,6 @@ package appleapi
 
 import (
 	"encoding/json"
-	"strconv"
-	"strings"
 )
 
 type DeviceCreateRequest struct {
@@ -44,66 +42,45 @@ type Device struct {
 }
 
 type DeviceResponse struct {
-	Data  Device        `json:"data,omitempty"`
-	Links DocumentLinks `json:"links,omitempty"`
+	Data Device `json:"data,omitempty"`
+	Document
 }
 
 type DevicesResponse struct {
-	Data  []Device          `json:"data,omitempty"`
-	Links DocumentLinks     `json:"links,omitempty"`
-	Mate  PagingInformation `json:"mate,omitempty"`
+	Data []Device `json:"data,omitempty"`
+	CollectionDocument
 }
 
 // https://developer.apple.com/documentation/appstoreconnectapi/list_devices
 type ListDevicesQuery struct {
-	Devices  string `json:"devices,omitempty"` //Possible values: addedDate, deviceClass, model, name, platform, status, udid
-	Id       string `json:"id,omitempty"`
-	Name     string `json:"name,omitempty"`
-	Platform string `json:"platform,omitempty"`
-	Status   string `json:"status,omitempty"`
-	Udid     string `json:"udid,omitempty"`
-	Limit    int    `json:"limit,omitempty"` //Maximum: 200
-	Sort     string `json:"sort,omitempty"`  //Possible values: id, -id, name, -name, platform, -platform, status, -status, udid, -udid
+	Devices  []DeviceField `json:"devices,omitempty"`
+	Id       []string      `json:"id,omitempty"`
+	Name     []string      `json:"name,omitempty"`
+	Platform []string      `json:"platform,omitempty"`
+	Status   []string      `json:"status,omitempty"`
+	Udid     []string      `json:"udid,omitempty"`
+	Limit    int           `json:"limit,omitempty"` //Maximum: 200
+	Sort     []SortKey     `json:"sort,omitempty"`  //DeviceSort
+}
+
+func (q *ListDevicesQuery) Builder() *QueryBuilder {
+	return NewQueryBuilder().
+		Fields("devices", fieldStrings(len(q.Devices), func(i int) string { return string(q.Devices[i]) })...).
+		Filter("id", q.Id...).
+		Filter("name", q.Name...).
+		Filter("platform", q.Platform...).
+		Filter("status", q.Status...).
+		Filter("udid", q.Udid...).
+		Limit(q.Limit).
+		Sort(q.Sort...)
+}
+
+func (q *ListDevicesQuery) Validate() error {
+	return q.Builder().Err()
 }
 
 func (q *ListDevicesQuery) QueryString() string {
-	sb := strings.Builder{}
-	if "" != q.Devices {
-		sb.WriteString("&fields[devices]=")
-		sb.WriteString(q.Devices)
-	}
-	if "" != q.Id {
-		sb.WriteString("&filter[id]=")
-		sb.WriteString(q.Id)
-	}
-	if "" != q.Name {
-		sb.WriteString("&filter[name]=")
-		sb.WriteString(q.Name)
-	}
-	if "" != q.Platform {
-		sb.WriteString("&filter[platform]=")
-		sb.WriteString(q.Platform)
-	}
-	if "" != q.Status {
-		sb.WriteString("&filter[status]=")
-		sb.WriteString(q.Status)
-	}
-	if "" != q.Udid {
-		sb.WriteString("&filter[udid]=")
-		sb.WriteString(q.Udid)
-	}
-	if q.Limit > 0 {
-		sb.WriteString("&limit=")
-		sb.WriteString(strconv.Itoa(q.Limit))
-	}
-	if "" != q.Sort {
-		sb.WriteString("&sort=")
-		sb.WriteString(q.Sort)
-	}
-	if sb.Len() > 1 {
-		return sb.String()[1:sb.Len()]
-	}
-	return sb.String()
+	return q.Builder().Encode()
 }
 
 type Devices struct {
@@ -112,13 +89,21 @@ type Devices struct {
 
 // https://developer.apple.com/documentation/appstoreconnectapi/list_devices
 func (c *Devices) Query(query *ListDevicesQuery) ([]byte, error) {
-	url := "https://api.appstoreconnect.apple.com/v1/devices"
+	url := c.Url("/v1/devices")
 	if query != nil {
+		if err := query.Validate(); err != nil {
+			return nil, err
+		}
 		url += "?" + query.QueryString()
 	}
 	return c.WebGet(url)
 }
 
+// Next reads the page at the links.next url of a previous Query.
+func (c *Devices) Next(next string) ([]byte, error) {
+	return c.WebGet(next)
+}
+
 // 增加设备
 // https://developer.apple.com/documentation/appstoreconnectapi/register_a_new_device
 func (c *Devices) DeviceCreate(udid, name string) ([]byte, error) {
@@ -128,7 +113,7 @@ func (c *Devices) DeviceCreate(udid, name string) ([]byte, error) {
 	req.Data.Attributes.Name = name
 	req.Data.Attributes.Udid = udid
 
-	url := "https://api.appstoreconnect.apple.com/v1/devices"
+	url := c.Url("/v1/devices")
 	reqJson, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
@@ -145,10 +130,10 @@ func (c *Devices) DeviceUpdate(id, name, status string) ([]byte, error) {
 	req.Data.Attributes.Name = name
 	req.Data.Attributes.Status = status
 
-	url := "https://api.appstoreconnect.apple.com/v1/devices/" + id
+	url := c.Url("/v1/devices/" + id)
 	reqJson, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
-	return c.WebPost(url, reqJson)
+	return c.WebPatch(url, reqJson)
 }
@@ -0,0 +1,216 @@
+package appleapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook observes the requests sent by a Token. BeforeSend may return the
+// request with a new context, AfterReceive must not read the response body.
+type Hook interface {
+	BeforeSend(req *http.Request) *http.Request
+	AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration)
+}
+
+// ErrorHook is implemented by hooks that want the decoded error of a
+// response status other than 2xx, OnApiError is called before AfterReceive.
+type ErrorHook interface {
+	OnApiError(req *http.Request, err *ApiError)
+}
+
+// WaitHook is implemented by hooks that delay requests, such as
+// RateLimiter. Wait is called before BeforeSend, a request is not sent when
+// it returns an error.
+type WaitHook interface {
+	Wait(ctx context.Context) error
+}
+
+// RetryHook is implemented by hooks that want to observe retries.
+type RetryHook interface {
+	OnRetry(method, url string, attempt int, wait time.Duration)
+}
+
+const (
+	HeaderRateLimit = "X-Rate-Limit"
+	HeaderRequestId = "X-Request-Id"
+)
+
+// RateLimit is the hourly quota reported by the X-Rate-Limit header, e.g.
+// "user-hour-lim:3600;user-hour-rem:3599;".
+type RateLimit struct {
+	Limit     int
+	Remaining int
+}
+
+func ParseRateLimit(h http.Header) (RateLimit, bool) {
+	rl := RateLimit{}
+	found := 0
+	for _, part := range strings.Split(h.Get(HeaderRateLimit), ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "user-hour-lim":
+			rl.Limit = n
+			found++
+		case "user-hour-rem":
+			rl.Remaining = n
+			found++
+		}
+	}
+	return rl, found == 2
+}
+
+// RedactAuthorization hides the bearer token of an Authorization header.
+func RedactAuthorization(v string) string {
+	if v == "" {
+		return ""
+	}
+	if strings.HasPrefix(v, "Bearer ") {
+		return "Bearer [REDACTED]"
+	}
+	return "[REDACTED]"
+}
+
+// LogrusHook logs every request with logrus. Successful requests are logged
+// at Info, API errors at Warn and transport errors at Error.
+type LogrusHook struct {
+	Logger logrus.FieldLogger // 默认 logrus.StandardLogger()
+}
+
+func NewLogrusHook(logger logrus.FieldLogger) *LogrusHook {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	return &LogrusHook{Logger: logger}
+}
+
+func (h *LogrusHook) BeforeSend(req *http.Request) *http.Request {
+	h.Logger.WithFields(logrus.Fields{
+		"method":        req.Method,
+		"path":          req.URL.Path,
+		"query":         req.URL.RawQuery,
+		"authorization": RedactAuthorization(req.Header.Get("Authorization")),
+	}).Debug("appleapi: sending request")
+	return req
+}
+
+func (h *LogrusHook) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	fields := logrus.Fields{
+		"method":  req.Method,
+		"path":    req.URL.Path,
+		"latency": latency,
+	}
+	if err != nil {
+		fields["error"] = err
+		h.Logger.WithFields(fields).Error("appleapi: request failed")
+		return
+	}
+	fields["status"] = resp.StatusCode
+	if id := resp.Header.Get(HeaderRequestId); id != "" {
+		fields["request_id"] = id
+	}
+	if rl, ok := ParseRateLimit(resp.Header); ok {
+		fields["rate_limit"] = rl.Limit
+		fields["rate_remaining"] = rl.Remaining
+	}
+	entry := h.Logger.WithFields(fields)
+	if resp.StatusCode >= 400 {
+		entry.Warn("appleapi: request returned an error")
+		return
+	}
+	entry.Info("appleapi: request")
+}
+
+// Tracer starts spans. It is a subset of an OpenTelemetry trace.Tracer, a
+// thin adapter turns an otel tracer into one:
+//
+//	func (a otelTracer) Start(ctx context.Context, name string) (context.Context, appleapi.Span) {
+//		ctx, span := a.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a subset of an OpenTelemetry trace.Span.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type spanKey struct{}
+
+// hookSpan remembers whether the error of the response is recorded.
+type hookSpan struct {
+	Span
+	recorded bool
+}
+
+// SpanHook creates a client span for every request, named after the method
+// and path, with OpenTelemetry semantic convention attributes.
+type SpanHook struct {
+	Tracer Tracer
+}
+
+func NewSpanHook(tracer Tracer) *SpanHook {
+	return &SpanHook{Tracer: tracer}
+}
+
+func (h *SpanHook) BeforeSend(req *http.Request) *http.Request {
+	ctx, span := h.Tracer.Start(req.Context(), "appstoreconnect "+req.Method+" "+req.URL.Path)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	span.SetAttribute("net.peer.name", req.URL.Hostname())
+	return req.WithContext(context.WithValue(ctx, spanKey{}, &hookSpan{Span: span}))
+}
+
+// OnApiError records the status and the code and detail of the first error
+// in the response.
+func (h *SpanHook) OnApiError(req *http.Request, e *ApiError) {
+	span, ok := req.Context().Value(spanKey{}).(*hookSpan)
+	if !ok {
+		return
+	}
+	span.SetAttribute("http.status_code", e.StatusCode)
+	if len(e.Response.Error) > 0 {
+		span.SetAttribute("appleapi.error.code", e.Response.Error[0].Code)
+		span.SetAttribute("appleapi.error.detail", e.Response.Error[0].Detail)
+	}
+	span.RecordError(e)
+	span.recorded = true
+}
+
+func (h *SpanHook) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	span, ok := req.Context().Value(spanKey{}).(*hookSpan)
+	if !ok {
+		return
+	}
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if id := resp.Header.Get(HeaderRequestId); id != "" {
+		span.SetAttribute("appleapi.request_id", id)
+	}
+	if rl, ok := ParseRateLimit(resp.Header); ok {
+		span.SetAttribute("appleapi.rate_limit.remaining", rl.Remaining)
+	}
+	if resp.StatusCode >= 400 && !span.recorded {
+		// 没有调用 OnApiError 的客户端, 只能记录状态码
+		span.RecordError(&ApiError{StatusCode: resp.StatusCode, Header: resp.Header})
+	}
+}
@@ -0,0 +1,170 @@
+package appleapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+type testSpan struct {
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	errs  []error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+func (s *testSpan) End() {
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{attrs: map[string]interface{}{"name": name}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestSpanHookRecordsApiError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantCode   interface{}
+		wantDetail interface{}
+		wantErrs   int
+	}{
+		{
+			name:       "conflict",
+			status:     http.StatusConflict,
+			body:       `{"errors":[{"status":"409","code":"ENTITY_ERROR.ATTRIBUTE.INVALID","title":"An attribute value is invalid.","detail":"A device with number '0001' already exists on this team."}]}`,
+			wantCode:   "ENTITY_ERROR.ATTRIBUTE.INVALID",
+			wantDetail: "A device with number '0001' already exists on this team.",
+			wantErrs:   1,
+		},
+		{
+			name:     "not JSON:API",
+			status:   http.StatusBadGateway,
+			body:     `<html>bad gateway</html>`,
+			wantErrs: 1,
+		},
+		{
+			name:   "ok",
+			status: http.StatusOK,
+			body:   `{"data":[]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			defer srv.Close()
+			tracer := &testTracer{}
+			token.Hooks = []Hook{NewSpanHook(tracer)}
+
+			_, err := token.WebGet(token.Url("/v1/devices"))
+			if tt.wantErrs > 0 && err == nil || tt.wantErrs == 0 && err != nil {
+				t.Fatalf("WebGet() = %v", err)
+			}
+			if len(tracer.spans) != 1 {
+				t.Fatalf("%d spans, want 1", len(tracer.spans))
+			}
+			span := tracer.spans[0]
+			if !span.ended {
+				t.Error("span was not ended")
+			}
+			if got := span.attrs["http.status_code"]; got != tt.status {
+				t.Errorf("http.status_code = %v, want %d", got, tt.status)
+			}
+			if got := span.attrs["appleapi.error.code"]; got != tt.wantCode {
+				t.Errorf("appleapi.error.code = %v, want %v", got, tt.wantCode)
+			}
+			if got := span.attrs["appleapi.error.detail"]; got != tt.wantDetail {
+				t.Errorf("appleapi.error.detail = %v, want %v", got, tt.wantDetail)
+			}
+			if len(span.errs) != tt.wantErrs {
+				t.Fatalf("%d errors recorded, want %d", len(span.errs), tt.wantErrs)
+			}
+			var apiErr *ApiError
+			if tt.wantErrs > 0 && (!errors.As(span.errs[0], &apiErr) || string(apiErr.Body) != tt.body) {
+				t.Errorf("recorded %v, want *ApiError with the response body", span.errs[0])
+			}
+		})
+	}
+}
+
+func TestLogrusHookRedactsAuthorization(t *testing.T) {
+	var bearer string
+	token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {
+		bearer = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		w.Header().Set(HeaderRequestId, "REQ-1")
+		w.Header().Set(HeaderRateLimit, "user-hour-lim:3600;user-hour-rem:3599;")
+		w.Write([]byte(`{"data":[]}`))
+	})
+	defer srv.Close()
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	out := &bytes.Buffer{}
+	logger.SetOutput(out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	token.Hooks = []Hook{NewLogrusHook(logger)}
+
+	if _, err := token.WebGet(token.Url("/v1/devices")); err != nil {
+		t.Fatal(err)
+	}
+	if bearer == "" {
+		t.Fatal("no bearer sent")
+	}
+	if strings.Contains(out.String(), bearer) {
+		t.Fatalf("bearer logged: %s", out.String())
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		for k, v := range e.Data {
+			if s, ok := v.(string); ok && strings.Contains(s, bearer) {
+				t.Errorf("field %s contains the bearer", k)
+			}
+		}
+	}
+	if got := entries[0].Data["authorization"]; got != "Bearer [REDACTED]" {
+		t.Errorf("authorization = %v", got)
+	}
+	last := entries[1]
+	if last.Level != logrus.InfoLevel {
+		t.Errorf("level = %v, want info", last.Level)
+	}
+	want := logrus.Fields{"status": 200, "request_id": "REQ-1", "rate_limit": 3600, "rate_remaining": 3599}
+	for k, v := range want {
+		if last.Data[k] != v {
+			t.Errorf("%s = %v, want %v", k, last.Data[k], v)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package appleapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIdTokenIssuer   = errors.New("siwa: id_token iss is not " + AppleIdUrl)
+	ErrIdTokenAudience = errors.New("siwa: id_token aud is not the client id")
+	ErrIdTokenExpired  = errors.New("siwa: id_token is expired")
+	ErrIdTokenNonce    = errors.New("siwa: id_token nonce does not match")
+)
+
+// real_user_status
+const (
+	RealUserUnsupported = 0
+	RealUserUnknown     = 1
+	RealUserLikelyReal  = 2
+)
+
+// FlexBool 解码 JSON 的 true 或 "true", 苹果的 email_verified 和 is_private_email 两种格式都用过
+type FlexBool bool
+
+func (b *FlexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "", "null":
+		*b = false
+	default:
+		return fmt.Errorf("siwa: invalid boolean %s", data)
+	}
+	return nil
+}
+
+// IdTokenClaims 是 id_token 的载荷
+// https://developer.apple.com/documentation/sign_in_with_apple/sign_in_with_apple_rest_api/authenticating_users_with_sign_in_with_apple
+type IdTokenClaims struct {
+	Iss            string   `json:"iss"`
+	Sub            string   `json:"sub"` // 用户在团队内唯一且不变的 ID
+	Aud            string   `json:"aud"`
+	Exp            int64    `json:"exp"`
+	Iat            int64    `json:"iat"`
+	Nonce          string   `json:"nonce,omitempty"`
+	NonceSupported bool     `json:"nonce_supported,omitempty"`
+	Email          string   `json:"email,omitempty"`
+	EmailVerified  FlexBool `json:"email_verified,omitempty"`
+	IsPrivateEmail FlexBool `json:"is_private_email,omitempty"`
+	RealUserStatus int      `json:"real_user_status,omitempty"`
+	TransferSub    string   `json:"transfer_sub,omitempty"` // app 转移到其他团队时的临时 ID
+	AuthTime       int64    `json:"auth_time,omitempty"`
+	AtHash         string   `json:"at_hash,omitempty"`
+	CHash          string   `json:"c_hash,omitempty"`
+}
+
+func (c *IdTokenClaims) ExpiresAt() time.Time {
+	return time.Unix(c.Exp, 0)
+}
+
+// IdTokenVerifier 验证 Sign in with Apple 的 id_token
+type IdTokenVerifier struct {
+	Keys      *KeySet
+	ClientIds []string      // 允许的 aud, 网页登录为 services ID, app 中登录为 bundle ID
+	Leeway    time.Duration // 检查 exp 时允许的时钟误差
+	Now       func() time.Time
+}
+
+// NewIdTokenVerifier 返回使用苹果公钥 AppleIdKeysUrl 的验证器
+func NewIdTokenVerifier(clientIds ...string) *IdTokenVerifier {
+	return &IdTokenVerifier{Keys: NewKeySet(AppleIdKeysUrl), ClientIds: clientIds}
+}
+
+func (v *IdTokenVerifier) audience(aud string) bool {
+	for _, id := range v.ClientIds {
+		if id == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify 验证签名、kid、iss、aud 和 exp，nonce 不为空时必须与 id_token 中的一致。
+// nonce 是授权请求中传给苹果的值, app 中通常是原始随机数的 SHA256
+func (v *IdTokenVerifier) Verify(ctx context.Context, idToken, nonce string) (*IdTokenClaims, error) {
+	claims := &IdTokenClaims{}
+	if _, err := v.Keys.Verify(ctx, idToken, claims); err != nil {
+		return nil, err
+	}
+	if claims.Iss != AppleIdUrl {
+		return nil, ErrIdTokenIssuer
+	}
+	if !v.audience(claims.Aud) {
+		return nil, ErrIdTokenAudience
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if !now.Before(claims.ExpiresAt().Add(v.Leeway)) {
+		return nil, ErrIdTokenExpired
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, ErrIdTokenNonce
+	}
+	return claims, nil
+}
+
+// VerifyIdToken 用 BaseUrl 下的 /auth/keys 验证 aud 为 ClientId 的 id_token
+func (s *SignInWithApple) VerifyIdToken(ctx context.Context, idToken, nonce string) (*IdTokenClaims, error) {
+	s.mu.Lock()
+	if s.verifier == nil {
+		base := s.BaseUrl
+		if base == "" {
+			base = AppleIdUrl
+		}
+		keys := NewKeySet(strings.TrimSuffix(base, "/") + "/auth/keys")
+		keys.Client = s.Client
+		s.verifier = &IdTokenVerifier{Keys: keys, ClientIds: []string{s.ClientId}}
+	}
+	v := s.verifier
+	s.mu.Unlock()
+	return v.Verify(ctx, idToken, nonce)
+}
@@ -0,0 +1,138 @@
+package appleapi
+
+import (
+	"encoding/json"
+)
+
+const (
+	TypeBundleIds            = "bundleIds"
+	TypeBundleIdCapabilities = "bundleIdCapabilities"
+	TypeCertificates         = "certificates"
+	TypeDevices              = "devices"
+	TypeProfiles             = "profiles"
+)
+
+// Resource is a resource object that can appear in "included".
+type Resource interface {
+	Ref() TypeId
+}
+
+func (b *BundleId) Ref() TypeId           { return TypeId{Id: b.Id, Type: TypeBundleIds} }
+func (c *BundleIdCapability) Ref() TypeId { return TypeId{Id: c.Id, Type: TypeBundleIdCapabilities} }
+func (c *Certificate) Ref() TypeId        { return TypeId{Id: c.Id, Type: TypeCertificates} }
+func (d *Device) Ref() TypeId             { return TypeId{Id: d.Id, Type: TypeDevices} }
+func (p *Profile) Ref() TypeId            { return TypeId{Id: p.Id, Type: TypeProfiles} }
+
+// UnknownResource keeps an included resource whose type is not known.
+type UnknownResource struct {
+	TypeId
+	Raw json.RawMessage
+}
+
+func (u *UnknownResource) Ref() TypeId { return u.TypeId }
+
+func (u *UnknownResource) MarshalJSON() ([]byte, error) {
+	return u.Raw, nil
+}
+
+// Included holds the "included" resources of a compound document, decoded by
+// their type.
+type Included []Resource
+
+func (in *Included) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return err
+	}
+	list := make(Included, 0, len(raws))
+	for _, raw := range raws {
+		var ref TypeId
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return err
+		}
+		var v Resource
+		switch ref.Type {
+		case TypeBundleIds:
+			v = new(BundleId)
+		case TypeBundleIdCapabilities:
+			v = new(BundleIdCapability)
+		case TypeCertificates:
+			v = new(Certificate)
+		case TypeDevices:
+			v = new(Device)
+		case TypeProfiles:
+			v = new(Profile)
+		default:
+			list = append(list, &UnknownResource{TypeId: ref, Raw: raw})
+			continue
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+		list = append(list, v)
+	}
+	*in = list
+	return nil
+}
+
+// Find returns the included resource referenced by ref, or nil.
+func (in Included) Find(ref TypeId) Resource {
+	for _, v := range in {
+		if v.Ref() == ref {
+			return v
+		}
+	}
+	return nil
+}
+
+func (in Included) BundleId(ref TypeId) *BundleId {
+	v, _ := in.Find(ref).(*BundleId)
+	return v
+}
+
+func (in Included) Profile(ref TypeId) *Profile {
+	v, _ := in.Find(ref).(*Profile)
+	return v
+}
+
+// 以下方法按 relationships 的 data 顺序返回已包含的资源, 未包含的资源会被跳过
+
+func (in Included) BundleIdCapabilities(refs []TypeId) []*BundleIdCapability {
+	list := make([]*BundleIdCapability, 0, len(refs))
+	for _, ref := range refs {
+		if v, ok := in.Find(ref).(*BundleIdCapability); ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (in Included) Certificates(refs []TypeId) []*Certificate {
+	list := make([]*Certificate, 0, len(refs))
+	for _, ref := range refs {
+		if v, ok := in.Find(ref).(*Certificate); ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (in Included) Devices(refs []TypeId) []*Device {
+	list := make([]*Device, 0, len(refs))
+	for _, ref := range refs {
+		if v, ok := in.Find(ref).(*Device); ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (in Included) Profiles(refs []TypeId) []*Profile {
+	list := make([]*Profile, 0, len(refs))
+	for _, ref := range refs {
+		if v, ok := in.Find(ref).(*Profile); ok {
+			list = append(list, v)
+		}
+	}
+	return list
+}
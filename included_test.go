@@ -0,0 +1,83 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIncludedDecodesByType(t *testing.T) {
+	var in Included
+	err := json.Unmarshal([]byte(`[
+		{"type": "bundleIds", "id": "B1", "attributes": {"identifier": "com.example.app"}},
+		{"type": "certificates", "id": "C1", "attributes": {"certificateType": "IOS_DISTRIBUTION"}},
+		{"type": "devices", "id": "D1", "attributes": {"udid": "0001"}},
+		{"type": "devices", "id": "D2", "attributes": {"udid": "0002"}},
+		{"type": "bundleIdCapabilities", "id": "B1_PUSH", "attributes": {"capabilityType": "PUSH_NOTIFICATIONS"}},
+		{"type": "apps", "id": "A1", "attributes": {"name": "Example"}}
+	]`), &in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  TypeId
+		want string // 解码后的 Go 类型
+	}{
+		{TypeId{Id: "B1", Type: TypeBundleIds}, "*appleapi.BundleId"},
+		{TypeId{Id: "C1", Type: TypeCertificates}, "*appleapi.Certificate"},
+		{TypeId{Id: "D2", Type: TypeDevices}, "*appleapi.Device"},
+		{TypeId{Id: "B1_PUSH", Type: TypeBundleIdCapabilities}, "*appleapi.BundleIdCapability"},
+		{TypeId{Id: "A1", Type: "apps"}, "*appleapi.UnknownResource"},
+		{TypeId{Id: "D1", Type: TypeCertificates}, "<nil>"},
+		{TypeId{Id: "missing", Type: TypeDevices}, "<nil>"},
+	}
+	for _, tt := range tests {
+		if got := typeName(in.Find(tt.ref)); got != tt.want {
+			t.Errorf("Find(%v) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+
+	if b := in.BundleId(TypeId{Id: "B1", Type: TypeBundleIds}); b == nil || b.Attributes.Identifier != "com.example.app" {
+		t.Errorf("BundleId(B1) = %+v", b)
+	}
+	// 按 refs 的顺序返回, 跳过没有包含的资源
+	devices := in.Devices([]TypeId{{Id: "D2", Type: TypeDevices}, {Id: "D9", Type: TypeDevices}, {Id: "D1", Type: TypeDevices}})
+	if len(devices) != 2 || devices[0].Attributes.Udid != "0002" || devices[1].Attributes.Udid != "0001" {
+		t.Errorf("Devices() = %+v", devices)
+	}
+	unknown, err := json.Marshal(in.Find(TypeId{Id: "A1", Type: "apps"}))
+	if err != nil || string(unknown) != `{"type":"apps","id":"A1","attributes":{"name":"Example"}}` {
+		t.Errorf("unknown resource encodes to %s, %v", unknown, err)
+	}
+}
+
+func TestIncludedResolvesProfileRelationships(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "profiles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &ProfilesResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err)
+	}
+	rel := resp.Data[0].Relationships
+	if b := resp.Included.BundleId(rel.BundleId.Data); b == nil || b.Attributes.Identifier != "com.example.app" {
+		t.Errorf("bundleId = %+v", b)
+	}
+	if certs := resp.Included.Certificates(rel.Certificates.Data); len(certs) != 1 || certs[0].Attributes.CertificateType != "IOS_DISTRIBUTION" {
+		t.Errorf("certificates = %+v", certs)
+	}
+	if devices := resp.Included.Devices(rel.Devices.Data); len(devices) != 1 || devices[0].Id != "8ZS5Y5WQ7L" {
+		t.Errorf("devices = %+v", devices)
+	}
+}
+
+func typeName(v Resource) string {
+	if v == nil {
+		return "<nil>"
+	}
+	return reflect.TypeOf(v).String()
+}
@@ -0,0 +1,174 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON:API 文档结构, 所有资源共用
+// https://developer.apple.com/documentation/appstoreconnectapi
+
+type ErrorResponse struct {
+	Error []struct {
+		Code   string            `json:"code,omitempty"`
+		Status string            `json:"status,omitempty"`
+		Id     string            `json:"id,omitempty"`
+		Title  string            `json:"title,omitempty"`
+		Detail string            `json:"detail,omitempty"`
+		Source map[string]string `json:"source,omitempty"`
+	} `json:"errors,omitempty"`
+}
+
+// ApiError is returned for a response status other than 2xx.
+type ApiError struct {
+	StatusCode int
+	Header     http.Header
+	Response   ErrorResponse
+	Body       []byte // 原始的响应, 不是 JSON:API 格式的错误可以从这里解码
+}
+
+func newApiError(resp *http.Response, body []byte) *ApiError {
+	e := &ApiError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+	json.Unmarshal(body, &e.Response)
+	return e
+}
+
+func (e *ApiError) Error() string {
+	sb := strings.Builder{}
+	sb.WriteString("appleapi: ")
+	sb.WriteString(strconv.Itoa(e.StatusCode))
+	sb.WriteString(" ")
+	sb.WriteString(http.StatusText(e.StatusCode))
+	for _, v := range e.Response.Error {
+		sb.WriteString("; ")
+		sb.WriteString(v.Code)
+		if v.Detail != "" {
+			sb.WriteString(": ")
+			sb.WriteString(v.Detail)
+		} else if v.Title != "" {
+			sb.WriteString(": ")
+			sb.WriteString(v.Title)
+		}
+	}
+	return sb.String()
+}
+
+// DateLayout is the layout of the dates in App Store Connect responses, such
+// as "2020-11-03T08:36:35.000+0000".
+const DateLayout = "2006-01-02T15:04:05.000-0700"
+
+// ParseDate parses a date attribute such as Certificate.Attributes.ExpirationDate.
+func ParseDate(v string) (time.Time, error) {
+	t, err := time.Parse(DateLayout, v)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, v)
+	}
+	return t, nil
+}
+
+type PagingInformation struct {
+	Paging struct {
+		Total int `json:"total"`           //The total number of resources matching your request.
+		Limit int `json:"limit,omitempty"` //The maximum number of resources to return per page, from 0 to 200.
+	} `json:"paging,omitempty"`
+}
+
+type PagedDocumentLinks struct {
+	First string `json:"first,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Self  string `json:"self,omitempty"`
+}
+
+type TypeId struct {
+	Id   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+type DocumentLinks struct {
+	Self string `json:"self,omitempty"`
+}
+
+type ResourceLinks struct {
+	Self string `json:"self,omitempty"`
+}
+
+type RelationshipLinks struct {
+	Related string `json:"related,omitempty"`
+	Self    string `json:"self,omitempty"`
+}
+
+// ToOneRelationship is a relationship of a resource object to one resource.
+type ToOneRelationship struct {
+	Data  TypeId            `json:"data,omitempty"`
+	Links RelationshipLinks `json:"links,omitempty"`
+}
+
+// ToManyRelationship is a relationship of a resource object to many
+// resources, Meta carries the paging of Data.
+type ToManyRelationship struct {
+	Data  []TypeId          `json:"data,omitempty"`
+	Links RelationshipLinks `json:"links,omitempty"`
+	Meta  PagingInformation `json:"meta,omitempty"`
+}
+
+// ToOneData is the relationship data of a create or update request.
+type ToOneData struct {
+	Data TypeId `json:"data,omitempty"`
+}
+
+// MarshalJSON omits the members that were not in the response, so that a
+// decoded relationship encodes back to the same document.
+func (r ToOneRelationship) MarshalJSON() ([]byte, error) {
+	v := make(map[string]interface{})
+	if r.Data != (TypeId{}) {
+		v["data"] = r.Data
+	}
+	if r.Links != (RelationshipLinks{}) {
+		v["links"] = r.Links
+	}
+	return json.Marshal(v)
+}
+
+// MarshalJSON omits the members that were not in the response, a present
+// but empty data is kept as [].
+func (r ToManyRelationship) MarshalJSON() ([]byte, error) {
+	v := make(map[string]interface{})
+	if r.Data != nil {
+		v["data"] = r.Data
+	}
+	if r.Links != (RelationshipLinks{}) {
+		v["links"] = r.Links
+	}
+	if r.Meta != (PagingInformation{}) {
+		v["meta"] = r.Meta
+	}
+	return json.Marshal(v)
+}
+
+// ToManyData is the relationship data of a create or update request.
+type ToManyData struct {
+	Data []TypeId `json:"data,omitempty"`
+}
+
+// Document is the envelope of a response with a single primary resource,
+// embedded after the Data field of each XxxResponse.
+type Document struct {
+	Links    DocumentLinks `json:"links,omitempty"`
+	Included Included      `json:"included,omitempty"`
+}
+
+// CollectionDocument is the envelope of a response with a page of resources,
+// embedded after the Data field of each XxxsResponse.
+type CollectionDocument struct {
+	Links    PagedDocumentLinks `json:"links,omitempty"`
+	Meta     PagingInformation  `json:"meta,omitempty"`
+	Included Included           `json:"included,omitempty"`
+}
+
+// HasNext reports whether there is a next page.
+func (d *CollectionDocument) HasNext() bool {
+	return d.Links.Next != ""
+}
@@ -0,0 +1,106 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compact 删除 null 和空对象, 结构体无法区分它们和缺少的成员
+func compact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			e = compact(e)
+			if m, ok := e.(map[string]interface{}); e == nil || ok && len(m) == 0 {
+				delete(v, k)
+				continue
+			}
+			v[k] = e
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = compact(e)
+		}
+	}
+	return v
+}
+
+func member(v interface{}, path string) interface{} {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	tests := []struct {
+		file    string
+		v       interface{}
+		members []string
+	}{
+		{"devices.json", &DevicesResponse{}, []string{"data", "meta.paging", "links.next"}},
+		{"device.json", &DeviceResponse{}, []string{"data"}},
+		{"profiles.json", &ProfilesResponse{}, []string{"data", "included", "meta.paging", "links.next"}},
+		{"profile.json", &ProfileResponse{}, []string{"data", "included"}},
+		{"bundleIds.json", &BundleIdsResponse{}, []string{"data", "included", "meta.paging", "links.next"}},
+		{"bundleId.json", &BundleIdResponse{}, []string{"data"}},
+		{"certificates.json", &CertificatesResponse{}, []string{"data", "meta.paging", "links.next"}},
+		{"certificate.json", &CertificateResponse{}, []string{"data"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			recorded, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = json.Unmarshal(recorded, tt.v); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			encoded, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			var want, got interface{}
+			json.Unmarshal(recorded, &want)
+			json.Unmarshal(encoded, &got)
+			want, got = compact(want), compact(got)
+			for _, path := range tt.members {
+				w, g := member(want, path), member(got, path)
+				if w == nil {
+					t.Fatalf("%s: recorded payload has no %s", tt.file, path)
+				}
+				if !reflect.DeepEqual(w, g) {
+					wb, _ := json.MarshalIndent(w, "", "  ")
+					gb, _ := json.MarshalIndent(g, "", "  ")
+					t.Errorf("%s changed after round trip\nrecorded: %s\nencoded:  %s", path, wb, gb)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectionDocumentPaging(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "profiles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &ProfilesResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.HasNext() || resp.Meta.Paging.Total != 4 || resp.Meta.Paging.Limit != 1 {
+		t.Errorf("paging = %+v, next = %q", resp.Meta.Paging, resp.Links.Next)
+	}
+	devices := resp.Data[0].Relationships.Devices
+	if devices.Meta.Paging.Total != 57 || len(devices.Data) != 1 {
+		t.Errorf("devices relationship paging = %+v with %d ids", devices.Meta.Paging, len(devices.Data))
+	}
+}
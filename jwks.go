@@ -0,0 +1,178 @@
+package appleapi
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// AppleIdKeysUrl 是 Sign in with Apple 签名 id_token 的公钥
+// https://developer.apple.com/documentation/sign_in_with_apple/fetch_apple_s_public_key_for_verifying_token_signature
+const AppleIdKeysUrl = AppleIdUrl + "/auth/keys"
+
+var ErrUnknownKid = errors.New("jwks: unknown kid")
+
+// https://developer.apple.com/documentation/sign_in_with_apple/jwkset/keys
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// NewRSAJwk 返回 RSA 公钥的 JWK
+func NewRSAJwk(kid string, key *rsa.PublicKey) Jwk {
+	return Jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k *Jwk) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("jwks: key %s has type %s", k.Kid, k.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("jwks: key %s has an invalid exponent", k.Kid)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+// KeySet 下载并缓存 JWKS。遇到未知的 kid 时重新下载一次，苹果轮换密钥后不需要等缓存过期
+type KeySet struct {
+	Url    string
+	Client *http.Client  // 默认 http.DefaultClient
+	MaxAge time.Duration // 默认 24 小时
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	missed  time.Time // 上次因未知的 kid 下载的时间
+}
+
+func NewKeySet(url string) *KeySet {
+	return &KeySet{Url: url}
+}
+
+// Key 返回 kid 对应的公钥
+func (s *KeySet) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	maxAge := s.MaxAge
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[kid]
+	switch {
+	case s.keys == nil || time.Since(s.fetched) >= maxAge:
+	case ok:
+		return key, nil
+	case time.Since(s.missed) < time.Minute:
+		// 一分钟内不因未知的 kid 重复下载
+		return nil, fmt.Errorf("%w %s", ErrUnknownKid, kid)
+	default:
+		s.missed = time.Now()
+	}
+	if err := s.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownKid, kid)
+}
+
+func (s *KeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, s.Url, nil)
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: %s returned %s", s.Url, resp.Status)
+	}
+	set := &Jwks{}
+	if err = json.Unmarshal(body, set); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for i := range set.Keys {
+		if set.Keys[i].Kty != "RSA" {
+			continue
+		}
+		key, err := set.Keys[i].RSAPublicKey()
+		if err != nil {
+			return err
+		}
+		keys[set.Keys[i].Kid] = key
+	}
+	s.keys = keys
+	s.fetched = time.Now()
+	return nil
+}
+
+// keySetAlg 是按 JWT 头中的 kid 选择公钥的 RS256 jwt.Algorithm
+type keySetAlg struct {
+	ctx  context.Context
+	keys *KeySet
+	*jwt.RSASHA
+}
+
+func (a *keySetAlg) Name() string {
+	return "RS256"
+}
+
+func (a *keySetAlg) Resolve(h jwt.Header) error {
+	key, err := a.keys.Key(a.ctx, h.KeyID)
+	if err != nil {
+		return err
+	}
+	a.RSASHA = jwt.NewRS256(jwt.RSAPublicKey(key))
+	return nil
+}
+
+// Verify 验证 RS256 签名并把载荷解码到 payload
+func (s *KeySet) Verify(ctx context.Context, token string, payload interface{}) (jwt.Header, error) {
+	return jwt.Verify([]byte(token), &keySetAlg{ctx: ctx, keys: s}, payload, jwt.ValidateHeader)
+}
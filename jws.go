@@ -0,0 +1,232 @@
+package appleapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// AppleRootCAG3Url 是 App Store 签名证书链的根证书, 验证时需要下载后传给 NewJwsVerifier
+const AppleRootCAG3Url = "https://www.apple.com/certificateauthority/AppleRootCA-G3.cer"
+
+var (
+	// App Store 签名证书和中间证书的扩展
+	OidAppStoreLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	OidAppStoreIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+
+	ErrJwsMalformed   = errors.New("jws: malformed")
+	ErrJwsAlgorithm   = errors.New("jws: alg is not ES256")
+	ErrJwsChain       = errors.New("jws: invalid x5c certificate chain")
+	ErrJwsBundleId    = errors.New("jws: bundleId does not match")
+	ErrJwsEnvironment = errors.New("jws: environment does not match")
+)
+
+// ParseCertificates 解析 PEM 或 DER 格式的证书, 如下载的 AppleRootCA-G3.cer
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block == nil {
+		return x509.ParseCertificates(data)
+	}
+	var list []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return list, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, cert)
+	}
+}
+
+// LoadCertificates 读取证书文件
+func LoadCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificates(data)
+}
+
+// JwsVerifier 验证 App Store 签名的交易、续订信息和通知
+// https://developer.apple.com/documentation/appstoreserverapi/jwstransaction
+type JwsVerifier struct {
+	Roots       []*x509.Certificate // Apple Root CA - G3, 测试时可以是本地生成的根证书
+	BundleId    string              // 不为空时检查交易的 bundleId
+	Environment string              // 不为空时检查 environment
+	Now         func() time.Time    // 检查证书有效期的时间, 默认载荷的 signedDate, 没有时为 time.Now
+}
+
+func NewJwsVerifier(roots ...*x509.Certificate) *JwsVerifier {
+	return &JwsVerifier{Roots: roots}
+}
+
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	X5c []string `json:"x5c"`
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAppleChain 验证 leaf 经过 intermediates 到 roots 的证书链, 并检查 App Store 签名证书和 WWDR 中间证书的扩展。
+// JWS 和收据使用同样的证书链
+func verifyAppleChain(leaf *x509.Certificate, intermediates, roots []*x509.Certificate, now time.Time) error {
+	if len(roots) == 0 {
+		return errors.New("no root certificate")
+	}
+	if !hasExtension(leaf, OidAppStoreLeaf) {
+		return errors.New("leaf is not an App Store signing certificate")
+	}
+	rootPool := x509.NewCertPool()
+	for _, c := range roots {
+		rootPool.AddCert(c)
+	}
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: pool,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if len(chain) > 2 && hasExtension(chain[1], OidAppStoreIntermediate) {
+			return nil
+		}
+	}
+	return errors.New("intermediate is not an Apple WWDR certificate")
+}
+
+// now 返回检查证书有效期的时间。签名证书过期前签名的交易仍然有效, 所以默认使用 signedDate,
+// 它在证书链验证后由签名保护
+func (v *JwsVerifier) now(signedDate int64) time.Time {
+	switch {
+	case v.Now != nil:
+		return v.Now()
+	case signedDate > 0:
+		return time.Unix(0, signedDate*int64(time.Millisecond))
+	}
+	return time.Now()
+}
+
+// chain 验证 x5c 证书链到 Roots 中的根证书, 返回签名证书
+func (v *JwsVerifier) chain(x5c []string, now time.Time) (*x509.Certificate, error) {
+	if len(x5c) < 2 {
+		return nil, fmt.Errorf("%w: %d certificates", ErrJwsChain, len(x5c))
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, s := range x5c {
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrJwsChain, err)
+		}
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrJwsChain, err)
+		}
+	}
+	if err := verifyAppleChain(certs[0], certs[1:], v.Roots, now); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrJwsChain, err)
+	}
+	return certs[0], nil
+}
+
+// Verify 验证证书链和 ES256 签名, 把载荷解码到 payload
+func (v *JwsVerifier) Verify(jws string, payload interface{}) error {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return ErrJwsMalformed
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrJwsMalformed
+	}
+	h := &jwsHeader{}
+	if err = json.Unmarshal(b, h); err != nil {
+		return ErrJwsMalformed
+	}
+	if h.Alg != "ES256" {
+		return ErrJwsAlgorithm
+	}
+	payloadJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrJwsMalformed
+	}
+	var signed struct {
+		SignedDate int64 `json:"signedDate"`
+	}
+	if err = json.Unmarshal(payloadJson, &signed); err != nil {
+		return ErrJwsMalformed
+	}
+	leaf, err := v.chain(h.X5c, v.now(signed.SignedDate))
+	if err != nil {
+		return err
+	}
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return fmt.Errorf("%w: leaf key is not P-256", ErrJwsChain)
+	}
+	// jwt 的 Verify 接受 base64 编码的签名
+	if err = jwt.NewES256(jwt.ECDSAPublicKey(pub)).Verify([]byte(parts[0]+"."+parts[1]), []byte(parts[2])); err != nil {
+		return err
+	}
+	return json.Unmarshal(payloadJson, payload)
+}
+
+func (v *JwsVerifier) check(bundleId, environment string) error {
+	if v.BundleId != "" && bundleId != v.BundleId {
+		return ErrJwsBundleId
+	}
+	if v.Environment != "" && environment != v.Environment {
+		return ErrJwsEnvironment
+	}
+	return nil
+}
+
+// VerifyTransaction 验证并解码 signedTransactionInfo 或 signedTransactions 中的一项
+func (v *JwsVerifier) VerifyTransaction(jws string) (*JWSTransactionDecodedPayload, error) {
+	t := &JWSTransactionDecodedPayload{}
+	if err := v.Verify(jws, t); err != nil {
+		return nil, err
+	}
+	if err := v.check(t.BundleId, t.Environment); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// VerifyRenewalInfo 验证并解码 signedRenewalInfo, 续订信息中没有 bundleId
+func (v *JwsVerifier) VerifyRenewalInfo(jws string) (*JWSRenewalInfoDecodedPayload, error) {
+	r := &JWSRenewalInfoDecodedPayload{}
+	if err := v.Verify(jws, r); err != nil {
+		return nil, err
+	}
+	if v.Environment != "" && r.Environment != v.Environment {
+		return nil, ErrJwsEnvironment
+	}
+	return r, nil
+}
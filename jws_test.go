@@ -0,0 +1,78 @@
+package appleapi_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func TestJwsVerifier(t *testing.T) {
+	signer := appleapitest.NewJwsSigner()
+	tx := &appleapi.JWSTransactionDecodedPayload{
+		TransactionId: "2000000000000001",
+		BundleId:      appleapitest.TestBundleId,
+		ProductId:     "com.example.app.coins",
+		Environment:   appleapi.EnvironmentSandbox,
+	}
+	sign := func(s *appleapitest.JwsSigner, v interface{}) string {
+		jws, err := s.Sign(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jws
+	}
+	valid := sign(signer, tx)
+	parts := strings.Split(valid, ".")
+	enc := base64.RawURLEncoding
+
+	// 只有 leaf 的 x5c
+	short := *signer
+	short.X5c = signer.X5c[:1]
+	// 载荷被修改, 签名不变
+	other := *tx
+	other.ProductId = "com.example.app.gems"
+	tampered := strings.Split(sign(signer, &other), ".")[1]
+
+	tests := []struct {
+		name  string
+		jws   string
+		edit  func(v *appleapi.JwsVerifier)
+		err   error
+		fails bool // 签名错误没有导出的错误值
+	}{
+		{"valid", valid, nil, nil, false},
+		{"untrusted root", valid, func(v *appleapi.JwsVerifier) { v.Roots = []*x509.Certificate{appleapitest.NewJwsSigner().Root} }, appleapi.ErrJwsChain, true},
+		{"no roots", valid, func(v *appleapi.JwsVerifier) { v.Roots = nil }, appleapi.ErrJwsChain, true},
+		{"leaf without App Store OID", sign(appleapitest.NewJwsSignerWith(nil, appleapi.OidAppStoreIntermediate), tx), nil, appleapi.ErrJwsChain, true},
+		{"intermediate without WWDR OID", sign(appleapitest.NewJwsSignerWith(appleapi.OidAppStoreLeaf, nil), tx), nil, appleapi.ErrJwsChain, true},
+		{"leaf only", sign(&short, tx), nil, appleapi.ErrJwsChain, true},
+		{"expired leaf", valid, func(v *appleapi.JwsVerifier) { v.Now = func() time.Time { return signer.Leaf.NotAfter.Add(time.Hour) } }, appleapi.ErrJwsChain, true},
+		{"tampered payload", parts[0] + "." + tampered + "." + parts[2], nil, nil, true},
+		{"alg", enc.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + parts[1] + "." + parts[2], nil, appleapi.ErrJwsAlgorithm, true},
+		{"malformed", parts[0] + "." + parts[1], nil, appleapi.ErrJwsMalformed, true},
+		{"bundle id", valid, func(v *appleapi.JwsVerifier) { v.BundleId = "com.example.other" }, appleapi.ErrJwsBundleId, true},
+		{"environment", valid, func(v *appleapi.JwsVerifier) { v.Environment = appleapi.EnvironmentProduction }, appleapi.ErrJwsEnvironment, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := appleapi.NewJwsVerifier(signer.Root)
+			v.BundleId = appleapitest.TestBundleId
+			if tt.edit != nil {
+				tt.edit(v)
+			}
+			got, err := v.VerifyTransaction(tt.jws)
+			if (err != nil) != tt.fails || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.ProductId != tx.ProductId {
+				t.Errorf("productId = %q", got.ProductId)
+			}
+		})
+	}
+}
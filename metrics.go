@@ -0,0 +1,252 @@
+package appleapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram, in
+// seconds.
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	resource string
+	method   string
+	status   string
+}
+
+type histogram struct {
+	counts []uint64 // 每个桶的累计次数, 最后一个是 +Inf
+	sum    float64
+}
+
+// Metrics collects Prometheus style metrics of the API calls and of the
+// credentials. Add it to Token.Hooks and serve it at /metrics:
+//
+//	m := appleapi.NewMetrics()
+//	token.Hooks = append(token.Hooks, m)
+//	http.Handle("/metrics", m)
+type Metrics struct {
+	Buckets []float64
+
+	mu             sync.Mutex
+	requests       map[requestLabels]uint64
+	latency        map[string]*histogram
+	retries        map[string]uint64
+	rateLimit      *RateLimit
+	certExpiration time.Time
+	profExpiration time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets:  DefaultLatencyBuckets,
+		requests: make(map[requestLabels]uint64),
+		latency:  make(map[string]*histogram),
+		retries:  make(map[string]uint64),
+	}
+}
+
+// resourceOf returns the resource type of an API path, "/v1/profiles/ID"
+// gives "profiles" and the App Store Server API path
+// "/inApps/v1/subscriptions/ID" gives "subscriptions".
+func resourceOf(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 0 && parts[0] == "inApps" {
+		parts = parts[1:]
+	}
+	if len(parts) >= 2 {
+		return parts[1]
+	}
+	return path
+}
+
+func (m *Metrics) BeforeSend(req *http.Request) *http.Request {
+	return req
+}
+
+func (m *Metrics) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	resource := resourceOf(req.URL.Path)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{resource: resource, method: req.Method, status: status}]++
+
+	h, ok := m.latency[resource]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.Buckets)+1)}
+		m.latency[resource] = h
+	}
+	seconds := latency.Seconds()
+	for i, b := range m.Buckets {
+		if seconds <= b {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(m.Buckets)]++
+	h.sum += seconds
+
+	if err == nil {
+		if rl, ok := ParseRateLimit(resp.Header); ok {
+			m.rateLimit = &rl
+		}
+	}
+}
+
+func (m *Metrics) OnRetry(method, rawurl string, attempt int, wait time.Duration) {
+	resource := rawurl
+	if u, err := url.Parse(rawurl); err == nil {
+		resource = resourceOf(u.Path)
+	}
+	m.mu.Lock()
+	m.retries[resource]++
+	m.mu.Unlock()
+}
+
+// ObserveCertificates records the nearest expiration of the certificates.
+func (m *Metrics) ObserveCertificates(list []Certificate) {
+	nearest := time.Time{}
+	for i := range list {
+		if t, err := list[i].Expiration(); err == nil && (nearest.IsZero() || t.Before(nearest)) {
+			nearest = t
+		}
+	}
+	m.mu.Lock()
+	m.certExpiration = nearest
+	m.mu.Unlock()
+}
+
+// ObserveProfiles records the nearest expiration of the active profiles.
+func (m *Metrics) ObserveProfiles(list []Profile) {
+	nearest := time.Time{}
+	for i := range list {
+		if list[i].Attributes.ProfileState == "INVALID" {
+			continue
+		}
+		if t, err := list[i].Expiration(); err == nil && (nearest.IsZero() || t.Before(nearest)) {
+			nearest = t
+		}
+	}
+	m.mu.Lock()
+	m.profExpiration = nearest
+	m.mu.Unlock()
+}
+
+// Refresh lists every certificate and profile and updates the expiration
+// gauges, call it periodically.
+func (m *Metrics) Refresh(c *Client) error {
+	certList, err := c.AllCertificates(&ListCertificatesQuery{
+		Certificates: []CertificateField{CertificateFieldExpirationDate},
+		Limit:        MaxLimit,
+	})
+	if err != nil {
+		return err
+	}
+	profList, _, err := c.AllProfiles(&ListProfilesQuery{
+		Profiles: []ProfileField{ProfileFieldExpirationDate, ProfileFieldProfileState},
+		Limit:    MaxLimit,
+	})
+	if err != nil {
+		return err
+	}
+	m.ObserveCertificates(certList)
+	m.ObserveProfiles(profList)
+	return nil
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sb := strings.Builder{}
+	sb.WriteString("# HELP appleapi_requests_total App Store Connect API requests by resource, method and status.\n")
+	sb.WriteString("# TYPE appleapi_requests_total counter\n")
+	keys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.resource != b.resource {
+			return a.resource < b.resource
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "appleapi_requests_total{resource=%q,method=%q,status=%q} %d\n", k.resource, k.method, k.status, m.requests[k])
+	}
+
+	sb.WriteString("# HELP appleapi_request_duration_seconds App Store Connect API request latency.\n")
+	sb.WriteString("# TYPE appleapi_request_duration_seconds histogram\n")
+	resources := make([]string, 0, len(m.latency))
+	for k := range m.latency {
+		resources = append(resources, k)
+	}
+	sort.Strings(resources)
+	for _, res := range resources {
+		h := m.latency[res]
+		for i, b := range m.Buckets {
+			fmt.Fprintf(&sb, "appleapi_request_duration_seconds_bucket{resource=%q,le=%q} %d\n", res, formatFloat(b), h.counts[i])
+		}
+		fmt.Fprintf(&sb, "appleapi_request_duration_seconds_bucket{resource=%q,le=\"+Inf\"} %d\n", res, h.counts[len(m.Buckets)])
+		fmt.Fprintf(&sb, "appleapi_request_duration_seconds_sum{resource=%q} %s\n", res, formatFloat(h.sum))
+		fmt.Fprintf(&sb, "appleapi_request_duration_seconds_count{resource=%q} %d\n", res, h.counts[len(m.Buckets)])
+	}
+
+	sb.WriteString("# HELP appleapi_retries_total App Store Connect API retries by resource.\n")
+	sb.WriteString("# TYPE appleapi_retries_total counter\n")
+	resources = resources[:0]
+	for k := range m.retries {
+		resources = append(resources, k)
+	}
+	sort.Strings(resources)
+	for _, res := range resources {
+		fmt.Fprintf(&sb, "appleapi_retries_total{resource=%q} %d\n", res, m.retries[res])
+	}
+
+	if m.rateLimit != nil {
+		sb.WriteString("# HELP appleapi_rate_limit_remaining Remaining requests of the hourly quota.\n")
+		sb.WriteString("# TYPE appleapi_rate_limit_remaining gauge\n")
+		fmt.Fprintf(&sb, "appleapi_rate_limit_remaining %d\n", m.rateLimit.Remaining)
+		sb.WriteString("# HELP appleapi_rate_limit_limit Hourly request quota.\n")
+		sb.WriteString("# TYPE appleapi_rate_limit_limit gauge\n")
+		fmt.Fprintf(&sb, "appleapi_rate_limit_limit %d\n", m.rateLimit.Limit)
+	}
+	if !m.certExpiration.IsZero() {
+		sb.WriteString("# HELP appleapi_certificate_expiration_timestamp_seconds Expiration of the certificate that expires first.\n")
+		sb.WriteString("# TYPE appleapi_certificate_expiration_timestamp_seconds gauge\n")
+		fmt.Fprintf(&sb, "appleapi_certificate_expiration_timestamp_seconds %d\n", m.certExpiration.Unix())
+	}
+	if !m.profExpiration.IsZero() {
+		sb.WriteString("# HELP appleapi_profile_expiration_timestamp_seconds Expiration of the active profile that expires first.\n")
+		sb.WriteString("# TYPE appleapi_profile_expiration_timestamp_seconds gauge\n")
+		fmt.Fprintf(&sb, "appleapi_profile_expiration_timestamp_seconds %d\n", m.profExpiration.Unix())
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
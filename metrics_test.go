@@ -0,0 +1,130 @@
+package appleapi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResourceOf(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/profiles", "profiles"},
+		{"/v1/profiles/K8N3D6V2PX", "profiles"},
+		{"/v1/profiles/K8N3D6V2PX/devices", "profiles"},
+		{"/inApps/v1/subscriptions/1000000123456789", "subscriptions"},
+		{"/inApps/v1/history/1000000123456789", "history"},
+		{"/inApps/v2/history/1000000123456789", "history"},
+		{"/inApps/v1/notifications/test", "notifications"},
+		{"/inApps/v1/transactions/1000000123456789", "transactions"},
+		{"/health", "/health"},
+	}
+	for _, tt := range tests {
+		if got := resourceOf(tt.path); got != tt.want {
+			t.Errorf("resourceOf(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// parseMetrics returns the samples of the text exposition format by name
+// and labels, e.g. `appleapi_retries_total{resource="devices"}`.
+func parseMetrics(t *testing.T, m *Metrics) map[string]string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]string)
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("bad sample %q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestMetricsWriteTo(t *testing.T) {
+	certExp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	profExp := time.Date(2029, 6, 7, 8, 9, 10, 0, time.UTC)
+	failures := 1
+	token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRateLimit, "user-hour-lim:3600;user-hour-rem:3500;")
+		switch {
+		case r.URL.Path == "/v1/devices" && failures > 0:
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/v1/devices":
+			w.Write([]byte(`{"data":[]}`))
+		case r.URL.Path == "/v1/certificates" && r.URL.Query().Get("cursor") == "":
+			fmt.Fprintf(w, `{"data":[{"type":"certificates","id":"C1","attributes":{"expirationDate":%q}}],"links":{"next":"http://%s/v1/certificates?cursor=2"}}`,
+				certExp.Add(time.Hour).Format(time.RFC3339), r.Host)
+		case r.URL.Path == "/v1/certificates":
+			fmt.Fprintf(w, `{"data":[{"type":"certificates","id":"C2","attributes":{"expirationDate":%q}}]}`, certExp.Format(time.RFC3339))
+		case r.URL.Path == "/v1/profiles":
+			fmt.Fprintf(w, `{"data":[{"type":"profiles","id":"P1","attributes":{"profileState":"ACTIVE","expirationDate":%q}},{"type":"profiles","id":"P2","attributes":{"profileState":"INVALID","expirationDate":%q}}]}`,
+				profExp.Format(time.RFC3339), profExp.Add(-time.Hour).Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer srv.Close()
+	m := NewMetrics()
+	token.Hooks = []Hook{m}
+	token.MaxRetries = 1
+	token.RetryWait = time.Millisecond
+
+	if _, err := token.WebGet(token.Url("/v1/devices")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Refresh(NewClient(token)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := parseMetrics(t, m)
+	want := map[string]string{
+		`appleapi_requests_total{resource="devices",method="GET",status="503"}`:      "1",
+		`appleapi_requests_total{resource="devices",method="GET",status="200"}`:      "1",
+		`appleapi_requests_total{resource="certificates",method="GET",status="200"}`: "2",
+		`appleapi_requests_total{resource="profiles",method="GET",status="200"}`:     "1",
+		`appleapi_request_duration_seconds_bucket{resource="devices",le="10"}`:       "2",
+		`appleapi_request_duration_seconds_bucket{resource="devices",le="+Inf"}`:     "2",
+		`appleapi_request_duration_seconds_count{resource="devices"}`:                "2",
+		`appleapi_request_duration_seconds_count{resource="certificates"}`:           "2",
+		`appleapi_retries_total{resource="devices"}`:                                 "1",
+		`appleapi_rate_limit_limit`:                                                  "3600",
+		`appleapi_rate_limit_remaining`:                                              "3500",
+		`appleapi_certificate_expiration_timestamp_seconds`:                          fmt.Sprint(certExp.Unix()),
+		`appleapi_profile_expiration_timestamp_seconds`:                              fmt.Sprint(profExp.Unix()),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if _, ok := got[`appleapi_request_duration_seconds_sum{resource="devices"}`]; !ok {
+		t.Error("no latency sum for devices")
+	}
+	// 桶是累计的, 每个桶不少于前一个
+	prev := 0
+	for _, b := range DefaultLatencyBuckets {
+		v, err := strconv.Atoi(got[fmt.Sprintf(`appleapi_request_duration_seconds_bucket{resource="devices",le="%s"}`, formatFloat(b))])
+		if err != nil || v < prev {
+			t.Errorf("bucket le=%v = %d after %d (%v)", b, v, prev, err)
+		}
+		prev = v
+	}
+}
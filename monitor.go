@@ -0,0 +1,247 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+type AlertKind string
+
+const (
+	AlertExpiring AlertKind = "expiring"
+	AlertExpired  AlertKind = "expired"
+	AlertInvalid  AlertKind = "invalid" // ProfileState == INVALID
+)
+
+// DefaultThresholds 是到期前提醒的时间: 30 天、7 天和 1 天
+var DefaultThresholds = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
+type Alert struct {
+	Kind       AlertKind     `json:"kind"`
+	Type       string        `json:"type"` // certificates, profiles
+	Id         string        `json:"id"`
+	Name       string        `json:"name"`
+	Expiration time.Time     `json:"expiration"`
+	Threshold  time.Duration `json:"threshold,omitempty"` // 触发的阈值，只用于 expiring
+}
+
+// Key 用于去重，同一资源每个阈值只提醒一次
+func (a *Alert) Key() string {
+	key := a.Type + "/" + a.Id + "/" + string(a.Kind)
+	if a.Kind == AlertExpiring {
+		key += "/" + a.Threshold.String()
+	}
+	return key
+}
+
+func (a *Alert) String() string {
+	switch a.Kind {
+	case AlertExpired:
+		return fmt.Sprintf("%s %q (%s) expired at %s", a.Type, a.Name, a.Id, a.Expiration.Format(time.RFC3339))
+	case AlertInvalid:
+		return fmt.Sprintf("%s %q (%s) is INVALID", a.Type, a.Name, a.Id)
+	}
+	return fmt.Sprintf("%s %q (%s) expires at %s, in less than %s", a.Type, a.Name, a.Id, a.Expiration.Format(time.RFC3339), formatDays(a.Threshold))
+}
+
+func formatDays(d time.Duration) string {
+	if d == 24*time.Hour {
+		return "1 day"
+	}
+	if d > 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// Monitor 定期检查证书和描述文件的有效期，通过 Sinks 发送提醒。
+// 已发送的提醒记录在 StateFile 中，资源恢复正常后记录会被清除
+type Monitor struct {
+	Client     *Client
+	Sinks      []Sink
+	Thresholds []time.Duration // 默认 DefaultThresholds
+	StateFile  string          // 为空时只在内存中去重
+	Now        func() time.Time
+
+	sent map[string]time.Time
+}
+
+func NewMonitor(client *Client, stateFile string, sinks ...Sink) *Monitor {
+	return &Monitor{Client: client, StateFile: stateFile, Sinks: sinks}
+}
+
+func (m *Monitor) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+// alert 返回过期或即将到期的提醒, 没有时返回 nil
+func (m *Monitor) alert(typ, id, name, expirationDate string, now time.Time) *Alert {
+	expiration, err := ParseDate(expirationDate)
+	if err != nil {
+		return nil
+	}
+	a := &Alert{Kind: AlertExpired, Type: typ, Id: id, Name: name, Expiration: expiration}
+	left := expiration.Sub(now)
+	if left <= 0 {
+		return a
+	}
+	thresholds := m.Thresholds
+	if thresholds == nil {
+		thresholds = DefaultThresholds
+	}
+	for _, t := range thresholds {
+		// 使用最小的满足条件的阈值
+		if left <= t && (a.Threshold == 0 || t < a.Threshold) {
+			a.Kind, a.Threshold = AlertExpiring, t
+		}
+	}
+	if a.Kind != AlertExpiring {
+		return nil
+	}
+	return a
+}
+
+// Check 读取证书和描述文件，返回当前所有的提醒，不去重
+func (m *Monitor) Check() ([]Alert, error) {
+	now := m.now()
+	var alerts []Alert
+
+	certificates, err := m.Client.AllCertificates(&ListCertificatesQuery{
+		Certificates: []CertificateField{CertificateFieldName, CertificateFieldDisplayName, CertificateFieldCertificateType, CertificateFieldExpirationDate},
+		Limit:        MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range certificates {
+		name := c.Attributes.DisplayName
+		if name == "" {
+			name = c.Attributes.Name
+		}
+		if a := m.alert(TypeCertificates, c.Id, name, c.Attributes.ExpirationDate, now); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+
+	profiles, _, err := m.Client.AllProfiles(&ListProfilesQuery{
+		Profiles: []ProfileField{ProfileFieldName, ProfileFieldProfileType, ProfileFieldProfileState, ProfileFieldExpirationDate},
+		Limit:    MaxLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range profiles {
+		if p.Attributes.ProfileState == "INVALID" {
+			expiration, _ := p.Expiration()
+			alerts = append(alerts, Alert{Kind: AlertInvalid, Type: TypeProfiles, Id: p.Id, Name: p.Attributes.Name, Expiration: expiration})
+			continue
+		}
+		if a := m.alert(TypeProfiles, p.Id, p.Attributes.Name, p.Attributes.ExpirationDate, now); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Expiration.Before(alerts[j].Expiration) })
+	return alerts, nil
+}
+
+func (m *Monitor) loadState() error {
+	if m.sent != nil {
+		return nil
+	}
+	m.sent = make(map[string]time.Time)
+	if m.StateFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(m.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &m.sent)
+}
+
+func (m *Monitor) saveState() error {
+	if m.StateFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(m.sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.StateFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.StateFile)
+}
+
+// RunOnce 检查一次，把未发送过的提醒发送到所有 Sink 并保存状态。
+// 有 Sink 发送失败时这些提醒不记录，下次会重新发送
+func (m *Monitor) RunOnce() ([]Alert, error) {
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	alerts, err := m.Check()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool, len(alerts))
+	var fresh []Alert
+	for i := range alerts {
+		key := alerts[i].Key()
+		current[key] = true
+		if _, ok := m.sent[key]; !ok {
+			fresh = append(fresh, alerts[i])
+		}
+	}
+	// 续期或删除的资源不再提醒, 清除记录
+	for key := range m.sent {
+		if !current[key] {
+			delete(m.sent, key)
+		}
+	}
+
+	if len(fresh) > 0 {
+		for _, s := range m.Sinks {
+			if err = s.Send(fresh); err != nil {
+				if serr := m.saveState(); serr != nil {
+					return fresh, fmt.Errorf("%w; save state: %v", err, serr)
+				}
+				return fresh, err
+			}
+		}
+		now := m.now()
+		for i := range fresh {
+			m.sent[fresh[i].Key()] = now
+		}
+	}
+	return fresh, m.saveState()
+}
+
+// Run 每隔 interval 调用一次 RunOnce，直到 ctx 结束。
+// 单次失败通过 onError 报告后继续运行, onError 可以为 nil
+func (m *Monitor) Run(ctx context.Context, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.RunOnce(); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package appleapi_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+var monitorNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const day = 24 * time.Hour
+
+func addMonitorCertificate(srv *appleapitest.Server, id, name string, expiration time.Time) {
+	c := appleapi.Certificate{Id: id}
+	c.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	c.Attributes.Name = name
+	c.Attributes.ExpirationDate = expiration.Format(appleapi.DateLayout)
+	srv.AddCertificate(c)
+}
+
+func addMonitorProfile(srv *appleapitest.Server, id, name, state string, expiration time.Time) {
+	p := appleapi.Profile{Id: id}
+	p.Attributes.Name = name
+	p.Attributes.ProfileType = "IOS_APP_STORE"
+	p.Attributes.ProfileState = state
+	p.Attributes.ExpirationDate = expiration.Format(appleapi.DateLayout)
+	srv.AddProfile(p)
+}
+
+func TestMonitorCheck(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	addMonitorCertificate(srv, "FAR", "not yet due", monitorNow.Add(40*day))
+	addMonitorCertificate(srv, "MONTH", "within 30 days", monitorNow.Add(20*day))
+	addMonitorCertificate(srv, "WEEK", "within 7 days", monitorNow.Add(5*day))
+	addMonitorCertificate(srv, "DAY", "within 1 day", monitorNow.Add(12*time.Hour))
+	addMonitorCertificate(srv, "EXACT", "exactly 7 days", monitorNow.Add(7*day))
+	addMonitorCertificate(srv, "EXPIRED", "expired", monitorNow.Add(-time.Hour))
+	addMonitorProfile(srv, "INVALID", "revoked certificate", "INVALID", monitorNow.Add(200*day))
+	addMonitorProfile(srv, "ACTIVE", "active", "ACTIVE", monitorNow.Add(100*day))
+	addMonitorProfile(srv, "SOON", "expiring profile", "ACTIVE", monitorNow.Add(3*day))
+
+	m := appleapi.NewMonitor(appleapi.NewClient(srv.Token()), "")
+	m.Now = func() time.Time { return monitorNow }
+	alerts, err := m.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]appleapi.Alert)
+	for _, a := range alerts {
+		got[a.Id] = a
+	}
+	tests := []struct {
+		id        string
+		kind      appleapi.AlertKind
+		threshold time.Duration
+	}{
+		{"MONTH", appleapi.AlertExpiring, 30 * day},
+		{"WEEK", appleapi.AlertExpiring, 7 * day},
+		{"DAY", appleapi.AlertExpiring, day},
+		{"EXACT", appleapi.AlertExpiring, 7 * day},
+		{"EXPIRED", appleapi.AlertExpired, 0},
+		{"INVALID", appleapi.AlertInvalid, 0},
+		{"SOON", appleapi.AlertExpiring, 7 * day},
+	}
+	for _, tt := range tests {
+		a, ok := got[tt.id]
+		if !ok {
+			t.Errorf("no alert for %s", tt.id)
+			continue
+		}
+		if a.Kind != tt.kind || a.Threshold != tt.threshold {
+			t.Errorf("%s: %s %s, want %s %s", tt.id, a.Kind, a.Threshold, tt.kind, tt.threshold)
+		}
+	}
+	for _, id := range []string{"FAR", "ACTIVE"} {
+		if a, ok := got[id]; ok {
+			t.Errorf("unexpected alert %s", a.String())
+		}
+	}
+	if len(alerts) != len(tests) {
+		t.Errorf("%d alerts, want %d", len(alerts), len(tests))
+	}
+	for i := 1; i < len(alerts); i++ {
+		if alerts[i].Expiration.Before(alerts[i-1].Expiration) {
+			t.Errorf("alerts are not sorted by expiration: %v", alerts)
+		}
+	}
+
+	m.Thresholds = []time.Duration{3 * day, 14 * day}
+	if alerts, err = m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range alerts {
+		if a.Id == "WEEK" && a.Threshold != 14*day || a.Id == "SOON" && a.Threshold != 3*day || a.Id == "MONTH" {
+			t.Errorf("custom thresholds: %s", a.String())
+		}
+	}
+}
+
+// recordingSink 记录发送的提醒, err 不为 nil 时发送失败
+type recordingSink struct {
+	sent [][]appleapi.Alert
+	err  error
+}
+
+func (s *recordingSink) Send(alerts []appleapi.Alert) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, alerts)
+	return nil
+}
+
+func readState(t *testing.T, file string) map[string]time.Time {
+	t.Helper()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := make(map[string]time.Time)
+	if err = json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestMonitorRunOnce(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	addMonitorCertificate(srv, "WEEK", "within 7 days", monitorNow.Add(5*day))
+	addMonitorProfile(srv, "INVALID", "revoked certificate", "INVALID", monitorNow.Add(200*day))
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	c := appleapi.NewClient(srv.Token())
+	now := monitorNow
+	newMonitor := func(sinks ...appleapi.Sink) *appleapi.Monitor {
+		m := appleapi.NewMonitor(c, stateFile, sinks...)
+		m.Now = func() time.Time { return now }
+		return m
+	}
+
+	// 发送失败的提醒不记录
+	failing := &recordingSink{err: errors.New("webhook is down")}
+	if _, err = newMonitor(failing).RunOnce(); err == nil || !strings.Contains(err.Error(), "webhook is down") {
+		t.Fatalf("RunOnce() with a failing sink = %v", err)
+	}
+	if state := readState(t, stateFile); len(state) != 0 {
+		t.Errorf("state after a failed send: %v", state)
+	}
+
+	sink := &recordingSink{}
+	m := newMonitor(sink)
+	fresh, err := m.RunOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 2 || len(sink.sent) != 1 || len(sink.sent[0]) != 2 {
+		t.Fatalf("first run sent %v", sink.sent)
+	}
+	state := readState(t, stateFile)
+	for _, key := range []string{"certificates/WEEK/expiring/168h0m0s", "profiles/INVALID/invalid"} {
+		if _, ok := state[key]; !ok {
+			t.Errorf("state has no %s: %v", key, state)
+		}
+	}
+	if fresh, err = m.RunOnce(); err != nil || len(fresh) != 0 {
+		t.Errorf("second run sent %v, %v", fresh, err)
+	}
+	// 新的 Monitor 从 StateFile 读取已发送的提醒
+	if fresh, err = newMonitor(sink).RunOnce(); err != nil || len(fresh) != 0 {
+		t.Errorf("run with the saved state sent %v, %v", fresh, err)
+	}
+
+	// 越过下一个阈值时再次提醒
+	now = monitorNow.Add(4*day + 12*time.Hour)
+	if fresh, err = m.RunOnce(); err != nil || len(fresh) != 1 || fresh[0].Threshold != day {
+		t.Errorf("run after the 1 day threshold sent %v, %v", fresh, err)
+	}
+
+	// 续期后清除记录
+	addMonitorCertificate(srv, "WEEK", "renewed", monitorNow.Add(365*day))
+	if fresh, err = m.RunOnce(); err != nil || len(fresh) != 0 {
+		t.Errorf("run after renewal sent %v, %v", fresh, err)
+	}
+	state = readState(t, stateFile)
+	for key := range state {
+		if strings.HasPrefix(key, "certificates/WEEK/") {
+			t.Errorf("state still has %s after renewal", key)
+		}
+	}
+	if _, ok := state["profiles/INVALID/invalid"]; !ok {
+		t.Errorf("state lost the invalid profile: %v", state)
+	}
+}
+
+func TestMonitorRunOnceReportsStateError(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	addMonitorCertificate(srv, "EXPIRED", "expired", monitorNow.Add(-time.Hour))
+	m := appleapi.NewMonitor(appleapi.NewClient(srv.Token()), filepath.Join(os.TempDir(), "missing-dir", "nested", "state.json"),
+		&recordingSink{err: errors.New("smtp is down")})
+	m.Now = func() time.Time { return monitorNow }
+
+	_, err := m.RunOnce()
+	if err == nil || !strings.Contains(err.Error(), "smtp is down") || !strings.Contains(err.Error(), "save state") {
+		t.Errorf("RunOnce() = %v, want the sink and the state errors", err)
+	}
+}
@@ -0,0 +1,353 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// App Store Server Notifications V2 的 notificationType
+// https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+const (
+	NotificationConsumptionRequest     = "CONSUMPTION_REQUEST"
+	NotificationDidChangeRenewalPref   = "DID_CHANGE_RENEWAL_PREF"
+	NotificationDidChangeRenewalStatus = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationDidFailToRenew         = "DID_FAIL_TO_RENEW"
+	NotificationDidRenew               = "DID_RENEW"
+	NotificationExpired                = "EXPIRED"
+	NotificationGracePeriodExpired     = "GRACE_PERIOD_EXPIRED"
+	NotificationOfferRedeemed          = "OFFER_REDEEMED"
+	NotificationPriceIncrease          = "PRICE_INCREASE"
+	NotificationRefund                 = "REFUND"
+	NotificationRefundDeclined         = "REFUND_DECLINED"
+	NotificationRefundReversed         = "REFUND_REVERSED"
+	NotificationRenewalExtended        = "RENEWAL_EXTENDED"
+	NotificationRenewalExtension       = "RENEWAL_EXTENSION"
+	NotificationRevoke                 = "REVOKE"
+	NotificationSubscribed             = "SUBSCRIBED"
+	NotificationTest                   = "TEST"
+)
+
+// subtype
+// https://developer.apple.com/documentation/appstoreservernotifications/subtype
+const (
+	SubtypeInitialBuy        = "INITIAL_BUY"
+	SubtypeResubscribe       = "RESUBSCRIBE"
+	SubtypeDowngrade         = "DOWNGRADE"
+	SubtypeUpgrade           = "UPGRADE"
+	SubtypeAutoRenewEnabled  = "AUTO_RENEW_ENABLED"
+	SubtypeAutoRenewDisabled = "AUTO_RENEW_DISABLED"
+	SubtypeVoluntary         = "VOLUNTARY"
+	SubtypeBillingRetry      = "BILLING_RETRY"
+	SubtypePriceIncrease     = "PRICE_INCREASE"
+	SubtypeGracePeriod       = "GRACE_PERIOD"
+	SubtypeBillingRecovery   = "BILLING_RECOVERY"
+	SubtypePending           = "PENDING"
+	SubtypeAccepted          = "ACCEPTED"
+	SubtypeProductNotForSale = "PRODUCT_NOT_FOR_SALE"
+	SubtypeSummary           = "SUMMARY"
+	SubtypeFailure           = "FAILURE"
+)
+
+// ResponseBodyV2 是苹果 POST 到通知地址的请求体
+// https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2
+type ResponseBodyV2 struct {
+	SignedPayload string `json:"signedPayload"`
+}
+
+// https://developer.apple.com/documentation/appstoreservernotifications/data
+type NotificationData struct {
+	AppAppleId            int64  `json:"appAppleId,omitempty"`
+	BundleId              string `json:"bundleId"`
+	BundleVersion         string `json:"bundleVersion,omitempty"`
+	Environment           string `json:"environment"`
+	SignedTransactionInfo string `json:"signedTransactionInfo,omitempty"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo,omitempty"`
+	Status                int    `json:"status,omitempty"` // SubscriptionActive 等
+}
+
+// NotificationSummary 是 RENEWAL_EXTENSION 通知中批量延期的结果
+// https://developer.apple.com/documentation/appstoreservernotifications/summary
+type NotificationSummary struct {
+	RequestIdentifier      string   `json:"requestIdentifier"`
+	Environment            string   `json:"environment"`
+	AppAppleId             int64    `json:"appAppleId"`
+	BundleId               string   `json:"bundleId"`
+	ProductId              string   `json:"productId"`
+	StorefrontCountryCodes []string `json:"storefrontCountryCodes,omitempty"`
+	SucceededCount         int64    `json:"succeededCount"`
+	FailedCount            int64    `json:"failedCount"`
+}
+
+// https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2decodedpayload
+type ResponseBodyV2DecodedPayload struct {
+	NotificationType string               `json:"notificationType"`
+	Subtype          string               `json:"subtype,omitempty"`
+	NotificationUUID string               `json:"notificationUUID"`
+	Data             *NotificationData    `json:"data,omitempty"`
+	Summary          *NotificationSummary `json:"summary,omitempty"`
+	Version          string               `json:"version"`
+	SignedDate       int64                `json:"signedDate"`
+}
+
+// ServerNotification 是验证后的通知, Transaction 和 RenewalInfo 是 data 中解码后的签名数据, 可能为 nil
+type ServerNotification struct {
+	ResponseBodyV2DecodedPayload
+	Transaction *JWSTransactionDecodedPayload
+	RenewalInfo *JWSRenewalInfoDecodedPayload
+}
+
+// VerifyNotification 验证 signedPayload 及其中的交易和续订信息
+func (v *JwsVerifier) VerifyNotification(signedPayload string) (*ServerNotification, error) {
+	n := &ServerNotification{}
+	if err := v.Verify(signedPayload, &n.ResponseBodyV2DecodedPayload); err != nil {
+		return nil, err
+	}
+	bundleId, environment := "", ""
+	if d := n.Data; d != nil {
+		bundleId, environment = d.BundleId, d.Environment
+	} else if s := n.Summary; s != nil {
+		bundleId, environment = s.BundleId, s.Environment
+	}
+	if err := v.check(bundleId, environment); err != nil {
+		return nil, err
+	}
+	if d := n.Data; d != nil {
+		var err error
+		if d.SignedTransactionInfo != "" {
+			if n.Transaction, err = v.VerifyTransaction(d.SignedTransactionInfo); err != nil {
+				return nil, err
+			}
+		}
+		if d.SignedRenewalInfo != "" {
+			if n.RenewalInfo, err = v.VerifyRenewalInfo(d.SignedRenewalInfo); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// NotificationStore 记录已处理的 notificationUUID, 苹果在响应不是 200 时会重试,
+// 同一通知也可能因网络问题送达多次。多个实例处理通知时应使用共享的存储。
+// Claim 必须是原子的: 同一 uuid 同时只有一个 Claim 成功, 成功后处理失败时调用 Release,
+// 处理成功时调用 MarkProcessed。共享存储的 Claim 应该有过期时间, 避免实例退出后通知无法再处理
+type NotificationStore interface {
+	Processed(uuid string) bool
+	Claim(uuid string) bool
+	Release(uuid string)
+	MarkProcessed(uuid string)
+}
+
+// MemoryNotificationStore 是进程内的 NotificationStore, 记录保留 TTL
+type MemoryNotificationStore struct {
+	TTL      time.Duration // 默认 7 天, 苹果最多在 3 天内重试 5 次
+	ClaimTTL time.Duration // 默认 10 分钟, 占用超过这个时间后可以再次 Claim, 避免漏掉 Release 的通知无法再处理
+
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	claimed map[string]time.Time
+}
+
+func NewMemoryNotificationStore() *MemoryNotificationStore {
+	return &MemoryNotificationStore{
+		TTL:      7 * 24 * time.Hour,
+		ClaimTTL: 10 * time.Minute,
+		seen:     make(map[string]time.Time),
+		claimed:  make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryNotificationStore) processed(uuid string) bool {
+	t, ok := m.seen[uuid]
+	return ok && time.Since(t) < m.TTL
+}
+
+func (m *MemoryNotificationStore) Processed(uuid string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.processed(uuid)
+}
+
+// Claim 在 uuid 未处理且没有被占用或占用已过期时占用它
+func (m *MemoryNotificationStore) Claim(uuid string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.processed(uuid) {
+		return false
+	}
+	if t, ok := m.claimed[uuid]; ok && time.Since(t) < m.ClaimTTL {
+		return false
+	}
+	m.claimed[uuid] = time.Now()
+	return true
+}
+
+func (m *MemoryNotificationStore) Release(uuid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claimed, uuid)
+}
+
+func (m *MemoryNotificationStore) MarkProcessed(uuid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, t := range m.seen {
+		if now.Sub(t) >= m.TTL {
+			delete(m.seen, k)
+		}
+	}
+	for k, t := range m.claimed {
+		if now.Sub(t) >= m.ClaimTTL {
+			delete(m.claimed, k)
+		}
+	}
+	m.seen[uuid] = now
+	delete(m.claimed, uuid)
+}
+
+// ServerNotificationHandler 接收 App Store Server Notifications V2。
+// 回调返回错误时响应 500, 苹果会稍后重试; 成功处理的通知记录在 Store 中, 重复送达时直接确认,
+// 正在被处理的通知再次送达时响应 503, 苹果会稍后重试。
+// 没有对应回调的通知交给 OnOther, 也没有设置 OnOther 时直接确认
+// https://developer.apple.com/documentation/appstoreservernotifications/receiving_app_store_server_notifications
+type ServerNotificationHandler struct {
+	Verifier *JwsVerifier
+	Store    NotificationStore  // 为 nil 时不去重
+	Logger   logrus.FieldLogger // 默认 logrus.StandardLogger()
+
+	OnSubscribed             func(ctx context.Context, n *ServerNotification) error
+	OnDidRenew               func(ctx context.Context, n *ServerNotification) error
+	OnDidFailToRenew         func(ctx context.Context, n *ServerNotification) error
+	OnDidChangeRenewalStatus func(ctx context.Context, n *ServerNotification) error
+	OnDidChangeRenewalPref   func(ctx context.Context, n *ServerNotification) error
+	OnExpired                func(ctx context.Context, n *ServerNotification) error
+	OnGracePeriodExpired     func(ctx context.Context, n *ServerNotification) error
+	OnOfferRedeemed          func(ctx context.Context, n *ServerNotification) error
+	OnPriceIncrease          func(ctx context.Context, n *ServerNotification) error
+	OnRefund                 func(ctx context.Context, n *ServerNotification) error
+	OnRefundDeclined         func(ctx context.Context, n *ServerNotification) error
+	OnRefundReversed         func(ctx context.Context, n *ServerNotification) error
+	OnRenewalExtended        func(ctx context.Context, n *ServerNotification) error
+	OnRenewalExtension       func(ctx context.Context, n *ServerNotification) error
+	OnRevoke                 func(ctx context.Context, n *ServerNotification) error
+	OnConsumptionRequest     func(ctx context.Context, n *ServerNotification) error // 需在 12 小时内调用 Send Consumption Information
+	OnTest                   func(ctx context.Context, n *ServerNotification) error
+	OnOther                  func(ctx context.Context, n *ServerNotification) error
+}
+
+func (h *ServerNotificationHandler) logger() logrus.FieldLogger {
+	if h.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return h.Logger
+}
+
+func (h *ServerNotificationHandler) callback(notificationType string) func(ctx context.Context, n *ServerNotification) error {
+	switch notificationType {
+	case NotificationSubscribed:
+		return h.OnSubscribed
+	case NotificationDidRenew:
+		return h.OnDidRenew
+	case NotificationDidFailToRenew:
+		return h.OnDidFailToRenew
+	case NotificationDidChangeRenewalStatus:
+		return h.OnDidChangeRenewalStatus
+	case NotificationDidChangeRenewalPref:
+		return h.OnDidChangeRenewalPref
+	case NotificationExpired:
+		return h.OnExpired
+	case NotificationGracePeriodExpired:
+		return h.OnGracePeriodExpired
+	case NotificationOfferRedeemed:
+		return h.OnOfferRedeemed
+	case NotificationPriceIncrease:
+		return h.OnPriceIncrease
+	case NotificationRefund:
+		return h.OnRefund
+	case NotificationRefundDeclined:
+		return h.OnRefundDeclined
+	case NotificationRefundReversed:
+		return h.OnRefundReversed
+	case NotificationRenewalExtended:
+		return h.OnRenewalExtended
+	case NotificationRenewalExtension:
+		return h.OnRenewalExtension
+	case NotificationRevoke:
+		return h.OnRevoke
+	case NotificationConsumptionRequest:
+		return h.OnConsumptionRequest
+	case NotificationTest:
+		return h.OnTest
+	}
+	return nil
+}
+
+func (h *ServerNotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 256<<10))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &ResponseBodyV2{}
+	if err = json.Unmarshal(body, req); err != nil || req.SignedPayload == "" {
+		http.Error(w, "signedPayload is required", http.StatusBadRequest)
+		return
+	}
+	n, err := h.Verifier.VerifyNotification(req.SignedPayload)
+	if err != nil {
+		// 不把验证失败的原因返回给调用方
+		h.logger().WithError(err).Warn("notification: rejected")
+		http.Error(w, "invalid notification", http.StatusUnauthorized)
+		return
+	}
+
+	log := h.logger().WithFields(logrus.Fields{"uuid": n.NotificationUUID, "type": n.NotificationType, "subtype": n.Subtype})
+	processed := false
+	if h.Store != nil {
+		if h.Store.Processed(n.NotificationUUID) {
+			log.Info("notification: duplicate")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !h.Store.Claim(n.NotificationUUID) {
+			// 另一个请求正在处理, 它失败时苹果还会重试, 不能在这里确认
+			log.Info("notification: in progress")
+			http.Error(w, "notification is being processed", http.StatusServiceUnavailable)
+			return
+		}
+		// 回调失败或 panic 时释放, 苹果重试时可以再次处理
+		defer func() {
+			if !processed {
+				h.Store.Release(n.NotificationUUID)
+			}
+		}()
+	}
+	fn := h.callback(n.NotificationType)
+	if fn == nil {
+		fn = h.OnOther
+	}
+	if fn == nil {
+		log.Info("notification: ignored")
+	} else if err = fn(r.Context(), n); err != nil {
+		log.WithError(err).Error("notification: failed")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.Store != nil {
+		h.Store.MarkProcessed(n.NotificationUUID)
+		processed = true
+	}
+	w.WriteHeader(http.StatusOK)
+}
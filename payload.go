@@ -0,0 +1,339 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// APNs 负载大小限制, voip 推送为 5KB
+const (
+	MaxPayloadSize     = 4096
+	MaxVoipPayloadSize = 5120
+)
+
+// interruption-level
+const (
+	InterruptionPassive       = "passive"
+	InterruptionActive        = "active"
+	InterruptionTimeSensitive = "time-sensitive"
+	InterruptionCritical      = "critical"
+)
+
+// Live Activity 的 event
+const (
+	LiveActivityStart  = "start"
+	LiveActivityUpdate = "update"
+	LiveActivityEnd    = "end"
+)
+
+var ErrPayloadTooLarge = errors.New("apns: payload too large")
+
+// PayloadLimit 返回一种推送类型的负载大小限制
+func PayloadLimit(pushType string) int {
+	if pushType == PushTypeVoip {
+		return MaxVoipPayloadSize
+	}
+	return MaxPayloadSize
+}
+
+// https://developer.apple.com/documentation/usernotifications/generating-a-remote-notification
+type ApsAlert struct {
+	Title           string   `json:"title,omitempty"`
+	Subtitle        string   `json:"subtitle,omitempty"`
+	Body            string   `json:"body,omitempty"`
+	LaunchImage     string   `json:"launch-image,omitempty"`
+	TitleLocKey     string   `json:"title-loc-key,omitempty"`
+	TitleLocArgs    []string `json:"title-loc-args,omitempty"`
+	SubtitleLocKey  string   `json:"subtitle-loc-key,omitempty"`
+	SubtitleLocArgs []string `json:"subtitle-loc-args,omitempty"`
+	LocKey          string   `json:"loc-key,omitempty"`
+	LocArgs         []string `json:"loc-args,omitempty"`
+}
+
+// ApsSound 是 critical alert 的声音字典, 普通声音只需要文件名
+type ApsSound struct {
+	Critical int     `json:"critical,omitempty"` // 1 表示 critical alert
+	Name     string  `json:"name,omitempty"`
+	Volume   float64 `json:"volume"` // 0 到 1
+}
+
+type Aps struct {
+	Alert             interface{} `json:"alert,omitempty"` // string 或 *ApsAlert
+	Badge             *int        `json:"badge,omitempty"`
+	Sound             interface{} `json:"sound,omitempty"` // string 或 *ApsSound
+	ThreadId          string      `json:"thread-id,omitempty"`
+	Category          string      `json:"category,omitempty"`
+	ContentAvailable  int         `json:"content-available,omitempty"`
+	MutableContent    int         `json:"mutable-content,omitempty"`
+	TargetContentId   string      `json:"target-content-id,omitempty"`
+	InterruptionLevel string      `json:"interruption-level,omitempty"`
+	RelevanceScore    *float64    `json:"relevance-score,omitempty"`
+	FilterCriteria    string      `json:"filter-criteria,omitempty"`
+
+	// Live Activity
+	Event          string      `json:"event,omitempty"`
+	ContentState   interface{} `json:"content-state,omitempty"`
+	Timestamp      int64       `json:"timestamp,omitempty"`
+	StaleDate      int64       `json:"stale-date,omitempty"`
+	DismissalDate  int64       `json:"dismissal-date,omitempty"`
+	AttributesType string      `json:"attributes-type,omitempty"`
+	Attributes     interface{} `json:"attributes,omitempty"`
+}
+
+// Payload 构造推送的 JSON 负载:
+//
+//	b, err := appleapi.NewPayload().AlertTitle("Hello").AlertBody("World").Badge(1).Sound("default").Bytes()
+type Payload struct {
+	Aps    Aps
+	custom map[string]interface{}
+}
+
+func NewPayload() *Payload {
+	return &Payload{custom: make(map[string]interface{})}
+}
+
+func (p *Payload) alert() *ApsAlert {
+	switch a := p.Aps.Alert.(type) {
+	case *ApsAlert:
+		return a
+	case string:
+		v := &ApsAlert{Body: a}
+		p.Aps.Alert = v
+		return v
+	}
+	v := &ApsAlert{}
+	p.Aps.Alert = v
+	return v
+}
+
+// AlertText 设置只有正文的提醒
+func (p *Payload) AlertText(body string) *Payload {
+	p.Aps.Alert = body
+	return p
+}
+
+func (p *Payload) AlertTitle(title string) *Payload {
+	p.alert().Title = title
+	return p
+}
+
+func (p *Payload) AlertSubtitle(subtitle string) *Payload {
+	p.alert().Subtitle = subtitle
+	return p
+}
+
+func (p *Payload) AlertBody(body string) *Payload {
+	p.alert().Body = body
+	return p
+}
+
+func (p *Payload) AlertLaunchImage(image string) *Payload {
+	p.alert().LaunchImage = image
+	return p
+}
+
+// AlertTitleLoc 使用 app 本地化文件中的标题
+func (p *Payload) AlertTitleLoc(key string, args ...string) *Payload {
+	a := p.alert()
+	a.TitleLocKey, a.TitleLocArgs = key, args
+	return p
+}
+
+func (p *Payload) AlertSubtitleLoc(key string, args ...string) *Payload {
+	a := p.alert()
+	a.SubtitleLocKey, a.SubtitleLocArgs = key, args
+	return p
+}
+
+func (p *Payload) AlertLoc(key string, args ...string) *Payload {
+	a := p.alert()
+	a.LocKey, a.LocArgs = key, args
+	return p
+}
+
+// Badge 设置角标, 0 清除角标
+func (p *Payload) Badge(n int) *Payload {
+	p.Aps.Badge = &n
+	return p
+}
+
+// Sound 设置声音文件名, "default" 为系统声音
+func (p *Payload) Sound(name string) *Payload {
+	p.Aps.Sound = name
+	return p
+}
+
+// CriticalSound 设置 critical alert 的声音, 需要苹果授予的权限
+func (p *Payload) CriticalSound(name string, volume float64) *Payload {
+	p.Aps.Sound = &ApsSound{Critical: 1, Name: name, Volume: volume}
+	return p
+}
+
+func (p *Payload) Category(category string) *Payload {
+	p.Aps.Category = category
+	return p
+}
+
+func (p *Payload) ThreadId(id string) *Payload {
+	p.Aps.ThreadId = id
+	return p
+}
+
+// ContentAvailable 标记后台更新推送
+func (p *Payload) ContentAvailable() *Payload {
+	p.Aps.ContentAvailable = 1
+	return p
+}
+
+// MutableContent 允许 notification service extension 修改内容
+func (p *Payload) MutableContent() *Payload {
+	p.Aps.MutableContent = 1
+	return p
+}
+
+func (p *Payload) TargetContentId(id string) *Payload {
+	p.Aps.TargetContentId = id
+	return p
+}
+
+func (p *Payload) InterruptionLevel(level string) *Payload {
+	p.Aps.InterruptionLevel = level
+	return p
+}
+
+// RelevanceScore 在 0 到 1 之间, 用于通知摘要的排序
+func (p *Payload) RelevanceScore(score float64) *Payload {
+	p.Aps.RelevanceScore = &score
+	return p
+}
+
+func (p *Payload) FilterCriteria(criteria string) *Payload {
+	p.Aps.FilterCriteria = criteria
+	return p
+}
+
+// LiveActivity 设置 Live Activity 的事件、内容状态和时间戳
+func (p *Payload) LiveActivity(event string, contentState interface{}, timestamp time.Time) *Payload {
+	p.Aps.Event = event
+	p.Aps.ContentState = contentState
+	p.Aps.Timestamp = timestamp.Unix()
+	return p
+}
+
+// LiveActivityStart 设置远程启动 Live Activity 的属性类型和属性
+func (p *Payload) LiveActivityStart(attributesType string, attributes interface{}) *Payload {
+	p.Aps.Event = LiveActivityStart
+	p.Aps.AttributesType = attributesType
+	p.Aps.Attributes = attributes
+	return p
+}
+
+func (p *Payload) StaleDate(t time.Time) *Payload {
+	p.Aps.StaleDate = t.Unix()
+	return p
+}
+
+func (p *Payload) DismissalDate(t time.Time) *Payload {
+	p.Aps.DismissalDate = t.Unix()
+	return p
+}
+
+// Custom 设置 aps 以外的自定义键
+func (p *Payload) Custom(key string, value interface{}) *Payload {
+	if p.custom == nil {
+		p.custom = make(map[string]interface{})
+	}
+	p.custom[key] = value
+	return p
+}
+
+func (p *Payload) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.custom)+1)
+	for k, v := range p.custom {
+		m[k] = v
+	}
+	m["aps"] = &p.Aps
+	return json.Marshal(m)
+}
+
+// PushType 根据内容推断 apns-push-type
+func (p *Payload) PushType() string {
+	a := &p.Aps
+	switch {
+	case a.Event != "":
+		return PushTypeLiveActivity
+	case a.ContentAvailable == 1 && a.Alert == nil && a.Badge == nil && a.Sound == nil:
+		return PushTypeBackground
+	}
+	return PushTypeAlert
+}
+
+// Validate 检查字段的取值和 pushType 的负载大小限制
+func (p *Payload) Validate(pushType string) error {
+	if _, ok := p.custom["aps"]; ok {
+		return errors.New("apns: custom key aps is reserved")
+	}
+	a := &p.Aps
+	if s, ok := a.Sound.(*ApsSound); ok && (s.Volume < 0 || s.Volume > 1) {
+		return fmt.Errorf("apns: sound volume %v is not between 0 and 1", s.Volume)
+	}
+	if a.RelevanceScore != nil && (*a.RelevanceScore < 0 || *a.RelevanceScore > 1) {
+		return fmt.Errorf("apns: relevance-score %v is not between 0 and 1", *a.RelevanceScore)
+	}
+	switch a.InterruptionLevel {
+	case "", InterruptionPassive, InterruptionActive, InterruptionTimeSensitive, InterruptionCritical:
+	default:
+		return fmt.Errorf("apns: unknown interruption-level %q", a.InterruptionLevel)
+	}
+	if pushType == PushTypeLiveActivity {
+		switch a.Event {
+		case LiveActivityStart, LiveActivityUpdate, LiveActivityEnd:
+		default:
+			return fmt.Errorf("apns: unknown live activity event %q", a.Event)
+		}
+		if a.Timestamp == 0 {
+			return errors.New("apns: live activity requires a timestamp")
+		}
+		if a.Event == LiveActivityStart && (a.AttributesType == "" || a.Attributes == nil) {
+			return errors.New("apns: live activity start requires attributes-type and attributes")
+		}
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if len(b) > PayloadLimit(pushType) {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrPayloadTooLarge, len(b), PayloadLimit(pushType))
+	}
+	return nil
+}
+
+// Bytes 验证并返回 JSON 负载
+func (p *Payload) Bytes() ([]byte, error) {
+	if err := p.Validate(p.PushType()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// Notification 返回发送到 deviceToken 的推送, 推送类型由内容推断, 后台推送的优先级为 5
+func (p *Payload) Notification(deviceToken, topic string) (*Notification, error) {
+	pushType := p.PushType()
+	if err := p.Validate(pushType); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notification{DeviceToken: deviceToken, Topic: topic, PushType: pushType, Payload: b}
+	switch pushType {
+	case PushTypeBackground:
+		n.Priority = PriorityConserve
+	case PushTypeLiveActivity:
+		n.Topic = topic + ".push-type.liveactivity"
+	}
+	return n, nil
+}
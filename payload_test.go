@@ -0,0 +1,88 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// paddedPayload 返回 JSON 正好 size 字节的负载
+func paddedPayload(t *testing.T, size int) *Payload {
+	t.Helper()
+	p := NewPayload().AlertText("hi").Custom("pad", "")
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Custom("pad", strings.Repeat("x", size-len(b)))
+}
+
+func TestPayloadSizeLimit(t *testing.T) {
+	tests := []struct {
+		pushType string
+		size     int
+		tooLarge bool
+	}{
+		{PushTypeAlert, MaxPayloadSize, false},
+		{PushTypeAlert, MaxPayloadSize + 1, true},
+		{PushTypeBackground, MaxPayloadSize + 1, true},
+		{PushTypeVoip, MaxPayloadSize + 1, false},
+		{PushTypeVoip, MaxVoipPayloadSize, false},
+		{PushTypeVoip, MaxVoipPayloadSize + 1, true},
+	}
+	for _, tt := range tests {
+		p := paddedPayload(t, tt.size)
+		if err := p.Validate(tt.pushType); errors.Is(err, ErrPayloadTooLarge) != tt.tooLarge {
+			t.Errorf("Validate(%s) of %d bytes: %v", tt.pushType, tt.size, err)
+		}
+	}
+	if _, err := paddedPayload(t, MaxPayloadSize+1).Notification(strings.Repeat("ab", 32), "com.example.app"); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("Notification of %d bytes: %v", MaxPayloadSize+1, err)
+	}
+}
+
+type failTransport struct{ t *testing.T }
+
+func (f failTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request %s %s", req.Method, req.URL)
+	return nil, errors.New("unexpected request")
+}
+
+func TestPushRejectsLargePayloadBeforeSending(t *testing.T) {
+	a := &Apns{Token: &ApnsToken{}, Client: &http.Client{Transport: failTransport{t}}}
+	n := &Notification{DeviceToken: strings.Repeat("ab", 32), PushType: PushTypeAlert, Payload: make([]byte, MaxPayloadSize+1)}
+	if _, err := a.Push(n); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("err = %v, want ErrPayloadTooLarge", err)
+	}
+	n.PushType, n.Payload = PushTypeVoip, make([]byte, MaxVoipPayloadSize+1)
+	if _, err := a.Push(n); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("voip err = %v, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestPayloadValidate(t *testing.T) {
+	score := 1.5
+	tests := []struct {
+		name     string
+		p        *Payload
+		pushType string
+		ok       bool
+	}{
+		{"alert", NewPayload().AlertText("hi"), PushTypeAlert, true},
+		{"reserved aps", NewPayload().Custom("aps", 1), PushTypeAlert, false},
+		{"volume", NewPayload().CriticalSound("default", 2), PushTypeAlert, false},
+		{"relevance", &Payload{Aps: Aps{RelevanceScore: &score}}, PushTypeAlert, false},
+		{"interruption", NewPayload().InterruptionLevel("loud"), PushTypeAlert, false},
+		{"live activity", NewPayload().LiveActivity(LiveActivityUpdate, map[string]int{"score": 1}, time.Now()), PushTypeLiveActivity, true},
+		{"live activity start", NewPayload().LiveActivity(LiveActivityStart, nil, time.Now()), PushTypeLiveActivity, false},
+		{"live activity event", NewPayload().LiveActivity("pause", nil, time.Now()), PushTypeLiveActivity, false},
+	}
+	for _, tt := range tests {
+		if err := tt.p.Validate(tt.pushType); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		}
+	}
+}
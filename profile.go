@@ -4,8 +4,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"os"
-	"strconv"
 	"strings"
+	"time"
 )
 
 type ProfileCreateRequest struct {
@@ -27,17 +27,9 @@ type ProfileCreateRequest struct {
 			ProfileType string `json:"profileType,omitempty"`
 		} `json:"attributes,omitempty"`
 		Relationships struct {
-			BundleId struct {
-				Data TypeId `json:"data,omitempty"` //bundleIds
-			} `json:"bundleId,omitempty"`
-
-			Certificates struct {
-				Data []TypeId `json:"data,omitempty"` //certificates
-			} `json:"certificates,omitempty"`
-
-			Devices struct {
-				Data []TypeId `json:"data,omitempty"` //devices
-			} `json:"devices,omitempty"`
+			BundleId     ToOneData  `json:"bundleId,omitempty"`     //bundleIds
+			Certificates ToManyData `json:"certificates,omitempty"` //certificates
+			Devices      ToManyData `json:"devices,omitempty"`      //devices
 		} `json:"relationships,omitempty"`
 
 		Type string `json:"type,omitempty"` //"profiles"
@@ -59,31 +51,9 @@ type Profile struct {
 	Id string `json:"id,omitempty"`
 
 	Relationships struct {
-		Certificates struct {
-			Data  []TypeId `json:"data,omitempty"` //certificates
-			Links struct {
-				Related string `json:"related,omitempty"`
-				Self    string `json:"self,omitempty"`
-			} `json:"links,omitempty"`
-			Meta PagingInformation `json:"meta,omitempty"`
-		} `json:"certificates,omitempty"`
-
-		Devices struct {
-			Data  []TypeId `json:"data,omitempty"` //devices
-			Links struct {
-				Related string `json:"related,omitempty"`
-				Self    string `json:"self,omitempty"`
-			} `json:"links,omitempty"`
-			Meta PagingInformation `json:"meta,omitempty"`
-		} `json:"devices,omitempty"`
-
-		BundleId struct {
-			Data  TypeId `json:"data,omitempty"` //bundleIds
-			Links struct {
-				Related string `json:"related,omitempty"`
-				Self    string `json:"self,omitempty"`
-			} `json:"links,omitempty"`
-		} `json:"bundleId,omitempty"`
+		Certificates ToManyRelationship `json:"certificates,omitempty"` //certificates
+		Devices      ToManyRelationship `json:"devices,omitempty"`      //devices
+		BundleId     ToOneRelationship  `json:"bundleId,omitempty"`     //bundleIds
 	} `json:"relationships,omitempty"`
 
 	Type  string        `json:"type,omitempty"` // profiles
@@ -104,96 +74,70 @@ func (c *Profile) SaveProfileContent(file string) error {
 	return nil
 }
 
+// IsXcodeManaged reports whether p was created by Xcode automatic signing,
+// such as "iOS Team Provisioning Profile: *" or "XC iOS: com.example.app".
+func (c *Profile) IsXcodeManaged() bool {
+	name := c.Attributes.Name
+	return strings.HasPrefix(name, "XC ") || strings.Contains(name, "Team Provisioning Profile: ")
+}
+
+func (c *Profile) Expiration() (time.Time, error) {
+	return ParseDate(c.Attributes.ExpirationDate)
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/profileresponse
+// Included possible types: BundleId, Certificate, Device
 type ProfileResponse struct {
-	Data     []Profile     `json:"data,omitempty"`
-	Links    DocumentLinks `json:"links,omitempty"`
-	Included []interface{} `json:"included,omitempty"`
+	Data Profile `json:"data,omitempty"`
+	Document
 }
 
 // https://developer.apple.com/documentation/appstoreconnectapi/profilesresponse
+// Included possible types: BundleId, Certificate, Device
 type ProfilesResponse struct {
-	Data     []Profile          `json:"data,omitempty"`
-	Links    PagedDocumentLinks `json:"links,omitempty"`
-	Meta     PagingInformation  `json:"meta,omitempty"`
-	Included []interface{}      `json:"included,omitempty"`
+	Data []Profile `json:"data,omitempty"`
+	CollectionDocument
 }
 
 type ListProfilesQuery struct {
-	Certificates      string `json:"certificates,omitempty"` //Possible values: certificateContent, certificateType, csrContent, displayName, expirationDate, name, platform, serialNumber
-	Devices           string `json:"devices,omitempty"`      //Possible values: addedDate, deviceClass, model, name, platform, status, udid
-	Profiles          string `json:"profiles,omitempty"`     //Possible values: bundleId, certificates, createdDate, devices, expirationDate, name, platform, profileContent, profileState, profileType, uuid
-	Id                string `json:"id,omitempty"`
-	Name              string `json:"name,omitempty"`
-	Include           string `json:"include,omitempty"`            //Possible values: bundleId, certificates, devices
-	Limit             int    `json:"limit,omitempty"`              //Maximum: 200
-	LimitCertificates int    `json:"limit_certificates,omitempty"` //Maximum: 50
-	LimitDevices      int    `json:"limit_devices,omitempty"`      //Maximum: 50
-	Sort              string `json:"sort,omitempty"`               //Possible values: id, -id, name, -name, profileState, -profileState, profileType, -profileType
-	BundleIds         string `json:"bundleIds,omitempty"`          //Possible values: bundleIdCapabilities, identifier, name, platform, profiles, seedId
-	ProfileState      string `json:"profileState,omitempty"`       //Possible values: ACTIVE, INVALID
-	ProfileType       string `json:"profileType,omitempty"`        //Possible values: IOS_APP_DEVELOPMENT, IOS_APP_STORE, IOS_APP_ADHOC, IOS_APP_INHOUSE, MAC_APP_DEVELOPMENT, MAC_APP_STORE, MAC_APP_DIRECT, TVOS_APP_DEVELOPMENT, TVOS_APP_STORE, TVOS_APP_ADHOC, TVOS_APP_INHOUSE
+	Certificates      []CertificateField `json:"certificates,omitempty"`
+	Devices           []DeviceField      `json:"devices,omitempty"`
+	Profiles          []ProfileField     `json:"profiles,omitempty"`
+	Id                []string           `json:"id,omitempty"`
+	Name              []string           `json:"name,omitempty"`
+	Include           []string           `json:"include,omitempty"`            //Possible values: bundleId, certificates, devices
+	Limit             int                `json:"limit,omitempty"`              //Maximum: 200
+	LimitCertificates int                `json:"limit_certificates,omitempty"` //Maximum: 50
+	LimitDevices      int                `json:"limit_devices,omitempty"`      //Maximum: 50
+	Sort              []SortKey          `json:"sort,omitempty"`               //ProfileSort
+	BundleIds         []BundleIdField    `json:"bundleIds,omitempty"`
+	ProfileState      []string           `json:"profileState,omitempty"` //Possible values: ACTIVE, INVALID
+	ProfileType       []string           `json:"profileType,omitempty"`  //Possible values: IOS_APP_DEVELOPMENT, IOS_APP_STORE, IOS_APP_ADHOC, IOS_APP_INHOUSE, MAC_APP_DEVELOPMENT, MAC_APP_STORE, MAC_APP_DIRECT, TVOS_APP_DEVELOPMENT, TVOS_APP_STORE, TVOS_APP_ADHOC, TVOS_APP_INHOUSE
 }
 
-func (q *ListProfilesQuery) QueryString() string {
-	sb := strings.Builder{}
+func (q *ListProfilesQuery) Builder() *QueryBuilder {
+	return NewQueryBuilder().
+		Fields("certificates", fieldStrings(len(q.Certificates), func(i int) string { return string(q.Certificates[i]) })...).
+		Fields("devices", fieldStrings(len(q.Devices), func(i int) string { return string(q.Devices[i]) })...).
+		Fields("profiles", fieldStrings(len(q.Profiles), func(i int) string { return string(q.Profiles[i]) })...).
+		Fields("bundleIds", fieldStrings(len(q.BundleIds), func(i int) string { return string(q.BundleIds[i]) })...).
+		Filter("id", q.Id...).
+		Filter("name", q.Name...).
+		Filter("profileState", q.ProfileState...).
+		Filter("profileType", q.ProfileType...).
+		Include(q.Include...).
+		Limit(q.Limit).
+		LimitRelated("certificates", q.LimitCertificates).
+		LimitRelated("devices", q.LimitDevices).
+		Sort(q.Sort...)
+}
 
-	if "" != q.Certificates {
-		sb.WriteString("&fields[certificates]=")
-		sb.WriteString(q.Certificates)
-	}
-	if "" != q.Devices {
-		sb.WriteString("&fields[devices]=")
-		sb.WriteString(q.Devices)
-	}
-	if "" != q.Profiles {
-		sb.WriteString("&fields[profiles]=")
-		sb.WriteString(q.Profiles)
-	}
-	if "" != q.Id {
-		sb.WriteString("&filter[id]=")
-		sb.WriteString(q.Id)
-	}
-	if "" != q.Name {
-		sb.WriteString("&filter[name]=")
-		sb.WriteString(q.Name)
-	}
-	if "" != q.Include {
-		sb.WriteString("&include=")
-		sb.WriteString(q.Include)
-	}
-	if q.Limit > 0 {
-		sb.WriteString("&limit=")
-		sb.WriteString(strconv.Itoa(q.Limit))
-	}
-	if q.LimitCertificates > 0 {
-		sb.WriteString("&limit[certificates]=")
-		sb.WriteString(strconv.Itoa(q.LimitCertificates))
-	}
-	if q.LimitDevices > 0 {
-		sb.WriteString("&limit[devices]=")
-		sb.WriteString(strconv.Itoa(q.LimitDevices))
-	}
-	if "" != q.Sort {
-		sb.WriteString("&sort=")
-		sb.WriteString(q.Sort)
-	}
-	if "" != q.BundleIds {
-		sb.WriteString("&fields[bundleIds]=")
-		sb.WriteString(q.BundleIds)
-	}
-	if "" != q.ProfileState {
-		sb.WriteString("&filter[profileState]=")
-		sb.WriteString(q.ProfileState)
-	}
-	if "" != q.ProfileType {
-		sb.WriteString("&filter[profileType]=")
-		sb.WriteString(q.ProfileType)
-	}
+func (q *ListProfilesQuery) Validate() error {
+	return q.Builder().Err()
+}
 
-	if sb.Len() > 1 {
-		return sb.String()[1:sb.Len()]
-	}
-	return sb.String()
+func (q *ListProfilesQuery) QueryString() string {
+	return q.Builder().Encode()
 }
 
 type Profiles struct {
@@ -201,22 +145,61 @@ type Profiles struct {
 }
 
 func (c *Profiles) Query(query *ListProfilesQuery) ([]byte, error) {
-	url := "https://api.appstoreconnect.apple.com/v1/profiles"
+	url := c.Url("/v1/profiles")
 	if query != nil {
+		if err := query.Validate(); err != nil {
+			return nil, err
+		}
 		url += "?" + query.QueryString()
 	}
 	return c.WebGet(url)
 }
 
+// Next reads the page at the links.next url of a previous Query.
+func (c *Profiles) Next(next string) ([]byte, error) {
+	return c.WebGet(next)
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/read_and_download_profile_information
 func (c *Profiles) ReadProfile(id string) ([]byte, error) {
-	url := "https://api.appstoreconnect.apple.com/v1/profiles/" + id
+	url := c.Url("/v1/profiles/" + id)
+	return c.WebGet(url)
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/list_all_certificates_in_a_profile
+// limit 为 0 时使用服务端的默认值, 后续页用 Next 读取
+func (c *Profiles) ProfileCertificates(id string, limit int) ([]byte, error) {
+	return c.related(id, TypeCertificates, limit)
+}
+
+// https://developer.apple.com/documentation/appstoreconnectapi/list_all_devices_in_a_profile
+func (c *Profiles) ProfileDevices(id string, limit int) ([]byte, error) {
+	return c.related(id, TypeDevices, limit)
+}
+
+func (c *Profiles) related(id, relationship string, limit int) ([]byte, error) {
+	b := NewQueryBuilder().Limit(limit)
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+	url := c.Url("/v1/profiles/" + id + "/" + relationship)
+	if q := b.Encode(); q != "" {
+		url += "?" + q
+	}
 	return c.WebGet(url)
 }
 
+// https://developer.apple.com/documentation/appstoreconnectapi/create_a_profile
 func (c *Profiles) ProfileCreate(name, bundleId string, certificates, devices []string) ([]byte, error) {
+	return c.ProfileCreateWithType(name, "", bundleId, certificates, devices)
+}
+
+// ProfileCreateWithType creates a profile of profileType, e.g. IOS_APP_STORE.
+func (c *Profiles) ProfileCreateWithType(name, profileType, bundleId string, certificates, devices []string) ([]byte, error) {
 	req := new(ProfileCreateRequest)
 	req.Data.Type = "profiles"
 	req.Data.Attributes.Name = name
+	req.Data.Attributes.ProfileType = profileType
 	req.Data.Relationships.BundleId.Data.Id = bundleId
 	req.Data.Relationships.BundleId.Data.Type = "bundleIds"
 	req.Data.Relationships.Certificates.Data = make([]TypeId, len(certificates))
@@ -232,10 +215,16 @@ func (c *Profiles) ProfileCreate(name, bundleId string, certificates, devices []
 		req.Data.Relationships.Devices.Data[i].Id = devices[i]
 	}
 
-	url := "https://api.appstoreconnect.apple.com/v1/profiles"
+	url := c.Url("/v1/profiles")
 	reqJson, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 	return c.WebPost(url, reqJson)
 }
+
+// https://developer.apple.com/documentation/appstoreconnectapi/delete_a_profile
+func (c *Profiles) ProfileDelete(id string) error {
+	_, err := c.WebDelete(c.Url("/v1/profiles/" + id))
+	return err
+}
@@ -0,0 +1,246 @@
+package appleapi
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxLimit        = 200 // limit 的最大值
+	MaxRelatedLimit = 50  // limit[relationship] 的最大值
+)
+
+var (
+	ErrQueryLimit        = errors.New("query: limit must be between 1 and 200")
+	ErrQueryRelatedLimit = errors.New("query: relationship limit must be between 1 and 50")
+)
+
+// SortKey is a sort field together with its direction.
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+func (k SortKey) String() string {
+	if k.Descending {
+		return "-" + k.Field
+	}
+	return k.Field
+}
+
+// QueryBuilder builds the query string of a list request with url.Values,
+// so that every value is escaped.
+type QueryBuilder struct {
+	values url.Values
+	err    error
+}
+
+func NewQueryBuilder() *QueryBuilder {
+	return &QueryBuilder{values: url.Values{}}
+}
+
+func (b *QueryBuilder) set(key string, values []string) {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) > 0 {
+		b.values.Set(key, strings.Join(list, ","))
+	}
+}
+
+// Fields adds the sparse fieldset fields[resource].
+func (b *QueryBuilder) Fields(resource string, fields ...string) *QueryBuilder {
+	b.set("fields["+resource+"]", fields)
+	return b
+}
+
+// Filter adds filter[name], matching any of the values.
+func (b *QueryBuilder) Filter(name string, values ...string) *QueryBuilder {
+	b.set("filter["+name+"]", values)
+	return b
+}
+
+func (b *QueryBuilder) Include(relationships ...string) *QueryBuilder {
+	b.set("include", relationships)
+	return b
+}
+
+func (b *QueryBuilder) Sort(keys ...SortKey) *QueryBuilder {
+	list := make([]string, len(keys))
+	for i, k := range keys {
+		list[i] = k.String()
+	}
+	b.set("sort", list)
+	return b
+}
+
+// Limit sets the page size, 0 means the server default.
+func (b *QueryBuilder) Limit(n int) *QueryBuilder {
+	if n == 0 {
+		return b
+	}
+	if n < 0 || n > MaxLimit {
+		b.setErr(fmt.Errorf("%w: %d", ErrQueryLimit, n))
+		return b
+	}
+	b.values.Set("limit", strconv.Itoa(n))
+	return b
+}
+
+// LimitRelated sets limit[relationship] for included resources, 0 means the
+// server default.
+func (b *QueryBuilder) LimitRelated(relationship string, n int) *QueryBuilder {
+	if n == 0 {
+		return b
+	}
+	if n < 0 || n > MaxRelatedLimit {
+		b.setErr(fmt.Errorf("%w: %s=%d", ErrQueryRelatedLimit, relationship, n))
+		return b
+	}
+	b.values.Set("limit["+relationship+"]", strconv.Itoa(n))
+	return b
+}
+
+func (b *QueryBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Err returns the first validation error.
+func (b *QueryBuilder) Err() error {
+	return b.err
+}
+
+func (b *QueryBuilder) Values() url.Values {
+	return b.values
+}
+
+func (b *QueryBuilder) Encode() string {
+	return b.values.Encode()
+}
+
+func fieldStrings(n int, at func(int) string) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = at(i)
+	}
+	return list
+}
+
+// Sparse fieldsets
+type BundleIdField string
+
+const (
+	BundleIdFieldBundleIdCapabilities BundleIdField = "bundleIdCapabilities"
+	BundleIdFieldIdentifier           BundleIdField = "identifier"
+	BundleIdFieldName                 BundleIdField = "name"
+	BundleIdFieldPlatform             BundleIdField = "platform"
+	BundleIdFieldProfiles             BundleIdField = "profiles"
+	BundleIdFieldSeedId               BundleIdField = "seedId"
+)
+
+type BundleIdCapabilityField string
+
+const (
+	BundleIdCapabilityFieldBundleId       BundleIdCapabilityField = "bundleId"
+	BundleIdCapabilityFieldCapabilityType BundleIdCapabilityField = "capabilityType"
+	BundleIdCapabilityFieldSettings       BundleIdCapabilityField = "settings"
+)
+
+type CertificateField string
+
+const (
+	CertificateFieldCertificateContent CertificateField = "certificateContent"
+	CertificateFieldCertificateType    CertificateField = "certificateType"
+	CertificateFieldCsrContent         CertificateField = "csrContent"
+	CertificateFieldDisplayName        CertificateField = "displayName"
+	CertificateFieldExpirationDate     CertificateField = "expirationDate"
+	CertificateFieldName               CertificateField = "name"
+	CertificateFieldPlatform           CertificateField = "platform"
+	CertificateFieldSerialNumber       CertificateField = "serialNumber"
+)
+
+type DeviceField string
+
+const (
+	DeviceFieldAddedDate   DeviceField = "addedDate"
+	DeviceFieldDeviceClass DeviceField = "deviceClass"
+	DeviceFieldModel       DeviceField = "model"
+	DeviceFieldName        DeviceField = "name"
+	DeviceFieldPlatform    DeviceField = "platform"
+	DeviceFieldStatus      DeviceField = "status"
+	DeviceFieldUdid        DeviceField = "udid"
+)
+
+type ProfileField string
+
+const (
+	ProfileFieldBundleId       ProfileField = "bundleId"
+	ProfileFieldCertificates   ProfileField = "certificates"
+	ProfileFieldCreatedDate    ProfileField = "createdDate"
+	ProfileFieldDevices        ProfileField = "devices"
+	ProfileFieldExpirationDate ProfileField = "expirationDate"
+	ProfileFieldName           ProfileField = "name"
+	ProfileFieldPlatform       ProfileField = "platform"
+	ProfileFieldProfileContent ProfileField = "profileContent"
+	ProfileFieldProfileState   ProfileField = "profileState"
+	ProfileFieldProfileType    ProfileField = "profileType"
+	ProfileFieldUuid           ProfileField = "uuid"
+)
+
+// Sort keys
+type BundleIdSort string
+
+const (
+	BundleIdSortId       BundleIdSort = "id"
+	BundleIdSortName     BundleIdSort = "name"
+	BundleIdSortPlatform BundleIdSort = "platform"
+	BundleIdSortSeedId   BundleIdSort = "seedId"
+)
+
+func (s BundleIdSort) Asc() SortKey  { return SortKey{Field: string(s)} }
+func (s BundleIdSort) Desc() SortKey { return SortKey{Field: string(s), Descending: true} }
+
+type CertificateSort string
+
+const (
+	CertificateSortCertificateType CertificateSort = "certificateType"
+	CertificateSortDisplayName     CertificateSort = "displayName"
+	CertificateSortId              CertificateSort = "id"
+	CertificateSortSerialNumber    CertificateSort = "serialNumber"
+)
+
+func (s CertificateSort) Asc() SortKey  { return SortKey{Field: string(s)} }
+func (s CertificateSort) Desc() SortKey { return SortKey{Field: string(s), Descending: true} }
+
+type DeviceSort string
+
+const (
+	DeviceSortId       DeviceSort = "id"
+	DeviceSortName     DeviceSort = "name"
+	DeviceSortPlatform DeviceSort = "platform"
+	DeviceSortStatus   DeviceSort = "status"
+	DeviceSortUdid     DeviceSort = "udid"
+)
+
+func (s DeviceSort) Asc() SortKey  { return SortKey{Field: string(s)} }
+func (s DeviceSort) Desc() SortKey { return SortKey{Field: string(s), Descending: true} }
+
+type ProfileSort string
+
+const (
+	ProfileSortId           ProfileSort = "id"
+	ProfileSortName         ProfileSort = "name"
+	ProfileSortProfileState ProfileSort = "profileState"
+	ProfileSortProfileType  ProfileSort = "profileType"
+)
+
+func (s ProfileSort) Asc() SortKey  { return SortKey{Field: string(s)} }
+func (s ProfileSort) Desc() SortKey { return SortKey{Field: string(s), Descending: true} }
@@ -0,0 +1,105 @@
+package appleapi
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestQueryString(t *testing.T) {
+	tests := []struct {
+		name  string
+		query interface{ QueryString() string }
+		want  url.Values
+	}{
+		{
+			name: "devices",
+			query: &ListDevicesQuery{
+				Devices:  []DeviceField{DeviceFieldName, DeviceFieldUdid},
+				Name:     []string{"QA iPhone", "Li's iPad & Mac"},
+				Platform: []string{PlatformIos},
+				Limit:    MaxLimit,
+				Sort:     []SortKey{DeviceSortName.Desc(), DeviceSortId.Asc()},
+			},
+			want: url.Values{
+				"fields[devices]":  {"name,udid"},
+				"filter[name]":     {"QA iPhone,Li's iPad & Mac"},
+				"filter[platform]": {"IOS"},
+				"limit":            {"200"},
+				"sort":             {"-name,id"},
+			},
+		},
+		{
+			name: "profiles",
+			query: &ListProfilesQuery{
+				Include:           []string{"bundleId", "devices"},
+				ProfileType:       []string{"IOS_APP_ADHOC", "IOS_APP_DEVELOPMENT"},
+				LimitCertificates: 1,
+				LimitDevices:      MaxRelatedLimit,
+			},
+			want: url.Values{
+				"include":             {"bundleId,devices"},
+				"filter[profileType]": {"IOS_APP_ADHOC,IOS_APP_DEVELOPMENT"},
+				"limit[certificates]": {"1"},
+				"limit[devices]":      {"50"},
+			},
+		},
+		{
+			name:  "empty values are skipped",
+			query: &ListBundlesQuery{Identifier: []string{"", "com.example.app"}, SeedId: []string{""}},
+			want:  url.Values{"filter[identifier]": {"com.example.app"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := tt.query.QueryString()
+			got, err := url.ParseQuery(raw)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q): %v", raw, err)
+			}
+			if got.Encode() != tt.want.Encode() {
+				t.Errorf("QueryString() = %q, want %q", raw, tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestQueryEscaping(t *testing.T) {
+	q := &ListDevicesQuery{Name: []string{"a&limit=1", "#x"}}
+	raw := q.QueryString()
+	if raw != "filter%5Bname%5D=a%26limit%3D1%2C%23x" {
+		t.Errorf("QueryString() = %q", raw)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		query interface{ Validate() error }
+		want  error
+	}{
+		{"default limit", &ListDevicesQuery{}, nil},
+		{"max limit", &ListDevicesQuery{Limit: 200}, nil},
+		{"limit over 200", &ListDevicesQuery{Limit: 201}, ErrQueryLimit},
+		{"negative limit", &ListCertificatesQuery{Limit: -1}, ErrQueryLimit},
+		{"max related limit", &ListProfilesQuery{LimitDevices: 50}, nil},
+		{"related limit over 50", &ListProfilesQuery{LimitDevices: 51}, ErrQueryRelatedLimit},
+		{"certificates limit over 50", &ListProfilesQuery{Limit: 10, LimitCertificates: 100}, ErrQueryRelatedLimit},
+		{"bundle profiles limit over 50", &ListBundlesQuery{LimitProfiles: 51}, ErrQueryRelatedLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryRejectsInvalidLimitBeforeSending(t *testing.T) {
+	c := &Devices{Token: &Token{}}
+	if _, err := c.Query(&ListDevicesQuery{Limit: 500}); !errors.Is(err, ErrQueryLimit) {
+		t.Errorf("Query() = %v, want %v", err, ErrQueryLimit)
+	}
+}
@@ -0,0 +1,263 @@
+package appleapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verifyReceipt 已被苹果弃用, 新的 app 应使用 App Store Server API
+// https://developer.apple.com/documentation/appstorereceipts/verifyreceipt
+const (
+	VerifyReceiptProductionUrl = "https://buy.itunes.apple.com/verifyReceipt"
+	VerifyReceiptSandboxUrl    = "https://sandbox.itunes.apple.com/verifyReceipt"
+)
+
+// verifyReceipt 的 status, 21100-21199 是苹果的内部错误
+// https://developer.apple.com/documentation/appstorereceipts/status
+const (
+	ReceiptStatusOk                  = 0
+	ReceiptStatusBadMethod           = 21000
+	ReceiptStatusBadData             = 21002
+	ReceiptStatusNotAuthenticated    = 21003
+	ReceiptStatusBadSecret           = 21004
+	ReceiptStatusServerUnavailable   = 21005
+	ReceiptStatusSubscriptionExpired = 21006
+	ReceiptStatusSandboxReceipt      = 21007
+	ReceiptStatusProductionReceipt   = 21008
+	ReceiptStatusInternalError       = 21009
+	ReceiptStatusAccountNotFound     = 21010
+)
+
+var receiptStatusText = map[int]string{
+	ReceiptStatusBadMethod:           "the request to the App Store was not made using the HTTP POST request method",
+	ReceiptStatusBadData:             "the data in the receipt-data property was malformed or the service experienced a temporary issue",
+	ReceiptStatusNotAuthenticated:    "the receipt could not be authenticated",
+	ReceiptStatusBadSecret:           "the shared secret you provided does not match the shared secret on file for your account",
+	ReceiptStatusServerUnavailable:   "the receipt server was temporarily unable to provide the receipt",
+	ReceiptStatusSubscriptionExpired: "the receipt is valid, but the subscription has expired",
+	ReceiptStatusSandboxReceipt:      "the receipt is from the test environment, but it was sent to the production environment",
+	ReceiptStatusProductionReceipt:   "the receipt is from the production environment, but it was sent to the test environment",
+	ReceiptStatusInternalError:       "internal data access error",
+	ReceiptStatusAccountNotFound:     "the user account cannot be found or has been deleted",
+}
+
+// ReceiptError 是 verifyReceipt 返回的非 0 status
+type ReceiptError struct {
+	Status      int
+	Environment string
+	IsRetryable bool // 21100-21199 的错误为 true 时可以重试
+}
+
+func (e *ReceiptError) Error() string {
+	text, ok := receiptStatusText[e.Status]
+	if !ok {
+		if e.Status >= 21100 && e.Status <= 21199 {
+			text = "internal data access error"
+		} else {
+			text = "unknown status"
+		}
+	}
+	return fmt.Sprintf("verifyReceipt: %d %s", e.Status, text)
+}
+
+// Temporary 表示稍后重试可能成功
+func (e *ReceiptError) Temporary() bool {
+	return e.IsRetryable || e.Status == ReceiptStatusServerUnavailable || e.Status == ReceiptStatusInternalError
+}
+
+// ReceiptTime 解码收据中 _ms 结尾的毫秒时间戳, 苹果把它编码为字符串
+type ReceiptTime struct {
+	time.Time
+}
+
+func (t *ReceiptTime) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("verifyReceipt: invalid timestamp %s", data)
+	}
+	t.Time = MillisTime(ms)
+	return nil
+}
+
+func (t ReceiptTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte(`""`), nil
+	}
+	return []byte(`"` + strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10) + `"`), nil
+}
+
+// https://developer.apple.com/documentation/appstorereceipts/requestbody
+type VerifyReceiptRequest struct {
+	ReceiptData            string `json:"receipt-data"`
+	Password               string `json:"password,omitempty"`
+	ExcludeOldTransactions bool   `json:"exclude-old-transactions,omitempty"`
+}
+
+// InAppReceipt 是 in_app 和 latest_receipt_info 中的一项, 时间取自 _ms 字段
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/receipt/in_app
+type InAppReceipt struct {
+	Quantity                    string      `json:"quantity"`
+	ProductId                   string      `json:"product_id"`
+	TransactionId               string      `json:"transaction_id"`
+	OriginalTransactionId       string      `json:"original_transaction_id"`
+	WebOrderLineItemId          string      `json:"web_order_line_item_id,omitempty"`
+	PurchaseDate                ReceiptTime `json:"purchase_date_ms"`
+	OriginalPurchaseDate        ReceiptTime `json:"original_purchase_date_ms"`
+	ExpiresDate                 ReceiptTime `json:"expires_date_ms"`
+	CancellationDate            ReceiptTime `json:"cancellation_date_ms"`
+	CancellationReason          string      `json:"cancellation_reason,omitempty"` // "1" app 的问题, "0" 其他原因
+	IsTrialPeriod               FlexBool    `json:"is_trial_period"`
+	IsInIntroOfferPeriod        FlexBool    `json:"is_in_intro_offer_period"`
+	IsUpgraded                  FlexBool    `json:"is_upgraded,omitempty"`
+	PromotionalOfferId          string      `json:"promotional_offer_id,omitempty"`
+	OfferCodeRefName            string      `json:"offer_code_ref_name,omitempty"`
+	SubscriptionGroupIdentifier string      `json:"subscription_group_identifier,omitempty"`
+	InAppOwnershipType          string      `json:"in_app_ownership_type,omitempty"` // OwnershipPurchased 等
+	AppAccountToken             string      `json:"app_account_token,omitempty"`
+}
+
+// Expired 表示订阅在 now 已过期或交易已退款
+func (r *InAppReceipt) Expired(now time.Time) bool {
+	if !r.CancellationDate.IsZero() {
+		return true
+	}
+	return !r.ExpiresDate.IsZero() && !now.Before(r.ExpiresDate.Time)
+}
+
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/pending_renewal_info
+type PendingRenewalInfo struct {
+	AutoRenewProductId     string      `json:"auto_renew_product_id"`
+	AutoRenewStatus        string      `json:"auto_renew_status"` // "1" 自动续订
+	ExpirationIntent       string      `json:"expiration_intent,omitempty"`
+	GracePeriodExpiresDate ReceiptTime `json:"grace_period_expires_date_ms"`
+	IsInBillingRetryPeriod string      `json:"is_in_billing_retry_period,omitempty"`
+	OfferCodeRefName       string      `json:"offer_code_ref_name,omitempty"`
+	OriginalTransactionId  string      `json:"original_transaction_id"`
+	PriceConsentStatus     string      `json:"price_consent_status,omitempty"`
+	ProductId              string      `json:"product_id"`
+	PromotionalOfferId     string      `json:"promotional_offer_id,omitempty"`
+	PriceIncreaseStatus    string      `json:"price_increase_status,omitempty"`
+}
+
+// https://developer.apple.com/documentation/appstorereceipts/responsebody/receipt
+type AppReceipt struct {
+	ReceiptType                string         `json:"receipt_type"`
+	AdamId                     int64          `json:"adam_id"`
+	AppItemId                  int64          `json:"app_item_id"`
+	BundleId                   string         `json:"bundle_id"`
+	ApplicationVersion         string         `json:"application_version"`
+	DownloadId                 int64          `json:"download_id"`
+	VersionExternalIdentifier  int64          `json:"version_external_identifier"`
+	ReceiptCreationDate        ReceiptTime    `json:"receipt_creation_date_ms"`
+	RequestDate                ReceiptTime    `json:"request_date_ms"`
+	OriginalPurchaseDate       ReceiptTime    `json:"original_purchase_date_ms"`
+	OriginalApplicationVersion string         `json:"original_application_version"`
+	ExpirationDate             ReceiptTime    `json:"expiration_date_ms"`
+	PreorderDate               ReceiptTime    `json:"preorder_date_ms"`
+	InApp                      []InAppReceipt `json:"in_app"`
+}
+
+// https://developer.apple.com/documentation/appstorereceipts/responsebody
+type VerifyReceiptResponse struct {
+	Status             int                  `json:"status"`
+	Environment        string               `json:"environment"`
+	IsRetryable        bool                 `json:"is-retryable,omitempty"`
+	Receipt            *AppReceipt          `json:"receipt,omitempty"`
+	LatestReceipt      string               `json:"latest_receipt,omitempty"`
+	LatestReceiptInfo  []InAppReceipt       `json:"latest_receipt_info,omitempty"`
+	PendingRenewalInfo []PendingRenewalInfo `json:"pending_renewal_info,omitempty"`
+}
+
+// ReceiptVerifier 调用 verifyReceipt 验证 app 收据, 先请求生产环境,
+// 收到 21007 时再请求沙盒环境, 审核人员使用的是沙盒收据
+type ReceiptVerifier struct {
+	Password               string // app 专用共享密钥, 验证自动续订订阅时需要
+	ExcludeOldTransactions bool   // 只返回每个订阅最新的续订交易
+	ProductionUrl          string // 默认 VerifyReceiptProductionUrl
+	SandboxUrl             string // 默认 VerifyReceiptSandboxUrl
+	Client                 *http.Client
+	Hooks                  []Hook
+}
+
+func NewReceiptVerifier(password string) *ReceiptVerifier {
+	return &ReceiptVerifier{Password: password, ProductionUrl: VerifyReceiptProductionUrl, SandboxUrl: VerifyReceiptSandboxUrl}
+}
+
+// Verify 验证 base64 编码的收据, status 不为 0 时返回 *ReceiptError 和响应
+func (v *ReceiptVerifier) Verify(ctx context.Context, receiptData string) (*VerifyReceiptResponse, error) {
+	req := &VerifyReceiptRequest{ReceiptData: receiptData, Password: v.Password, ExcludeOldTransactions: v.ExcludeOldTransactions}
+	u := v.ProductionUrl
+	if u == "" {
+		u = VerifyReceiptProductionUrl
+	}
+	resp, err := v.post(ctx, u, req)
+	if err == nil && resp.Status == ReceiptStatusSandboxReceipt {
+		u = v.SandboxUrl
+		if u == "" {
+			u = VerifyReceiptSandboxUrl
+		}
+		resp, err = v.post(ctx, u, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != ReceiptStatusOk {
+		return resp, &ReceiptError{Status: resp.Status, Environment: resp.Environment, IsRetryable: resp.IsRetryable}
+	}
+	return resp, nil
+}
+
+func (v *ReceiptVerifier) post(ctx context.Context, u string, r *VerifyReceiptRequest) (*VerifyReceiptResponse, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, h := range v.Hooks {
+		req = h.BeforeSend(req)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	for i := len(v.Hooks) - 1; i >= 0; i-- {
+		v.Hooks[i].AfterReceive(req, resp, err, latency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newApiError(resp, body)
+	}
+	result := &VerifyReceiptResponse{}
+	if err = json.Unmarshal(body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,395 @@
+package appleapi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// 在设备上验证收据, 收据是 PKCS#7 签名的 ASN.1 数据
+// https://developer.apple.com/documentation/appstorereceipts/validating_receipts_on_the_device
+
+var (
+	ErrReceiptMalformed = errors.New("receipt: malformed PKCS#7 receipt")
+	ErrReceiptSignature = errors.New("receipt: invalid signature")
+	ErrReceiptChain     = errors.New("receipt: invalid certificate chain")
+	ErrReceiptBundleId  = errors.New("receipt: bundle_id does not match")
+
+	oidPkcs7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPkcs7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSha1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSha256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRsaEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSha1WithRsa     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSha256WithRsa   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidEcPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidEcdsaWithSha256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// 收据属性的类型
+// https://developer.apple.com/library/archive/releasenotes/General/ValidateAppStoreReceipt/Chapters/ReceiptFields.html
+const (
+	ReceiptFieldReceiptType                = 0
+	ReceiptFieldAppItemId                  = 1
+	ReceiptFieldBundleId                   = 2
+	ReceiptFieldApplicationVersion         = 3
+	ReceiptFieldOpaqueValue                = 4
+	ReceiptFieldSha1Hash                   = 5
+	ReceiptFieldCreationDate               = 12
+	ReceiptFieldInAppPurchase              = 17
+	ReceiptFieldOriginalApplicationVersion = 19
+	ReceiptFieldExpirationDate             = 21
+
+	InAppFieldQuantity              = 1701
+	InAppFieldProductId             = 1702
+	InAppFieldTransactionId         = 1703
+	InAppFieldPurchaseDate          = 1704
+	InAppFieldOriginalTransactionId = 1705
+	InAppFieldOriginalPurchaseDate  = 1706
+	InAppFieldExpiresDate           = 1708
+	InAppFieldWebOrderLineItemId    = 1711
+	InAppFieldCancellationDate      = 1712
+	InAppFieldIsTrialPeriod         = 1713
+	InAppFieldIsInIntroOfferPeriod  = 1719
+	InAppFieldPromotionalOfferId    = 1721
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerial           pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// receiptAttribute 是收据载荷中 SET 的一项
+type receiptAttribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+// pkcs7 是解析后的 PKCS#7 SignedData, 只支持 DER 编码
+type pkcs7 struct {
+	content      []byte
+	certificates []*x509.Certificate
+	signer       pkcs7SignerInfo
+}
+
+func parsePkcs7(data []byte) (*pkcs7, error) {
+	ci := pkcs7ContentInfo{}
+	if rest, err := asn1.Unmarshal(data, &ci); err != nil || len(rest) > 0 {
+		return nil, ErrReceiptMalformed
+	}
+	if !ci.ContentType.Equal(oidPkcs7SignedData) {
+		return nil, fmt.Errorf("%w: content type %v", ErrReceiptMalformed, ci.ContentType)
+	}
+	sd := pkcs7SignedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptMalformed, err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidPkcs7Data) || len(sd.SignerInfos) != 1 {
+		return nil, ErrReceiptMalformed
+	}
+	p := &pkcs7{signer: sd.SignerInfos[0]}
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &p.content); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptMalformed, err)
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReceiptMalformed, err)
+		}
+		p.certificates = certs
+	}
+	return p, nil
+}
+
+// signatureAlgorithm 返回签名算法和摘要算法
+func (s *pkcs7SignerInfo) signatureAlgorithm() (x509.SignatureAlgorithm, crypto.Hash) {
+	digest := s.DigestAlgorithm.Algorithm
+	enc := s.DigestEncryptionAlgorithm.Algorithm
+	switch {
+	case digest.Equal(oidSha1) && (enc.Equal(oidRsaEncryption) || enc.Equal(oidSha1WithRsa)):
+		return x509.SHA1WithRSA, crypto.SHA1
+	case digest.Equal(oidSha256) && (enc.Equal(oidRsaEncryption) || enc.Equal(oidSha256WithRsa)):
+		return x509.SHA256WithRSA, crypto.SHA256
+	case digest.Equal(oidSha256) && (enc.Equal(oidEcPublicKey) || enc.Equal(oidEcdsaWithSha256)):
+		return x509.ECDSAWithSHA256, crypto.SHA256
+	}
+	return x509.UnknownSignatureAlgorithm, 0
+}
+
+// verify 用签名者的证书验证签名, 返回签名证书
+func (p *pkcs7) verify() (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	for _, c := range p.certificates {
+		if bytes.Equal(c.RawIssuer, p.signer.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(p.signer.IssuerAndSerial.Serial) == 0 {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("%w: no signer certificate", ErrReceiptSignature)
+	}
+	alg, hash := p.signer.signatureAlgorithm()
+	if alg == x509.UnknownSignatureAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm %v", ErrReceiptSignature, p.signer.DigestEncryptionAlgorithm.Algorithm)
+	}
+
+	signed := p.content
+	if attrs := p.signer.AuthenticatedAttributes.FullBytes; len(attrs) > 0 {
+		// 有签名属性时签名的是属性的 DER 编码 (SET OF), 属性中的 messageDigest 是内容的摘要
+		signed = append([]byte{0x31}, attrs[1:]...)
+		var list []pkcs7Attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &list, "set"); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReceiptMalformed, err)
+		}
+		var digest []byte
+		for _, a := range list {
+			if a.Type.Equal(oidMessageDigest) {
+				asn1.Unmarshal(a.Values.Bytes, &digest)
+			}
+		}
+		h := hash.New()
+		h.Write(p.content)
+		if digest == nil || !bytes.Equal(digest, h.Sum(nil)) {
+			return nil, fmt.Errorf("%w: message digest does not match", ErrReceiptSignature)
+		}
+	}
+	if err := cert.CheckSignature(alg, signed, p.signer.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptSignature, err)
+	}
+	return cert, nil
+}
+
+// LocalReceipt 是在本地解析的收据, 字段和 verifyReceipt 返回的 receipt 相同
+type LocalReceipt struct {
+	AppReceipt
+	OpaqueValue []byte
+	Sha1Hash    []byte
+
+	bundleId []byte // bundle_id 属性的原始值, 用于计算 Sha1Hash
+}
+
+// ValidateHash 检查收据是否属于 deviceId 的设备, deviceId 在 iOS 上是 identifierForVendor 的 16 个字节,
+// 在 macOS 上是主网卡的 MAC 地址
+func (r *LocalReceipt) ValidateHash(deviceId []byte) bool {
+	h := sha1.New()
+	h.Write(deviceId)
+	h.Write(r.OpaqueValue)
+	h.Write(r.bundleId)
+	return bytes.Equal(h.Sum(nil), r.Sha1Hash)
+}
+
+func parseReceiptAttributes(data []byte) ([]receiptAttribute, error) {
+	var list []receiptAttribute
+	if _, err := asn1.UnmarshalWithParams(data, &list, "set"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptMalformed, err)
+	}
+	return list, nil
+}
+
+// 属性值是 DER 编码的 UTF8String, IA5String 或 INTEGER
+func receiptString(value []byte) string {
+	var s string
+	asn1.Unmarshal(value, &s)
+	return s
+}
+
+func receiptInt(value []byte) int64 {
+	var n int64
+	asn1.Unmarshal(value, &n)
+	return n
+}
+
+func receiptTime(value []byte) ReceiptTime {
+	t, err := time.Parse(time.RFC3339, receiptString(value))
+	if err != nil {
+		return ReceiptTime{}
+	}
+	return ReceiptTime{Time: t}
+}
+
+func parseInAppReceipt(data []byte) (InAppReceipt, error) {
+	r := InAppReceipt{}
+	attrs, err := parseReceiptAttributes(data)
+	if err != nil {
+		return r, err
+	}
+	for _, a := range attrs {
+		switch a.Type {
+		case InAppFieldQuantity:
+			r.Quantity = strconv.FormatInt(receiptInt(a.Value), 10)
+		case InAppFieldProductId:
+			r.ProductId = receiptString(a.Value)
+		case InAppFieldTransactionId:
+			r.TransactionId = receiptString(a.Value)
+		case InAppFieldOriginalTransactionId:
+			r.OriginalTransactionId = receiptString(a.Value)
+		case InAppFieldPurchaseDate:
+			r.PurchaseDate = receiptTime(a.Value)
+		case InAppFieldOriginalPurchaseDate:
+			r.OriginalPurchaseDate = receiptTime(a.Value)
+		case InAppFieldExpiresDate:
+			r.ExpiresDate = receiptTime(a.Value)
+		case InAppFieldCancellationDate:
+			r.CancellationDate = receiptTime(a.Value)
+		case InAppFieldWebOrderLineItemId:
+			if n := receiptInt(a.Value); n != 0 {
+				r.WebOrderLineItemId = strconv.FormatInt(n, 10)
+			}
+		case InAppFieldIsTrialPeriod:
+			r.IsTrialPeriod = receiptInt(a.Value) == 1
+		case InAppFieldIsInIntroOfferPeriod:
+			r.IsInIntroOfferPeriod = receiptInt(a.Value) == 1
+		case InAppFieldPromotionalOfferId:
+			r.PromotionalOfferId = receiptString(a.Value)
+		}
+	}
+	return r, nil
+}
+
+func parseReceiptPayload(data []byte) (*LocalReceipt, error) {
+	attrs, err := parseReceiptAttributes(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &LocalReceipt{}
+	for _, a := range attrs {
+		switch a.Type {
+		case ReceiptFieldReceiptType:
+			r.ReceiptType = receiptString(a.Value)
+		case ReceiptFieldAppItemId:
+			r.AppItemId = receiptInt(a.Value)
+		case ReceiptFieldBundleId:
+			r.BundleId = receiptString(a.Value)
+			r.bundleId = a.Value
+		case ReceiptFieldApplicationVersion:
+			r.ApplicationVersion = receiptString(a.Value)
+		case ReceiptFieldOpaqueValue:
+			r.OpaqueValue = a.Value
+		case ReceiptFieldSha1Hash:
+			r.Sha1Hash = a.Value
+		case ReceiptFieldCreationDate:
+			r.ReceiptCreationDate = receiptTime(a.Value)
+		case ReceiptFieldInAppPurchase:
+			iap, err := parseInAppReceipt(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			r.InApp = append(r.InApp, iap)
+		case ReceiptFieldOriginalApplicationVersion:
+			r.OriginalApplicationVersion = receiptString(a.Value)
+		case ReceiptFieldExpirationDate:
+			r.ExpirationDate = receiptTime(a.Value)
+		}
+	}
+	return r, nil
+}
+
+// receiptDer 返回 DER 格式的收据, data 可以是 base64 编码, 如 app 上传的 receipt-data
+func receiptDer(data []byte) ([]byte, error) {
+	if len(data) > 0 && data[0] == 0x30 {
+		return data, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, ErrReceiptMalformed
+	}
+	return der, nil
+}
+
+// ParseReceipt 解析收据但不验证签名, 只能用于调试或已经通过其他方式验证过的收据
+func ParseReceipt(data []byte) (*LocalReceipt, error) {
+	der, err := receiptDer(data)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePkcs7(der)
+	if err != nil {
+		return nil, err
+	}
+	return parseReceiptPayload(p.content)
+}
+
+// LocalReceiptVerifier 验证收据的签名和证书链后解析收据, 证书链和 App Store JWS 相同,
+// 根证书是 Apple Inc. Root (https://www.apple.com/appleca/AppleIncRootCertificate.cer)
+type LocalReceiptVerifier struct {
+	Roots    []*x509.Certificate
+	BundleId string           // 不为空时检查收据的 bundle_id
+	Now      func() time.Time // 检查证书有效期的时间, 默认收据的创建时间, 签名证书过期后签发的收据仍然有效
+}
+
+func NewLocalReceiptVerifier(roots ...*x509.Certificate) *LocalReceiptVerifier {
+	return &LocalReceiptVerifier{Roots: roots}
+}
+
+// Verify 验证并解析 DER 或 base64 编码的收据
+func (v *LocalReceiptVerifier) Verify(data []byte) (*LocalReceipt, error) {
+	der, err := receiptDer(data)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePkcs7(der)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := p.verify()
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseReceiptPayload(p.content)
+	if err != nil {
+		return nil, err
+	}
+	now := r.ReceiptCreationDate.Time
+	if v.Now != nil {
+		now = v.Now()
+	} else if now.IsZero() {
+		now = time.Now()
+	}
+	if err = verifyAppleChain(leaf, p.certificates, v.Roots, now); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptChain, err)
+	}
+	if v.BundleId != "" && r.BundleId != v.BundleId {
+		return nil, ErrReceiptBundleId
+	}
+	return r, nil
+}
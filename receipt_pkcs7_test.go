@@ -0,0 +1,120 @@
+package appleapi_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func TestLocalReceiptVerifier(t *testing.T) {
+	srv := appleapitest.NewReceiptServer()
+	defer srv.Close()
+	deviceId := bytes.Repeat([]byte{7}, 16)
+	now := time.Now().UTC().Truncate(time.Second)
+	leaf := srv.LeafCertificate()
+	receipt := func(created time.Time) appleapi.AppReceipt {
+		return appleapi.AppReceipt{
+			ReceiptType:         "ProductionSandbox",
+			BundleId:            appleapitest.TestBundleId,
+			ApplicationVersion:  "1.2",
+			ReceiptCreationDate: appleapi.ReceiptTime{Time: created},
+			InApp: []appleapi.InAppReceipt{{
+				Quantity:              "1",
+				ProductId:             "com.example.app.coins",
+				TransactionId:         "1000000000000001",
+				OriginalTransactionId: "1000000000000001",
+				PurchaseDate:          appleapi.ReceiptTime{Time: now.Add(-time.Minute)},
+			}},
+		}
+	}
+	wrongDigest := func(content []byte) []byte { return make([]byte, 32) }
+
+	tests := []struct {
+		name string
+		data []byte
+		edit func(v *appleapi.LocalReceiptVerifier)
+		err  error
+	}{
+		{"signed attributes", srv.SignReceipt(receipt(now), deviceId), nil, nil},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(srv.SignReceipt(receipt(now), deviceId))), nil, nil},
+		{"signed content", srv.SignReceiptWith(receipt(now), deviceId, nil), nil, nil},
+		{"message digest", srv.SignReceiptWith(receipt(now), deviceId, wrongDigest), nil, appleapi.ErrReceiptSignature},
+		{"malformed", []byte{0x30, 0x03, 0x02, 0x01, 0x01}, nil, appleapi.ErrReceiptMalformed},
+		{"bundle id", srv.SignReceipt(receipt(now), deviceId), func(v *appleapi.LocalReceiptVerifier) { v.BundleId = "com.example.other" }, appleapi.ErrReceiptBundleId},
+		{"untrusted root", srv.SignReceipt(receipt(now), deviceId), func(v *appleapi.LocalReceiptVerifier) { v.Roots = nil }, appleapi.ErrReceiptChain},
+		// 默认用收据的创建时间检查证书有效期
+		{"created after the leaf expired", srv.SignReceipt(receipt(leaf.NotAfter.Add(time.Hour)), deviceId), nil, appleapi.ErrReceiptChain},
+		{"created before the leaf", srv.SignReceipt(receipt(leaf.NotBefore.Add(-time.Hour)), deviceId), nil, appleapi.ErrReceiptChain},
+		{"Now overrides the creation date", srv.SignReceipt(receipt(leaf.NotBefore.Add(-time.Hour)), deviceId), func(v *appleapi.LocalReceiptVerifier) { v.Now = time.Now }, nil},
+		{"Now after the leaf expired", srv.SignReceipt(receipt(now), deviceId), func(v *appleapi.LocalReceiptVerifier) {
+			v.Now = func() time.Time { return leaf.NotAfter.Add(time.Hour) }
+		}, appleapi.ErrReceiptChain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := srv.LocalReceiptVerifier()
+			v.BundleId = appleapitest.TestBundleId
+			if tt.edit != nil {
+				tt.edit(v)
+			}
+			r, err := v.Verify(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if r.BundleId != appleapitest.TestBundleId || r.ApplicationVersion != "1.2" || len(r.InApp) != 1 || r.InApp[0].ProductId != "com.example.app.coins" {
+				t.Errorf("receipt = %+v", r)
+			}
+			if !r.ValidateHash(deviceId) || r.ValidateHash(make([]byte, 16)) {
+				t.Error("sha1 hash does not match only the device")
+			}
+		})
+	}
+}
+
+func TestParseReceiptWithoutVerifying(t *testing.T) {
+	srv := appleapitest.NewReceiptServer()
+	defer srv.Close()
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r, err := appleapi.ParseReceipt(srv.SignReceipt(appleapi.AppReceipt{BundleId: appleapitest.TestBundleId, ReceiptCreationDate: appleapi.ReceiptTime{Time: created}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.BundleId != appleapitest.TestBundleId || !r.ReceiptCreationDate.Equal(created) {
+		t.Errorf("receipt = %+v", r)
+	}
+}
+
+func TestJwsVerifierUsesSignedDate(t *testing.T) {
+	srv := appleapitest.NewServerApiServer()
+	defer srv.Close()
+	leaf := srv.LeafCertificate()
+	sign := func(signed time.Time) string {
+		return srv.Sign(&appleapi.JWSTransactionDecodedPayload{
+			TransactionId: "2000000000000001",
+			BundleId:      srv.Bid,
+			SignedDate:    signed.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	v := srv.JwsVerifier()
+	if _, err := v.VerifyTransaction(sign(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	// 证书有效期外签名的交易无效, Now 覆盖 signedDate
+	for _, signed := range []time.Time{leaf.NotBefore.Add(-time.Hour), leaf.NotAfter.Add(time.Hour)} {
+		if _, err := v.VerifyTransaction(sign(signed)); !errors.Is(err, appleapi.ErrJwsChain) {
+			t.Errorf("signed at %v: err = %v, want %v", signed, err, appleapi.ErrJwsChain)
+		}
+	}
+	v.Now = time.Now
+	if _, err := v.VerifyTransaction(sign(leaf.NotAfter.Add(time.Hour))); err != nil {
+		t.Errorf("with Now: %v", err)
+	}
+}
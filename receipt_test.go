@@ -0,0 +1,88 @@
+package appleapi_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func TestReceiptVerifierStatus(t *testing.T) {
+	srv := appleapitest.NewReceiptServer()
+	defer srv.Close()
+	sandbox := srv.AddReceipt(appleapi.VerifyReceiptResponse{Environment: appleapi.EnvironmentSandbox})
+	production := srv.AddReceipt(appleapi.VerifyReceiptResponse{Environment: appleapi.EnvironmentProduction})
+	subscription := srv.AddReceipt(appleapi.VerifyReceiptResponse{
+		LatestReceiptInfo: []appleapi.InAppReceipt{{OriginalTransactionId: "1000000000000001"}},
+	})
+	unavailable := srv.AddReceipt(appleapi.VerifyReceiptResponse{})
+	srv.SetStatus(unavailable, appleapi.ReceiptStatusServerUnavailable)
+	retryable := srv.AddReceipt(appleapi.VerifyReceiptResponse{})
+	srv.SetStatus(retryable, 21150)
+
+	tests := []struct {
+		name        string
+		data        string
+		password    string
+		status      int
+		environment string
+		requests    int // 21007 时再请求沙盒环境
+		temporary   bool
+	}{
+		{"production", production, appleapitest.TestSharedSecret, appleapi.ReceiptStatusOk, appleapi.EnvironmentProduction, 1, false},
+		{"sandbox fallback", sandbox, appleapitest.TestSharedSecret, appleapi.ReceiptStatusOk, appleapi.EnvironmentSandbox, 2, false},
+		{"malformed", "bm90IGEgcmVjZWlwdA==", appleapitest.TestSharedSecret, appleapi.ReceiptStatusBadData, appleapi.EnvironmentProduction, 1, false},
+		{"shared secret", subscription, "wrong", appleapi.ReceiptStatusBadSecret, appleapi.EnvironmentSandbox, 2, false},
+		{"unavailable", unavailable, appleapitest.TestSharedSecret, appleapi.ReceiptStatusServerUnavailable, appleapi.EnvironmentProduction, 1, true},
+		{"retryable", retryable, appleapitest.TestSharedSecret, 21150, appleapi.EnvironmentProduction, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(srv.Requests())
+			v := srv.ReceiptVerifier()
+			v.Password = tt.password
+			resp, err := v.Verify(context.Background(), tt.data)
+			if n := len(srv.Requests()) - before; n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.Status != tt.status || resp.Environment != tt.environment {
+				t.Errorf("status %d in %s, want %d in %s", resp.Status, resp.Environment, tt.status, tt.environment)
+			}
+			var e *appleapi.ReceiptError
+			if tt.status == appleapi.ReceiptStatusOk {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &e) || e.Status != tt.status || e.Environment != tt.environment || e.Temporary() != tt.temporary {
+				t.Errorf("err = %#v, want status %d, temporary %v", err, tt.status, tt.temporary)
+			}
+		})
+	}
+}
+
+func TestReceiptErrorText(t *testing.T) {
+	tests := []struct {
+		status int
+		text   string
+	}{
+		{appleapi.ReceiptStatusSandboxReceipt, "the receipt is from the test environment"},
+		{appleapi.ReceiptStatusAccountNotFound, "the user account cannot be found"},
+		{21100, "internal data access error"},
+		{21199, "internal data access error"},
+		{21200, "unknown status"},
+	}
+	for _, tt := range tests {
+		e := &appleapi.ReceiptError{Status: tt.status}
+		if msg := e.Error(); !strings.Contains(msg, tt.text) {
+			t.Errorf("%d: %q does not contain %q", tt.status, msg, tt.text)
+		}
+	}
+}
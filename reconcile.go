@@ -0,0 +1,667 @@
+package appleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ChangeAction string
+
+const (
+	ChangeCreate  ChangeAction = "create"
+	ChangeUpdate  ChangeAction = "update"
+	ChangeReplace ChangeAction = "replace" // 先删除再创建
+	ChangeDelete  ChangeAction = "delete"
+)
+
+func (a ChangeAction) symbol() string {
+	switch a {
+	case ChangeCreate:
+		return "+"
+	case ChangeUpdate:
+		return "~"
+	case ChangeReplace:
+		return "-/+"
+	case ChangeDelete:
+		return "-"
+	}
+	return "?"
+}
+
+// Change 是计划中的一个变更
+type Change struct {
+	Action  ChangeAction
+	Type    string // 资源类型, e.g. TypeBundleIds
+	Name    string
+	Id      string // 线上资源 id, 新建时为空
+	Details []string
+
+	apply func(c *Client, st *applyState) error
+}
+
+func (ch *Change) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%3s %s %q", ch.Action.symbol(), ch.Type, ch.Name)
+	if ch.Id != "" {
+		fmt.Fprintf(&buf, " (%s)", ch.Id)
+	}
+	for _, d := range ch.Details {
+		buf.WriteString("\n      ")
+		buf.WriteString(d)
+	}
+	return buf.String()
+}
+
+// Plan 是 Spec 与线上状态的差异，Changes 按依赖顺序排列：
+// 设备、bundle ID、能力、描述文件，最后是禁用设备和删除 bundle ID
+type Plan struct {
+	Changes []*Change
+
+	client *Client
+	state  *applyState
+}
+
+// applyState 记录 Apply 过程中资源的 id，新建的资源在后续变更中引用
+type applyState struct {
+	bundles map[string]string // identifier -> id
+	devices map[string]string // 小写 udid -> id
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Summary 返回增加、修改、删除的数量，replace 同时计入增加和删除
+func (p *Plan) Summary() (add, change, destroy int) {
+	for _, ch := range p.Changes {
+		switch ch.Action {
+		case ChangeCreate:
+			add++
+		case ChangeUpdate:
+			change++
+		case ChangeReplace:
+			add++
+			destroy++
+		case ChangeDelete:
+			destroy++
+		}
+	}
+	return
+}
+
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "No changes. Live state matches the spec.\n"
+	}
+	var buf bytes.Buffer
+	for _, ch := range p.Changes {
+		buf.WriteString(ch.String())
+		buf.WriteString("\n")
+	}
+	add, change, destroy := p.Summary()
+	fmt.Fprintf(&buf, "\nPlan: %d to add, %d to change, %d to destroy.\n", add, change, destroy)
+	return buf.String()
+}
+
+// Apply 按顺序执行变更，遇到错误立即停止，之前的变更不会回滚
+func (p *Plan) Apply() error {
+	for _, ch := range p.Changes {
+		if err := ch.apply(p.client, p.state); err != nil {
+			return fmt.Errorf("appleapi: %s %s %q: %w", ch.Action, ch.Type, ch.Name, err)
+		}
+	}
+	return nil
+}
+
+// liveState 是计划时读取的线上状态
+type liveState struct {
+	devices      map[string]*Device // 小写 udid
+	deviceUdids  map[string]string  // id -> 小写 udid
+	bundles      map[string]*BundleId
+	capabilities map[string][]*BundleIdCapability // bundle identifier
+	certificates []Certificate
+	profiles     []Profile
+}
+
+func (c *Client) readLiveState() (*liveState, error) {
+	live := &liveState{
+		devices:      make(map[string]*Device),
+		deviceUdids:  make(map[string]string),
+		bundles:      make(map[string]*BundleId),
+		capabilities: make(map[string][]*BundleIdCapability),
+	}
+
+	devices, err := c.AllDevices(&ListDevicesQuery{Limit: MaxLimit})
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		d := &devices[i]
+		udid := strings.ToLower(d.Attributes.Udid)
+		live.devices[udid] = d
+		live.deviceUdids[d.Id] = udid
+	}
+
+	bundles, included, err := c.AllBundleIds(&ListBundlesQuery{
+		Include:           []string{TypeBundleIdCapabilities},
+		Limit:             MaxLimit,
+		LimitCapabilities: MaxRelatedLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range bundles {
+		b := &bundles[i]
+		rel := b.Relationships.BundleIdCapabilities
+		live.bundles[b.Attributes.Identifier] = b
+		if rel.Meta.Paging.Total <= len(rel.Data) {
+			live.capabilities[b.Attributes.Identifier] = included.BundleIdCapabilities(rel.Data)
+			continue
+		}
+		// 超过 limit[bundleIdCapabilities] 时读取全部能力
+		list, err := c.AllBundleIdCapabilities(b.Id)
+		if err != nil {
+			return nil, err
+		}
+		capabilities := make([]*BundleIdCapability, len(list))
+		for j := range list {
+			capabilities[j] = &list[j]
+		}
+		live.capabilities[b.Attributes.Identifier] = capabilities
+	}
+
+	if live.certificates, err = c.AllCertificates(&ListCertificatesQuery{Limit: MaxLimit}); err != nil {
+		return nil, err
+	}
+
+	live.profiles, _, err = c.AllProfiles(&ListProfilesQuery{
+		Include:           []string{"bundleId", "certificates", "devices"},
+		Limit:             MaxLimit,
+		LimitCertificates: MaxRelatedLimit,
+		LimitDevices:      MaxRelatedLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 超过 limit[...] 的关系只对 spec 管理的描述文件读取, 见 Plan
+	return live, nil
+}
+
+// Plan 读取线上的设备、bundle ID、证书和描述文件，返回使其与 spec 一致所需的变更。
+// 证书不由 Plan 创建，描述文件引用的证书必须已经存在
+func (c *Client) Plan(spec *Spec) (*Plan, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	live, err := c.readLiveState()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		client: c,
+		state: &applyState{
+			bundles: make(map[string]string),
+			devices: make(map[string]string),
+		},
+	}
+	for identifier, b := range live.bundles {
+		plan.state.bundles[identifier] = b.Id
+	}
+	for udid, d := range live.devices {
+		plan.state.devices[udid] = d.Id
+	}
+
+	var profileChanges, pruneChanges []*Change
+	wanted := spec.devices()
+
+	// 设备
+	udids := make([]string, 0, len(wanted))
+	for udid := range wanted {
+		udids = append(udids, udid)
+	}
+	sort.Strings(udids)
+	for _, udid := range udids {
+		if ch := planDevice(wanted[udid], live.devices[udid]); ch != nil {
+			plan.Changes = append(plan.Changes, ch)
+		}
+	}
+	if spec.Prune {
+		for _, d := range sortedDevices(live.devices) {
+			if _, ok := wanted[strings.ToLower(d.Attributes.Udid)]; !ok && d.Attributes.Status != "DISABLED" {
+				pruneChanges = append(pruneChanges, disableDevice(d))
+			}
+		}
+	}
+
+	// bundle ID 和能力
+	var capabilityChanges []*Change
+	inSpec := make(map[string]bool)
+	for _, b := range spec.BundleIds {
+		inSpec[b.Identifier] = true
+		if ch := planBundleId(b, live.bundles[b.Identifier]); ch != nil {
+			plan.Changes = append(plan.Changes, ch)
+		}
+		capabilityChanges = append(capabilityChanges, planCapabilities(b, live.capabilities[b.Identifier], spec.Prune)...)
+	}
+	plan.Changes = append(plan.Changes, capabilityChanges...)
+
+	// 描述文件
+	liveProfiles := make(map[string]*Profile)
+	duplicates := make(map[string][]string) // 名称 -> id
+	for i := range live.profiles {
+		p := &live.profiles[i]
+		if other, ok := liveProfiles[p.Attributes.Name]; ok {
+			if len(duplicates[p.Attributes.Name]) == 0 {
+				duplicates[p.Attributes.Name] = []string{other.Id}
+			}
+			duplicates[p.Attributes.Name] = append(duplicates[p.Attributes.Name], p.Id)
+			continue
+		}
+		liveProfiles[p.Attributes.Name] = p
+	}
+	managed := make(map[string]bool)
+	for _, b := range spec.BundleIds {
+		for _, ps := range b.Profiles {
+			name := ps.ProfileName(b.Identifier)
+			managed[name] = true
+			if ids := duplicates[name]; len(ids) > 0 {
+				// 无法确定哪一个是 spec 管理的描述文件
+				return nil, fmt.Errorf("appleapi: profile %s: %d live profiles have this name: %s", name, len(ids), strings.Join(ids, ", "))
+			}
+			if p := liveProfiles[name]; p != nil {
+				if err := c.completeProfile(p); err != nil {
+					return nil, err
+				}
+			}
+			ch, err := planProfile(spec, b, ps, liveProfiles[name], live)
+			if err != nil {
+				return nil, err
+			}
+			if ch != nil {
+				profileChanges = append(profileChanges, ch)
+			}
+		}
+	}
+	if spec.Prune {
+		var deletes []*Change
+		for _, p := range live.profiles {
+			// Xcode 自动管理的描述文件由 Xcode 重新生成, 不删除
+			if !managed[p.Attributes.Name] && !p.IsXcodeManaged() {
+				deletes = append(deletes, deleteProfile(p.Id, p.Attributes.Name))
+			}
+		}
+		profileChanges = append(deletes, profileChanges...)
+	}
+	plan.Changes = append(plan.Changes, profileChanges...)
+
+	plan.Changes = append(plan.Changes, pruneChanges...)
+	if spec.Prune {
+		identifiers := make([]string, 0, len(live.bundles))
+		for identifier := range live.bundles {
+			if !inSpec[identifier] {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+		sort.Strings(identifiers)
+		for _, identifier := range identifiers {
+			plan.Changes = append(plan.Changes, deleteBundleId(live.bundles[identifier]))
+		}
+	}
+	return plan, nil
+}
+
+func sortedDevices(devices map[string]*Device) []*Device {
+	list := make([]*Device, 0, len(devices))
+	for _, d := range devices {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Attributes.Udid < list[j].Attributes.Udid })
+	return list
+}
+
+func planDevice(want DeviceSpec, d *Device) *Change {
+	udid := strings.ToLower(want.Udid)
+	if d == nil {
+		return &Change{
+			Action: ChangeCreate,
+			Type:   TypeDevices,
+			Name:   want.Name,
+			Details: []string{
+				fmt.Sprintf("udid: %q", want.Udid),
+			},
+			apply: func(c *Client, st *applyState) error {
+				b, err := c.Devices.DeviceCreate(want.Udid, want.Name)
+				if err != nil {
+					return err
+				}
+				resp := &DeviceResponse{}
+				if err = json.Unmarshal(b, resp); err != nil {
+					return err
+				}
+				st.devices[udid] = resp.Data.Id
+				return nil
+			},
+		}
+	}
+
+	var details []string
+	if d.Attributes.Name != want.Name {
+		details = append(details, fmt.Sprintf("name: %q => %q", d.Attributes.Name, want.Name))
+	}
+	if d.Attributes.Status == "DISABLED" {
+		details = append(details, `status: "DISABLED" => "ENABLED"`)
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	id := d.Id
+	return &Change{
+		Action:  ChangeUpdate,
+		Type:    TypeDevices,
+		Name:    want.Name,
+		Id:      id,
+		Details: details,
+		apply: func(c *Client, st *applyState) error {
+			_, err := c.Devices.DeviceUpdate(id, want.Name, "ENABLED")
+			return err
+		},
+	}
+}
+
+// 设备不能删除，只能禁用
+func disableDevice(d *Device) *Change {
+	id := d.Id
+	return &Change{
+		Action:  ChangeUpdate,
+		Type:    TypeDevices,
+		Name:    d.Attributes.Name,
+		Id:      id,
+		Details: []string{`status: "ENABLED" => "DISABLED"`},
+		apply: func(c *Client, st *applyState) error {
+			_, err := c.Devices.DeviceUpdate(id, "", "DISABLED")
+			return err
+		},
+	}
+}
+
+func planBundleId(want BundleIdSpec, b *BundleId) *Change {
+	if b == nil {
+		return &Change{
+			Action:  ChangeCreate,
+			Type:    TypeBundleIds,
+			Name:    want.Identifier,
+			Details: []string{fmt.Sprintf("name: %q", want.Name)},
+			apply: func(c *Client, st *applyState) error {
+				b, err := c.Bundles.BundleIdCreate(want.Identifier, want.Name)
+				if err != nil {
+					return err
+				}
+				resp := &BundleIdResponse{}
+				if err = json.Unmarshal(b, resp); err != nil {
+					return err
+				}
+				st.bundles[want.Identifier] = resp.Data.Id
+				return nil
+			},
+		}
+	}
+	if b.Attributes.Name == want.Name {
+		return nil
+	}
+	id := b.Id
+	return &Change{
+		Action:  ChangeUpdate,
+		Type:    TypeBundleIds,
+		Name:    want.Identifier,
+		Id:      id,
+		Details: []string{fmt.Sprintf("name: %q => %q", b.Attributes.Name, want.Name)},
+		apply: func(c *Client, st *applyState) error {
+			_, err := c.Bundles.BundleIdUpdate(id, want.Name)
+			return err
+		},
+	}
+}
+
+func deleteBundleId(b *BundleId) *Change {
+	id := b.Id
+	return &Change{
+		Action: ChangeDelete,
+		Type:   TypeBundleIds,
+		Name:   b.Attributes.Identifier,
+		Id:     id,
+		apply: func(c *Client, st *applyState) error {
+			return c.Bundles.BundleIdDelete(id)
+		},
+	}
+}
+
+func planCapabilities(want BundleIdSpec, live []*BundleIdCapability, prune bool) []*Change {
+	var changes []*Change
+	enabled := make(map[string]bool)
+	for _, v := range live {
+		enabled[v.Attributes.CapabilityType] = true
+	}
+	wanted := make(map[string]bool)
+	for _, capabilityType := range want.Capabilities {
+		wanted[capabilityType] = true
+		if enabled[capabilityType] {
+			continue
+		}
+		identifier, capabilityType := want.Identifier, capabilityType
+		changes = append(changes, &Change{
+			Action: ChangeCreate,
+			Type:   TypeBundleIdCapabilities,
+			Name:   identifier + " " + capabilityType,
+			apply: func(c *Client, st *applyState) error {
+				_, err := c.Bundles.CapabilityEnable(st.bundles[identifier], capabilityType, nil)
+				return err
+			},
+		})
+	}
+	if prune {
+		for _, v := range live {
+			if wanted[v.Attributes.CapabilityType] {
+				continue
+			}
+			id := v.Id
+			changes = append(changes, &Change{
+				Action: ChangeDelete,
+				Type:   TypeBundleIdCapabilities,
+				Name:   want.Identifier + " " + v.Attributes.CapabilityType,
+				Id:     id,
+				apply: func(c *Client, st *applyState) error {
+					return c.Bundles.CapabilityDisable(id)
+				},
+			})
+		}
+	}
+	return changes
+}
+
+// profileCertificates 返回描述文件应包含的证书 id
+func profileCertificates(want ProfileSpec, name string, live *liveState) ([]string, error) {
+	ids := make(map[string]bool)
+	for _, id := range want.Certificates {
+		found := false
+		for _, v := range live.certificates {
+			if v.Id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("appleapi: profile %s: certificate %s not found", name, id)
+		}
+		ids[id] = true
+	}
+	now := time.Now()
+	for _, certificateType := range want.CertificateTypes {
+		for i := range live.certificates {
+			v := &live.certificates[i]
+			if v.Attributes.CertificateType != certificateType {
+				continue
+			}
+			if t, err := v.Expiration(); err == nil && t.Before(now) {
+				continue
+			}
+			ids[v.Id] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("appleapi: profile %s: no valid certificate of type %s", name, strings.Join(want.CertificateTypes, ", "))
+	}
+	return sortedKeys(ids), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func planProfile(spec *Spec, bundle BundleIdSpec, want ProfileSpec, p *Profile, live *liveState) (*Change, error) {
+	name := want.ProfileName(bundle.Identifier)
+	certificates, err := profileCertificates(want, name, live)
+	if err != nil {
+		return nil, err
+	}
+	udidSet := make(map[string]bool)
+	for _, g := range want.DeviceGroups {
+		for _, d := range spec.DeviceGroups[g] {
+			udidSet[strings.ToLower(d.Udid)] = true
+		}
+	}
+	udids := sortedKeys(udidSet)
+
+	// resolve 返回 bundle ID 和设备的 id, 替换时在删除前调用
+	resolve := func(st *applyState) (string, []string, error) {
+		devices := make([]string, len(udids))
+		for i, udid := range udids {
+			id, ok := st.devices[udid]
+			if !ok {
+				return "", nil, fmt.Errorf("device %s is not registered", udid)
+			}
+			devices[i] = id
+		}
+		bundleId, ok := st.bundles[bundle.Identifier]
+		if !ok {
+			return "", nil, fmt.Errorf("bundle ID %s is not registered", bundle.Identifier)
+		}
+		return bundleId, devices, nil
+	}
+	create := func(c *Client, st *applyState) error {
+		bundleId, devices, err := resolve(st)
+		if err != nil {
+			return err
+		}
+		_, err = c.Profiles.ProfileCreateWithType(name, want.Type, bundleId, certificates, devices)
+		return err
+	}
+
+	if p == nil {
+		return &Change{
+			Action: ChangeCreate,
+			Type:   TypeProfiles,
+			Name:   name,
+			Details: []string{
+				fmt.Sprintf("type: %q", want.Type),
+				fmt.Sprintf("certificates: %v", certificates),
+				fmt.Sprintf("devices: %v", udids),
+			},
+			apply: create,
+		}, nil
+	}
+
+	var details []string
+	a := &p.Attributes
+	if a.ProfileType != want.Type {
+		details = append(details, fmt.Sprintf("type: %q => %q", a.ProfileType, want.Type))
+	}
+	liveBundle := p.Relationships.BundleId.Data.Id
+	if b := live.bundles[bundle.Identifier]; b == nil || b.Id != liveBundle {
+		details = append(details, fmt.Sprintf("bundleId: %q => %q", liveBundle, bundle.Identifier))
+	}
+	if a.ProfileState == "INVALID" {
+		details = append(details, `profileState: "INVALID"`)
+	}
+	if t, err := p.Expiration(); err == nil && t.Before(time.Now()) {
+		details = append(details, fmt.Sprintf("expired: %s", a.ExpirationDate))
+	}
+	liveCertificates := refIds(p.Relationships.Certificates.Data)
+	sort.Strings(liveCertificates)
+	if !equalStrings(liveCertificates, certificates) {
+		details = append(details, fmt.Sprintf("certificates: %v => %v", liveCertificates, certificates))
+	}
+	liveUdids := make([]string, 0, len(p.Relationships.Devices.Data))
+	for _, ref := range p.Relationships.Devices.Data {
+		if udid, ok := live.deviceUdids[ref.Id]; ok {
+			liveUdids = append(liveUdids, udid)
+		} else {
+			liveUdids = append(liveUdids, ref.Id)
+		}
+	}
+	sort.Strings(liveUdids)
+	if !equalStrings(liveUdids, udids) {
+		details = append(details, fmt.Sprintf("devices: %v => %v", liveUdids, udids))
+	}
+	if len(details) == 0 {
+		return nil, nil
+	}
+
+	// 描述文件不能修改，只能删除后重新创建
+	id := p.Id
+	return &Change{
+		Action:  ChangeReplace,
+		Type:    TypeProfiles,
+		Name:    name,
+		Id:      id,
+		Details: details,
+		apply: func(c *Client, st *applyState) error {
+			bundleId, devices, err := resolve(st)
+			if err != nil {
+				return err
+			}
+			_, err = c.recreateProfile(&ProfileRecreateError{
+				DeletedId:    id,
+				Name:         name,
+				ProfileType:  want.Type,
+				BundleId:     bundleId,
+				Certificates: certificates,
+				Devices:      devices,
+			})
+			return err
+		},
+	}, nil
+}
+
+func deleteProfile(id, name string) *Change {
+	return &Change{
+		Action: ChangeDelete,
+		Type:   TypeProfiles,
+		Name:   name,
+		Id:     id,
+		apply: func(c *Client, st *applyState) error {
+			return c.Profiles.ProfileDelete(id)
+		},
+	}
+}
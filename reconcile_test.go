@@ -0,0 +1,412 @@
+package appleapi_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+// seedProfiles 在 srv 中创建 n 台设备和使用全部设备的两个 ad hoc 描述文件
+func seedProfiles(t *testing.T, srv *appleapitest.Server, n int) (devices []appleapi.DeviceSpec, managed, unmanaged *appleapi.Profile) {
+	t.Helper()
+	bundle := srv.AddBundleId(appleapi.BundleId{})
+	bundle.Attributes.Identifier = "com.example.app"
+	bundle.Attributes.Name = "Example"
+	srv.AddBundleId(*bundle)
+	cert := appleapi.Certificate{}
+	cert.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	cert.Attributes.ExpirationDate = time.Now().AddDate(1, 0, 0).UTC().Format(appleapi.DateLayout)
+	certId := srv.AddCertificate(cert).Id
+
+	ids := make([]string, n)
+	for i := range ids {
+		d := appleapi.Device{}
+		d.Attributes.Udid = fmt.Sprintf("00008020-%016X", i)
+		d.Attributes.Name = fmt.Sprintf("iPhone %d", i)
+		d.Attributes.Platform = appleapi.PlatformIos
+		ids[i] = srv.AddDevice(d).Id
+		devices = append(devices, appleapi.DeviceSpec{Udid: d.Attributes.Udid, Name: d.Attributes.Name})
+	}
+	add := func(name string) *appleapi.Profile {
+		p := appleapi.Profile{}
+		p.Attributes.Name = name
+		p.Attributes.ProfileType = "IOS_APP_ADHOC"
+		p.Attributes.ExpirationDate = time.Now().AddDate(1, 0, 0).UTC().Format(appleapi.DateLayout)
+		p.Relationships.BundleId.Data = bundle.Ref()
+		p.Relationships.Certificates.Data = []appleapi.TypeId{{Id: certId, Type: appleapi.TypeCertificates}}
+		for _, id := range ids {
+			p.Relationships.Devices.Data = append(p.Relationships.Devices.Data, appleapi.TypeId{Id: id, Type: appleapi.TypeDevices})
+		}
+		return srv.AddProfile(p)
+	}
+	return devices, add("com.example.app IOS_APP_ADHOC"), add("unmanaged")
+}
+
+func TestPlanReadsRelationshipsPastTheLimit(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	devices, managed, _ := seedProfiles(t, srv, appleapi.MaxRelatedLimit+10)
+	c := appleapitest.NewMockClient(srv)
+
+	spec := &appleapi.Spec{
+		BundleIds: []appleapi.BundleIdSpec{{
+			Identifier: "com.example.app",
+			Name:       "Example",
+			Profiles: []appleapi.ProfileSpec{{
+				Type:             "IOS_APP_ADHOC",
+				CertificateTypes: []string{"IOS_DISTRIBUTION"},
+				DeviceGroups:     []string{"qa"},
+			}},
+		}},
+		DeviceGroups: map[string][]appleapi.DeviceSpec{"qa": devices},
+	}
+	plan, err := c.Plan(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Errorf("plan for an up to date profile with %d devices:\n%s", len(devices), plan)
+	}
+	// 只有 spec 管理的描述文件需要读取完整的设备
+	calls := c.Profiles.(*appleapitest.ProfileServiceMock).CallsTo("ProfileDevices")
+	if len(calls) != 1 || calls[0].Args[0] != managed.Id {
+		t.Errorf("ProfileDevices calls = %+v, want one for %s", calls, managed.Id)
+	}
+
+	spec.DeviceGroups["qa"] = devices[:len(devices)-1]
+	if plan, err = c.Plan(spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != appleapi.ChangeReplace || !strings.Contains(plan.String(), "devices:") {
+		t.Errorf("plan after removing a device:\n%s", plan)
+	}
+}
+
+func TestProfileWithRelationshipsPages(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	_, managed, _ := seedProfiles(t, srv, 2*appleapi.MaxLimit+1)
+	c := appleapi.NewClient(srv.Token())
+
+	p, err := c.ProfileWithRelationships(managed.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Relationships.Devices.Data); n != 2*appleapi.MaxLimit+1 {
+		t.Errorf("profile has %d devices, want %d", n, 2*appleapi.MaxLimit+1)
+	}
+	if n := len(p.Relationships.Certificates.Data); n != 1 {
+		t.Errorf("profile has %d certificates, want 1", n)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	group := map[string][]appleapi.DeviceSpec{"qa": {{Udid: "00008020-000A4C2E1A88002E", Name: "iPhone"}}}
+	profile := appleapi.ProfileSpec{Type: "IOS_APP_ADHOC", CertificateTypes: []string{"IOS_DISTRIBUTION"}, DeviceGroups: []string{"qa"}}
+	tests := []struct {
+		name string
+		spec appleapi.Spec
+		want string // 为空表示有效
+	}{
+		{"valid", appleapi.Spec{
+			BundleIds:    []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "Example", Profiles: []appleapi.ProfileSpec{profile}}},
+			DeviceGroups: group,
+		}, ""},
+		{"missing udid", appleapi.Spec{
+			DeviceGroups: map[string][]appleapi.DeviceSpec{"qa": {{Name: "iPhone"}}},
+		}, "udid is required"},
+		{"device with two names", appleapi.Spec{
+			DeviceGroups: map[string][]appleapi.DeviceSpec{
+				"qa":  {{Udid: "00008020-000A4C2E1A88002E", Name: "iPhone"}},
+				"dev": {{Udid: "00008020-000a4c2e1a88002e", Name: "Other"}},
+			},
+		}, "has names"},
+		{"missing name", appleapi.Spec{
+			BundleIds: []appleapi.BundleIdSpec{{Identifier: "com.example.app"}},
+		}, "identifier and name are required"},
+		{"duplicate bundle ID", appleapi.Spec{
+			BundleIds: []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "A"}, {Identifier: "com.example.app", Name: "B"}},
+		}, "duplicate bundle ID"},
+		{"missing profile type", appleapi.Spec{
+			BundleIds: []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "Example", Profiles: []appleapi.ProfileSpec{{CertificateTypes: []string{"IOS_DISTRIBUTION"}}}}},
+		}, "profile type is required"},
+		{"no certificates", appleapi.Spec{
+			BundleIds: []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "Example", Profiles: []appleapi.ProfileSpec{{Type: "IOS_APP_STORE"}}}},
+		}, "certificates or certificateTypes is required"},
+		{"duplicate profile", appleapi.Spec{
+			BundleIds:    []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "Example", Profiles: []appleapi.ProfileSpec{profile, profile}}},
+			DeviceGroups: group,
+		}, "duplicate profile com.example.app IOS_APP_ADHOC"},
+		{"unknown device group", appleapi.Spec{
+			BundleIds: []appleapi.BundleIdSpec{{Identifier: "com.example.app", Name: "Example", Profiles: []appleapi.ProfileSpec{profile}}},
+		}, "unknown device group qa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"spec.yaml": `
+prune: true
+deviceGroups:
+  qa:
+    - udid: 00008020-000A4C2E1A88002E
+      name: iPhone
+bundleIds:
+  - identifier: com.example.app
+    name: Example
+    capabilities: [PUSH_NOTIFICATIONS]
+    profiles:
+      - type: IOS_APP_ADHOC
+        certificateTypes: [IOS_DISTRIBUTION]
+        deviceGroups: [qa]
+`,
+		"spec.json": `{"prune":true,"deviceGroups":{"qa":[{"udid":"00008020-000A4C2E1A88002E","name":"iPhone"}]},
+"bundleIds":[{"identifier":"com.example.app","name":"Example","capabilities":["PUSH_NOTIFICATIONS"],
+"profiles":[{"type":"IOS_APP_ADHOC","certificateTypes":["IOS_DISTRIBUTION"],"deviceGroups":["qa"]}]}]}`,
+		"unknown.yaml": "bundleIds: []\nprofiles: []\n",
+		"invalid.yaml": "bundleIds:\n  - identifier: com.example.app\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"spec.yaml", "spec.json"} {
+		spec, err := appleapi.LoadSpec(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !spec.Prune || len(spec.BundleIds) != 1 || len(spec.DeviceGroups["qa"]) != 1 {
+			t.Fatalf("%s: %+v", name, spec)
+		}
+		b := spec.BundleIds[0]
+		if b.Identifier != "com.example.app" || len(b.Capabilities) != 1 || len(b.Profiles) != 1 || b.Profiles[0].ProfileName(b.Identifier) != "com.example.app IOS_APP_ADHOC" {
+			t.Errorf("%s: bundle ID %+v", name, b)
+		}
+	}
+	if _, err = appleapi.LoadSpec(filepath.Join(dir, "unknown.yaml")); err == nil {
+		t.Error("unknown YAML field was accepted")
+	}
+	if _, err = appleapi.LoadSpec(filepath.Join(dir, "invalid.yaml")); err == nil || !strings.Contains(err.Error(), "identifier and name are required") {
+		t.Errorf("invalid spec: %v", err)
+	}
+	if _, err = appleapi.LoadSpec(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file was accepted")
+	}
+}
+
+// adhocSpec 返回一个包含 com.example.app 和一个 ad hoc 描述文件的 spec
+func adhocSpec(devices []appleapi.DeviceSpec) *appleapi.Spec {
+	return &appleapi.Spec{
+		BundleIds: []appleapi.BundleIdSpec{{
+			Identifier:   "com.example.app",
+			Name:         "Example",
+			Capabilities: []string{"PUSH_NOTIFICATIONS"},
+			Profiles: []appleapi.ProfileSpec{{
+				Type:             "IOS_APP_ADHOC",
+				CertificateTypes: []string{"IOS_DISTRIBUTION"},
+				DeviceGroups:     []string{"qa"},
+			}},
+		}},
+		DeviceGroups: map[string][]appleapi.DeviceSpec{"qa": devices},
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	cert := appleapi.Certificate{}
+	cert.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	cert.Attributes.ExpirationDate = time.Now().AddDate(1, 0, 0).UTC().Format(appleapi.DateLayout)
+	srv.AddCertificate(cert)
+	c := appleapi.NewClient(srv.Token())
+
+	spec := adhocSpec([]appleapi.DeviceSpec{
+		{Udid: "00008020-000A4C2E1A88002E", Name: "iPhone"},
+		{Udid: "00008030-001C25E20E38802E", Name: "iPad"},
+	})
+	plan, err := c.Plan(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ch := range plan.Changes {
+		got = append(got, string(ch.Action)+" "+ch.Type+" "+ch.Name)
+	}
+	want := []string{
+		"create devices iPhone",
+		"create devices iPad",
+		"create bundleIds com.example.app",
+		"create bundleIdCapabilities com.example.app PUSH_NOTIFICATIONS",
+		"create profiles com.example.app IOS_APP_ADHOC",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if add, change, destroy := plan.Summary(); add != 5 || change != 0 || destroy != 0 {
+		t.Errorf("Summary() = %d, %d, %d", add, change, destroy)
+	}
+	if err = plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	if plan, err = c.Plan(spec); err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Fatalf("plan after apply:\n%s", plan)
+	}
+	profiles, _, err := c.AllProfiles(&appleapi.ListProfilesQuery{Include: []string{"devices"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 || len(profiles[0].Relationships.Devices.Data) != 2 {
+		t.Fatalf("profiles after apply: %+v", profiles)
+	}
+
+	// 改名的设备更新, 新设备使描述文件重新生成
+	spec.DeviceGroups["qa"][0].Name = "iPhone XS"
+	spec.DeviceGroups["qa"] = append(spec.DeviceGroups["qa"], appleapi.DeviceSpec{Udid: "00008101-000A1D2E3F40001E", Name: "iPhone 12"})
+	if plan, err = c.Plan(spec); err != nil {
+		t.Fatal(err)
+	}
+	if add, change, destroy := plan.Summary(); add != 2 || change != 1 || destroy != 1 {
+		t.Errorf("Summary() = %d, %d, %d, want 2, 1, 1:\n%s", add, change, destroy, plan)
+	}
+	if err = plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = c.Plan(spec); err != nil || !plan.Empty() {
+		t.Fatalf("plan after second apply: %v\n%s", err, plan)
+	}
+}
+
+func TestPlanPrune(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	devices, managed, unmanaged := seedProfiles(t, srv, 2)
+	spec := adhocSpec(devices[:1])
+	spec.BundleIds[0].Capabilities = nil
+	spec.Prune = true
+
+	bundle := managed.Relationships.BundleId.Data
+	xcode := appleapi.Profile{}
+	xcode.Attributes.Name = "iOS Team Provisioning Profile: com.example.app"
+	xcode.Attributes.ProfileType = "IOS_APP_DEVELOPMENT"
+	xcode.Relationships.BundleId.Data = bundle
+	xcode.Relationships.Certificates.Data = managed.Relationships.Certificates.Data
+	xcodeId := srv.AddProfile(xcode).Id
+	other := appleapi.BundleId{}
+	other.Attributes.Identifier = "com.example.other"
+	other.Attributes.Name = "Other"
+	otherId := srv.AddBundleId(other).Id
+	c := appleapi.NewClient(srv.Token())
+	if _, err := c.Bundles.CapabilityEnable(bundle.Id, "ICLOUD", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := c.Plan(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := plan.String()
+	for _, want := range []string{
+		fmt.Sprintf(`- profiles "unmanaged" (%s)`, unmanaged.Id),
+		`- bundleIdCapabilities "com.example.app ICLOUD"`,
+		fmt.Sprintf(`- bundleIds "com.example.other" (%s)`, otherId),
+		`~ devices "iPhone 1"`,
+		`status: "ENABLED" => "DISABLED"`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("plan does not contain %q:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, xcodeId) {
+		t.Errorf("plan deletes the Xcode managed profile:\n%s", s)
+	}
+	if err = plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.Resource(unmanaged.Ref()) != nil {
+		t.Error("unmanaged profile was not deleted")
+	}
+	if srv.Resource(appleapi.TypeId{Id: xcodeId, Type: appleapi.TypeProfiles}) == nil {
+		t.Error("Xcode managed profile was deleted")
+	}
+	if srv.Resource(appleapi.TypeId{Id: otherId, Type: appleapi.TypeBundleIds}) != nil {
+		t.Error("bundle ID that is not in the spec was not deleted")
+	}
+	if plan, err = c.Plan(spec); err != nil || !plan.Empty() {
+		t.Fatalf("plan after prune: %v\n%s", err, plan)
+	}
+}
+
+func TestPlanRejectsDuplicateLiveProfiles(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	devices, managed, _ := seedProfiles(t, srv, 1)
+	dup := *managed
+	dup.Id = ""
+	dupId := srv.AddProfile(dup).Id
+	c := appleapi.NewClient(srv.Token())
+
+	_, err := c.Plan(adhocSpec(devices))
+	if err == nil || !strings.Contains(err.Error(), managed.Id) || !strings.Contains(err.Error(), dupId) {
+		t.Errorf("Plan() = %v, want an error naming %s and %s", err, managed.Id, dupId)
+	}
+}
+
+func TestPlanPagesCapabilities(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	devices, managed, _ := seedProfiles(t, srv, 1)
+	c := appleapitest.NewMockClient(srv)
+	spec := adhocSpec(devices)
+	spec.BundleIds[0].Capabilities = nil
+	for i := 0; i < appleapi.MaxRelatedLimit+5; i++ {
+		capabilityType := fmt.Sprintf("CAPABILITY_%02d", i)
+		if _, err := c.Bundles.CapabilityEnable(managed.Relationships.BundleId.Data.Id, capabilityType, nil); err != nil {
+			t.Fatal(err)
+		}
+		spec.BundleIds[0].Capabilities = append(spec.BundleIds[0].Capabilities, capabilityType)
+	}
+
+	plan, err := c.Plan(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Errorf("plan for %d enabled capabilities:\n%s", len(spec.BundleIds[0].Capabilities), plan)
+	}
+	if calls := c.Bundles.(*appleapitest.BundleIDServiceMock).CallsTo("BundleIdCapabilities"); len(calls) != 1 {
+		t.Errorf("BundleIdCapabilities calls = %+v, want 1", calls)
+	}
+}
@@ -0,0 +1,91 @@
+package appleapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DoContext is Do with a context. A failed request is retried up to
+// MaxRetries times, every retry is reported to the RetryHook hooks such as
+// the retry counter of Metrics.
+func (t *Token) DoContext(ctx context.Context, method, url string, reqJson []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, respBody, err := t.send(ctx, method, url, reqJson)
+		if attempt >= t.MaxRetries || !retryable(method, resp, err) || ctx.Err() != nil {
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return nil, newApiError(resp, respBody)
+			}
+			return respBody, nil
+		}
+		wait := t.retryWait(attempt, resp)
+		for _, h := range t.Hooks {
+			if r, ok := h.(RetryHook); ok {
+				r.OnRetry(method, url, attempt+1, wait)
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether a failed request can be sent again. Idempotent
+// methods are retried after a 429, a 5xx or a transport error. POST and
+// PATCH are retried only when Apple did not process the request: after a
+// 429, or when the connection could not be made. A create that timed out
+// may have succeeded, sending it again would create a duplicate. Errors
+// that happen before sending, such as an invalid AuthKey, are never retried.
+func retryable(method string, resp *http.Response, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodPut:
+		if err != nil {
+			return isTransportError(err)
+		}
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	}
+	if err != nil {
+		return isDialError(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests
+}
+
+// isTransportError reports whether err was returned by the http.Client or
+// while reading the response body, including timeouts.
+func isTransportError(err error) bool {
+	var ue *url.Error
+	var ne net.Error
+	return errors.As(err, &ue) || errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isDialError reports whether err happened while connecting, before any
+// byte of the request was sent.
+func isDialError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// retryWait returns the Retry-After header, or RetryWait doubled on every
+// attempt.
+func (t *Token) retryWait(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	wait := t.RetryWait
+	if wait <= 0 {
+		wait = time.Second
+	}
+	return wait << uint(attempt)
+}
@@ -0,0 +1,203 @@
+package appleapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RotateOptions struct {
+	CertificateId string // 要替换的证书 id
+	CommonName    string // 新证书签名请求的 CN, 默认旧证书的名称
+	Revoke        bool   // 描述文件更新后吊销旧证书
+	DryRun        bool   // 只返回将要执行的步骤
+	OutDir        string // 保存 <证书 id>.p12 和 <描述文件名称>.mobileprovision, 为空时不保存
+	P12Password   string
+	Store         *Store // 不为 nil 时同时保存到 Store, OutDir 和 Store 至少需要一个
+}
+
+type RotateResult struct {
+	Steps       []string // 已执行的步骤, DryRun 时为将要执行的步骤
+	Certificate *Certificate
+	Profiles    []Profile
+	Files       []string
+	Quarantined []string // Revoke 后移到 Store 的 quarantine 目录的旧证书文件
+}
+
+func (r *RotateResult) step(format string, args ...interface{}) {
+	r.Steps = append(r.Steps, fmt.Sprintf(format, args...))
+}
+
+// profileFileName 把描述文件名称转换为文件名
+func profileFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, name) + ".mobileprovision"
+}
+
+// RotateCertificate 用新的私钥和证书替换一个证书:
+// 生成私钥和签名请求，创建同类型的证书，重新生成所有引用旧证书的描述文件，
+// 然后按需要吊销旧证书。出错时返回已完成的部分
+func (c *Client) RotateCertificate(opt RotateOptions) (*RotateResult, error) {
+	result := &RotateResult{}
+	if !opt.DryRun && opt.OutDir == "" && opt.Store == nil {
+		// 新私钥只在本地生成, 不保存就无法使用新证书
+		return result, fmt.Errorf("appleapi: rotate %s: OutDir or Store is required to keep the new private key", opt.CertificateId)
+	}
+	b, err := c.Certificates.ReadCertificate(opt.CertificateId)
+	if err != nil {
+		return result, err
+	}
+	resp := &CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return result, err
+	}
+	old := &resp.Data
+	certificateType := old.Attributes.CertificateType
+
+	profiles, included, err := c.AllProfiles(&ListProfilesQuery{
+		Include:           []string{"bundleId", "certificates", "devices"},
+		Limit:             MaxLimit,
+		LimitCertificates: MaxRelatedLimit,
+		LimitDevices:      MaxRelatedLimit,
+	})
+	if err != nil {
+		return result, err
+	}
+	var affected []*Profile
+	for i := range profiles {
+		p := &profiles[i]
+		// 旧证书可能在 limit[certificates] 之外
+		if err = c.CompleteProfileCertificates(p); err != nil {
+			return result, err
+		}
+		if !containsAll(p.Relationships.Certificates.Data, []string{old.Id}) {
+			continue
+		}
+		if err = c.CompleteProfileDevices(p); err != nil {
+			return result, err
+		}
+		// 保存到 Store 需要 bundle identifier, 在修改任何资源之前检查
+		if opt.Store != nil && included.BundleId(p.Relationships.BundleId.Data) == nil {
+			return result, fmt.Errorf("appleapi: rotate %s: profile %s: bundle ID %s is not included, cannot store the regenerated profile", old.Id, p.Id, p.Relationships.BundleId.Data.Id)
+		}
+		affected = append(affected, p)
+	}
+
+	commonName := opt.CommonName
+	if commonName == "" {
+		commonName = old.Attributes.Name
+	}
+	if opt.DryRun {
+		result.step("create %s certificate %q", certificateType, commonName)
+		for _, p := range affected {
+			result.step("regenerate profile %q (%s)", p.Attributes.Name, p.Id)
+		}
+		if opt.Revoke {
+			result.step("revoke certificate %s", old.Id)
+		}
+		return result, nil
+	}
+
+	key, csr, err := NewCertificateRequest(commonName, "")
+	if err != nil {
+		return result, err
+	}
+	if b, err = c.Certificates.CertificateCreate(csr, certificateType); err != nil {
+		return result, err
+	}
+	resp = &CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return result, err
+	}
+	cert := &resp.Data
+	result.Certificate = cert
+	result.step("created %s certificate %s", certificateType, cert.Id)
+
+	// 先保存私钥，后面的步骤失败时新证书仍然可用
+	cer, err := base64.StdEncoding.DecodeString(cert.Attributes.CertificateContent)
+	if err != nil {
+		return result, err
+	}
+	if opt.OutDir != "" {
+		if err = os.MkdirAll(opt.OutDir, 0755); err != nil {
+			return result, err
+		}
+		p12, err := EncodeP12(key, cer, opt.P12Password)
+		if err != nil {
+			return result, err
+		}
+		file := filepath.Join(opt.OutDir, cert.Id+".p12")
+		if err = ioutil.WriteFile(file, p12, 0600); err != nil {
+			return result, err
+		}
+		result.Files = append(result.Files, file)
+	}
+	if opt.Store != nil {
+		if err = opt.Store.PutCertificate(cert, key); err != nil {
+			return result, err
+		}
+	}
+
+	for _, p := range affected {
+		certificates := []string{cert.Id}
+		for _, ref := range p.Relationships.Certificates.Data {
+			if ref.Id != old.Id {
+				certificates = append(certificates, ref.Id)
+			}
+		}
+		b, err := c.RegenerateProfile(p.Id, certificates, refIds(p.Relationships.Devices.Data))
+		if err != nil {
+			return result, err
+		}
+		presp := &ProfileResponse{}
+		if err = json.Unmarshal(b, presp); err != nil {
+			return result, err
+		}
+		np := &presp.Data
+		result.Profiles = append(result.Profiles, *np)
+		result.step("regenerated profile %q: %s => %s", np.Attributes.Name, p.Id, np.Id)
+
+		if opt.OutDir != "" {
+			file := filepath.Join(opt.OutDir, profileFileName(np.Attributes.Name))
+			if err = np.SaveProfileContent(file); err != nil {
+				return result, err
+			}
+			result.Files = append(result.Files, file)
+		}
+		if opt.Store != nil {
+			bundle := included.BundleId(p.Relationships.BundleId.Data)
+			if err = opt.Store.PutProfile(np, bundle.Attributes.Identifier); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if opt.Revoke {
+		if err = c.Certificates.CertificateRevoke(old.Id); err != nil {
+			return result, err
+		}
+		result.step("revoked certificate %s", old.Id)
+		if opt.Store != nil {
+			// 与 Sync 相同, 私钥不能恢复, 只移到 quarantine 目录
+			for _, name := range []string{CertificatePath(certificateType, old.Id), PrivateKeyPath(certificateType, old.Id)} {
+				if !opt.Store.Exists(name) {
+					continue
+				}
+				if err = opt.Store.Quarantine(name); err != nil {
+					return result, err
+				}
+				result.Quarantined = append(result.Quarantined, name)
+			}
+		}
+	}
+	return result, nil
+}
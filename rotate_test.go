@@ -0,0 +1,275 @@
+package appleapi_test
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestRotateCertificateKeepsKey(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	old := appleapi.Certificate{}
+	old.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	old.Attributes.Name = "Example Distribution"
+	old.Attributes.ExpirationDate = time.Now().AddDate(0, 1, 0).UTC().Format(appleapi.DateLayout)
+	id := srv.AddCertificate(old).Id
+	c := appleapi.NewClient(srv.Token())
+
+	// 没有保存私钥的地方时不能创建证书
+	if _, err := c.RotateCertificate(appleapi.RotateOptions{CertificateId: id}); err == nil {
+		t.Fatal("rotate without OutDir and Store succeeded")
+	}
+	if n := srv.Requests(); n != 0 {
+		t.Errorf("rejected rotate sent %d requests", n)
+	}
+	result, err := c.RotateCertificate(appleapi.RotateOptions{CertificateId: id, DryRun: true})
+	if err != nil || len(result.Steps) == 0 {
+		t.Fatalf("dry run: %v, %v", result.Steps, err)
+	}
+
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := appleapi.NewStore(dir, "secret")
+	if result, err = c.RotateCertificate(appleapi.RotateOptions{CertificateId: id, Store: s}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Certificate("IOS_DISTRIBUTION", result.Certificate.Id); err != nil {
+		t.Errorf("new certificate is not in the store: %v", err)
+	}
+}
+
+// rotateFixture 创建一个证书 (私钥保存在 s), 引用它的两个描述文件和一个无关的描述文件
+func rotateFixture(t *testing.T, srv *appleapitest.Server, s *appleapi.Store) (old *appleapi.Certificate, profiles []*appleapi.Profile, other *appleapi.Profile) {
+	t.Helper()
+	c := appleapi.NewClient(srv.Token())
+	key, csr, err := appleapi.NewCertificateRequest("Example Distribution", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Certificates.CertificateCreate(csr, "IOS_DISTRIBUTION")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &appleapi.CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err)
+	}
+	old = &resp.Data
+	if err = s.PutCertificate(old, key); err != nil {
+		t.Fatal(err)
+	}
+	otherCert := appleapi.Certificate{}
+	otherCert.Attributes.CertificateType = "IOS_DISTRIBUTION"
+	otherCert.Attributes.ExpirationDate = time.Now().AddDate(1, 0, 0).UTC().Format(appleapi.DateLayout)
+	otherCertId := srv.AddCertificate(otherCert).Id
+
+	bundle := appleapi.BundleId{}
+	bundle.Attributes.Identifier = "com.example.app"
+	bundle.Attributes.Name = "Example"
+	bundleRef := srv.AddBundleId(bundle).Ref()
+	d := appleapi.Device{}
+	d.Attributes.Udid = "00008020-000A4C2E1A88002E"
+	d.Attributes.Name = "iPhone"
+	deviceRef := srv.AddDevice(d).Ref()
+
+	add := func(name, profileType string, certs ...string) *appleapi.Profile {
+		p := appleapi.Profile{}
+		p.Attributes.Name = name
+		p.Attributes.ProfileType = profileType
+		p.Relationships.BundleId.Data = bundleRef
+		for _, id := range certs {
+			p.Relationships.Certificates.Data = append(p.Relationships.Certificates.Data, appleapi.TypeId{Id: id, Type: appleapi.TypeCertificates})
+		}
+		if profileType == "IOS_APP_ADHOC" {
+			p.Relationships.Devices.Data = []appleapi.TypeId{deviceRef}
+		}
+		return srv.AddProfile(p)
+	}
+	profiles = []*appleapi.Profile{
+		add("Example App Store", "IOS_APP_STORE", old.Id),
+		add("Example Ad Hoc", "IOS_APP_ADHOC", old.Id, otherCertId),
+	}
+	other = add("Other App Store", "IOS_APP_STORE", otherCertId)
+	return old, profiles, other
+}
+
+func TestRotateCertificate(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := appleapi.NewStore(filepath.Join(dir, "store"), "secret")
+	old, profiles, other := rotateFixture(t, srv, s)
+	c := appleapi.NewClient(srv.Token())
+
+	result, err := c.RotateCertificate(appleapi.RotateOptions{CertificateId: old.Id, Revoke: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSteps := []string{
+		fmt.Sprintf("create IOS_DISTRIBUTION certificate %q", old.Attributes.Name),
+		fmt.Sprintf(`regenerate profile "Example App Store" (%s)`, profiles[0].Id),
+		fmt.Sprintf(`regenerate profile "Example Ad Hoc" (%s)`, profiles[1].Id),
+		"revoke certificate " + old.Id,
+	}
+	if !reflect.DeepEqual(result.Steps, wantSteps) {
+		t.Errorf("dry run steps:\n%s\nwant:\n%s", strings.Join(result.Steps, "\n"), strings.Join(wantSteps, "\n"))
+	}
+	if srv.Resource(profiles[0].Ref()) == nil || srv.Resource(old.Ref()) == nil {
+		t.Fatal("dry run changed the server")
+	}
+
+	out := filepath.Join(dir, "out")
+	result, err = c.RotateCertificate(appleapi.RotateOptions{
+		CertificateId: old.Id,
+		Revoke:        true,
+		OutDir:        out,
+		P12Password:   "p12 secret",
+		Store:         s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := result.Certificate
+
+	// 两个描述文件用新证书重新生成, 保留其他证书和设备
+	if len(result.Profiles) != 2 {
+		t.Fatalf("%d profiles regenerated, want 2", len(result.Profiles))
+	}
+	for i, p := range profiles {
+		if srv.Resource(p.Ref()) != nil {
+			t.Errorf("old profile %s was not deleted", p.Id)
+		}
+		np, ok := srv.Resource(result.Profiles[i].Ref()).(*appleapi.Profile)
+		if !ok {
+			t.Fatalf("regenerated profile %s is not on the server", result.Profiles[i].Id)
+		}
+		if np.Attributes.Name != p.Attributes.Name || np.Attributes.ProfileType != p.Attributes.ProfileType {
+			t.Errorf("regenerated %q %s, want %q %s", np.Attributes.Name, np.Attributes.ProfileType, p.Attributes.Name, p.Attributes.ProfileType)
+		}
+		var certs []string
+		for _, ref := range np.Relationships.Certificates.Data {
+			certs = append(certs, ref.Id)
+		}
+		if len(certs) != len(p.Relationships.Certificates.Data) || certs[0] != cert.Id {
+			t.Errorf("profile %q certificates = %v, want the new %s first and the others kept", np.Attributes.Name, certs, cert.Id)
+		}
+		for _, id := range certs {
+			if id == old.Id {
+				t.Errorf("profile %q still uses the old certificate", np.Attributes.Name)
+			}
+		}
+		if !reflect.DeepEqual(np.Relationships.Devices.Data, p.Relationships.Devices.Data) {
+			t.Errorf("profile %q devices = %v, want %v", np.Attributes.Name, np.Relationships.Devices.Data, p.Relationships.Devices.Data)
+		}
+		if _, err = os.Stat(filepath.Join(out, strings.Replace(p.Attributes.Name, " ", "_", -1)+".mobileprovision")); err != nil {
+			t.Errorf("profile %q was not saved: %v", p.Attributes.Name, err)
+		}
+		if _, err = s.Profile(p.Attributes.ProfileType, "com.example.app"); err != nil {
+			t.Errorf("profile %q is not in the store: %v", p.Attributes.Name, err)
+		}
+	}
+	if srv.Resource(other.Ref()) == nil {
+		t.Error("profile that does not use the old certificate was regenerated")
+	}
+
+	// 旧证书被吊销, 私钥移到 quarantine 目录
+	if srv.Resource(old.Ref()) != nil {
+		t.Error("old certificate was not revoked")
+	}
+	for _, name := range []string{appleapi.CertificatePath("IOS_DISTRIBUTION", old.Id), appleapi.PrivateKeyPath("IOS_DISTRIBUTION", old.Id)} {
+		if s.Exists(name) {
+			t.Errorf("%s is still in the store", name)
+		}
+		if !s.Exists(appleapi.QuarantinePath(name)) {
+			t.Errorf("%s was not quarantined", name)
+		}
+	}
+	if len(result.Quarantined) != 2 {
+		t.Errorf("Quarantined = %v", result.Quarantined)
+	}
+
+	// .p12 包含新证书和匹配的私钥
+	p12, err := ioutil.ReadFile(filepath.Join(out, cert.Id+".p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, x, err := pkcs12.Decode(p12, "p12 secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cer, err := base64.StdEncoding.DecodeString(cert.Attributes.CertificateContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(x.Raw, cer) {
+		t.Error(".p12 does not contain the new certificate")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !reflect.DeepEqual(signer.Public(), x.PublicKey) {
+		t.Error(".p12 private key does not match the certificate")
+	}
+	if _, _, err = pkcs12.Decode(p12, "wrong"); err == nil {
+		t.Error(".p12 decoded with a wrong password")
+	}
+}
+
+func TestRotateCertificateRequiresIncludedBundle(t *testing.T) {
+	srv := appleapitest.NewServer()
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := appleapi.NewStore(dir, "secret")
+	old, _, _ := rotateFixture(t, srv, s)
+	c := appleapitest.NewMockClient(srv)
+	// 去掉 included 中的 bundle ID
+	profiles := c.Profiles.(*appleapitest.ProfileServiceMock)
+	profiles.QueryFunc = func(q *appleapi.ListProfilesQuery) ([]byte, error) {
+		b, err := profiles.Fallback.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		resp := &appleapi.ProfilesResponse{}
+		if err = json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		included := resp.Included[:0]
+		for _, r := range resp.Included {
+			if r.Ref().Type != appleapi.TypeBundleIds {
+				included = append(included, r)
+			}
+		}
+		resp.Included = included
+		return json.Marshal(resp)
+	}
+
+	if _, err = c.RotateCertificate(appleapi.RotateOptions{CertificateId: old.Id, Store: s}); err == nil || !strings.Contains(err.Error(), "is not included") {
+		t.Fatalf("RotateCertificate() = %v, want an error about the missing bundle ID", err)
+	}
+	if n := len(c.Certificates.(*appleapitest.CertificateServiceMock).CallsTo("CertificateCreate")); n != 0 {
+		t.Errorf("certificate created before the bundle ID check")
+	}
+}
@@ -0,0 +1,425 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	ServerApiProductionUrl = "https://api.storekit.itunes.apple.com"
+	ServerApiSandboxUrl    = "https://api.storekit-sandbox.itunes.apple.com"
+)
+
+// App Store Server API 的 errorCode
+// https://developer.apple.com/documentation/appstoreserverapi/error_codes
+const (
+	ErrorCodeInvalidAppIdentifier            = 4000002
+	ErrorCodeInvalidTransactionId            = 4000006
+	ErrorCodeInvalidOriginalTransactionId    = 4000008
+	ErrorCodeInvalidExtendByDays             = 4000009
+	ErrorCodeInvalidExtendReasonCode         = 4000010
+	ErrorCodeInvalidRequestIdentifier        = 4000011
+	ErrorCodeInvalidRequestRevision          = 4000005
+	ErrorCodeInvalidPaginationToken          = 4000014
+	ErrorCodeInvalidStartDate                = 4000015
+	ErrorCodeInvalidEndDate                  = 4000016
+	ErrorCodePaginationTokenExpired          = 4000017
+	ErrorCodeInvalidNotificationType         = 4000018
+	ErrorCodeMultipleFiltersSupplied         = 4000019
+	ErrorCodeSubscriptionExtensionIneligible = 4030004
+	ErrorCodeSubscriptionMaxExtension        = 4030005
+	ErrorCodeAccountNotFound                 = 4040001
+	ErrorCodeAppNotFound                     = 4040003
+	ErrorCodeOriginalTransactionIdNotFound   = 4040005
+	ErrorCodeServerNotificationUrlNotFound   = 4040007
+	ErrorCodeTestNotificationNotFound        = 4040008
+	ErrorCodeTransactionIdNotFound           = 4040010
+	ErrorCodeRateLimitExceeded               = 4290000
+	ErrorCodeGeneralInternal                 = 5000000
+)
+
+// ServerApiError 是 App Store Server API 返回的错误
+type ServerApiError struct {
+	StatusCode   int         `json:"-"`
+	Header       http.Header `json:"-"`
+	ErrorCode    int64       `json:"errorCode"`
+	ErrorMessage string      `json:"errorMessage"`
+}
+
+func (e *ServerApiError) Error() string {
+	if e.ErrorCode == 0 {
+		return fmt.Sprintf("serverapi: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("serverapi: %d %d %s", e.StatusCode, e.ErrorCode, e.ErrorMessage)
+}
+
+// NotFound 表示交易或订单不存在, 可能属于另一个环境
+func (e *ServerApiError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// ServerApi 调用 App Store Server API。令牌和 App Store Connect API 一样用 .p8 密钥签名,
+// 密钥在 App Store Connect 的 In-App Purchase 页面创建, 令牌中带 bid
+// https://developer.apple.com/documentation/appstoreserverapi
+type ServerApi struct {
+	*Token
+}
+
+// NewServerApi 返回 bundleId 的客户端, sandbox 为 true 时使用沙盒环境
+func NewServerApi(secret, kid, issuerId, bundleId string, sandbox bool) *ServerApi {
+	t := &Token{Secret: secret, Kid: kid, Iss: issuerId, Bid: bundleId, BaseUrl: ServerApiProductionUrl}
+	if sandbox {
+		t.BaseUrl = ServerApiSandboxUrl
+	}
+	return &ServerApi{Token: t}
+}
+
+// Sandbox 返回使用同一个密钥的沙盒环境客户端, 交易在生产环境不存在时可以再查沙盒环境
+func (s *ServerApi) Sandbox() *ServerApi {
+	t := &Token{
+		Secret:     s.Secret,
+		Kid:        s.Kid,
+		Iss:        s.Iss,
+		Bid:        s.Bid,
+		BaseUrl:    ServerApiSandboxUrl,
+		Client:     s.Client,
+		Hooks:      s.Hooks,
+		MaxRetries: s.MaxRetries,
+		RetryWait:  s.RetryWait,
+	}
+	return &ServerApi{Token: t}
+}
+
+// do 发送请求并把响应解码到 v, 错误转换为 *ServerApiError
+func (s *ServerApi) do(ctx context.Context, method, path string, query url.Values, req, v interface{}) error {
+	u := s.Url(path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body []byte
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	resp, err := s.DoContext(ctx, method, u, body)
+	if e, ok := err.(*ApiError); ok {
+		se := &ServerApiError{StatusCode: e.StatusCode, Header: e.Header}
+		json.Unmarshal(e.Body, se)
+		return se
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp, v)
+}
+
+// 排序
+const (
+	SortAscending  = "ASCENDING"
+	SortDescending = "DESCENDING"
+)
+
+// TransactionHistoryQuery 是 Get Transaction History 的过滤条件, 零值表示不过滤
+type TransactionHistoryQuery struct {
+	Revision                     string // 上一页响应的 revision
+	StartDate                    time.Time
+	EndDate                      time.Time
+	ProductIds                   []string
+	ProductTypes                 []string // AUTO_RENEWABLE, NON_RENEWABLE, CONSUMABLE, NON_CONSUMABLE
+	Sort                         string   // SortAscending 或 SortDescending
+	SubscriptionGroupIdentifiers []string
+	InAppOwnershipType           string
+	Revoked                      *bool
+}
+
+func (q *TransactionHistoryQuery) values() url.Values {
+	v := url.Values{}
+	if q == nil {
+		return v
+	}
+	if q.Revision != "" {
+		v.Set("revision", q.Revision)
+	}
+	if !q.StartDate.IsZero() {
+		v.Set("startDate", strconv.FormatInt(q.StartDate.UnixNano()/int64(time.Millisecond), 10))
+	}
+	if !q.EndDate.IsZero() {
+		v.Set("endDate", strconv.FormatInt(q.EndDate.UnixNano()/int64(time.Millisecond), 10))
+	}
+	v["productId"] = q.ProductIds
+	v["productType"] = q.ProductTypes
+	v["subscriptionGroupIdentifier"] = q.SubscriptionGroupIdentifiers
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.InAppOwnershipType != "" {
+		v.Set("inAppOwnershipType", q.InAppOwnershipType)
+	}
+	if q.Revoked != nil {
+		v.Set("revoked", strconv.FormatBool(*q.Revoked))
+	}
+	for k, s := range v {
+		if len(s) == 0 {
+			delete(v, k)
+		}
+	}
+	return v
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/historyresponse
+type HistoryResponse struct {
+	AppAppleId         int64    `json:"appAppleId"`
+	BundleId           string   `json:"bundleId"`
+	Environment        string   `json:"environment"`
+	HasMore            bool     `json:"hasMore"`
+	Revision           string   `json:"revision"`
+	SignedTransactions []string `json:"signedTransactions"`
+}
+
+// TransactionHistory 返回一页交易历史, transactionId 可以是用户的任一交易
+// https://developer.apple.com/documentation/appstoreserverapi/get_transaction_history
+func (s *ServerApi) TransactionHistory(ctx context.Context, transactionId string, q *TransactionHistoryQuery) (*HistoryResponse, error) {
+	resp := &HistoryResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v2/history/"+url.PathEscape(transactionId), q.values(), nil, resp)
+	return resp, err
+}
+
+// TransactionHistoryV1 是已弃用的 v1 接口, 不支持过滤
+func (s *ServerApi) TransactionHistoryV1(ctx context.Context, originalTransactionId, revision string) (*HistoryResponse, error) {
+	q := url.Values{}
+	if revision != "" {
+		q.Set("revision", revision)
+	}
+	resp := &HistoryResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v1/history/"+url.PathEscape(originalTransactionId), q, nil, resp)
+	return resp, err
+}
+
+// AllTransactionHistory 按 revision 取得所有页, 返回签名的交易
+func (s *ServerApi) AllTransactionHistory(ctx context.Context, transactionId string, q *TransactionHistoryQuery) ([]string, error) {
+	page := TransactionHistoryQuery{}
+	if q != nil {
+		page = *q
+	}
+	var list []string
+	for {
+		resp, err := s.TransactionHistory(ctx, transactionId, &page)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, resp.SignedTransactions...)
+		if !resp.HasMore || resp.Revision == "" {
+			return list, nil
+		}
+		page.Revision = resp.Revision
+	}
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/transactioninforesponse
+type TransactionInfoResponse struct {
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/get_transaction_info
+func (s *ServerApi) TransactionInfo(ctx context.Context, transactionId string) (*TransactionInfoResponse, error) {
+	resp := &TransactionInfoResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v1/transactions/"+url.PathEscape(transactionId), nil, nil, resp)
+	return resp, err
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/statusresponse
+type StatusResponse struct {
+	AppAppleId  int64                     `json:"appAppleId"`
+	BundleId    string                    `json:"bundleId"`
+	Environment string                    `json:"environment"`
+	Data        []SubscriptionGroupStatus `json:"data"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/subscriptiongroupidentifieritem
+type SubscriptionGroupStatus struct {
+	SubscriptionGroupIdentifier string            `json:"subscriptionGroupIdentifier"`
+	LastTransactions            []LastTransaction `json:"lastTransactions"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/lasttransactionsitem
+type LastTransaction struct {
+	OriginalTransactionId string `json:"originalTransactionId"`
+	Status                int    `json:"status"` // SubscriptionActive 等
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo"`
+}
+
+// AllSubscriptionStatuses 返回用户所有订阅组的状态, status 为空时返回所有状态
+// https://developer.apple.com/documentation/appstoreserverapi/get_all_subscription_statuses
+func (s *ServerApi) AllSubscriptionStatuses(ctx context.Context, transactionId string, status ...int) (*StatusResponse, error) {
+	q := url.Values{}
+	for _, v := range status {
+		q.Add("status", strconv.Itoa(v))
+	}
+	resp := &StatusResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v1/subscriptions/"+url.PathEscape(transactionId), q, nil, resp)
+	return resp, err
+}
+
+// OrderLookupResponse 的 status
+const (
+	OrderLookupValid   = 0
+	OrderLookupInvalid = 1
+)
+
+// https://developer.apple.com/documentation/appstoreserverapi/orderlookupresponse
+type OrderLookupResponse struct {
+	Status             int      `json:"status"`
+	SignedTransactions []string `json:"signedTransactions"`
+}
+
+// LookUpOrderId 用收据邮件中的订单号查找交易
+// https://developer.apple.com/documentation/appstoreserverapi/look_up_order_id
+func (s *ServerApi) LookUpOrderId(ctx context.Context, orderId string) (*OrderLookupResponse, error) {
+	resp := &OrderLookupResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v1/lookup/"+url.PathEscape(orderId), nil, nil, resp)
+	return resp, err
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/refundhistoryresponse
+type RefundHistoryResponse struct {
+	HasMore            bool     `json:"hasMore"`
+	Revision           string   `json:"revision"`
+	SignedTransactions []string `json:"signedTransactions"`
+}
+
+// RefundHistory 返回一页已退款的交易, revision 为上一页响应的 revision
+// https://developer.apple.com/documentation/appstoreserverapi/get_refund_history
+func (s *ServerApi) RefundHistory(ctx context.Context, transactionId, revision string) (*RefundHistoryResponse, error) {
+	q := url.Values{}
+	if revision != "" {
+		q.Set("revision", revision)
+	}
+	resp := &RefundHistoryResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v2/refund/lookup/"+url.PathEscape(transactionId), q, nil, resp)
+	return resp, err
+}
+
+// extendReasonCode
+const (
+	ExtendReasonUndeclared           = 0
+	ExtendReasonCustomerSatisfaction = 1
+	ExtendReasonOther                = 2
+	ExtendReasonServiceIssue         = 3
+)
+
+// https://developer.apple.com/documentation/appstoreserverapi/extendrenewaldaterequest
+type ExtendRenewalDateRequest struct {
+	ExtendByDays      int    `json:"extendByDays"` // 最多 90 天
+	ExtendReasonCode  int    `json:"extendReasonCode"`
+	RequestIdentifier string `json:"requestIdentifier"` // UUID, 重试时使用同一个值
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/extendrenewaldateresponse
+type ExtendRenewalDateResponse struct {
+	OriginalTransactionId string `json:"originalTransactionId"`
+	WebOrderLineItemId    string `json:"webOrderLineItemId"`
+	Success               bool   `json:"success"`
+	EffectiveDate         int64  `json:"effectiveDate"`
+}
+
+// ExtendSubscriptionRenewalDate 延长订阅的续订日期, 每个订阅一年最多两次
+// https://developer.apple.com/documentation/appstoreserverapi/extend_a_subscription_renewal_date
+func (s *ServerApi) ExtendSubscriptionRenewalDate(ctx context.Context, originalTransactionId string, req *ExtendRenewalDateRequest) (*ExtendRenewalDateResponse, error) {
+	resp := &ExtendRenewalDateResponse{}
+	err := s.do(ctx, http.MethodPut, "/inApps/v1/subscriptions/extend/"+url.PathEscape(originalTransactionId), nil, req, resp)
+	return resp, err
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/sendtestnotificationresponse
+type SendTestNotificationResponse struct {
+	TestNotificationToken string `json:"testNotificationToken"`
+}
+
+// RequestTestNotification 让苹果向 App Store Connect 中配置的通知地址发送一条 TEST 通知
+// https://developer.apple.com/documentation/appstoreserverapi/request_a_test_notification
+func (s *ServerApi) RequestTestNotification(ctx context.Context) (*SendTestNotificationResponse, error) {
+	resp := &SendTestNotificationResponse{}
+	err := s.do(ctx, http.MethodPost, "/inApps/v1/notifications/test", nil, nil, resp)
+	return resp, err
+}
+
+// sendAttemptResult
+const (
+	SendAttemptSuccess              = "SUCCESS"
+	SendAttemptTimedOut             = "TIMED_OUT"
+	SendAttemptTlsIssue             = "TLS_ISSUE"
+	SendAttemptCircularRedirect     = "CIRCULAR_REDIRECT"
+	SendAttemptNoResponse           = "NO_RESPONSE"
+	SendAttemptSocketIssue          = "SOCKET_ISSUE"
+	SendAttemptUnsupportedCharset   = "UNSUPPORTED_CHARSET"
+	SendAttemptInvalidResponse      = "INVALID_RESPONSE"
+	SendAttemptPrematureClose       = "PREMATURE_CLOSE"
+	SendAttemptUnsuccessfulHttpCode = "UNSUCCESSFUL_HTTP_RESPONSE_CODE"
+	SendAttemptOther                = "OTHER"
+)
+
+// https://developer.apple.com/documentation/appstoreserverapi/sendattemptitem
+type SendAttempt struct {
+	AttemptDate       int64  `json:"attemptDate"`
+	SendAttemptResult string `json:"sendAttemptResult"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/checktestnotificationresponse
+type CheckTestNotificationResponse struct {
+	SignedPayload string        `json:"signedPayload"`
+	SendAttempts  []SendAttempt `json:"sendAttempts"`
+}
+
+// TestNotificationStatus 返回 RequestTestNotification 发送的通知及发送结果
+// https://developer.apple.com/documentation/appstoreserverapi/get_test_notification_status
+func (s *ServerApi) TestNotificationStatus(ctx context.Context, testNotificationToken string) (*CheckTestNotificationResponse, error) {
+	resp := &CheckTestNotificationResponse{}
+	err := s.do(ctx, http.MethodGet, "/inApps/v1/notifications/test/"+url.PathEscape(testNotificationToken), nil, nil, resp)
+	return resp, err
+}
+
+// NotificationHistoryRequest 的时间为毫秒, 最早可以查询 180 天前的通知。
+// TransactionId 和 NotificationType 不能同时使用
+// https://developer.apple.com/documentation/appstoreserverapi/notificationhistoryrequest
+type NotificationHistoryRequest struct {
+	StartDate           int64  `json:"startDate"`
+	EndDate             int64  `json:"endDate"`
+	NotificationType    string `json:"notificationType,omitempty"`
+	NotificationSubtype string `json:"notificationSubtype,omitempty"`
+	OnlyFailures        bool   `json:"onlyFailures,omitempty"`
+	TransactionId       string `json:"transactionId,omitempty"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/notificationhistoryresponseitem
+type NotificationHistoryItem struct {
+	SignedPayload string        `json:"signedPayload"`
+	SendAttempts  []SendAttempt `json:"sendAttempts"`
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/notificationhistoryresponse
+type NotificationHistoryResponse struct {
+	NotificationHistory []NotificationHistoryItem `json:"notificationHistory"`
+	HasMore             bool                      `json:"hasMore"`
+	PaginationToken     string                    `json:"paginationToken"`
+}
+
+// NotificationHistory 返回一页通知历史, paginationToken 为上一页响应的 paginationToken
+// https://developer.apple.com/documentation/appstoreserverapi/get_notification_history
+func (s *ServerApi) NotificationHistory(ctx context.Context, req *NotificationHistoryRequest, paginationToken string) (*NotificationHistoryResponse, error) {
+	q := url.Values{}
+	if paginationToken != "" {
+		q.Set("paginationToken", paginationToken)
+	}
+	resp := &NotificationHistoryResponse{}
+	err := s.do(ctx, http.MethodPost, "/inApps/v1/notifications/history", q, req, resp)
+	return resp, err
+}
@@ -0,0 +1,230 @@
+package appleapi_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func TestAllTransactionHistory(t *testing.T) {
+	srv := appleapitest.NewServerApiServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	start := time.Now().Add(-time.Hour)
+	var first string
+	for i := 0; i < 5; i++ {
+		tx := srv.AddTransaction(appleapi.JWSTransactionDecodedPayload{
+			ProductId:    "com.example.coins",
+			Type:         appleapi.ProductTypeConsumable,
+			PurchaseDate: start.Add(time.Duration(i)*time.Minute).UnixNano() / int64(time.Millisecond),
+		})
+		if i == 0 {
+			first = tx.TransactionId
+		}
+	}
+	api := srv.ServerApi()
+	v := srv.JwsVerifier()
+
+	signed, err := api.AllTransactionHistory(context.Background(), first, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 5 {
+		t.Fatalf("%d transactions, want 5 over 3 pages", len(signed))
+	}
+	var last int64
+	for i, s := range signed {
+		tx, err := v.VerifyTransaction(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.PurchaseDate < last {
+			t.Errorf("transaction %d is out of order", i)
+		}
+		last = tx.PurchaseDate
+	}
+
+	// 过滤条件在每页都发送
+	signed, err = api.AllTransactionHistory(context.Background(), first, &appleapi.TransactionHistoryQuery{Sort: appleapi.SortDescending})
+	if err != nil || len(signed) != 5 {
+		t.Fatalf("descending: %d, %v", len(signed), err)
+	}
+	if tx, _ := v.VerifyTransaction(signed[0]); tx == nil || tx.PurchaseDate != last {
+		t.Errorf("descending history does not start with the latest transaction")
+	}
+}
+
+func TestServerApiError(t *testing.T) {
+	srv := appleapitest.NewServerApiServer()
+	defer srv.Close()
+	api := srv.ServerApi()
+
+	_, err := api.TransactionInfo(context.Background(), "1000000000000000")
+	var se *appleapi.ServerApiError
+	if !errors.As(err, &se) {
+		t.Fatalf("err = %T %v, want a ServerApiError", err, err)
+	}
+	if se.StatusCode != http.StatusNotFound || se.ErrorCode != appleapi.ErrorCodeTransactionIdNotFound || !se.NotFound() {
+		t.Errorf("error = %+v", se)
+	}
+	if se.ErrorMessage == "" || !strings.Contains(se.Error(), "4040010") {
+		t.Errorf("Error() = %q", se.Error())
+	}
+
+	_, err = api.ExtendSubscriptionRenewalDate(context.Background(), "1000000000000000", &appleapi.ExtendRenewalDateRequest{ExtendByDays: 91, RequestIdentifier: "r"})
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest || se.ErrorCode != appleapi.ErrorCodeInvalidExtendByDays || se.NotFound() {
+		t.Errorf("invalid extendByDays: %v", err)
+	}
+
+	// 没有 errorCode 的响应
+	bad := srv.ServerApi()
+	bad.Kid = "OTHERKEY12"
+	_, err = bad.TransactionInfo(context.Background(), "1000000000000000")
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized || se.ErrorCode != 0 || se.Error() != "serverapi: 401 Unauthorized" {
+		t.Errorf("401: %v", err)
+	}
+}
+
+// apiClaims 解码 Authorization 中 JWT 的载荷, 不验证签名
+func apiClaims(t *testing.T, req *http.Request) *appleapi.ApiPayload {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), ".")
+	if len(parts) != 3 {
+		t.Fatalf("Authorization = %q", req.Header.Get("Authorization"))
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &appleapi.ApiPayload{}
+	if err = json.Unmarshal(b, pl); err != nil {
+		t.Fatal(err)
+	}
+	return pl
+}
+
+// hostRouter 把生产环境的请求返回 404, 沙盒环境的请求转发到 srv, 记录请求
+type hostRouter struct {
+	srv      *appleapitest.ServerApiServer
+	requests []*http.Request
+}
+
+func (h *hostRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.requests = append(h.requests, req)
+	switch req.URL.Host {
+	case "api.storekit.itunes.apple.com":
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"errorCode":4040010,"errorMessage":"Transaction id not found."}`)),
+			Request:    req,
+		}, nil
+	case "api.storekit-sandbox.itunes.apple.com":
+		u, _ := url.Parse(h.srv.URL)
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+		return h.srv.Client().Transport.RoundTrip(req)
+	}
+	return nil, errors.New("unexpected host " + req.URL.Host)
+}
+
+func TestServerApiSandbox(t *testing.T) {
+	srv := appleapitest.NewServerApiServer()
+	defer srv.Close()
+	tx := srv.AddTransaction(appleapi.JWSTransactionDecodedPayload{ProductId: "com.example.pro"})
+	router := &hostRouter{srv: srv}
+	fake := srv.ServerApi()
+	api := appleapi.NewServerApi(fake.Secret, fake.Kid, fake.Iss, fake.Bid, false)
+	api.Client = &http.Client{Transport: router}
+	api.MaxRetries = 1
+	if api.BaseUrl != appleapi.ServerApiProductionUrl {
+		t.Fatalf("BaseUrl = %s", api.BaseUrl)
+	}
+
+	// 生产环境找不到时查沙盒环境
+	_, err := api.TransactionInfo(context.Background(), tx.TransactionId)
+	var se *appleapi.ServerApiError
+	if !errors.As(err, &se) || !se.NotFound() {
+		t.Fatalf("production: %v, want not found", err)
+	}
+	sandbox := api.Sandbox()
+	if sandbox.BaseUrl != appleapi.ServerApiSandboxUrl || api.BaseUrl != appleapi.ServerApiProductionUrl {
+		t.Errorf("Sandbox() BaseUrl = %s, original %s", sandbox.BaseUrl, api.BaseUrl)
+	}
+	if sandbox.MaxRetries != 1 || sandbox.Client != api.Client {
+		t.Error("Sandbox() did not keep the settings")
+	}
+	resp, err := sandbox.TransactionInfo(context.Background(), tx.TransactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := srv.JwsVerifier().VerifyTransaction(resp.SignedTransactionInfo); err != nil || got.TransactionId != tx.TransactionId {
+		t.Errorf("sandbox transaction = %+v, %v", got, err)
+	}
+
+	if len(router.requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(router.requests))
+	}
+	for _, req := range router.requests {
+		pl := apiClaims(t, req)
+		if pl.Bid != appleapitest.TestBundleId || pl.Aud != "appstoreconnect-v1" || pl.Iss != fake.Iss {
+			t.Errorf("%s claims = %+v", req.URL.Host, pl)
+		}
+		if pl.Exp-pl.Iat > 3600 {
+			t.Errorf("%s token lifetime %ds", req.URL.Host, pl.Exp-pl.Iat)
+		}
+	}
+}
+
+func TestExtendSubscriptionRenewalDate(t *testing.T) {
+	srv := appleapitest.NewServerApiServer()
+	defer srv.Close()
+	expires := time.Now().Add(10 * 24 * time.Hour)
+	tx := srv.AddTransaction(appleapi.JWSTransactionDecodedPayload{
+		ProductId:   "com.example.monthly",
+		Type:        appleapi.ProductTypeAutoRenewable,
+		ExpiresDate: expires.UnixNano() / int64(time.Millisecond),
+	})
+	api := srv.ServerApi()
+	req := &appleapi.ExtendRenewalDateRequest{ExtendByDays: 7, ExtendReasonCode: appleapi.ExtendReasonServiceIssue, RequestIdentifier: "0f7cbd67-8ab3-4b5c-9d2c-0a5e6b0b6f4e"}
+
+	first, err := api.ExtendSubscriptionRenewalDate(context.Background(), tx.OriginalTransactionId, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tx.ExpiresDate + int64(7*24*time.Hour/time.Millisecond)
+	if !first.Success || first.EffectiveDate != want || first.OriginalTransactionId != tx.OriginalTransactionId {
+		t.Errorf("response = %+v, want effective date %d", first, want)
+	}
+
+	// 重试使用同一个 requestIdentifier, 不会再次延长
+	for i := 0; i < 3; i++ {
+		again, err := api.ExtendSubscriptionRenewalDate(context.Background(), tx.OriginalTransactionId, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *again != *first {
+			t.Errorf("retry %d = %+v, want %+v", i, again, first)
+		}
+	}
+	if got, _ := srv.Transaction(tx.TransactionId); got.ExpiresDate != want {
+		t.Errorf("expires date = %d after retries, want %d", got.ExpiresDate, want)
+	}
+
+	// 新的 requestIdentifier 再次延长
+	req.RequestIdentifier = "5b1c7bb0-7c6e-4b8e-a1f1-1d7e0c1f2a3b"
+	second, err := api.ExtendSubscriptionRenewalDate(context.Background(), tx.OriginalTransactionId, req)
+	if err != nil || second.EffectiveDate != want+int64(7*24*time.Hour/time.Millisecond) {
+		t.Errorf("second extension = %+v, %v", second, err)
+	}
+}
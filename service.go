@@ -0,0 +1,49 @@
+package appleapi
+
+// BundleIDService manages bundle IDs, implemented by *Bundles.
+type BundleIDService interface {
+	Query(query *ListBundlesQuery) ([]byte, error)
+	Next(next string) ([]byte, error)
+	BundleIdCreate(identifier, name string) ([]byte, error)
+	BundleIdUpdate(id, name string) ([]byte, error)
+	BundleIdDelete(id string) error
+	BundleIdCapabilities(id string, limit int) ([]byte, error)
+	CapabilityEnable(bundleId, capabilityType string, settings []CapabilitySetting) ([]byte, error)
+	CapabilityDisable(id string) error
+}
+
+// CertificateService manages certificates, implemented by *Certificates.
+type CertificateService interface {
+	Query(query *ListCertificatesQuery) ([]byte, error)
+	Next(next string) ([]byte, error)
+	ReadCertificate(id string) ([]byte, error)
+	CertificateCreate(csrContent, certificateType string) ([]byte, error)
+	CertificateRevoke(id string) error
+}
+
+// DeviceService manages devices, implemented by *Devices.
+type DeviceService interface {
+	Query(query *ListDevicesQuery) ([]byte, error)
+	Next(next string) ([]byte, error)
+	DeviceCreate(udid, name string) ([]byte, error)
+	DeviceUpdate(id, name, status string) ([]byte, error)
+}
+
+// ProfileService manages provisioning profiles, implemented by *Profiles.
+type ProfileService interface {
+	Query(query *ListProfilesQuery) ([]byte, error)
+	Next(next string) ([]byte, error)
+	ReadProfile(id string) ([]byte, error)
+	ProfileCertificates(id string, limit int) ([]byte, error)
+	ProfileDevices(id string, limit int) ([]byte, error)
+	ProfileCreate(name, bundleId string, certificates, devices []string) ([]byte, error)
+	ProfileCreateWithType(name, profileType, bundleId string, certificates, devices []string) ([]byte, error)
+	ProfileDelete(id string) error
+}
+
+var (
+	_ BundleIDService    = (*Bundles)(nil)
+	_ CertificateService = (*Certificates)(nil)
+	_ DeviceService      = (*Devices)(nil)
+	_ ProfileService     = (*Profiles)(nil)
+)
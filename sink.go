@@ -0,0 +1,112 @@
+package appleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sink 发送 Monitor 的提醒
+type Sink interface {
+	Send(alerts []Alert) error
+}
+
+// SinkFunc 把函数转换为 Sink
+type SinkFunc func(alerts []Alert) error
+
+func (f SinkFunc) Send(alerts []Alert) error {
+	return f(alerts)
+}
+
+func alertText(alerts []Alert) string {
+	var buf bytes.Buffer
+	for i := range alerts {
+		buf.WriteString(alerts[i].String())
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// WriterSink 每条提醒写一行，例如写到 os.Stdout
+type WriterSink struct {
+	W io.Writer
+}
+
+func (s *WriterSink) Send(alerts []Alert) error {
+	_, err := io.WriteString(s.W, alertText(alerts))
+	return err
+}
+
+// WebhookSink 以 JSON POST 提醒:
+//
+//	{"text": "...", "alerts": [...]}
+//
+// text 字段兼容 Slack 的 incoming webhook
+type WebhookSink struct {
+	Url    string
+	Header http.Header
+	Client *http.Client
+}
+
+func (s *WebhookSink) Send(alerts []Alert) error {
+	body, err := json.Marshal(struct {
+		Text   string  `json:"text"`
+		Alerts []Alert `json:"alerts"`
+	}{alertText(alerts), alerts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("appleapi: webhook %s returned %s: %s", s.Url, resp.Status, b)
+	}
+	return nil
+}
+
+// SmtpSink 把提醒作为一封邮件发送, Auth 为 nil 时不认证
+type SmtpSink struct {
+	Addr    string // host:port
+	Auth    smtp.Auth
+	From    string
+	To      []string
+	Subject string // 默认 "App Store Connect signing alerts"
+}
+
+func (s *SmtpSink) Send(alerts []Alert) error {
+	subject := s.Subject
+	if subject == "" {
+		subject = "App Store Connect signing alerts"
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s (%d)\r\n", subject, len(alerts))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(alertText(alerts), "\n", "\r\n", -1))
+	return smtp.SendMail(s.Addr, s.Auth, s.From, s.To, msg.Bytes())
+}
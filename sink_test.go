@@ -0,0 +1,199 @@
+package appleapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlerts() []Alert {
+	expiration := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+	return []Alert{
+		{Kind: AlertExpiring, Type: TypeCertificates, Id: "C1", Name: "Example Distribution", Expiration: expiration, Threshold: 7 * 24 * time.Hour},
+		{Kind: AlertInvalid, Type: TypeProfiles, Id: "P1", Name: "Example App Store", Expiration: expiration},
+	}
+}
+
+const testAlertText = `certificates "Example Distribution" (C1) expires at 2026-03-06T12:00:00Z, in less than 7 days
+profiles "Example App Store" (P1) is INVALID
+`
+
+func TestWriterSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := (&WriterSink{W: buf}).Send(testAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testAlertText {
+		t.Errorf("WriterSink wrote %q, want %q", buf.String(), testAlertText)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got struct {
+		Text   string  `json:"text"`
+		Alerts []Alert `json:"alerts"`
+	}
+	var header http.Header
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("no_service"))
+	}))
+	defer srv.Close()
+	sink := &WebhookSink{Url: srv.URL, Header: http.Header{"X-Token": {"secret"}}}
+
+	if err := sink.Send(testAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if header.Get("X-Token") != "secret" {
+		t.Errorf("custom header was not sent: %v", header)
+	}
+	if got.Text != testAlertText {
+		t.Errorf("text = %q, want %q", got.Text, testAlertText)
+	}
+	want := testAlerts()
+	if len(got.Alerts) != len(want) {
+		t.Fatalf("%d alerts, want %d", len(got.Alerts), len(want))
+	}
+	for i := range want {
+		if got.Alerts[i].Key() != want[i].Key() || !got.Alerts[i].Expiration.Equal(want[i].Expiration) || got.Alerts[i].Name != want[i].Name {
+			t.Errorf("alert %d = %+v, want %+v", i, got.Alerts[i], want[i])
+		}
+	}
+
+	status = http.StatusNotFound
+	err := sink.Send(testAlerts())
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "no_service") {
+		t.Errorf("Send() to a failing webhook = %v", err)
+	}
+}
+
+// smtpServer 是只接收一封邮件的 SMTP 服务器
+type smtpServer struct {
+	ln   net.Listener
+	from string
+	to   []string
+	data chan string
+}
+
+func newSmtpServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, data: make(chan string, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestSmtpSink(t *testing.T) {
+	srv := newSmtpServer(t)
+	defer srv.ln.Close()
+	sink := &SmtpSink{Addr: srv.ln.Addr().String(), From: "alerts@example.com", To: []string{"ios@example.com", "ops@example.com"}}
+
+	if err := sink.Send(testAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	var data string
+	select {
+	case data = <-srv.data:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	if srv.from != "alerts@example.com" || strings.Join(srv.to, ",") != "ios@example.com,ops@example.com" {
+		t.Errorf("envelope from %q to %v", srv.from, srv.to)
+	}
+	parts := strings.SplitN(data, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("message has no body: %q", data)
+	}
+	for _, want := range []string{
+		"From: alerts@example.com\r\n",
+		"To: ios@example.com, ops@example.com\r\n",
+		"Subject: App Store Connect signing alerts (2)\r\n",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(parts[0]+"\r\n", want) {
+			t.Errorf("headers do not contain %q:\n%s", want, parts[0])
+		}
+	}
+	if body := strings.Replace(parts[1], "\r\n", "\n", -1); body != testAlertText {
+		t.Errorf("body = %q, want %q", body, testAlertText)
+	}
+}
+
+func TestSinkFunc(t *testing.T) {
+	var got []Alert
+	sink := SinkFunc(func(alerts []Alert) error {
+		got = alerts
+		return nil
+	})
+	if err := sink.Send(testAlerts()); err != nil || len(got) != 2 {
+		t.Errorf("SinkFunc received %v, %v", got, err)
+	}
+}
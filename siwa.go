@@ -0,0 +1,213 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+const AppleIdUrl = "https://appleid.apple.com"
+
+// MaxClientSecretLifetime 是苹果允许的 client_secret 最长有效期, 约 6 个月
+const MaxClientSecretLifetime = 15777000 * time.Second
+
+// token_type_hint
+const (
+	TokenTypeHintAccess  = "access_token"
+	TokenTypeHintRefresh = "refresh_token"
+)
+
+var ErrClientSecretLifetime = errors.New("siwa: client_secret lifetime must not exceed 6 months")
+
+// SignInWithApple 生成 client_secret 并调用 Sign in with Apple 的 REST API，
+// 密钥是在开发者网站创建的、启用了 Sign in with Apple 的 .p8 密钥
+type SignInWithApple struct {
+	Secret      string // .p8
+	Kid         string
+	TeamId      string
+	ClientId    string        // services ID, app 中登录时为 bundle ID
+	RedirectUri string        // 授权时使用的 redirect_uri, app 中登录时为空
+	Lifetime    time.Duration // client_secret 的有效期, 默认 MaxClientSecretLifetime
+	BaseUrl     string        // 默认 AppleIdUrl, 测试时可指向本地服务
+	Client      *http.Client  // 默认 http.DefaultClient
+	Hooks       []Hook
+
+	mu       sync.Mutex
+	secret   string
+	created  time.Time
+	key      *jwt.ECDSASHA
+	verifier *IdTokenVerifier
+}
+
+type ClientSecretPayload struct {
+	Iss string `json:"iss"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+	Aud string `json:"aud"`
+	Sub string `json:"sub"`
+}
+
+// https://developer.apple.com/documentation/sign_in_with_apple/tokenresponse
+type AppleIdTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"` // 刷新令牌时不返回
+	IdToken      string `json:"id_token"`
+}
+
+// AppleIdError 是 /auth/token 和 /auth/revoke 返回的错误, Code 如 invalid_grant, invalid_client
+// https://developer.apple.com/documentation/sign_in_with_apple/errorresponse
+type AppleIdError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *AppleIdError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("siwa: %d %s: %s", e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("siwa: %d %s", e.StatusCode, e.Code)
+}
+
+func (s *SignInWithApple) lifetime() time.Duration {
+	if s.Lifetime <= 0 {
+		return MaxClientSecretLifetime
+	}
+	return s.Lifetime
+}
+
+// ClientSecret 返回当前的 client_secret, 超过一半有效期后重新生成
+func (s *SignInWithApple) ClientSecret() (string, error) {
+	lifetime := s.lifetime()
+	if lifetime > MaxClientSecretLifetime {
+		return "", ErrClientSecretLifetime
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key == nil {
+		pk, err := ReadPrivate([]byte(s.Secret))
+		if err != nil {
+			return "", err
+		}
+		s.key = jwt.NewES256(jwt.ECDSAPrivateKey(pk))
+	}
+
+	now := time.Now()
+	if s.secret == "" || now.Sub(s.created) > lifetime/2 {
+		pl := &ClientSecretPayload{
+			Iss: s.TeamId,
+			Iat: now.Unix(),
+			Exp: now.Add(lifetime).Unix(),
+			Aud: AppleIdUrl,
+			Sub: s.ClientId,
+		}
+		secret, err := jwt.Sign(pl, s.key, jwt.KeyID(s.Kid))
+		if err != nil {
+			return "", err
+		}
+		s.secret = string(secret)
+		s.created = now
+	}
+	return s.secret, nil
+}
+
+// ExchangeCode 用授权码换取令牌, 授权码只能使用一次, 5 分钟内有效
+// https://developer.apple.com/documentation/sign_in_with_apple/generate_and_validate_tokens
+func (s *SignInWithApple) ExchangeCode(ctx context.Context, code string) (*AppleIdTokenResponse, error) {
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}}
+	if s.RedirectUri != "" {
+		form.Set("redirect_uri", s.RedirectUri)
+	}
+	return s.token(ctx, form)
+}
+
+// RefreshToken 用刷新令牌验证用户仍然有效并取得新的 access_token 和 id_token
+func (s *SignInWithApple) RefreshToken(ctx context.Context, refreshToken string) (*AppleIdTokenResponse, error) {
+	return s.token(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+}
+
+// Revoke 使令牌失效, 用户删除账号时应调用。tokenTypeHint 为 TokenTypeHintAccess 或 TokenTypeHintRefresh
+// https://developer.apple.com/documentation/sign_in_with_apple/revoke_tokens
+func (s *SignInWithApple) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	form := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+	_, err := s.post(ctx, "/auth/revoke", form)
+	return err
+}
+
+func (s *SignInWithApple) token(ctx context.Context, form url.Values) (*AppleIdTokenResponse, error) {
+	body, err := s.post(ctx, "/auth/token", form)
+	if err != nil {
+		return nil, err
+	}
+	resp := &AppleIdTokenResponse{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// post 发送带 client_id 和 client_secret 的表单, 非 200 的响应返回 *AppleIdError
+func (s *SignInWithApple) post(ctx context.Context, path string, form url.Values) ([]byte, error) {
+	secret, err := s.ClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	form.Set("client_id", s.ClientId)
+	form.Set("client_secret", secret)
+
+	base := s.BaseUrl
+	if base == "" {
+		base = AppleIdUrl
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(base, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, h := range s.Hooks {
+		req = h.BeforeSend(req)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	for i := len(s.Hooks) - 1; i >= 0; i-- {
+		s.Hooks[i].AfterReceive(req, resp, err, latency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &AppleIdError{StatusCode: resp.StatusCode}
+		if err = json.Unmarshal(body, e); err != nil || e.Code == "" {
+			e.Code = http.StatusText(resp.StatusCode)
+		}
+		return nil, e
+	}
+	return body, nil
+}
@@ -0,0 +1,211 @@
+package appleapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sign in with Apple 服务器通知的事件类型
+const (
+	SiwaEmailDisabled  = "email-disabled"
+	SiwaEmailEnabled   = "email-enabled"
+	SiwaConsentRevoked = "consent-revoked"
+	SiwaAccountDelete  = "account-delete"
+)
+
+// SiwaEvent 是服务器通知中的事件, EmailDisabled 和 EmailEnabled 是私密中继邮箱的转发状态
+// https://developer.apple.com/documentation/sign_in_with_apple/processing_changes_for_sign_in_with_apple_accounts
+type SiwaEvent struct {
+	Type           string   `json:"type"`
+	Sub            string   `json:"sub"`
+	Email          string   `json:"email,omitempty"`
+	IsPrivateEmail FlexBool `json:"is_private_email,omitempty"`
+	EventTime      int64    `json:"event_time"` // 毫秒
+}
+
+func (e *SiwaEvent) Time() time.Time {
+	return time.Unix(0, e.EventTime*int64(time.Millisecond))
+}
+
+// SiwaNotification 是通知 JWT 的载荷, 苹果把 events 编码为 JSON 字符串
+type SiwaNotification struct {
+	Iss    string    `json:"iss"`
+	Aud    string    `json:"aud"`
+	Iat    int64     `json:"iat"`
+	Jti    string    `json:"jti"`
+	Events SiwaEvent `json:"-"`
+}
+
+func (n *SiwaNotification) UnmarshalJSON(data []byte) error {
+	type notification SiwaNotification
+	v := &struct {
+		*notification
+		Events json.RawMessage `json:"events"`
+	}{notification: (*notification)(n)}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	events := []byte(v.Events)
+	var s string
+	if json.Unmarshal(events, &s) == nil {
+		events = []byte(s)
+	}
+	return json.Unmarshal(events, &n.Events)
+}
+
+func (n *SiwaNotification) MarshalJSON() ([]byte, error) {
+	events, err := json.Marshal(&n.Events)
+	if err != nil {
+		return nil, err
+	}
+	type notification SiwaNotification
+	return json.Marshal(&struct {
+		*notification
+		Events string `json:"events"`
+	}{(*notification)(n), string(events)})
+}
+
+// VerifyNotification 验证通知的签名、iss 和 aud, 返回解码后的通知
+func (v *IdTokenVerifier) VerifyNotification(ctx context.Context, payload string) (*SiwaNotification, error) {
+	n := &SiwaNotification{}
+	if _, err := v.Keys.Verify(ctx, payload, n); err != nil {
+		return nil, err
+	}
+	if n.Iss != AppleIdUrl {
+		return nil, ErrIdTokenIssuer
+	}
+	if !v.audience(n.Aud) {
+		return nil, ErrIdTokenAudience
+	}
+	if n.Events.Type == "" || n.Events.Sub == "" {
+		return nil, errors.New("siwa: notification has no event")
+	}
+	return n, nil
+}
+
+// ErrNotificationIat 表示通知的 iat 超过 MaxAge 或在未来
+var ErrNotificationIat = errors.New("siwa: notification iat is out of range")
+
+// DefaultSiwaNotificationMaxAge 是通知 iat 的默认最长时间, 与 MemoryNotificationStore 的 TTL 相同
+const DefaultSiwaNotificationMaxAge = 7 * 24 * time.Hour
+
+// SiwaNotificationHandler 接收苹果 POST 到服务器通知地址的 {"payload": "<JWT>"}。
+// 回调返回错误时响应 500, 苹果会稍后重试; 没有设置回调的事件直接确认。
+// 防止重放: iat 超过 MaxAge 的通知被拒绝, MaxAge 内的 jti 记录在 Store 中, 重复的通知直接确认
+type SiwaNotificationHandler struct {
+	Verifier *IdTokenVerifier
+	Store    NotificationStore  // 按 jti 去重, 为 nil 时不去重; 记录应至少保留 MaxAge
+	MaxAge   time.Duration      // 默认 DefaultSiwaNotificationMaxAge
+	Logger   logrus.FieldLogger // 默认 logrus.StandardLogger()
+
+	OnEmailDisabled  func(ctx context.Context, e *SiwaEvent) error
+	OnEmailEnabled   func(ctx context.Context, e *SiwaEvent) error
+	OnConsentRevoked func(ctx context.Context, e *SiwaEvent) error
+	OnAccountDelete  func(ctx context.Context, e *SiwaEvent) error
+}
+
+func (h *SiwaNotificationHandler) logger() logrus.FieldLogger {
+	if h.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return h.Logger
+}
+
+// checkIat 检查 iat 在 MaxAge 内且不在未来, 允许 Verifier.Leeway 的误差
+func (h *SiwaNotificationHandler) checkIat(n *SiwaNotification) error {
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultSiwaNotificationMaxAge
+	}
+	now := time.Now()
+	if h.Verifier.Now != nil {
+		now = h.Verifier.Now()
+	}
+	iat := time.Unix(n.Iat, 0)
+	if n.Iat == 0 || now.Sub(iat) > maxAge+h.Verifier.Leeway || iat.Sub(now) > h.Verifier.Leeway {
+		return ErrNotificationIat
+	}
+	return nil
+}
+
+func (h *SiwaNotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &struct {
+		Payload string `json:"payload"`
+	}{}
+	if err = json.Unmarshal(body, req); err != nil || req.Payload == "" {
+		http.Error(w, "payload is required", http.StatusBadRequest)
+		return
+	}
+	n, err := h.Verifier.VerifyNotification(r.Context(), req.Payload)
+	if err == nil {
+		err = h.checkIat(n)
+	}
+	if err != nil {
+		// 不把验证失败的原因返回给调用方
+		h.logger().WithError(err).Warn("siwa: rejected notification")
+		http.Error(w, "invalid notification", http.StatusUnauthorized)
+		return
+	}
+
+	log := h.logger().WithFields(logrus.Fields{"jti": n.Jti, "type": n.Events.Type, "sub": n.Events.Sub})
+	processed := false
+	if h.Store != nil {
+		if h.Store.Processed(n.Jti) {
+			log.Info("siwa: duplicate notification")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !h.Store.Claim(n.Jti) {
+			log.Info("siwa: notification in progress")
+			http.Error(w, "notification is being processed", http.StatusServiceUnavailable)
+			return
+		}
+		// 回调失败或 panic 时释放, 苹果重试时可以再次处理
+		defer func() {
+			if !processed {
+				h.Store.Release(n.Jti)
+			}
+		}()
+	}
+	var fn func(ctx context.Context, e *SiwaEvent) error
+	switch n.Events.Type {
+	case SiwaEmailDisabled:
+		fn = h.OnEmailDisabled
+	case SiwaEmailEnabled:
+		fn = h.OnEmailEnabled
+	case SiwaConsentRevoked:
+		fn = h.OnConsentRevoked
+	case SiwaAccountDelete:
+		fn = h.OnAccountDelete
+	default:
+		log.Info("siwa: ignored notification")
+	}
+	if fn != nil {
+		if err = fn(r.Context(), &n.Events); err != nil {
+			log.WithError(err).Error("siwa: notification failed")
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if h.Store != nil {
+		h.Store.MarkProcessed(n.Jti)
+		processed = true
+	}
+	w.WriteHeader(http.StatusOK)
+}
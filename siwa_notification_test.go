@@ -0,0 +1,164 @@
+package appleapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSiwaNotificationEvents(t *testing.T) {
+	event := `{"type":"email-disabled","sub":"001234.abcdef.0123","email":"abc@privaterelay.appleid.com","is_private_email":"true","event_time":1700000000123}`
+	for name, events := range map[string]string{
+		"string": jsonString(event),
+		"object": event,
+	} {
+		n := &appleapi.SiwaNotification{}
+		payload := `{"iss":"https://appleid.apple.com","aud":"com.example.web","iat":1700000000,"jti":"j1","events":` + events + `}`
+		if err := json.Unmarshal([]byte(payload), n); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		e := n.Events
+		if e.Type != appleapi.SiwaEmailDisabled || e.Sub != "001234.abcdef.0123" || e.Email != "abc@privaterelay.appleid.com" || !bool(e.IsPrivateEmail) {
+			t.Errorf("%s: events = %+v", name, e)
+		}
+		if !e.Time().Equal(time.Unix(1700000000, 123*int64(time.Millisecond))) {
+			t.Errorf("%s: event time = %v", name, e.Time())
+		}
+		if n.Jti != "j1" || n.Iat != 1700000000 || n.Aud != "com.example.web" {
+			t.Errorf("%s: notification = %+v", name, n)
+		}
+	}
+
+	// 编码时 events 是 JSON 字符串
+	b, err := json.Marshal(&appleapi.SiwaNotification{Jti: "j1", Events: appleapi.SiwaEvent{Type: appleapi.SiwaAccountDelete, Sub: "s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := raw["events"].(string); !ok || !strings.Contains(s, `"account-delete"`) {
+		t.Errorf("events = %#v, want a JSON string", raw["events"])
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// newSiwaHandler returns a handler that verifies the notifications of srv
+// and counts the account-delete callbacks.
+func newSiwaHandler(srv *appleapitest.AppleIdServer, calls *int) (*appleapi.SiwaNotificationHandler, *logtest.Hook) {
+	keys := appleapi.NewKeySet(srv.URL + "/auth/keys")
+	keys.Client = srv.Client()
+	logger, hook := logtest.NewNullLogger()
+	return &appleapi.SiwaNotificationHandler{
+		Verifier: &appleapi.IdTokenVerifier{Keys: keys, ClientIds: []string{appleapitest.TestClientId}, Leeway: time.Minute},
+		Store:    appleapi.NewMemoryNotificationStore(),
+		Logger:   logger,
+		OnAccountDelete: func(ctx context.Context, e *appleapi.SiwaEvent) error {
+			*calls++
+			return nil
+		},
+	}, hook
+}
+
+func postSiwa(h http.Handler, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/siwa", bytes.NewReader(body)))
+	return w
+}
+
+func TestSiwaNotificationHandlerRejects(t *testing.T) {
+	srv := appleapitest.NewAppleIdServer()
+	defer srv.Close()
+	forged := appleapitest.NewAppleIdServer() // 另一个密钥, kid 相同
+	defer forged.Close()
+	event := appleapi.SiwaEvent{Type: appleapi.SiwaAccountDelete, Sub: "001234.abcdef.0123"}
+	srv.ClientId = "com.example.other"
+	otherAud := srv.Notification(event)
+	srv.ClientId = appleapitest.TestClientId
+
+	tests := []struct {
+		name string
+		body []byte
+		now  time.Duration // Verifier.Now 相对现在的偏移
+		err  string        // 日志中的原因
+	}{
+		{"bad signature", forged.Notification(event), 0, "verification"},
+		{"wrong aud", otherAud, 0, appleapi.ErrIdTokenAudience.Error()},
+		{"iat too old", srv.Notification(event), 8 * 24 * time.Hour, appleapi.ErrNotificationIat.Error()},
+		{"iat in the future", srv.Notification(event), -time.Hour, appleapi.ErrNotificationIat.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h, hook := newSiwaHandler(srv, &calls)
+			h.Verifier.Now = func() time.Time { return time.Now().Add(tt.now) }
+			w := postSiwa(h, tt.body)
+			if w.Code != http.StatusUnauthorized || w.Body.String() != "invalid notification\n" {
+				t.Errorf("response = %d %q, want 401 without the reason", w.Code, w.Body.String())
+			}
+			if calls != 0 {
+				t.Error("callback was called")
+			}
+			e := hook.LastEntry()
+			if e == nil || e.Level != logrus.WarnLevel {
+				t.Fatalf("rejection was not logged: %v", e)
+			}
+			if err, _ := e.Data[logrus.ErrorKey].(error); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("logged error = %v, want %q", e.Data[logrus.ErrorKey], tt.err)
+			}
+		})
+	}
+}
+
+func TestSiwaNotificationHandlerReplay(t *testing.T) {
+	srv := appleapitest.NewAppleIdServer()
+	defer srv.Close()
+	calls := 0
+	h, _ := newSiwaHandler(srv, &calls)
+	body := srv.Notification(appleapi.SiwaEvent{Type: appleapi.SiwaAccountDelete, Sub: "001234.abcdef.0123"})
+
+	for i := 0; i < 2; i++ {
+		if w := postSiwa(h, body); w.Code != http.StatusOK {
+			t.Fatalf("post %d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("replayed notification called the callback %d times", calls)
+	}
+
+	// 失败和 panic 后释放, 重试时再次处理
+	fail := errors.New("database is down")
+	body = srv.Notification(appleapi.SiwaEvent{Type: appleapi.SiwaAccountDelete, Sub: "001234.abcdef.0456"})
+	h.OnAccountDelete = func(ctx context.Context, e *appleapi.SiwaEvent) error { return fail }
+	if w := postSiwa(h, body); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed callback: %d", w.Code)
+	}
+	h.OnAccountDelete = func(ctx context.Context, e *appleapi.SiwaEvent) error { panic("bug") }
+	func() {
+		defer func() { recover() }()
+		postSiwa(h, body)
+	}()
+	h.OnAccountDelete = func(ctx context.Context, e *appleapi.SiwaEvent) error {
+		calls++
+		return nil
+	}
+	if w := postSiwa(h, body); w.Code != http.StatusOK || calls != 2 {
+		t.Errorf("retry after failures: %d, %d calls", w.Code, calls)
+	}
+}
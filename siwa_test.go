@@ -0,0 +1,150 @@
+package appleapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// newTestSiwa returns a SignInWithApple that sends to a server recording the
+// form of each request, the server replies with status and body.
+func newTestSiwa(t *testing.T, status int, body string) (*SignInWithApple, *httptest.Server, *[]url.Values) {
+	t.Helper()
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		r.PostForm.Set("path", r.URL.Path)
+		forms = append(forms, r.PostForm)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	_, secret := newTestKey(t)
+	s := &SignInWithApple{
+		Secret:   secret,
+		Kid:      "SIWAKEY123",
+		TeamId:   "TEAMID1234",
+		ClientId: "com.example.web",
+		BaseUrl:  srv.URL,
+		Client:   srv.Client(),
+	}
+	return s, srv, &forms
+}
+
+func TestClientSecret(t *testing.T) {
+	key, secret := newTestKey(t)
+	s := &SignInWithApple{Secret: secret, Kid: "SIWAKEY123", TeamId: "TEAMID1234", ClientId: "com.example.web"}
+
+	cs, err := s.ClientSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &ClientSecretPayload{}
+	h, err := jwt.Verify([]byte(cs), jwt.NewES256(jwt.ECDSAPublicKey(&key.PublicKey)), pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID != "SIWAKEY123" || h.Algorithm != "ES256" {
+		t.Errorf("header = %+v", h)
+	}
+	if pl.Iss != "TEAMID1234" || pl.Sub != "com.example.web" || pl.Aud != "https://appleid.apple.com" {
+		t.Errorf("claims = %+v", pl)
+	}
+	if d := time.Duration(pl.Exp-pl.Iat) * time.Second; d != MaxClientSecretLifetime || d > 6*31*24*time.Hour {
+		t.Errorf("lifetime = %v, want %v", d, MaxClientSecretLifetime)
+	}
+	if again, _ := s.ClientSecret(); again != cs {
+		t.Error("client_secret was not reused")
+	}
+
+	s = &SignInWithApple{Secret: secret, Kid: "SIWAKEY123", TeamId: "TEAMID1234", ClientId: "com.example.web", Lifetime: time.Hour}
+	if cs, err = s.ClientSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jwt.Verify([]byte(cs), jwt.NewES256(jwt.ECDSAPublicKey(&key.PublicKey)), pl); err != nil || pl.Exp-pl.Iat != 3600 {
+		t.Errorf("Lifetime 1h: exp - iat = %d, %v", pl.Exp-pl.Iat, err)
+	}
+
+	s.Lifetime = MaxClientSecretLifetime + time.Second
+	if _, err = s.ClientSecret(); err != ErrClientSecretLifetime {
+		t.Errorf("lifetime over 6 months: %v, want %v", err, ErrClientSecretLifetime)
+	}
+}
+
+func TestSignInWithAppleForms(t *testing.T) {
+	s, srv, forms := newTestSiwa(t, http.StatusOK, `{"access_token":"a.b","token_type":"Bearer","expires_in":3600,"refresh_token":"r.1","id_token":"x.y.z"}`)
+	defer srv.Close()
+	s.RedirectUri = "https://example.com/callback"
+	ctx := context.Background()
+
+	resp, err := s.ExchangeCode(ctx, "c.0.code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken != "a.b" || resp.RefreshToken != "r.1" || resp.IdToken != "x.y.z" || resp.ExpiresIn != 3600 {
+		t.Errorf("response = %+v", resp)
+	}
+	if _, err = s.RefreshToken(ctx, "r.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Revoke(ctx, "r.1", TokenTypeHintRefresh); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Revoke(ctx, "a.b", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _ := s.ClientSecret()
+	want := []url.Values{
+		{"path": {"/auth/token"}, "grant_type": {"authorization_code"}, "code": {"c.0.code"}, "redirect_uri": {"https://example.com/callback"}},
+		{"path": {"/auth/token"}, "grant_type": {"refresh_token"}, "refresh_token": {"r.1"}},
+		{"path": {"/auth/revoke"}, "token": {"r.1"}, "token_type_hint": {"refresh_token"}},
+		{"path": {"/auth/revoke"}, "token": {"a.b"}},
+	}
+	if len(*forms) != len(want) {
+		t.Fatalf("%d requests, want %d", len(*forms), len(want))
+	}
+	for i, w := range want {
+		w.Set("client_id", "com.example.web")
+		w.Set("client_secret", secret)
+		if got := (*forms)[i]; got.Encode() != w.Encode() {
+			t.Errorf("request %d:\n got %s\nwant %s", i, got.Encode(), w.Encode())
+		}
+	}
+}
+
+func TestAppleIdError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   AppleIdError
+	}{
+		{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"The code has expired or has been revoked."}`,
+			AppleIdError{StatusCode: 400, Code: "invalid_grant", Description: "The code has expired or has been revoked."}},
+		{http.StatusBadRequest, `{"error":"invalid_client"}`, AppleIdError{StatusCode: 400, Code: "invalid_client"}},
+		{http.StatusServiceUnavailable, `<html>Service Unavailable</html>`, AppleIdError{StatusCode: 503, Code: "Service Unavailable"}},
+	}
+	for _, tt := range tests {
+		s, srv, _ := newTestSiwa(t, tt.status, tt.body)
+		_, err := s.ExchangeCode(context.Background(), "c.0.code")
+		srv.Close()
+		var e *AppleIdError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: err = %v, want an AppleIdError", tt.body, err)
+			continue
+		}
+		if *e != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.body, *e, tt.want)
+		}
+	}
+}
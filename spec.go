@@ -0,0 +1,125 @@
+package appleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Spec 描述期望的签名配置，由 Reconciler 与线上状态比较
+type Spec struct {
+	BundleIds    []BundleIdSpec          `json:"bundleIds,omitempty" yaml:"bundleIds,omitempty"`
+	DeviceGroups map[string][]DeviceSpec `json:"deviceGroups,omitempty" yaml:"deviceGroups,omitempty"`
+	// Prune 删除不在 Spec 中的 bundle ID、能力和描述文件 (Xcode 管理的描述文件除外)，
+	// 并禁用不在任何分组中的设备
+	Prune bool `json:"prune,omitempty" yaml:"prune,omitempty"`
+}
+
+type BundleIdSpec struct {
+	Identifier   string        `json:"identifier" yaml:"identifier"`
+	Name         string        `json:"name" yaml:"name"`
+	Capabilities []string      `json:"capabilities,omitempty" yaml:"capabilities,omitempty"` //e.g. PUSH_NOTIFICATIONS, ICLOUD
+	Profiles     []ProfileSpec `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+}
+
+type ProfileSpec struct {
+	Name             string   `json:"name,omitempty" yaml:"name,omitempty"`                         // 默认 "<identifier> <type>"
+	Type             string   `json:"type" yaml:"type"`                                             //e.g. IOS_APP_STORE
+	CertificateTypes []string `json:"certificateTypes,omitempty" yaml:"certificateTypes,omitempty"` // 使用这些类型的全部未过期证书
+	Certificates     []string `json:"certificates,omitempty" yaml:"certificates,omitempty"`         // 证书 id
+	DeviceGroups     []string `json:"deviceGroups,omitempty" yaml:"deviceGroups,omitempty"`
+}
+
+type DeviceSpec struct {
+	Udid string `json:"udid" yaml:"udid"`
+	Name string `json:"name" yaml:"name"`
+}
+
+// LoadSpec 读取 .json 文件，其他扩展名按 YAML 解析
+func LoadSpec(file string) (*Spec, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(b, spec)
+	} else {
+		err = yaml.UnmarshalStrict(b, spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("appleapi: spec %s: %v", file, err)
+	}
+	return spec, spec.Validate()
+}
+
+// ProfileName 返回描述文件的名称
+func (p *ProfileSpec) ProfileName(identifier string) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return identifier + " " + p.Type
+}
+
+// Validate 检查重复项和引用的设备分组
+func (s *Spec) Validate() error {
+	udids := make(map[string]string)
+	for group, list := range s.DeviceGroups {
+		for _, d := range list {
+			if d.Udid == "" {
+				return fmt.Errorf("appleapi: device group %s: udid is required", group)
+			}
+			udid := strings.ToLower(d.Udid)
+			if other, ok := udids[udid]; ok && other != d.Name {
+				return fmt.Errorf("appleapi: device %s has names %q and %q", d.Udid, other, d.Name)
+			}
+			udids[udid] = d.Name
+		}
+	}
+
+	identifiers := make(map[string]bool)
+	profiles := make(map[string]bool)
+	for _, b := range s.BundleIds {
+		if b.Identifier == "" || b.Name == "" {
+			return fmt.Errorf("appleapi: bundle ID %q: identifier and name are required", b.Identifier)
+		}
+		if identifiers[b.Identifier] {
+			return fmt.Errorf("appleapi: duplicate bundle ID %s", b.Identifier)
+		}
+		identifiers[b.Identifier] = true
+		for _, p := range b.Profiles {
+			if p.Type == "" {
+				return fmt.Errorf("appleapi: bundle ID %s: profile type is required", b.Identifier)
+			}
+			if len(p.CertificateTypes) == 0 && len(p.Certificates) == 0 {
+				return fmt.Errorf("appleapi: profile %s: certificates or certificateTypes is required", p.ProfileName(b.Identifier))
+			}
+			name := p.ProfileName(b.Identifier)
+			if profiles[name] {
+				return fmt.Errorf("appleapi: duplicate profile %s", name)
+			}
+			profiles[name] = true
+			for _, g := range p.DeviceGroups {
+				if _, ok := s.DeviceGroups[g]; !ok {
+					return fmt.Errorf("appleapi: profile %s: unknown device group %s", name, g)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// devices 返回所有分组中的设备，以小写 udid 为键
+func (s *Spec) devices() map[string]DeviceSpec {
+	all := make(map[string]DeviceSpec)
+	for _, list := range s.DeviceGroups {
+		for _, d := range list {
+			all[strings.ToLower(d.Udid)] = d
+		}
+	}
+	return all
+}
@@ -0,0 +1,242 @@
+package appleapi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 加密文件格式: magic | salt(16) | nonce(12) | AES-256-GCM 密文
+// 密钥由口令经 scrypt(N=32768, r=8, p=1) 派生
+const (
+	storeMagic     = "APPLEAPI1"
+	storeSaltSize  = 16
+	storeNonceSize = 12
+	storeScryptN   = 1 << 15
+	storeScryptR   = 8
+	storeScryptP   = 1
+)
+
+var (
+	ErrStoreFormat     = errors.New("appleapi: not an encrypted store file")
+	ErrStorePassphrase = errors.New("appleapi: wrong passphrase or corrupted store file")
+)
+
+// Encrypt 用口令加密数据
+func Encrypt(passphrase string, plain []byte) ([]byte, error) {
+	salt := make([]byte, storeSaltSize)
+	nonce := make([]byte, storeNonceSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	aead, err := storeCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(storeMagic)+storeSaltSize+storeNonceSize+len(plain)+aead.Overhead())
+	out = append(out, storeMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, []byte(storeMagic)), nil
+}
+
+// Decrypt 解密 Encrypt 的结果
+func Decrypt(passphrase string, data []byte) ([]byte, error) {
+	if len(data) < len(storeMagic)+storeSaltSize+storeNonceSize || !bytes.HasPrefix(data, []byte(storeMagic)) {
+		return nil, ErrStoreFormat
+	}
+	data = data[len(storeMagic):]
+	salt, nonce, sealed := data[:storeSaltSize], data[storeSaltSize:storeSaltSize+storeNonceSize], data[storeSaltSize+storeNonceSize:]
+	aead, err := storeCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, sealed, []byte(storeMagic))
+	if err != nil {
+		return nil, ErrStorePassphrase
+	}
+	return plain, nil
+}
+
+func storeCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, storeScryptN, storeScryptR, storeScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Store 是加密保存证书、私钥和描述文件的本地目录，可以提交到 git 仓库在团队中共享。
+// 目录结构:
+//
+//	certs/<certificateType>/<id>.cer
+//	certs/<certificateType>/<id>.key
+//	profiles/<profileType>/<bundle identifier>.mobileprovision
+//	quarantine/certs/<certificateType>/<id>.{cer,key}  Sync 的 Prune 移出的失效证书
+type Store struct {
+	Dir        string
+	Passphrase string
+}
+
+func NewStore(dir, passphrase string) *Store {
+	return &Store{Dir: dir, Passphrase: passphrase}
+}
+
+func CertificatePath(certificateType, id string) string {
+	return filepath.Join("certs", certificateType, id+".cer")
+}
+
+func PrivateKeyPath(certificateType, id string) string {
+	return filepath.Join("certs", certificateType, id+".key")
+}
+
+func ProfilePath(profileType, identifier string) string {
+	return filepath.Join("profiles", profileType, identifier+".mobileprovision")
+}
+
+func QuarantinePath(name string) string {
+	return filepath.Join("quarantine", name)
+}
+
+// Write 加密写入文件, name 为相对 Dir 的路径
+func (s *Store) Write(name string, data []byte) error {
+	enc, err := Encrypt(s.Passphrase, data)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(s.Dir, name)
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免中断时留下不完整的文件
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, enc, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Read 读取并解密文件
+func (s *Store) Read(name string) ([]byte, error) {
+	enc, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(s.Passphrase, enc)
+}
+
+func (s *Store) Exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.Dir, name))
+	return err == nil
+}
+
+func (s *Store) Remove(name string) error {
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Quarantine 把文件移到 quarantine/<name>, 已存在的旧文件被覆盖
+func (s *Store) Quarantine(name string) error {
+	file := filepath.Join(s.Dir, QuarantinePath(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(s.Dir, name), file)
+}
+
+// Export 解密文件并保存到 file
+func (s *Store) Export(name, file string) error {
+	b, err := s.Read(name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// PutCertificate 保存证书和私钥，key 为 nil 时只保存证书
+func (s *Store) PutCertificate(c *Certificate, key crypto.PrivateKey) error {
+	cer, err := base64.StdEncoding.DecodeString(c.Attributes.CertificateContent)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		pem, err := MarshalPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if err = s.Write(PrivateKeyPath(c.Attributes.CertificateType, c.Id), pem); err != nil {
+			return err
+		}
+	}
+	return s.Write(CertificatePath(c.Attributes.CertificateType, c.Id), cer)
+}
+
+// Certificate 返回 DER 格式的证书和私钥
+func (s *Store) Certificate(certificateType, id string) ([]byte, crypto.PrivateKey, error) {
+	cer, err := s.Read(CertificatePath(certificateType, id))
+	if err != nil {
+		return nil, nil, err
+	}
+	pem, err := s.Read(PrivateKeyPath(certificateType, id))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ParsePrivateKey(pem)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cer, key, nil
+}
+
+// CertificateIds 返回保存的某类型证书的 id
+func (s *Store) CertificateIds(certificateType string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.Dir, "certs", certificateType))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, info := range infos {
+		if name := info.Name(); strings.HasSuffix(name, ".cer") {
+			ids = append(ids, strings.TrimSuffix(name, ".cer"))
+		}
+	}
+	return ids, nil
+}
+
+// PutProfile 保存描述文件, identifier 为描述文件的 bundle identifier
+func (s *Store) PutProfile(p *Profile, identifier string) error {
+	b, err := base64.StdEncoding.DecodeString(p.Attributes.ProfileContent)
+	if err != nil {
+		return err
+	}
+	return s.Write(ProfilePath(p.Attributes.ProfileType, identifier), b)
+}
+
+// Profile 返回 .mobileprovision 文件的内容
+func (s *Store) Profile(profileType, identifier string) ([]byte, error) {
+	return s.Read(ProfilePath(profileType, identifier))
+}
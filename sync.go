@@ -0,0 +1,266 @@
+package appleapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type SyncOptions struct {
+	CertificateType string   // e.g. IOS_DISTRIBUTION
+	ProfileType     string   // e.g. IOS_APP_STORE
+	Identifiers     []string // bundle identifier, 必须已经注册
+	CommonName      string   // 新证书签名请求的 CN, 默认 "appleapi"
+	ReadOnly        bool     // 只检查，缺失或过期时返回错误
+	Prune           bool     // 把失效证书的文件移到 quarantine 目录, 否则只在 Stale 中报告
+}
+
+// SyncReport 记录 Sync 修改的内容，路径相对 Store.Dir
+type SyncReport struct {
+	Certificate string   // 使用的证书 id
+	Created     []string // 在 App Store Connect 创建的资源
+	Stored      []string // 写入的文件
+	Stale       []string // 已吊销、过期或没有私钥的证书文件, 未修改
+	Quarantined []string // Prune 时移到 quarantine 目录的文件
+}
+
+// 开发和 ad hoc 描述文件需要包含设备
+func profileNeedsDevices(profileType string) bool {
+	return strings.Contains(profileType, "DEVELOPMENT") || strings.Contains(profileType, "ADHOC")
+}
+
+func profilePlatform(profileType string) string {
+	if strings.HasPrefix(profileType, "MAC_") {
+		return PlatformMac
+	}
+	return PlatformIos
+}
+
+// Sync 检查 Store 中的证书和描述文件与 App Store Connect 是否一致，
+// 重新创建缺失、失效或过期的资源并保存到 Store
+func (s *Store) Sync(c *Client, opt SyncOptions) (*SyncReport, error) {
+	report := &SyncReport{}
+	cert, err := s.syncCertificate(c, opt, report)
+	if err != nil {
+		return report, err
+	}
+	report.Certificate = cert.Id
+	if opt.ProfileType == "" || len(opt.Identifiers) == 0 {
+		return report, nil
+	}
+
+	var devices []string
+	if profileNeedsDevices(opt.ProfileType) {
+		list, err := c.AllDevices(&ListDevicesQuery{
+			Platform: []string{profilePlatform(opt.ProfileType)},
+			Status:   []string{"ENABLED"},
+			Limit:    MaxLimit,
+		})
+		if err != nil {
+			return report, err
+		}
+		for _, d := range list {
+			devices = append(devices, d.Id)
+		}
+	}
+
+	bundles, _, err := c.AllBundleIds(&ListBundlesQuery{Identifier: opt.Identifiers, Limit: MaxLimit})
+	if err != nil {
+		return report, err
+	}
+	profiles, _, err := c.AllProfiles(&ListProfilesQuery{
+		ProfileType:       []string{opt.ProfileType},
+		Include:           []string{"bundleId", "certificates", "devices"},
+		Limit:             MaxLimit,
+		LimitCertificates: MaxRelatedLimit,
+		LimitDevices:      MaxRelatedLimit,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, identifier := range opt.Identifiers {
+		var bundle *BundleId
+		for i := range bundles {
+			// filter[identifier] 可能返回前缀相同的 bundle ID
+			if bundles[i].Attributes.Identifier == identifier {
+				bundle = &bundles[i]
+			}
+		}
+		if bundle == nil {
+			return report, fmt.Errorf("appleapi: bundle ID %s is not registered", identifier)
+		}
+		var matched []*Profile
+		for i := range profiles {
+			if profiles[i].Relationships.BundleId.Data.Id == bundle.Id {
+				matched = append(matched, &profiles[i])
+			}
+		}
+		if err = s.syncProfile(c, opt, bundle, cert.Id, devices, matched, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (s *Store) syncCertificate(c *Client, opt SyncOptions, report *SyncReport) (*Certificate, error) {
+	live, err := c.AllCertificates(&ListCertificatesQuery{CertificateType: []string{opt.CertificateType}, Limit: MaxLimit})
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.CertificateIds(opt.CertificateType)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var best *Certificate
+	var bestExpiration time.Time
+	for _, id := range stored {
+		var cert *Certificate
+		for i := range live {
+			if live[i].Id == id {
+				cert = &live[i]
+			}
+		}
+		var expiration time.Time
+		if cert != nil {
+			expiration, err = cert.Expiration()
+		}
+		if cert == nil || err != nil || expiration.Before(now) || !s.Exists(PrivateKeyPath(opt.CertificateType, id)) {
+			// 已吊销、过期或没有私钥的证书不再使用, 私钥不能恢复所以从不自动删除
+			for _, name := range []string{CertificatePath(opt.CertificateType, id), PrivateKeyPath(opt.CertificateType, id)} {
+				if !s.Exists(name) {
+					continue
+				}
+				if !opt.Prune || opt.ReadOnly {
+					report.Stale = append(report.Stale, name)
+					continue
+				}
+				if err = s.Quarantine(name); err != nil {
+					return nil, err
+				}
+				report.Quarantined = append(report.Quarantined, name)
+			}
+			continue
+		}
+		// 检查口令
+		if _, _, err = s.Certificate(opt.CertificateType, id); err != nil {
+			return nil, err
+		}
+		if best == nil || expiration.After(bestExpiration) {
+			best, bestExpiration = cert, expiration
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	if opt.ReadOnly {
+		return nil, fmt.Errorf("appleapi: no valid %s certificate in the store", opt.CertificateType)
+	}
+
+	commonName := opt.CommonName
+	if commonName == "" {
+		commonName = "appleapi"
+	}
+	key, csr, err := NewCertificateRequest(commonName, "")
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.Certificates.CertificateCreate(csr, opt.CertificateType)
+	if err != nil {
+		return nil, err
+	}
+	resp := &CertificateResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return nil, err
+	}
+	cert := &resp.Data
+	if err = s.PutCertificate(cert, key); err != nil {
+		return nil, err
+	}
+	report.Created = append(report.Created, TypeCertificates+"/"+cert.Id)
+	report.Stored = append(report.Stored, CertificatePath(opt.CertificateType, cert.Id), PrivateKeyPath(opt.CertificateType, cert.Id))
+	return cert, nil
+}
+
+func containsAll(refs []TypeId, ids []string) bool {
+	set := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		set[ref.Id] = true
+	}
+	for _, id := range ids {
+		if !set[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) syncProfile(c *Client, opt SyncOptions, bundle *BundleId, certificate string, devices []string, live []*Profile, report *SyncReport) error {
+	identifier := bundle.Attributes.Identifier
+	name := ProfilePath(opt.ProfileType, identifier)
+	now := time.Now()
+	for _, p := range live {
+		rel := &p.Relationships
+		if p.Attributes.ProfileState != "ACTIVE" {
+			continue
+		}
+		if expiration, err := p.Expiration(); err != nil || expiration.Before(now) {
+			continue
+		}
+		if err := c.completeProfile(p); err != nil {
+			return err
+		}
+		if !containsAll(rel.Certificates.Data, []string{certificate}) || !containsAll(rel.Devices.Data, devices) {
+			continue
+		}
+		content, err := base64.StdEncoding.DecodeString(p.Attributes.ProfileContent)
+		if err != nil {
+			return err
+		}
+		if stored, err := s.Read(name); err == nil && bytes.Equal(stored, content) {
+			return nil
+		} else if err != nil && s.Exists(name) {
+			return err
+		}
+		if opt.ReadOnly {
+			return fmt.Errorf("appleapi: profile %s in the store is outdated", name)
+		}
+		if err = s.PutProfile(p, identifier); err != nil {
+			return err
+		}
+		report.Stored = append(report.Stored, name)
+		return nil
+	}
+	if opt.ReadOnly {
+		return fmt.Errorf("appleapi: no valid %s profile for %s", opt.ProfileType, identifier)
+	}
+
+	// 名称必须唯一，先删除同名的旧描述文件
+	profileName := identifier + " " + opt.ProfileType
+	for _, p := range live {
+		if p.Attributes.Name == profileName {
+			if err := c.Profiles.ProfileDelete(p.Id); err != nil {
+				return err
+			}
+		}
+	}
+	b, err := c.Profiles.ProfileCreateWithType(profileName, opt.ProfileType, bundle.Id, []string{certificate}, devices)
+	if err != nil {
+		return err
+	}
+	resp := &ProfileResponse{}
+	if err = json.Unmarshal(b, resp); err != nil {
+		return err
+	}
+	if err = s.PutProfile(&resp.Data, identifier); err != nil {
+		return err
+	}
+	report.Created = append(report.Created, TypeProfiles+"/"+resp.Data.Id)
+	report.Stored = append(report.Stored, name)
+	return nil
+}
@@ -0,0 +1,121 @@
+package appleapi_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gamebtc/appleapi"
+	"github.com/gamebtc/appleapi/appleapitest"
+)
+
+func newSyncServer(t *testing.T, devices int) *appleapitest.Server {
+	t.Helper()
+	srv := appleapitest.NewServer()
+	b := appleapi.BundleId{}
+	b.Attributes.Identifier = "com.example.app"
+	b.Attributes.Name = "Example"
+	b.Attributes.Platform = appleapi.PlatformIos
+	srv.AddBundleId(b)
+	for i := 0; i < devices; i++ {
+		d := appleapi.Device{}
+		d.Attributes.Udid = fmt.Sprintf("00008020-%016X", i)
+		d.Attributes.Name = fmt.Sprintf("iPhone %d", i)
+		d.Attributes.Platform = appleapi.PlatformIos
+		srv.AddDevice(d)
+	}
+	return srv
+}
+
+func newTestStore(t *testing.T) *appleapi.Store {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "appleapi-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appleapi.NewStore(dir, "secret")
+}
+
+func TestSyncKeepsProfileWithManyDevices(t *testing.T) {
+	srv := newSyncServer(t, appleapi.MaxRelatedLimit+10)
+	defer srv.Close()
+	c := appleapi.NewClient(srv.Token())
+	s := newTestStore(t)
+	defer os.RemoveAll(s.Dir)
+	opt := appleapi.SyncOptions{
+		CertificateType: "IOS_DISTRIBUTION",
+		ProfileType:     "IOS_APP_ADHOC",
+		Identifiers:     []string{"com.example.app"},
+	}
+
+	report, err := s.Sync(c, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 2 {
+		t.Fatalf("first sync created %v, want a certificate and a profile", report.Created)
+	}
+	name := appleapi.ProfilePath(opt.ProfileType, "com.example.app")
+	if err = s.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// 描述文件的设备超过 limit[devices], 应该分页读取而不是重新创建
+	if report, err = s.Sync(c, opt); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 || !reflect.DeepEqual(report.Stored, []string{name}) {
+		t.Errorf("second sync created %v and stored %v, want only %s stored", report.Created, report.Stored, name)
+	}
+}
+
+func TestSyncNeverRemovesStaleKeys(t *testing.T) {
+	const certType = "IOS_DISTRIBUTION"
+	stale := []string{appleapi.CertificatePath(certType, "REVOKED"), appleapi.PrivateKeyPath(certType, "REVOKED")}
+	tests := []struct {
+		name        string
+		opt         appleapi.SyncOptions
+		stale       []string
+		quarantined []string
+	}{
+		{"report", appleapi.SyncOptions{CertificateType: certType}, stale, nil},
+		{"read only", appleapi.SyncOptions{CertificateType: certType, ReadOnly: true, Prune: true}, stale, nil},
+		{"prune", appleapi.SyncOptions{CertificateType: certType, Prune: true}, nil, stale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSyncServer(t, 0)
+			defer srv.Close()
+			s := newTestStore(t)
+			defer os.RemoveAll(s.Dir)
+			for _, name := range stale {
+				if err := s.Write(name, []byte("revoked")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report, err := s.Sync(appleapi.NewClient(srv.Token()), tt.opt)
+			if tt.opt.ReadOnly {
+				if err == nil {
+					t.Error("read only sync without a valid certificate succeeded")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report.Stale, tt.stale) || !reflect.DeepEqual(report.Quarantined, tt.quarantined) {
+				t.Errorf("stale = %v, quarantined = %v, want %v and %v", report.Stale, report.Quarantined, tt.stale, tt.quarantined)
+			}
+			for _, name := range stale {
+				if tt.quarantined != nil {
+					name = appleapi.QuarantinePath(name)
+				}
+				if b, err := s.Read(name); err != nil || string(b) != "revoked" {
+					t.Errorf("%s: %q, %v", filepath.ToSlash(name), b, err)
+				}
+			}
+		})
+	}
+}
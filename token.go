@@ -2,12 +2,16 @@ package appleapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gbrlsnchs/jwt/v3"
@@ -54,59 +58,79 @@ func AuthKeyFromFile(fileName string) (*ecdsa.PrivateKey, error) {
 	return ReadPrivate(bytes)
 }
 
-type ErrorResponse struct {
-	Error []struct {
-		Code   string            `json:"code,omitempty"`
-		Status string            `json:"status,omitempty"`
-		Id     string            `json:"id,omitempty"`
-		Title  string            `json:"title,omitempty"`
-		Detail string            `json:"detail,omitempty"`
-		Source map[string]string `json:"source,omitempty"`
-	} `json:"errors,omitempty"`
-}
-
-type PagingInformation struct {
-	Paging struct {
-		Total int `json:"total,omitempty"` //The total number of resources matching your request.
-		Limit int `json:"limit,omitempty"` //The maximum number of resources to return per page, from 0 to 200.
-	} `json:"paging,omitempty"`
-}
-
-type PagedDocumentLinks struct {
-	First string `json:"first,omitempty"`
-	Next  string `json:"next,omitempty"`
-	Self  string `json:"self,omitempty"`
-}
-
-type TypeId struct {
-	Id   string `json:"id,omitempty"`
-	Type string `json:"type,omitempty"`
-}
-
-type DocumentLinks struct {
-	Self string `json:"self,omitempty"`
-}
+const DefaultBaseUrl = "https://api.appstoreconnect.apple.com"
 
-type ResourceLinks struct {
-	Self string `json:"self,omitempty"`
-}
+// tokenLifetime 是 JWT 的有效期, apple 建议 20 分钟, 最长支持 60 分钟。
+// 到期前 tokenRefresh 重新签名, 避免发送已过期的 bearer
+const (
+	tokenLifetime = 20 * time.Minute
+	tokenRefresh  = tokenLifetime - time.Minute
+)
 
 type Token struct {
 	Secret  string
 	Kid     string
 	Iss     string
+	Bid     string       // App Store Server API 的 bundle ID, 其他 API 为空
+	BaseUrl string       // 默认 DefaultBaseUrl, 测试时可指向本地服务
+	Client  *http.Client // 默认 http.DefaultClient
+	Hooks   []Hook       // 请求发送前和收到响应后依次调用
+
+	// MaxRetries is the number of retries of a failed request, see retryable
+	// for the failures that are retried. 0 disables retries.
+	MaxRetries int
+	RetryWait  time.Duration // 默认 1 秒
+
+	mu      sync.Mutex // 保护 bearer, created 和 key, 一个 Token 可以被多个 goroutine 使用
 	bearer  string
 	created time.Time
 	key     *jwt.ECDSASHA
+
+	ctx    context.Context // WithContext 设置, Do 使用
+	parent *Token          // WithContext 的副本使用 parent 的 bearer
+}
+
+// WithContext returns a shallow copy of t whose Do and Web* requests use
+// ctx. The copy shares the bearer of t, so the services of a Client can be
+// bound to the context of one operation.
+func (t *Token) WithContext(ctx context.Context) *Token {
+	if ctx == nil {
+		panic("appleapi: nil context")
+	}
+	return &Token{
+		Secret:     t.Secret,
+		Kid:        t.Kid,
+		Iss:        t.Iss,
+		Bid:        t.Bid,
+		BaseUrl:    t.BaseUrl,
+		Client:     t.Client,
+		Hooks:      t.Hooks,
+		MaxRetries: t.MaxRetries,
+		RetryWait:  t.RetryWait,
+		ctx:        ctx,
+		parent:     t.root(),
+	}
+}
+
+func (t *Token) root() *Token {
+	if t.parent != nil {
+		return t.parent
+	}
+	return t
 }
 
 type ApiPayload struct {
 	Aud string `json:"aud,omitempty"`
+	Iat int64  `json:"iat,omitempty"`
 	Exp int64  `json:"exp,omitempty"`
 	Iss string `json:"iss,omitempty"`
+	Bid string `json:"bid,omitempty"`
 }
 
 func (t *Token) getAuthorization() (string, error) {
+	t = t.root()
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.key == nil {
 		pk, err := ReadPrivate([]byte(t.Secret))
 		if err != nil {
@@ -116,14 +140,14 @@ func (t *Token) getAuthorization() (string, error) {
 	}
 
 	now := time.Now()
-	since := now.Sub(t.created).Seconds()
-	if since > 3000 || t.bearer == "" {
+	if now.Sub(t.created) >= tokenRefresh || t.bearer == "" {
 		t.created = now
 		p1 := &ApiPayload{
 			Aud: "appstoreconnect-v1",
-			// 注意,apple建议20分钟，最长支持60分钟
-			Exp: now.Add(time.Minute * 20).Unix(),
+			Iat: now.Unix(),
+			Exp: now.Add(tokenLifetime).Unix(),
 			Iss: t.Iss,
+			Bid: t.Bid,
 		}
 		bearer, err := jwt.Sign(p1, t.key, jwt.KeyID(t.Kid))
 		if err != nil {
@@ -135,40 +159,119 @@ func (t *Token) getAuthorization() (string, error) {
 }
 
 func (t *Token) Verify(bearer string) (jwt.Header, error) {
+	t = t.root()
+	t.mu.Lock()
+	key := t.key
+	t.mu.Unlock()
 	pl := &ApiPayload{}
-	return jwt.Verify([]byte(bearer), t.key, pl)
+	return jwt.Verify([]byte(bearer), key, pl)
 }
 
-func (t *Token) WebGet(url string) ([]byte, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
-	auth, _ := t.getAuthorization()
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+auth)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, nil
+// Url returns the absolute url of an API path such as "/v1/devices".
+func (t *Token) Url(path string) string {
+	if t.BaseUrl == "" {
+		return DefaultBaseUrl + path
 	}
-	//if resp.StatusCode != http.StatusOK {
-	//	return nil, fmt.Errorf("invalid respoinse code: %s", resp.Status)
-	//}
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, nil
+	return strings.TrimSuffix(t.BaseUrl, "/") + path
 }
 
-func (t *Token) WebPost(url string, reqJson []byte) ([]byte, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(reqJson))
-	auth, _ := t.getAuthorization()
+// Do sends an authorized request and returns the response body. A response
+// status other than 2xx is returned as *ApiError.
+func (t *Token) Do(method, url string, reqJson []byte) ([]byte, error) {
+	ctx := t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return t.DoContext(ctx, method, url, reqJson)
+}
+
+// hooks returns t.Hooks and the RateLimiter of a Batch carried by ctx.
+func (t *Token) hooks(ctx context.Context) []Hook {
+	l, _ := ctx.Value(limiterKey{}).(*RateLimiter)
+	if l == nil {
+		return t.Hooks
+	}
+	for _, h := range t.Hooks {
+		if h == Hook(l) {
+			return t.Hooks
+		}
+	}
+	return append(t.Hooks[:len(t.Hooks):len(t.Hooks)], l)
+}
+
+// send sends one request, the response body is already read and closed.
+func (t *Token) send(ctx context.Context, method, url string, reqJson []byte) (*http.Response, []byte, error) {
+	var body io.Reader
+	if reqJson != nil {
+		body = bytes.NewReader(reqJson)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	auth, err := t.getAuthorization()
+	if err != nil {
+		return nil, nil, err
+	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Authorization", "Bearer "+auth)
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hooks := t.hooks(ctx)
+	for _, h := range hooks {
+		if w, ok := h.(WaitHook); ok {
+			if err = w.Wait(ctx); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, h := range hooks {
+		req = h.BeforeSend(req)
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
+	var respBody []byte
+	if err == nil {
+		respBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			resp = nil
+		}
+	}
+	latency := time.Since(start)
+	if resp != nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		apiErr := newApiError(resp, respBody)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if h, ok := hooks[i].(ErrorHook); ok {
+				h.OnApiError(req, apiErr)
+			}
+		}
+	}
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].AfterReceive(req, resp, err, latency)
+	}
 	if err != nil {
-		return nil, nil
+		return nil, nil, err
 	}
-	//if resp.StatusCode != http.StatusOK {
-	//	return nil, fmt.Errorf("invalid respoinse code: %s", resp.Status)
-	//}
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, nil
+	return resp, respBody, nil
+}
+
+func (t *Token) WebGet(url string) ([]byte, error) {
+	return t.Do(http.MethodGet, url, nil)
+}
+
+func (t *Token) WebPost(url string, reqJson []byte) ([]byte, error) {
+	return t.Do(http.MethodPost, url, reqJson)
+}
+
+func (t *Token) WebPatch(url string, reqJson []byte) ([]byte, error) {
+	return t.Do(http.MethodPatch, url, reqJson)
+}
+
+func (t *Token) WebDelete(url string) ([]byte, error) {
+	return t.Do(http.MethodDelete, url, nil)
 }
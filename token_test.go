@@ -0,0 +1,221 @@
+package appleapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// newTestKey returns a new P-256 key and its .p8 encoding.
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// newTestToken returns a Token signed by a new key that sends to handler.
+func newTestToken(t *testing.T, handler http.HandlerFunc) (*Token, *httptest.Server) {
+	t.Helper()
+	_, secret := newTestKey(t)
+	srv := httptest.NewServer(handler)
+	return &Token{
+		Secret:  secret,
+		Kid:     "TESTKEY123",
+		Iss:     "test-issuer",
+		BaseUrl: srv.URL,
+		Client:  srv.Client(),
+	}, srv
+}
+
+func TestRetryable(t *testing.T) {
+	dial := &url.Error{Op: "Post", URL: "https://api.appstoreconnect.apple.com/v1/devices", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	timeout := &url.Error{Op: "Post", URL: "https://api.appstoreconnect.apple.com/v1/devices", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")}}
+	tests := []struct {
+		method string
+		status int
+		err    error
+		want   bool
+	}{
+		{http.MethodGet, http.StatusTooManyRequests, nil, true},
+		{http.MethodGet, http.StatusServiceUnavailable, nil, true},
+		{http.MethodGet, 0, timeout, true},
+		{http.MethodGet, http.StatusNotFound, nil, false},
+		{http.MethodGet, 0, ErrAuthKeyNotPem, false},
+		{http.MethodGet, 0, &url.Error{Op: "Get", URL: "https://api.appstoreconnect.apple.com/v1/devices", Err: io.ErrUnexpectedEOF}, true},
+		{http.MethodDelete, http.StatusInternalServerError, nil, true},
+		{http.MethodPut, 0, timeout, true},
+		{http.MethodPost, http.StatusTooManyRequests, nil, true},
+		{http.MethodPost, 0, dial, true},
+		{http.MethodPost, http.StatusInternalServerError, nil, false},
+		{http.MethodPost, http.StatusBadGateway, nil, false},
+		{http.MethodPost, 0, timeout, false},
+		{http.MethodPatch, http.StatusServiceUnavailable, nil, false},
+		{http.MethodPatch, http.StatusTooManyRequests, nil, true},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.err == nil {
+			resp = &http.Response{StatusCode: tt.status}
+		}
+		if got := retryable(tt.method, resp, tt.err); got != tt.want {
+			t.Errorf("retryable(%s, %d, %v) = %v, want %v", tt.method, tt.status, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int // 依次返回的状态码, 之后返回 200
+		want     int   // 收到的请求数
+		wantErr  bool
+	}{
+		{"get after 503", http.MethodGet, []int{503, 503}, 3, false},
+		{"get gives up", http.MethodGet, []int{500, 500, 500, 500}, 3, true},
+		{"get 404 is final", http.MethodGet, []int{404}, 1, true},
+		{"post after 429", http.MethodPost, []int{429}, 2, false},
+		{"post 500 is not retried", http.MethodPost, []int{500}, 1, true},
+		{"post 504 is not retried", http.MethodPost, []int{504}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				n := requests
+				requests++
+				mu.Unlock()
+				if n < len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n])
+					return
+				}
+				w.Write([]byte(`{"data":{}}`))
+			})
+			defer srv.Close()
+			token.MaxRetries = 2
+			token.RetryWait = time.Millisecond
+			var retries []int
+			token.Hooks = []Hook{retryRecorder(func(attempt int) { retries = append(retries, attempt) })}
+
+			_, err := token.Do(tt.method, token.Url("/v1/devices"), []byte(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.want {
+				t.Errorf("%d requests, want %d", requests, tt.want)
+			}
+			if len(retries) != tt.want-1 {
+				t.Errorf("OnRetry called for attempts %v, want %d retries", retries, tt.want-1)
+			}
+		})
+	}
+}
+
+func TestDoRetriesPostOnDialError(t *testing.T) {
+	token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {})
+	srv.Close() // 连接被拒绝, 请求没有发出
+	token.MaxRetries = 2
+	token.RetryWait = time.Millisecond
+	retries := 0
+	token.Hooks = []Hook{retryRecorder(func(int) { retries++ })}
+	if _, err := token.WebPost(token.Url("/v1/devices"), []byte(`{}`)); err == nil {
+		t.Fatal("WebPost() succeeded on a closed server")
+	}
+	if retries != 2 {
+		t.Errorf("%d retries, want 2", retries)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	token := &Token{RetryWait: 10 * time.Millisecond}
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{0, "", 10 * time.Millisecond},
+		{1, "", 20 * time.Millisecond},
+		{3, "", 80 * time.Millisecond},
+		{1, "3", 3 * time.Second},
+		{1, "soon", 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		if got := token.retryWait(tt.attempt, resp); got != tt.want {
+			t.Errorf("retryWait(%d, %q) = %v, want %v", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+	if got := (&Token{}).retryWait(0, nil); got != time.Second {
+		t.Errorf("default wait = %v, want 1s", got)
+	}
+}
+
+// retryRecorder is a Hook that only observes retries.
+type retryRecorder func(attempt int)
+
+func (f retryRecorder) BeforeSend(req *http.Request) *http.Request { return req }
+func (f retryRecorder) AfterReceive(req *http.Request, resp *http.Response, err error, latency time.Duration) {
+}
+func (f retryRecorder) OnRetry(method, url string, attempt int, wait time.Duration) { f(attempt) }
+
+func TestTokenRefreshesBeforeExpiry(t *testing.T) {
+	token, srv := newTestToken(t, func(w http.ResponseWriter, r *http.Request) {})
+	srv.Close()
+	first, err := token.getAuthorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := token.getAuthorization(); again != first {
+		t.Error("bearer was signed again right away")
+	}
+	pl := &ApiPayload{}
+	if _, err = jwt.Verify([]byte(first), token.key, pl); err != nil {
+		t.Fatal(err)
+	}
+	if exp := time.Unix(pl.Exp, 0).Sub(time.Unix(pl.Iat, 0)); exp != tokenLifetime {
+		t.Errorf("exp - iat = %s, want %s", exp, tokenLifetime)
+	}
+
+	// 一个 Monitor 或 ServerApi 长时间使用同一个 Token, 过期前必须重新签名
+	token.mu.Lock()
+	token.created = time.Now().Add(-tokenLifetime + 30*time.Second)
+	token.mu.Unlock()
+	second, err := token.getAuthorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("bearer that expires in 30s was reused")
+	}
+	if _, err = jwt.Verify([]byte(second), token.key, pl); err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(time.Unix(pl.Exp, 0)) < tokenLifetime-time.Minute {
+		t.Errorf("new bearer expires at %s", time.Unix(pl.Exp, 0))
+	}
+}
@@ -0,0 +1,112 @@
+package appleapi
+
+import "time"
+
+// App Store Server API 和 App Store Server Notifications 中签名数据的解码结果
+// https://developer.apple.com/documentation/appstoreserverapi/data_types
+
+// environment
+const (
+	EnvironmentSandbox      = "Sandbox"
+	EnvironmentProduction   = "Production"
+	EnvironmentXcode        = "Xcode"
+	EnvironmentLocalTesting = "LocalTesting"
+)
+
+// type
+const (
+	ProductTypeAutoRenewable = "Auto-Renewable Subscription"
+	ProductTypeNonConsumable = "Non-Consumable"
+	ProductTypeConsumable    = "Consumable"
+	ProductTypeNonRenewing   = "Non-Renewing Subscription"
+)
+
+// inAppOwnershipType
+const (
+	OwnershipPurchased    = "PURCHASED"
+	OwnershipFamilyShared = "FAMILY_SHARED"
+)
+
+// transactionReason
+const (
+	TransactionReasonPurchase = "PURCHASE"
+	TransactionReasonRenewal  = "RENEWAL"
+)
+
+// 订阅的 status
+const (
+	SubscriptionActive             = 1
+	SubscriptionExpired            = 2
+	SubscriptionBillingRetry       = 3
+	SubscriptionBillingGracePeriod = 4
+	SubscriptionRevoked            = 5
+)
+
+// revocationReason
+const (
+	RevocationOther    = 0
+	RevocationAppIssue = 1
+)
+
+// https://developer.apple.com/documentation/appstoreserverapi/jwstransactiondecodedpayload
+type JWSTransactionDecodedPayload struct {
+	TransactionId               string `json:"transactionId"`
+	OriginalTransactionId       string `json:"originalTransactionId"`
+	WebOrderLineItemId          string `json:"webOrderLineItemId,omitempty"`
+	BundleId                    string `json:"bundleId"`
+	ProductId                   string `json:"productId"`
+	SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier,omitempty"`
+	PurchaseDate                int64  `json:"purchaseDate"` // 毫秒, 下同
+	OriginalPurchaseDate        int64  `json:"originalPurchaseDate"`
+	ExpiresDate                 int64  `json:"expiresDate,omitempty"`
+	Quantity                    int    `json:"quantity"`
+	Type                        string `json:"type"`
+	AppAccountToken             string `json:"appAccountToken,omitempty"`
+	InAppOwnershipType          string `json:"inAppOwnershipType"`
+	SignedDate                  int64  `json:"signedDate"`
+	RevocationReason            *int   `json:"revocationReason,omitempty"`
+	RevocationDate              int64  `json:"revocationDate,omitempty"`
+	IsUpgraded                  bool   `json:"isUpgraded,omitempty"`
+	OfferType                   int    `json:"offerType,omitempty"`
+	OfferIdentifier             string `json:"offerIdentifier,omitempty"`
+	Environment                 string `json:"environment"`
+	Storefront                  string `json:"storefront,omitempty"`
+	StorefrontId                string `json:"storefrontId,omitempty"`
+	TransactionReason           string `json:"transactionReason,omitempty"`
+	Currency                    string `json:"currency,omitempty"`
+	Price                       int64  `json:"price,omitempty"` // 千分之一货币单位
+}
+
+// https://developer.apple.com/documentation/appstoreserverapi/jwsrenewalinfodecodedpayload
+type JWSRenewalInfoDecodedPayload struct {
+	OriginalTransactionId       string `json:"originalTransactionId"`
+	AutoRenewProductId          string `json:"autoRenewProductId"`
+	ProductId                   string `json:"productId"`
+	AutoRenewStatus             int    `json:"autoRenewStatus"` // 1 自动续订
+	ExpirationIntent            int    `json:"expirationIntent,omitempty"`
+	GracePeriodExpiresDate      int64  `json:"gracePeriodExpiresDate,omitempty"`
+	IsInBillingRetryPeriod      bool   `json:"isInBillingRetryPeriod,omitempty"`
+	OfferIdentifier             string `json:"offerIdentifier,omitempty"`
+	OfferType                   int    `json:"offerType,omitempty"`
+	PriceIncreaseStatus         *int   `json:"priceIncreaseStatus,omitempty"`
+	RecentSubscriptionStartDate int64  `json:"recentSubscriptionStartDate,omitempty"`
+	RenewalDate                 int64  `json:"renewalDate,omitempty"`
+	SignedDate                  int64  `json:"signedDate"`
+	Environment                 string `json:"environment"`
+}
+
+// MillisTime 把 App Store 的毫秒时间戳转换为 time.Time, 0 返回零值
+func MillisTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Expired 表示订阅在 now 已过期或交易已退款
+func (t *JWSTransactionDecodedPayload) Expired(now time.Time) bool {
+	if t.RevocationDate != 0 {
+		return true
+	}
+	return t.ExpiresDate != 0 && !now.Before(MillisTime(t.ExpiresDate))
+}